@@ -0,0 +1,151 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/radix"
+)
+
+// Backup will make all nodes write snapshots of the data they own as it was at the same time, to files in their data directories,
+// and return the manifest of the backup, which is also recorded in the backups namespace of the system keyspace.
+func (self *Conn) Backup(id string) (result common.BackupManifest, err error) {
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.Backup", id, &result); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.Backup(id)
+	}
+	return
+}
+
+// Backups returns the manifests of the backups recorded in the system keyspace.
+func (self *Conn) Backups() (result []common.BackupManifest, err error) {
+	for _, item := range self.SystemEntries(common.SystemBackups) {
+		var manifest common.BackupManifest
+		if err = json.Unmarshal(item.Value, &manifest); err != nil {
+			return
+		}
+		result = append(result, manifest)
+	}
+	return
+}
+
+// GetBackup returns the manifest of the backup with id, if it is recorded in the system keyspace.
+func (self *Conn) GetBackup(id string) (result common.BackupManifest, existed bool, err error) {
+	encoded, existed := self.SystemGet(common.SystemBackups, []byte(id))
+	if existed {
+		err = json.Unmarshal(encoded, &result)
+	}
+	return
+}
+
+// BackupReader returns the content of one file of a backup.
+type BackupReader func(file common.BackupFile) ([]byte, error)
+
+// FetchBackup returns a BackupReader fetching the files of the backup with id from the nodes that wrote them, one chunk at a time.
+func FetchBackup(id string) BackupReader {
+	return func(file common.BackupFile) (result []byte, err error) {
+		for {
+			var chunk []byte
+			if err = (common.Remote{Addr: file.Addr}).Call("DHash.ReadBackup", common.BackupChunk{ID: id, Offset: int64(len(result))}, &chunk); err != nil {
+				return
+			}
+			if len(chunk) == 0 {
+				return
+			}
+			result = append(result, chunk...)
+		}
+	}
+}
+
+// BackupDir returns a BackupReader finding the files anywhere under dir, for example after copying the backup directories of all the nodes there.
+// The files are recognized by their checksums, so they can be named and organized freely.
+func BackupDir(dir string) BackupReader {
+	var found map[string]string
+	return func(file common.BackupFile) (result []byte, err error) {
+		if found == nil {
+			found = make(map[string]string)
+			if err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					var content []byte
+					if content, err = ioutil.ReadFile(path); err != nil {
+						return err
+					}
+					found[string(murmur.HashBytes(content))] = path
+				}
+				return err
+			}); err != nil {
+				return
+			}
+		}
+		path, ok := found[string(file.Checksum)]
+		if !ok {
+			return nil, fmt.Errorf("The backup file %v from %v is not in %v", file.Path, file.Addr, dir)
+		}
+		return ioutil.ReadFile(path)
+	}
+}
+
+// ReadBackupManifest returns the manifest of the backup with id found anywhere under dir, for example after copying the backup directories of the nodes there.
+func ReadBackupManifest(dir, id string) (result common.BackupManifest, err error) {
+	found := ""
+	if err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && found == "" && !info.IsDir() && info.Name() == id+".manifest" {
+			found = path
+		}
+		return err
+	}); err != nil {
+		return
+	}
+	if found == "" {
+		err = fmt.Errorf("No manifest of the backup %v in %v", id, dir)
+		return
+	}
+	encoded, err := ioutil.ReadFile(found)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(encoded, &result)
+	return
+}
+
+// RestoreBackup will fetch each file of the backup described by manifest from the node that wrote it, and restore it like RestoreBackupWith.
+func (self *Conn) RestoreBackup(manifest common.BackupManifest) (changed int, err error) {
+	return self.RestoreBackupWith(manifest, FetchBackup(manifest.ID))
+}
+
+// RestoreBackupWith will read each file of the backup described by manifest using read, verify it, and send its entries to the nodes currently owning them.
+// The cluster doesn't need to have the same nodes, positions or size as the one the backup was made of, since the entries are distributed according to the current ring.
+// It returns the number of changed entries. Only entries newer than the ones already in the database will be applied.
+func (self *Conn) RestoreBackupWith(manifest common.BackupManifest, read BackupReader) (changed int, err error) {
+	if !manifest.Complete() {
+		return 0, fmt.Errorf("Backup %v is incomplete: %v", manifest.ID, manifest.Failed)
+	}
+	for _, file := range manifest.Files {
+		var encoded []byte
+		if encoded, err = read(file); err != nil {
+			return
+		}
+		if !bytes.Equal(murmur.HashBytes(encoded), file.Checksum) {
+			return changed, fmt.Errorf("The backup file %v from %v doesn't match its checksum", file.Path, file.Addr)
+		}
+		var snapshot []radix.SnapshotEntry
+		if snapshot, err = radix.DecodeSnapshot(encoded); err != nil {
+			return
+		}
+		var tmp int
+		if tmp, err = self.restoreEntries(snapshot); err != nil {
+			return
+		}
+		changed += tmp
+	}
+	return
+}
@@ -0,0 +1,310 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// flushInterval is how often a Conn with buffered writes tries to flush them to the cluster.
+	flushInterval = time.Second
+	tokenSize     = 16
+)
+
+// ErrUnavailable is returned when no node of the cluster answers.
+var ErrUnavailable = errors.New("No node of the cluster is available")
+
+// ErrBufferFull is returned when a write can't be buffered because the write buffer is full.
+var ErrBufferFull = errors.New("The write buffer is full")
+
+// noLiveNodes is what a Conn panics with when none of its known nodes answers.
+type noLiveNodes struct {
+	conn *Conn
+}
+
+func (self noLiveNodes) Error() string {
+	return fmt.Sprintf("%v doesn't know of any live nodes!", self.conn)
+}
+
+type bufferedWrite struct {
+	Operation string
+	Item      common.Item
+}
+
+// writeBuffer is a bounded queue of writes, stored in a file to survive restarts.
+type writeBuffer struct {
+	lock      *sync.Mutex
+	flushLock *sync.Mutex
+	path      string
+	file      *os.File
+	maxSize   int64
+	size      int64
+	writes    []bufferedWrite
+}
+
+func encodeWrite(w bufferedWrite) (result []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = gob.NewEncoder(buf).Encode(w); err != nil {
+		return
+	}
+	result = make([]byte, 4+buf.Len())
+	binary.BigEndian.PutUint32(result, uint32(buf.Len()))
+	copy(result[4:], buf.Bytes())
+	return
+}
+
+// openWriteBuffer will open the write buffer stored at path, and load the writes in it.
+// A partially written last write, left by a crash, is discarded.
+func openWriteBuffer(path string, maxSize int64) (result *writeBuffer, err error) {
+	result = &writeBuffer{
+		lock:      new(sync.Mutex),
+		flushLock: new(sync.Mutex),
+		path:      path,
+		maxSize:   maxSize,
+	}
+	if file, err := os.Open(path); err == nil {
+		header := make([]byte, 4)
+		for {
+			if _, err = io.ReadFull(file, header); err != nil {
+				break
+			}
+			encoded := make([]byte, binary.BigEndian.Uint32(header))
+			if _, err = io.ReadFull(file, encoded); err != nil {
+				break
+			}
+			var w bufferedWrite
+			if err = gob.NewDecoder(bytes.NewBuffer(encoded)).Decode(&w); err != nil {
+				break
+			}
+			result.writes = append(result.writes, w)
+		}
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err = result.compact(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// compact will rewrite the file of this writeBuffer to contain only the writes not yet flushed.
+func (self *writeBuffer) compact() (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	tmpPath := self.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	var size int64
+	var encoded []byte
+	for _, w := range self.writes {
+		if encoded, err = encodeWrite(w); err != nil {
+			tmp.Close()
+			return
+		}
+		if _, err = tmp.Write(encoded); err != nil {
+			tmp.Close()
+			return
+		}
+		size += int64(len(encoded))
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return
+	}
+	tmp.Close()
+	if err = os.Rename(tmpPath, self.path); err != nil {
+		return
+	}
+	if self.file != nil {
+		self.file.Close()
+	}
+	if self.file, err = os.OpenFile(self.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return
+	}
+	self.size = size
+	return
+}
+
+// push will durably append w to this writeBuffer, or return ErrBufferFull if it would grow larger than its max size.
+func (self *writeBuffer) push(w bufferedWrite) (err error) {
+	encoded, err := encodeWrite(w)
+	if err != nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.size+int64(len(encoded)) > self.maxSize {
+		return ErrBufferFull
+	}
+	if _, err = self.file.Write(encoded); err != nil {
+		return
+	}
+	if err = self.file.Sync(); err != nil {
+		return
+	}
+	self.size += int64(len(encoded))
+	self.writes = append(self.writes, w)
+	return
+}
+func (self *writeBuffer) peek() (result bufferedWrite, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(self.writes) == 0 {
+		return
+	}
+	return self.writes[0], true
+}
+func (self *writeBuffer) pop() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.writes = self.writes[1:]
+}
+func (self *writeBuffer) len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.writes)
+}
+func (self *writeBuffer) close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.file.Close()
+}
+
+func (self *Conn) getWriteBuffer() *writeBuffer {
+	self.bufferLock.RLock()
+	defer self.bufferLock.RUnlock()
+	return self.writeBuffer
+}
+
+// SetWriteBuffer will make this Conn queue writes in a buffer stored at path, of at most maxSize bytes, when no node of the cluster is available.
+// The buffered writes, including any left in the buffer by an earlier process, are flushed to the cluster in order when it becomes available again.
+// An empty path will close the write buffer, leaving any unflushed writes in it.
+func (self *Conn) SetWriteBuffer(path string, maxSize int64) (err error) {
+	var buffer *writeBuffer
+	if path != "" {
+		if buffer, err = openWriteBuffer(path, maxSize); err != nil {
+			return
+		}
+	}
+	self.bufferLock.Lock()
+	old := self.writeBuffer
+	self.writeBuffer = buffer
+	self.bufferLock.Unlock()
+	if old != nil {
+		old.flushLock.Lock()
+		defer old.flushLock.Unlock()
+		if err = old.close(); err != nil {
+			return
+		}
+	}
+	if buffer != nil {
+		go self.flushPeriodically(buffer)
+	}
+	return
+}
+
+// Buffered returns the number of writes in the write buffer of this Conn.
+func (self *Conn) Buffered() int {
+	if buffer := self.getWriteBuffer(); buffer != nil {
+		return buffer.len()
+	}
+	return 0
+}
+
+// attempt will perform w, and return ErrUnavailable instead of panicking if no node of the cluster answers.
+func (self *Conn) attempt(w bufferedWrite) (err error) {
+	nodes := self.ring.Nodes()
+	defer func() {
+		if e := recover(); e != nil {
+			// Concurrent operations see an empty ring when the last node is removed, and may panic in other ways.
+			if _, ok := e.(noLiveNodes); !ok && self.ring.Size() > 0 {
+				panic(e)
+			}
+			self.ring.SetNodes(nodes)
+			err = ErrUnavailable
+		}
+	}()
+	return self.send(w.Operation, w.Item)
+}
+
+// write will perform the write operation on data, or queue it in the write buffer if this Conn has one and the cluster is unavailable.
+// Writes are queued behind any already buffered writes, to keep them in order.
+func (self *Conn) write(operation string, data common.Item) (err error) {
+	buffer := self.getWriteBuffer()
+	if buffer == nil {
+		return self.send(operation, data)
+	}
+	// The token makes the nodes ignore the write if it is flushed again after already being applied, for example when a response is lost.
+	data.Token = make([]byte, tokenSize)
+	if _, err = rand.Read(data.Token); err != nil {
+		return
+	}
+	w := bufferedWrite{
+		Operation: operation,
+		Item:      data,
+	}
+	if buffer.len() == 0 {
+		if err = self.attempt(w); err != ErrUnavailable && !common.IsNoQuorum(err) {
+			return
+		}
+	}
+	return buffer.push(w)
+}
+
+// Flush will try to write the writes in the write buffer of this Conn to the cluster, in order, and return ErrUnavailable if the cluster is still unavailable.
+// Buffered writes refused by the cluster, like writes to write-once keys, are dropped since they would just fail again,
+// except writes to frozen ranges which are kept until the range is unfrozen, and writes rejected because the cluster is below its minimum size, which are kept until it has reached it. Writes rejected by overloaded nodes are retried after backing off, and never dropped.
+func (self *Conn) Flush() (err error) {
+	buffer := self.getWriteBuffer()
+	if buffer == nil {
+		return
+	}
+	return self.flush(buffer)
+}
+func (self *Conn) flush(buffer *writeBuffer) (err error) {
+	buffer.flushLock.Lock()
+	defer buffer.flushLock.Unlock()
+	if self.getWriteBuffer() != buffer {
+		return
+	}
+	flushed := 0
+	for {
+		w, ok := buffer.peek()
+		if !ok {
+			break
+		}
+		if err = self.attempt(w); err == ErrUnavailable || common.IsFrozen(err) || common.IsNoQuorum(err) {
+			break
+		}
+		err = nil
+		buffer.pop()
+		flushed++
+	}
+	if flushed > 0 {
+		if compactErr := buffer.compact(); err == nil {
+			err = compactErr
+		}
+	}
+	return
+}
+func (self *Conn) flushPeriodically(buffer *writeBuffer) {
+	for self.getWriteBuffer() == buffer {
+		if buffer.len() > 0 {
+			self.flush(buffer)
+		}
+		time.Sleep(flushInterval)
+	}
+}
@@ -2,10 +2,16 @@ package client
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
+	"github.com/zond/god/radix"
 	"github.com/zond/setop"
 	"net/rpc"
+	"regexp"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,6 +23,22 @@ const (
 	stopped
 )
 
+const (
+	// rerouteBackoff is how long to wait before retrying an operation rerouted by a node that has the same view of the ring as we do.
+	rerouteBackoff = time.Millisecond * 100
+	// minOverloadBackoff and maxOverloadBackoff bound how long to wait before retrying an operation rejected by an overloaded node.
+	minOverloadBackoff = time.Millisecond * 50
+	maxOverloadBackoff = time.Second * 5
+	// defaultQuorumTimeout is how long to keep retrying operations rejected by nodes waiting for the cluster to reach its minimum size, unless changed with SetQuorumTimeout.
+	defaultQuorumTimeout = time.Second * 30
+	// restoreBatchSize is the maximum number of entries Restore sends to a node in one call.
+	restoreBatchSize = 1024
+	// delMultiBatchSize is the maximum number of keys DelMulti sends to a node in one call.
+	delMultiBatchSize = 1024
+)
+
+var mergePattern = regexp.MustCompile("(\\(\\s*\\w+\\s*:\\s*)\\w+")
+
 func findKeys(op *setop.SetOp) (result map[string]bool) {
 	result = make(map[string]bool)
 	for _, source := range op.Sources {
@@ -42,6 +64,33 @@ func findKeys(op *setop.SetOp) (result map[string]bool) {
 // Sub trees can be 'mirrored', which means that they contain a tree mirroring its values as keys and its keys as values.
 // To mirror a sub tree, call SubAddConfiguration for the sub tree and set 'mirrored' to 'yes'.
 //
+// Immutability:
+//
+// Keys put using PutImmutable, and keys with prefixes added using AddImmutablePrefix, are write-once. Their values can't be changed or deleted,
+// and sub keys can be added to their sub trees but not changed or deleted. Writes to them fail with common.ErrImmutable, which the methods
+// prefixed Try return while the other write methods ignore it. Use SetOverride to change write-once keys anyway,
+// and SubAddConfiguration for the key setting 'immutable' to 'no' to make a key mutable again.
+//
+// Writes to keys in ranges frozen using Freeze fail with common.ErrFrozen in the same way, until the range is unfrozen using Unfreeze.
+//
+// System keyspace:
+//
+// Keys containing common.SystemPrefix are reserved for god and its subsystems, and reads and writes of them fail with common.ErrReserved.
+// They are organized in namespaces, like common.SystemBackups, that are only written by the nodes themselves and read using the methods prefixed System.
+// Some namespaces, like common.SystemLocks, are kept per key, next to the key, by the owner of the key.
+//
+// Routing:
+//
+// Conn keeps a copy of the ring and sends every operation directly to the nodes responsible for its key, without any intermediate hop.
+// Reads are sent to all replicas of the key, and the most recent answer wins, so a failing replica will not fail the read.
+// Nodes reject writes to keys they are not the owners of with common.ErrReroute, which makes Conn refresh its ring and retry.
+// Call Start to also refresh the ring regularly.
+//
+// Zones:
+//
+// Nodes can be placed in zones, like datacenters, using dhash.Node.SetZone. GetStale asks only one replica, preferring the ones in the zone
+// set using SetZone, and otherwise the ones with the lowest measured latency, to avoid cross-zone reads when slightly stale values are acceptable.
+//
 // Naming conventions:
 //
 // If there are two methods with similar names except that one has a capital S prefixed, that means that the method with the capital S will not return until all nodes responsible for the written data has received the data, while the one without the capital S will return as soon as the owner of the data has received it.
@@ -58,8 +107,36 @@ func findKeys(op *setop.SetOp) (result map[string]bool) {
 //
 // Usage: https://github.com/zond/god/blob/master/client/client_test.go
 type Conn struct {
-	ring  *common.Ring
-	state int32
+	ring        *common.Ring
+	state       int32
+	qos         int32
+	override    int32
+	bufferLock  sync.RWMutex
+	writeBuffer *writeBuffer
+	zoneLock    sync.RWMutex
+	zone        string
+	zones       map[string]string
+	latencies   map[string]time.Duration
+	// overloads contains when each node last rejected an operation because it was overloaded, and how long we waited before retrying.
+	overloadLock sync.Mutex
+	overloads    map[string]overload
+	// quorumLosses contains when each node started rejecting operations because the cluster was below its minimum size, and how long we last waited before retrying.
+	quorumLosses  map[string]quorumLoss
+	quorumTimeout int64
+	// clockOffset is how far ahead of the local clock the clock of the cluster was when last sampled, and clockSampled whether it has been.
+	clockOffset  int64
+	clockSampled int32
+}
+
+type overload struct {
+	at      time.Time
+	backoff time.Duration
+}
+
+type quorumLoss struct {
+	since   time.Time
+	at      time.Time
+	backoff time.Duration
 }
 
 // NewConnRing creates a new Conn from a given set of known nodes. For internal usage.
@@ -84,6 +161,44 @@ func MustConn(addr string) (result *Conn) {
 	}
 	return
 }
+
+// SetQoS will set the service class of all further requests from this Conn.
+// Use common.Batch for bulk jobs that should not disturb interactive users of the cluster.
+func (self *Conn) SetQoS(qos common.QoS) {
+	atomic.StoreInt32(&self.qos, int32(qos))
+}
+
+// QoS returns the service class of requests from this Conn.
+func (self *Conn) QoS() common.QoS {
+	return common.QoS(atomic.LoadInt32(&self.qos))
+}
+
+// SetOverride will make all further writes from this Conn override immutability, allowing administrators to change and delete write-once keys.
+func (self *Conn) SetOverride(override bool) {
+	if override {
+		atomic.StoreInt32(&self.override, 1)
+	} else {
+		atomic.StoreInt32(&self.override, 0)
+	}
+}
+
+// Override returns whether writes from this Conn override immutability.
+func (self *Conn) Override() bool {
+	return atomic.LoadInt32(&self.override) == 1
+}
+
+// SetQuorumTimeout will set for how long operations rejected by nodes waiting for the cluster to reach its minimum size are retried, before they return common.ErrNoQuorum.
+func (self *Conn) SetQuorumTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&self.quorumTimeout, int64(timeout))
+}
+
+// QuorumTimeout returns for how long operations rejected by nodes waiting for the cluster to reach its minimum size are retried.
+func (self *Conn) QuorumTimeout() time.Duration {
+	if timeout := atomic.LoadInt64(&self.quorumTimeout); timeout != 0 {
+		return time.Duration(timeout)
+	}
+	return defaultQuorumTimeout
+}
 func (self *Conn) hasState(s int32) bool {
 	return atomic.LoadInt32(&self.state) == s
 }
@@ -91,10 +206,105 @@ func (self *Conn) changeState(old, neu int32) bool {
 	return atomic.CompareAndSwapInt32(&self.state, old, neu)
 }
 func (self *Conn) removeNode(node common.Remote) {
+	if self.ring.Size() == 1 {
+		panic(noLiveNodes{self})
+	}
 	self.ring.Remove(node)
 	self.Reconnect()
 }
 
+// refresh will fetch the set of known nodes from node, which just told us we have an outdated view of the ring.
+func (self *Conn) refresh(node common.Remote) {
+	myRingHash := self.ring.Hash()
+	var newNodes common.Remotes
+	if err := node.Call("Discord.Nodes", 0, &newNodes); err != nil {
+		self.removeNode(node)
+		return
+	}
+	self.ring.SetNodes(newNodes)
+	if bytes.Compare(myRingHash, self.ring.Hash()) == 0 {
+		time.Sleep(rerouteBackoff)
+	}
+}
+
+// backoff will wait before retrying an operation rejected by node because it was overloaded.
+// The wait doubles, up to maxOverloadBackoff, each time node rejects operations again soon after the last wait.
+func (self *Conn) backoff(node common.Remote) {
+	self.overloadLock.Lock()
+	if self.overloads == nil {
+		self.overloads = make(map[string]overload)
+	}
+	backoff := minOverloadBackoff
+	if last, found := self.overloads[node.Addr]; found && time.Now().Sub(last.at) < last.backoff*2 {
+		if backoff = last.backoff * 2; backoff > maxOverloadBackoff {
+			backoff = maxOverloadBackoff
+		}
+	}
+	self.overloads[node.Addr] = overload{
+		at:      time.Now().Add(backoff),
+		backoff: backoff,
+	}
+	self.overloadLock.Unlock()
+	time.Sleep(backoff)
+}
+
+// awaitQuorum will wait before retrying an operation rejected by node because the cluster was below its minimum size, and return false if node has done so for longer than the quorum timeout.
+// The wait doubles, up to maxOverloadBackoff, each time node rejects operations again soon after the last wait.
+func (self *Conn) awaitQuorum(node common.Remote) bool {
+	self.overloadLock.Lock()
+	if self.quorumLosses == nil {
+		self.quorumLosses = make(map[string]quorumLoss)
+	}
+	now := time.Now()
+	loss, found := self.quorumLosses[node.Addr]
+	if !found || now.Sub(loss.at) > loss.backoff*2 {
+		loss = quorumLoss{
+			since:   now,
+			backoff: minOverloadBackoff,
+		}
+	} else if now.Sub(loss.since) > self.QuorumTimeout() {
+		delete(self.quorumLosses, node.Addr)
+		self.overloadLock.Unlock()
+		return false
+	} else if loss.backoff *= 2; loss.backoff > maxOverloadBackoff {
+		loss.backoff = maxOverloadBackoff
+	}
+	loss.at = now.Add(loss.backoff)
+	self.quorumLosses[node.Addr] = loss
+	self.overloadLock.Unlock()
+	var newNodes common.Remotes
+	if err := node.Call("Discord.Nodes", 0, &newNodes); err != nil {
+		self.removeNode(node)
+		return true
+	}
+	self.ring.SetNodes(newNodes)
+	time.Sleep(loss.backoff)
+	return true
+}
+
+// handleError will refresh the ring if err is common.ErrReroute, wait for quorum if err is common.ErrNoQuorum, back off if err is common.ErrOverloaded, and remove node if it failed to answer.
+// It returns whether the operation should be retried.
+// Other errors returned by node, like common.ErrImmutable, are not retried since they would just fail again.
+// Neither are common.ErrNoQuorum errors from nodes that have returned them for longer than the quorum timeout.
+func (self *Conn) handleError(node common.Remote, err error) bool {
+	if common.IsReroute(err) {
+		self.refresh(node)
+		return true
+	}
+	if common.IsNoQuorum(err) {
+		return self.awaitQuorum(node)
+	}
+	if common.IsOverloaded(err) {
+		self.backoff(node)
+		return true
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return false
+	}
+	self.removeNode(node)
+	return true
+}
+
 // Nodes returns the set of known nodes for this Conn.
 func (self *Conn) Nodes() common.Remotes {
 	return self.ring.Nodes()
@@ -107,6 +317,7 @@ func (self *Conn) update() {
 		self.removeNode(node)
 		return
 	}
+	self.sampleClock(node)
 	if bytes.Compare(myRingHash, otherRingHash) != 0 {
 		var newNodes common.Remotes
 		if err := node.Call("Discord.Nodes", 0, &newNodes); err != nil {
@@ -123,6 +334,26 @@ func (self *Conn) updateRegularly() {
 	}
 }
 
+// sampleClock will estimate the offset of the clock of the cluster from the clock of node, assuming the answer took as long to arrive as the question.
+func (self *Conn) sampleClock(node common.Remote) {
+	var nodeTime time.Time
+	before := time.Now()
+	if err := node.Call("Timenet.ActualTime", 0, &nodeTime); err != nil {
+		return
+	}
+	atomic.StoreInt64(&self.clockOffset, int64(nodeTime.Sub(before.Add(time.Now().Sub(before)/2))))
+	atomic.StoreInt32(&self.clockSampled, 1)
+}
+
+// Time returns the time of the cluster, estimated from the clock of a node every time the set of known nodes is updated.
+// Use it instead of the local clock when comparing with times stored in the cluster, so that clients with skewed clocks agree.
+func (self *Conn) Time() time.Time {
+	if atomic.LoadInt32(&self.clockSampled) == 0 {
+		self.sampleClock(self.ring.Random())
+	}
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&self.clockOffset)))
+}
+
 // Start will begin to regularly update the set of known nodes for this Conn.
 func (self *Conn) Start() {
 	if self.changeState(created, started) {
@@ -130,7 +361,13 @@ func (self *Conn) Start() {
 	}
 }
 
+// Stop will stop updating the set of known nodes for this Conn.
+func (self *Conn) Stop() {
+	self.changeState(started, stopped)
+}
+
 // Reconnect will try to refetch the set of known nodes from a randomly chosen currently known node.
+// It panics if none of the currently known nodes answers.
 func (self *Conn) Reconnect() {
 	node := self.ring.Random()
 	var err error
@@ -142,85 +379,80 @@ func (self *Conn) Reconnect() {
 		}
 		self.ring.Remove(node)
 		if self.ring.Size() == 0 {
-			panic(fmt.Errorf("%v doesn't know of any live nodes!", self))
+			panic(noLiveNodes{self})
 		}
 		node = self.ring.Random()
 	}
 }
 
-func (self *Conn) subClear(key []byte, sync bool) {
-	data := common.Item{
-		Key:  key,
-		Sync: sync,
-	}
-	_, _, successor := self.ring.Remotes(key)
-	var x int
-	if err := successor.Call("DHash.SubClear", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.subClear(key, sync)
+// item will return an Item for writing to key, with the service class and immutability override of this Conn.
+func (self *Conn) item(key, subKey, value []byte, sync bool) common.Item {
+	return common.Item{
+		Key:      key,
+		SubKey:   subKey,
+		Value:    value,
+		Sync:     sync,
+		QoS:      self.QoS(),
+		Override: self.Override(),
 	}
 }
-func (self *Conn) subDel(key, subKey []byte, sync bool) {
-	data := common.Item{
-		Key:    key,
-		SubKey: subKey,
-		Sync:   sync,
-	}
-	_, _, successor := self.ring.Remotes(key)
+
+// send will perform the write operation on data at the successor of its key, retrying until it succeeds or fails with an error that would just fail again.
+func (self *Conn) send(operation string, data common.Item) error {
 	var x int
-	if err := successor.Call("DHash.SubDel", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.subDel(key, subKey, sync)
+	for {
+		_, _, successor := self.ring.Remotes(data.Key)
+		err := successor.Call(operation, data, &x)
+		if err == nil {
+			return nil
+		}
+		if !self.handleError(*successor, err) {
+			return err
+		}
 	}
 }
-func (self *Conn) subPutVia(succ *common.Remote, key, subKey, value []byte, sync bool) {
-	data := common.Item{
-		Key:    key,
-		SubKey: subKey,
-		Value:  value,
-		Sync:   sync,
-	}
+func (self *Conn) subClear(key []byte, sync bool) error {
+	return self.write("DHash.SubClear", self.item(key, nil, nil, sync))
+}
+func (self *Conn) subDel(key, subKey []byte, sync bool) error {
+	return self.write("DHash.SubDel", self.item(key, subKey, nil, sync))
+}
+func (self *Conn) subPutVia(succ *common.Remote, key, subKey, value []byte, sync bool) error {
 	var x int
-	if err := succ.Call("DHash.SubPut", data, &x); err != nil {
-		self.removeNode(*succ)
+	for {
+		err := succ.Call("DHash.SubPut", self.item(key, subKey, value, sync), &x)
+		if err == nil {
+			return nil
+		}
+		if !self.handleError(*succ, err) {
+			return err
+		}
 		_, _, newSuccessor := self.ring.Remotes(key)
 		*succ = *newSuccessor
-		self.subPutVia(succ, key, subKey, value, sync)
 	}
 }
-func (self *Conn) subPut(key, subKey, value []byte, sync bool) {
-	_, _, successor := self.ring.Remotes(key)
-	self.subPutVia(successor, key, subKey, value, sync)
+func (self *Conn) subPut(key, subKey, value []byte, sync bool) error {
+	return self.write("DHash.SubPut", self.item(key, subKey, value, sync))
 }
-func (self *Conn) del(key []byte, sync bool) {
-	data := common.Item{
-		Key:  key,
-		Sync: sync,
-	}
-	_, _, successor := self.ring.Remotes(key)
-	var x int
-	if err := successor.Call("DHash.Del", data, &x); err != nil {
-		self.removeNode(*successor)
-		self.del(key, sync)
-	}
+func (self *Conn) del(key []byte, sync bool) error {
+	return self.write("DHash.Del", self.item(key, nil, nil, sync))
 }
-func (self *Conn) putVia(succ *common.Remote, key, value []byte, sync bool) {
-	data := common.Item{
-		Key:   key,
-		Value: value,
-		Sync:  sync,
-	}
+func (self *Conn) putVia(succ *common.Remote, data common.Item) error {
 	var x int
-	if err := succ.Call("DHash.Put", data, &x); err != nil {
-		self.removeNode(*succ)
-		_, _, newSuccessor := self.ring.Remotes(key)
+	for {
+		err := succ.Call("DHash.Put", data, &x)
+		if err == nil {
+			return nil
+		}
+		if !self.handleError(*succ, err) {
+			return err
+		}
+		_, _, newSuccessor := self.ring.Remotes(data.Key)
 		*succ = *newSuccessor
-		self.putVia(succ, key, value, sync)
 	}
 }
-func (self *Conn) put(key, value []byte, sync bool) {
-	_, _, successor := self.ring.Remotes(key)
-	self.putVia(successor, key, value, sync)
+func (self *Conn) put(data common.Item) error {
+	return self.write("DHash.Put", data)
 }
 func (self *Conn) mergeRecent(operation string, r common.Range, up bool) (result []common.Item) {
 	currentRedundancy := self.ring.Redundancy()
@@ -237,14 +469,34 @@ func (self *Conn) mergeRecent(operation string, r common.Range, up bool) (result
 		futures[i] = nextSuccessor.Go(operation, r, &thisResult)
 		nextKey = nextSuccessor.Pos
 	}
+	var answered []*[]common.Item
+	var failed, overloaded common.Remotes
 	for index, future := range futures {
 		<-future.Done
 		if future.Error != nil {
-			self.removeNode(nodes[index])
-			return self.mergeRecent(operation, r, up)
+			if common.IsReroute(future.Error) {
+				self.refresh(nodes[index])
+				return self.mergeRecent(operation, r, up)
+			}
+			if common.IsOverloaded(future.Error) {
+				overloaded = append(overloaded, nodes[index])
+			} else {
+				failed = append(failed, nodes[index])
+			}
+		} else {
+			answered = append(answered, results[index])
 		}
 	}
-	result = common.MergeItems(results, up)
+	if len(answered) == 0 {
+		if len(failed) == 0 {
+			self.backoff(overloaded[0])
+		} else {
+			self.removeNode(failed[0])
+		}
+		return self.mergeRecent(operation, r, up)
+	}
+	self.dropFailed(failed)
+	result = common.MergeItems(answered, up)
 	return
 }
 func (self *Conn) findRecent(operation string, data common.Item) (result *common.Item) {
@@ -262,21 +514,45 @@ func (self *Conn) findRecent(operation string, data common.Item) (result *common
 		futures[i] = nextSuccessor.Go(operation, data, thisResult)
 		nextKey = nextSuccessor.Pos
 	}
+	var failed, overloaded common.Remotes
 	for index, future := range futures {
 		<-future.Done
 		if future.Error != nil {
-			self.removeNode(nodes[index])
-			return self.findRecent(operation, data)
-		}
-		if result == nil || result.Timestamp < results[index].Timestamp {
+			if common.IsReroute(future.Error) {
+				self.refresh(nodes[index])
+				return self.findRecent(operation, data)
+			}
+			if common.IsOverloaded(future.Error) {
+				overloaded = append(overloaded, nodes[index])
+			} else {
+				failed = append(failed, nodes[index])
+			}
+		} else if result == nil || result.Timestamp < results[index].Timestamp {
 			result = results[index]
 		}
 	}
+	if result == nil {
+		if len(failed) == 0 {
+			self.backoff(overloaded[0])
+		} else {
+			self.removeNode(failed[0])
+		}
+		return self.findRecent(operation, data)
+	}
+	self.dropFailed(failed)
 	return
 }
+
+// dropFailed will remove nodes that failed while their replicas answered, without waiting for a new ring.
+// If they are still alive the regular ring updates will bring them back.
+func (self *Conn) dropFailed(failed common.Remotes) {
+	for _, node := range failed {
+		self.ring.Remove(node)
+	}
+}
 func (self *Conn) consume(c chan [2][]byte, wait *sync.WaitGroup, successor *common.Remote) {
 	for pair := range c {
-		self.putVia(successor, pair[0], pair[1], false)
+		self.putVia(successor, self.item(pair[0], nil, pair[1], false))
 	}
 	wait.Done()
 }
@@ -330,12 +606,166 @@ func (self *Conn) SubPut(key, subKey, value []byte) {
 
 // SPut will put value under key.
 func (self *Conn) SPut(key, value []byte) {
-	self.put(key, value, true)
+	self.put(self.item(key, nil, value, true))
 }
 
 // Put will put value under key.
 func (self *Conn) Put(key, value []byte) {
-	self.put(key, value, false)
+	self.put(self.item(key, nil, value, false))
+}
+
+// PutImmutable will put value under key, and make key write-once.
+func (self *Conn) PutImmutable(key, value []byte) error {
+	data := self.item(key, nil, value, true)
+	data.Immutable = true
+	return self.put(data)
+}
+
+// TryPut will put value under key, or return common.ErrImmutable if key is write-once and already has a value.
+func (self *Conn) TryPut(key, value []byte) error {
+	return self.put(self.item(key, nil, value, false))
+}
+
+// TrySubPut will put value under subKey in the sub tree defined by key, or return common.ErrImmutable if key is write-once and subKey already has a value.
+func (self *Conn) TrySubPut(key, subKey, value []byte) error {
+	return self.subPut(key, subKey, value, false)
+}
+
+// TrySSubPut will put value under subKey in the sub tree defined by key like SSubPut, or return the error that made the write fail.
+func (self *Conn) TrySSubPut(key, subKey, value []byte) error {
+	return self.subPut(key, subKey, value, true)
+}
+
+// TryDel will remove the byte value under key, or return common.ErrImmutable if key is write-once.
+func (self *Conn) TryDel(key []byte) error {
+	return self.del(key, false)
+}
+
+// TrySubDel will remove the value under subKey from the sub tree defined by key, or return common.ErrImmutable if key is write-once.
+func (self *Conn) TrySubDel(key, subKey []byte) error {
+	return self.subDel(key, subKey, false)
+}
+
+// TrySSubDel will remove the value under subKey from the sub tree defined by key like SSubDel, or return the error that made the write fail.
+func (self *Conn) TrySSubDel(key, subKey []byte) error {
+	return self.subDel(key, subKey, true)
+}
+
+// TrySubClear will remove all byte values from the sub tree defined by key, or return common.ErrImmutable if key is write-once.
+func (self *Conn) TrySubClear(key []byte) error {
+	return self.subClear(key, false)
+}
+
+// PutContent will put value under its murmur hash, and return the hash as key. Putting the same value again only adds a reference to it.
+// Content keys are write-once, and are removed when all references to them are removed using DelContent.
+func (self *Conn) PutContent(value []byte) (key []byte, err error) {
+	data := self.item(nil, nil, value, true)
+	for {
+		_, _, successor := self.ring.Remotes(murmur.HashBytes(value))
+		if err = successor.Call("DHash.PutContent", data, &key); err == nil || !self.handleError(*successor, err) {
+			return
+		}
+	}
+}
+
+// PutChunk will put value under its murmur hash as a chunk, and return the hash as key.
+// Chunks are removed by garbage collection when no manifest has referred to them for the grace period of the nodes.
+// A manifest is a sub tree configured with 'manifest' set to 'yes', and it refers to the chunks whose keys are values in it.
+func (self *Conn) PutChunk(value []byte) (key []byte, err error) {
+	data := self.item(nil, nil, value, true)
+	for {
+		_, _, successor := self.ring.Remotes(murmur.HashBytes(value))
+		if err = successor.Call("DHash.PutChunk", data, &key); err == nil || !self.handleError(*successor, err) {
+			return
+		}
+	}
+}
+
+// GetRange will return at most length bytes of the value under key, starting at offset, and whether key existed.
+// The owner of a chunked value only fetches the chunks containing the bytes, so reading a small part of a large value is cheap.
+// Keys with codecs can not be read by byte range.
+func (self *Conn) GetRange(key []byte, offset, length int) (value []byte, existed bool, err error) {
+	r := common.ByteRange{
+		Key:    key,
+		Offset: int64(offset),
+		Length: int64(length),
+		QoS:    self.QoS(),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Item
+	if err = successor.Call("DHash.GetRange", r, &result); err != nil {
+		if !self.handleError(*successor, err) {
+			return
+		}
+		return self.GetRange(key, offset, length)
+	}
+	return result.Value, result.Exists, nil
+}
+
+// AppendValue will append data to the value under key, or put data under key if it has no value.
+// The owner of a chunked value only puts the new bytes as chunks, so growing a large value doesn't rewrite it.
+// Keys with codecs can not be appended to, and write-once keys can only be appended to when they have no value.
+func (self *Conn) AppendValue(key, data []byte) error {
+	return self.write("DHash.AppendValue", self.item(key, nil, data, false))
+}
+
+// NextID will return a unique ID generated by a random node, made of the time of the cluster in milliseconds, the node id of the node and a sequence number.
+// IDs are roughly time ordered. Use common.DecodeID to find the parts of an ID.
+// It returns common.ErrNoIDNode if the node wasn't given a node id.
+func (self *Conn) NextID() (id int64, err error) {
+	node := self.ring.Random()
+	if err = node.Call("DHash.NextID", 0, &id); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.NextID()
+	}
+	return
+}
+
+// CollectGarbage will make all known nodes collect their garbage chunks right away, and return the number of removed chunks.
+// Chunks found unreferenced for the first time are only recorded as unreferenced, and removed by a later collection after the grace period.
+func (self *Conn) CollectGarbage() (removed int, err error) {
+	for _, node := range self.ring.Nodes() {
+		var x int
+		if e := node.Call("DHash.CollectGarbage", 0, &x); e != nil && err == nil {
+			err = e
+		}
+		removed += x
+	}
+	return
+}
+
+// SetRetention will make the owner of the sub tree defined by key remove its oldest members beyond maxMembers, and the members put longer than maxAge ago.
+// Zero maxMembers or maxAge means no limit. Members beyond maxMembers are removed right after the put adding them, and members older than maxAge every clean interval.
+func (self *Conn) SetRetention(key []byte, maxMembers int, maxAge time.Duration) {
+	self.SubAddConfiguration(key, common.MaxMembersConf, strconv.Itoa(maxMembers))
+	self.SubAddConfiguration(key, common.MaxAgeConf, maxAge.String())
+}
+
+// Trim will make all known nodes enforce the retention policies of their sub trees right away, and return the number of removed members.
+func (self *Conn) Trim() (removed int, err error) {
+	for _, node := range self.ring.Nodes() {
+		var x int
+		if e := node.Call("DHash.Trim", 0, &x); e != nil && err == nil {
+			err = e
+		}
+		removed += x
+	}
+	return
+}
+
+// DelContent will remove a reference to the content under key, and remove the content itself when no references remain.
+func (self *Conn) DelContent(key []byte) (err error) {
+	_, _, successor := self.ring.Remotes(key)
+	var x int
+	if err = successor.Call("DHash.DelContent", self.item(key, nil, nil, true), &x); err != nil {
+		if !self.handleError(*successor, err) {
+			return
+		}
+		return self.DelContent(key)
+	}
+	return
 }
 
 // Dump will return a channel to send multiple key/value pairs through. When finished, close the channel and #Wait for the *sync.WaitGroup.
@@ -386,11 +816,90 @@ func (self *Conn) Del(key []byte) {
 	self.del(key, false)
 }
 
+// DelMulti will remove the byte values under keys, sending the keys owned by each node to it in batches, to all nodes in parallel, instead of one call per key.
+// It returns the error of removing each key, or nil if it was removed. Unless async is set the nodes wait for the replicas to remove the keys before answering.
+// Unlike the other writes it is not buffered when the cluster is unavailable.
+func (self *Conn) DelMulti(keys [][]byte, async bool) (errs []error) {
+	errs = make([]error, len(keys))
+	pending := make([]int, len(keys))
+	for index := range pending {
+		pending[index] = index
+	}
+	for len(pending) > 0 {
+		pending = self.delMulti(keys, pending, !async, errs)
+	}
+	return
+}
+
+// delMulti will remove the keys at indices from their owners, record the errors in errs, and return the indices of the keys to retry with a refreshed ring.
+func (self *Conn) delMulti(keys [][]byte, indices []int, synchronous bool, errs []error) (retry []int) {
+	parts := make(map[string][]int)
+	owners := make(map[string]common.Remote)
+	for _, index := range indices {
+		_, _, successor := self.ring.Remotes(keys[index])
+		parts[successor.Addr] = append(parts[successor.Addr], index)
+		owners[successor.Addr] = *successor
+	}
+	lock := new(sync.Mutex)
+	wait := new(sync.WaitGroup)
+	for addr, part := range parts {
+		wait.Add(1)
+		go func(owner common.Remote, part []int) {
+			defer wait.Done()
+			for len(part) > 0 {
+				batch := part
+				if len(batch) > delMultiBatchSize {
+					batch = batch[:delMultiBatchSize]
+				}
+				part = part[len(batch):]
+				items := make([]common.Item, len(batch))
+				for i, index := range batch {
+					items[i] = self.item(keys[index], nil, nil, synchronous)
+				}
+				var results []string
+				err := owner.Call("DHash.DelMulti", items, &results)
+				lock.Lock()
+				if err != nil {
+					if self.handleError(owner, err) {
+						retry = append(retry, batch...)
+						retry = append(retry, part...)
+						part = nil
+					} else {
+						for _, index := range batch {
+							errs[index] = err
+						}
+					}
+				} else {
+					refreshed := false
+					for i, index := range batch {
+						if results[i] == "" {
+							continue
+						}
+						if e := rpc.ServerError(results[i]); common.IsReroute(e) || common.IsNoQuorum(e) {
+							if !refreshed {
+								self.handleError(owner, e)
+								refreshed = true
+							}
+							retry = append(retry, index)
+						} else {
+							errs[index] = e
+						}
+					}
+				}
+				lock.Unlock()
+			}
+		}(owners[addr], part)
+	}
+	wait.Wait()
+	return
+}
+
 // MirrorReverseIndexOf will return the the distance from the end for subKey, looking at the mirror tree of the sub tree defined by key.
 func (self *Conn) MirrorReverseIndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
@@ -407,6 +916,7 @@ func (self *Conn) MirrorIndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
@@ -423,6 +933,7 @@ func (self *Conn) ReverseIndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
@@ -439,6 +950,7 @@ func (self *Conn) IndexOf(key, subKey []byte) (index int, existed bool) {
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	var result common.Index
@@ -450,10 +962,44 @@ func (self *Conn) IndexOf(key, subKey []byte) (index int, existed bool) {
 	return
 }
 
+// Rank will return the number of members with higher values than member in the mirrored sub tree defined by key, with members of equal values ordered by key.
+// The member with the highest value has rank 0. Key must be mirrored, by calling SubAddConfiguration for it setting 'mirrored' to 'yes'.
+func (self *Conn) Rank(key, member []byte) (rank int, existed bool) {
+	data := common.Item{
+		Key:    key,
+		SubKey: member,
+		QoS:    self.QoS(),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	var result common.Index
+	if err := successor.Call("DHash.Rank", data, &result); err != nil {
+		self.removeNode(*successor)
+		return self.Rank(key, member)
+	}
+	rank, existed = result.N, result.Existed
+	return
+}
+
+// TopN will return the n members with the highest values in the mirrored sub tree defined by key, highest first, with their values and ranks.
+func (self *Conn) TopN(key []byte, n int) (result []common.Item) {
+	r := common.Range{
+		Key: key,
+		Len: n,
+		QoS: self.QoS(),
+	}
+	_, _, successor := self.ring.Remotes(key)
+	if err := successor.Call("DHash.TopN", r, &result); err != nil {
+		self.removeNode(*successor)
+		return self.TopN(key, n)
+	}
+	return
+}
+
 // Next will return the next key and value after key.
 func (self *Conn) Next(key []byte) (nextKey, nextValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -479,6 +1025,7 @@ func (self *Conn) Next(key []byte) (nextKey, nextValue []byte, existed bool) {
 func (self *Conn) Prev(key []byte) (prevKey, prevValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -508,6 +1055,7 @@ func (self *Conn) MirrorCount(key, min, max []byte, mininc, maxinc bool) (result
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.MirrorCount", r, &result); err != nil {
@@ -525,6 +1073,7 @@ func (self *Conn) Count(key, min, max []byte, mininc, maxinc bool) (result int)
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	_, _, successor := self.ring.Remotes(key)
 	if err := successor.Call("DHash.Count", r, &result); err != nil {
@@ -539,6 +1088,7 @@ func (self *Conn) MirrorNextIndex(key []byte, index int) (foundKey, foundValue [
 	data := common.Item{
 		Key:   key,
 		Index: index,
+		QoS:   self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -555,6 +1105,7 @@ func (self *Conn) MirrorPrevIndex(key []byte, index int) (foundKey, foundValue [
 	data := common.Item{
 		Key:   key,
 		Index: index,
+		QoS:   self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -571,6 +1122,7 @@ func (self *Conn) NextIndex(key []byte, index int) (foundKey, foundValue []byte,
 	data := common.Item{
 		Key:   key,
 		Index: index,
+		QoS:   self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -587,6 +1139,7 @@ func (self *Conn) PrevIndex(key []byte, index int) (foundKey, foundValue []byte,
 	data := common.Item{
 		Key:   key,
 		Index: index,
+		QoS:   self.QoS(),
 	}
 	result := &common.Item{}
 	_, _, successor := self.ring.Remotes(key)
@@ -615,6 +1168,7 @@ func (self *Conn) MirrorReverseSliceIndex(key []byte, min, max *int) (result []c
 		MaxIndex: ma,
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
+		QoS:      self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorReverseSliceIndex", r, false)
 	return
@@ -637,6 +1191,7 @@ func (self *Conn) MirrorSliceIndex(key []byte, min, max *int) (result []common.I
 		MaxIndex: ma,
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
+		QoS:      self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorSliceIndex", r, true)
 	return
@@ -651,6 +1206,7 @@ func (self *Conn) MirrorReverseSlice(key, min, max []byte, mininc, maxinc bool)
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorReverseSlice", r, false)
 	return
@@ -665,6 +1221,7 @@ func (self *Conn) MirrorSlice(key, min, max []byte, mininc, maxinc bool) (result
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorSlice", r, true)
 	return
@@ -678,6 +1235,7 @@ func (self *Conn) MirrorSliceLen(key, min []byte, mininc bool, maxRes int) (resu
 		Min:    min,
 		MinInc: mininc,
 		Len:    maxRes,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorSliceLen", r, true)
 	return
@@ -691,6 +1249,7 @@ func (self *Conn) MirrorReverseSliceLen(key, max []byte, maxinc bool, maxRes int
 		Max:    max,
 		MaxInc: maxinc,
 		Len:    maxRes,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.MirrorReverseSliceLen", r, false)
 	return
@@ -713,6 +1272,7 @@ func (self *Conn) ReverseSliceIndex(key []byte, min, max *int) (result []common.
 		MaxIndex: ma,
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
+		QoS:      self.QoS(),
 	}
 	result = self.mergeRecent("DHash.ReverseSliceIndex", r, false)
 	return
@@ -735,6 +1295,7 @@ func (self *Conn) SliceIndex(key []byte, min, max *int) (result []common.Item) {
 		MaxIndex: ma,
 		MinInc:   min != nil,
 		MaxInc:   max != nil,
+		QoS:      self.QoS(),
 	}
 	result = self.mergeRecent("DHash.SliceIndex", r, true)
 	return
@@ -749,6 +1310,7 @@ func (self *Conn) ReverseSlice(key, min, max []byte, mininc, maxinc bool) (resul
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.ReverseSlice", r, false)
 	return
@@ -763,11 +1325,52 @@ func (self *Conn) Slice(key, min, max []byte, mininc, maxinc bool) (result []com
 		Max:    max,
 		MinInc: mininc,
 		MaxInc: maxinc,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.Slice", r, true)
 	return
 }
 
+// Query will return the items matching q, executed by the nodes owning them.
+// If q.Range.Key is nil the top level tree is queried, otherwise the sub tree defined by q.Range.Key.
+// If q.Range.Len is positive at most that many items will be returned.
+// It panics if a node refuses q with an error that would just fail again, like an invalid filter.
+func (self *Conn) Query(q common.Query) (result []common.Item) {
+	q.Range.QoS = self.QoS()
+	if q.Range.Key != nil {
+		_, _, successor := self.ring.Remotes(q.Range.Key)
+		if err := successor.Call("DHash.Query", q, &result); err != nil {
+			if !self.handleError(*successor, err) {
+				panic(err)
+			}
+			return self.Query(q)
+		}
+		return
+	}
+	nodes := self.ring.Nodes()
+	futures := make([]*rpc.Call, len(nodes))
+	results := make([]*[]common.Item, len(nodes))
+	for index, node := range nodes {
+		var thisResult []common.Item
+		results[index] = &thisResult
+		futures[index] = node.Go("DHash.Query", q, &thisResult)
+	}
+	for index, future := range futures {
+		<-future.Done
+		if future.Error != nil {
+			if !self.handleError(nodes[index], future.Error) {
+				panic(future.Error)
+			}
+			return self.Query(q)
+		}
+	}
+	result = common.MergeItems(results, true)
+	if q.Range.Len > 0 && len(result) > q.Range.Len {
+		result = result[:q.Range.Len]
+	}
+	return
+}
+
 // SliceLen will return at most maxRes elements after min in the sub tree defined by key.
 // A min of nil will return from the start.
 func (self *Conn) SliceLen(key, min []byte, mininc bool, maxRes int) (result []common.Item) {
@@ -776,6 +1379,7 @@ func (self *Conn) SliceLen(key, min []byte, mininc bool, maxRes int) (result []c
 		Min:    min,
 		MinInc: mininc,
 		Len:    maxRes,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.SliceLen", r, true)
 	return
@@ -789,6 +1393,7 @@ func (self *Conn) ReverseSliceLen(key, max []byte, maxinc bool, maxRes int) (res
 		Max:    max,
 		MaxInc: maxinc,
 		Len:    maxRes,
+		QoS:    self.QoS(),
 	}
 	result = self.mergeRecent("DHash.ReverseSliceLen", r, false)
 	return
@@ -799,6 +1404,7 @@ func (self *Conn) SubMirrorPrev(key, subKey []byte) (prevKey, prevValue []byte,
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	result := self.findRecent("DHash.SubMirrorPrev", data)
 	prevKey, prevValue, existed = result.Key, result.Value, result.Exists
@@ -810,6 +1416,7 @@ func (self *Conn) SubMirrorNext(key, subKey []byte) (nextKey, nextValue []byte,
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	result := self.findRecent("DHash.SubMirrorNext", data)
 	nextKey, nextValue, existed = result.Key, result.Value, result.Exists
@@ -821,6 +1428,7 @@ func (self *Conn) SubPrev(key, subKey []byte) (prevKey, prevValue []byte, existe
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	result := self.findRecent("DHash.SubPrev", data)
 	prevKey, prevValue, existed = result.Key, result.Value, result.Exists
@@ -832,6 +1440,7 @@ func (self *Conn) SubNext(key, subKey []byte) (nextKey, nextValue []byte, existe
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	result := self.findRecent("DHash.SubNext", data)
 	nextKey, nextValue, existed = result.Key, result.Value, result.Exists
@@ -842,6 +1451,7 @@ func (self *Conn) SubNext(key, subKey []byte) (nextKey, nextValue []byte, existe
 func (self *Conn) MirrorLast(key []byte) (lastKey, lastValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := self.findRecent("DHash.MirrorLast", data)
 	lastKey, lastValue, existed = result.Key, result.Value, result.Exists
@@ -852,6 +1462,7 @@ func (self *Conn) MirrorLast(key []byte) (lastKey, lastValue []byte, existed boo
 func (self *Conn) MirrorFirst(key []byte) (firstKey, firstValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := self.findRecent("DHash.MirrorFirst", data)
 	firstKey, firstValue, existed = result.Key, result.Value, result.Exists
@@ -862,6 +1473,7 @@ func (self *Conn) MirrorFirst(key []byte) (firstKey, firstValue []byte, existed
 func (self *Conn) Last(key []byte) (lastKey, lastValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := self.findRecent("DHash.Last", data)
 	lastKey, lastValue, existed = result.Key, result.Value, result.Exists
@@ -872,6 +1484,7 @@ func (self *Conn) Last(key []byte) (lastKey, lastValue []byte, existed bool) {
 func (self *Conn) First(key []byte) (firstKey, firstValue []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := self.findRecent("DHash.First", data)
 	firstKey, firstValue, existed = result.Key, result.Value, result.Exists
@@ -883,6 +1496,7 @@ func (self *Conn) SubGet(key, subKey []byte) (value []byte, existed bool) {
 	data := common.Item{
 		Key:    key,
 		SubKey: subKey,
+		QoS:    self.QoS(),
 	}
 	result := self.findRecent("DHash.SubGet", data)
 	if result.Value != nil {
@@ -897,6 +1511,7 @@ func (self *Conn) SubGet(key, subKey []byte) (value []byte, existed bool) {
 func (self *Conn) Get(key []byte) (value []byte, existed bool) {
 	data := common.Item{
 		Key: key,
+		QoS: self.QoS(),
 	}
 	result := self.findRecent("DHash.Get", data)
 	if result.Value != nil {
@@ -907,6 +1522,37 @@ func (self *Conn) Get(key []byte) (value []byte, existed bool) {
 	return
 }
 
+// DebugGet will return the value under key like Get, and the Timing of the replica whose value was returned.
+func (self *Conn) DebugGet(key []byte) (value []byte, existed bool, timing common.Timing) {
+	data := common.Item{
+		Key:   key,
+		QoS:   self.QoS(),
+		Debug: true,
+	}
+	result := self.findRecent("DHash.Get", data)
+	if result.Value != nil {
+		value, existed = result.Value, result.Exists
+	}
+	if result.Timing != nil {
+		timing = *result.Timing
+	}
+	return
+}
+
+// DebugPut will put value under key like SPut, and return the Timing of the owner of key.
+// Unlike SPut it is not buffered when the cluster is unavailable.
+func (self *Conn) DebugPut(key, value []byte) (timing common.Timing, err error) {
+	data := self.item(key, nil, value, true)
+	_, _, successor := self.ring.Remotes(key)
+	if err = successor.Call("DHash.DebugPut", data, &timing); err != nil {
+		if !self.handleError(*successor, err) {
+			return
+		}
+		return self.DebugPut(key, value)
+	}
+	return
+}
+
 // DescribeTree will return a string representation of the complete tree in the node at pos.
 // Used for debug purposes, don't do it on big databases!
 func (self *Conn) DescribeTree(pos []byte) (result string, err error) {
@@ -919,6 +1565,18 @@ func (self *Conn) DescribeTree(pos []byte) (result string, err error) {
 	return
 }
 
+// TreePage will return a page of the entries, as stored, in the node at pos, filtered and limited by r.
+// Use the Next of the page as the From of r to get the next page, to browse large nodes without fetching all their data at once.
+func (self *Conn) TreePage(pos []byte, r common.TreePageRequest) (result common.TreePage, err error) {
+	_, match, _ := self.ring.Remotes(pos)
+	if match == nil {
+		err = fmt.Errorf("No node with position %v found", common.HexEncode(pos))
+		return
+	}
+	err = match.Call("DHash.TreePage", r, &result)
+	return
+}
+
 // DescribeTree will return a string representation of the complete trees of all known nodes.
 // Used for debug purposes, don't do it on big databases!
 func (self *Conn) DescribeAllTrees() string {
@@ -931,6 +1589,75 @@ func (self *Conn) DescribeAllTrees() string {
 	return string(buf.Bytes())
 }
 
+// ClusterStats will return the Stats of all nodes in the cluster, and their totals, as collected by one of the nodes.
+func (self *Conn) ClusterStats() (result common.ClusterStats, err error) {
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.ClusterStats", 0, &result); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.ClusterStats()
+	}
+	return
+}
+
+// Heatmap will return the number of keys and bytes in each of n equally sized segments of the ring, as collected by one of the nodes.
+func (self *Conn) Heatmap(n int) (result common.Heatmap, err error) {
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.Heatmap", n, &result); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.Heatmap(n)
+	}
+	return
+}
+
+// Workers will return what the background workers of each known node are doing, and when they last ran, by node address.
+func (self *Conn) Workers() (result map[string][]common.WorkerStatus, err error) {
+	result = make(map[string][]common.WorkerStatus)
+	for _, node := range self.ring.Nodes() {
+		var statuses []common.WorkerStatus
+		if e := node.Call("DHash.Workers", 0, &statuses); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		result[node.Addr] = statuses
+	}
+	return
+}
+
+// Divergences will return the divergent values the syncs of each known node have found and copied between replicas, by node address.
+// If key is not nil, only the values of key, or within the sub tree of key, are returned. Nodes only record them after dhash.Node#SetForensics.
+func (self *Conn) Divergences(key []byte) (result map[string][]common.Divergence, err error) {
+	result = make(map[string][]common.Divergence)
+	for _, node := range self.ring.Nodes() {
+		var divergences []common.Divergence
+		if e := node.Call("DHash.Divergences", key, &divergences); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		result[node.Addr] = divergences
+	}
+	return
+}
+
+// RiskReport will return an estimate of the data loss exposure of the cluster, as collected by one of the nodes.
+func (self *Conn) RiskReport() (result common.RiskReport, err error) {
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.RiskReport", 0, &result); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.RiskReport()
+	}
+	return
+}
+
 // DescribeAllNodes will return the description structures of all known nodes.
 func (self *Conn) DescribeAllNodes() (result []common.DHashDescription) {
 	for _, rem := range self.ring.Nodes() {
@@ -952,6 +1679,165 @@ func (self *Conn) DescribeNode(pos []byte) (result common.DHashDescription, err
 	return
 }
 
+func (self *Conn) callNode(pos []byte, method string, arg, result interface{}) error {
+	_, match, _ := self.ring.Remotes(pos)
+	if match == nil {
+		return fmt.Errorf("No node with position %v found", common.HexEncode(pos))
+	}
+	return match.Call(method, arg, result)
+}
+
+// SyncNode will make the node at pos synchronize the data it owns with its replicas right away.
+func (self *Conn) SyncNode(pos []byte) error {
+	var x int
+	return self.callNode(pos, "DHash.Sync", 0, &x)
+}
+
+// DecommissionNode will make the node at pos push the data it owns to its replicas and then stop.
+func (self *Conn) DecommissionNode(pos []byte) error {
+	var x int
+	return self.callNode(pos, "DHash.Decommission", 0, &x)
+}
+
+// PauseMigration will stop all known nodes from migrating until ResumeMigration is called, for example during maintenance or bulk loads.
+func (self *Conn) PauseMigration() error {
+	return self.callAll("DHash.PauseMigration")
+}
+
+// ResumeMigration will let all known nodes migrate again after PauseMigration.
+func (self *Conn) ResumeMigration() error {
+	return self.callAll("DHash.ResumeMigration")
+}
+
+// PauseSync will stop all known nodes from periodically synchronizing with their replicas until ResumeSync is called.
+func (self *Conn) PauseSync() error {
+	return self.callAll("DHash.PauseSync")
+}
+
+// ResumeSync will let all known nodes synchronize periodically again after PauseSync.
+func (self *Conn) ResumeSync() error {
+	return self.callAll("DHash.ResumeSync")
+}
+
+// SetRedundancy will change the number of copies of each key kept by the cluster to r.
+// Lowering it is refused unless every node has its data on the replicas it would have with r, and the excess copies are kept for the redundancy grace period of the nodes.
+func (self *Conn) SetRedundancy(r int) (err error) {
+	var x int
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.SetRedundancy", r, &x); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.SetRedundancy(r)
+	}
+	return
+}
+
+// Freeze will make all known nodes reject writes to keys from min, inclusive, to max, exclusive, with common.ErrFrozen until Unfreeze is called with the same keys.
+// A nil min or max means the range is unbounded in that direction. Use it to keep a range stable during application migrations or repairs.
+func (self *Conn) Freeze(min, max []byte) error {
+	return self.callAllArg("DHash.Freeze", common.Range{Min: min, Max: max, MinInc: true})
+}
+
+// Unfreeze will let all known nodes accept writes to keys from min to max again after Freeze.
+func (self *Conn) Unfreeze(min, max []byte) error {
+	return self.callAllArg("DHash.Unfreeze", common.Range{Min: min, Max: max, MinInc: true})
+}
+
+// Frozen returns the ranges one of the nodes rejects writes to.
+func (self *Conn) Frozen() (result []common.Range, err error) {
+	node := self.ring.Nodes()[0]
+	if err = node.Call("DHash.Frozen", 0, &result); err != nil {
+		if !self.handleError(node, err) {
+			return
+		}
+		return self.Frozen()
+	}
+	return
+}
+
+// callAll will call method on all known nodes, and return the first error encountered.
+func (self *Conn) callAll(method string) (err error) {
+	return self.callAllArg(method, 0)
+}
+func (self *Conn) callAllArg(method string, arg interface{}) (err error) {
+	var x int
+	for _, node := range self.ring.Nodes() {
+		if e := node.Call(method, arg, &x); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// RestoreReport will return the report of what the node at pos found when restoring its persisted data.
+func (self *Conn) RestoreReport(pos []byte) (result persistence.Report, err error) {
+	err = self.callNode(pos, "DHash.RestoreReport", 0, &result)
+	return
+}
+
+// Snapshot will return a compressed snapshot of the data owned by all known nodes, as encoded by radix.EncodeSnapshot.
+func (self *Conn) Snapshot() (result []byte, err error) {
+	var snapshot []radix.SnapshotEntry
+	for _, node := range self.ring.Nodes() {
+		var encoded []byte
+		if err = node.Call("DHash.Snapshot", 0, &encoded); err != nil {
+			return
+		}
+		var part []radix.SnapshotEntry
+		if part, err = radix.DecodeSnapshot(encoded); err != nil {
+			return
+		}
+		snapshot = append(snapshot, part...)
+	}
+	return radix.EncodeSnapshot(snapshot)
+}
+
+// Restore will apply a snapshot created by Snapshot to the nodes owning its entries, and return the number of changed entries.
+// Only entries newer than the ones already in the database will be applied.
+func (self *Conn) Restore(encoded []byte) (changed int, err error) {
+	snapshot, err := radix.DecodeSnapshot(encoded)
+	if err != nil {
+		return
+	}
+	return self.restoreEntries(snapshot)
+}
+
+// restoreEntries will send snapshot to the nodes currently owning its entries, in batches of at most restoreBatchSize entries per node.
+func (self *Conn) restoreEntries(snapshot []radix.SnapshotEntry) (changed int, err error) {
+	parts := make(map[string][]radix.SnapshotEntry)
+	owners := make(map[string]common.Remote)
+	flush := func(addr string) (err error) {
+		var encoded []byte
+		if encoded, err = radix.EncodeSnapshot(parts[addr]); err != nil {
+			return
+		}
+		var tmp int
+		if err = owners[addr].Call("DHash.Restore", encoded, &tmp); err != nil {
+			return
+		}
+		changed += tmp
+		delete(parts, addr)
+		return
+	}
+	for _, entry := range snapshot {
+		_, _, successor := self.ring.Remotes(entry.Key)
+		parts[successor.Addr] = append(parts[successor.Addr], entry)
+		owners[successor.Addr] = *successor
+		if len(parts[successor.Addr]) >= restoreBatchSize {
+			if err = flush(successor.Addr); err != nil {
+				return
+			}
+		}
+	}
+	for addr := range parts {
+		if err = flush(addr); err != nil {
+			return
+		}
+	}
+	return
+}
+
 // SubSize will return the size of the sub tree defined by key.
 func (self *Conn) SubSize(key []byte) (result int) {
 	_, _, successor := self.ring.Remotes(key)
@@ -989,15 +1875,21 @@ func (self *Conn) Describe() string {
 // Either expr.Op or expr.Code has to be set.
 //
 // If expr.Op is nil expr.Code will be parsed using SetOpParser to provide expr.Op.
+//
+// If expr.Code uses merge functions registered using dhash.RegisterMerge it will be parsed by the server, and expr.Op must be nil.
 func (self *Conn) SetExpression(expr setop.SetExpression) (result []setop.SetOpResult) {
-	if expr.Op == nil {
-		expr.Op = setop.MustParse(expr.Code)
+	op := expr.Op
+	if op == nil {
+		var err error
+		if op, err = setop.NewSetOpParser(expr.Code).Parse(); err != nil {
+			op = setop.MustParse(mergePattern.ReplaceAllString(expr.Code, "${1}Append"))
+		}
 	}
 	var biggestKey []byte
 	biggestSize := 0
 	var thisSize int
 
-	for key, _ := range findKeys(expr.Op) {
+	for key, _ := range findKeys(op) {
 		thisSize = self.SubSize([]byte(key))
 		if biggestKey == nil {
 			biggestKey = []byte(key)
@@ -1011,13 +1903,66 @@ func (self *Conn) SetExpression(expr setop.SetExpression) (result []setop.SetOpR
 	var results []setop.SetOpResult
 	err := successor.Call("DHash.SetExpression", expr, &results)
 	for err != nil {
-		self.removeNode(*successor)
+		if !self.handleError(*successor, err) {
+			panic(err)
+		}
 		_, _, successor = self.ring.Remotes(biggestKey)
 		err = successor.Call("DHash.SetExpression", expr, &results)
 	}
 	return results
 }
 
+func (self *Conn) lock(lease common.Lease) (result common.Lease) {
+	key := common.SystemKeyFor(common.SystemLocks, lease.Key)
+	_, _, successor := self.ring.Remotes(key)
+	err := successor.Call("DHash.Lock", lease, &result)
+	for err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			panic(err)
+		}
+		self.removeNode(*successor)
+		_, _, successor = self.ring.Remotes(key)
+		err = successor.Call("DHash.Lock", lease, &result)
+	}
+	return
+}
+
+// Lock will try to acquire a cluster wide lease on key for ttl, and return the token identifying the lease if successful.
+func (self *Conn) Lock(key []byte, ttl time.Duration) (token []byte, acquired bool) {
+	result := self.lock(common.Lease{
+		Key:      key,
+		Duration: ttl,
+	})
+	return result.Token, result.Acquired
+}
+
+// Renew will try to extend the lease on key identified by token to ttl from now.
+// It will return false if the lease has expired and been acquired by someone else.
+func (self *Conn) Renew(key, token []byte, ttl time.Duration) (renewed bool) {
+	return self.lock(common.Lease{
+		Key:      key,
+		Token:    token,
+		Duration: ttl,
+	}).Acquired
+}
+
+// Unlock will release the lease on key identified by token. It will return false if the lease had already expired or been released.
+func (self *Conn) Unlock(key, token []byte) (released bool) {
+	lease := common.Lease{
+		Key:   key,
+		Token: token,
+	}
+	leaseKey := common.SystemKeyFor(common.SystemLocks, key)
+	_, _, successor := self.ring.Remotes(leaseKey)
+	err := successor.Call("DHash.Unlock", lease, &released)
+	for err != nil {
+		self.removeNode(*successor)
+		_, _, successor = self.ring.Remotes(leaseKey)
+		err = successor.Call("DHash.Unlock", lease, &released)
+	}
+	return
+}
+
 // Configuration will return the configuration for the entire cluster.
 // Not internally used for anything right now.
 func (self *Conn) Configuration() (conf map[string]string) {
@@ -1038,7 +1983,7 @@ func (self *Conn) SubConfiguration(key []byte) (conf map[string]string) {
 	_, _, successor := self.ring.Remotes(nil)
 	if err := successor.Call("DHash.SubConfiguration", key, &result); err != nil {
 		self.removeNode(*successor)
-		return self.Configuration()
+		return self.SubConfiguration(key)
 	}
 	return result.Data
 }
@@ -1071,6 +2016,28 @@ func (self *Conn) SubAddConfiguration(treeKey []byte, key, value string) {
 	var x int
 	if err := successor.Call("DHash.SubAddConfiguration", conf, &x); err != nil {
 		self.removeNode(*successor)
-		self.AddConfiguration(key, value)
+		self.SubAddConfiguration(treeKey, key, value)
+	}
+}
+
+// AddImmutablePrefix will make all keys starting with prefix write-once, by adding it to the configuration of all currently known nodes.
+func (self *Conn) AddImmutablePrefix(prefix []byte) {
+	self.configureAll(common.ImmutablePrefixConf+hex.EncodeToString(prefix), "yes")
+}
+
+// RemoveImmutablePrefix will make keys starting with prefix mutable again, unless they were put using PutImmutable.
+func (self *Conn) RemoveImmutablePrefix(prefix []byte) {
+	self.configureAll(common.ImmutablePrefixConf+hex.EncodeToString(prefix), "no")
+}
+func (self *Conn) configureAll(key, value string) {
+	conf := common.ConfItem{
+		Key:   key,
+		Value: value,
+	}
+	var x int
+	for _, node := range self.ring.Nodes() {
+		if err := node.Call("DHash.AddConfiguration", conf, &x); err != nil {
+			self.removeNode(node)
+		}
 	}
 }
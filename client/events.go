@@ -0,0 +1,151 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// eventsWait is how long a subscription asks each node to wait for new events before asking again.
+const eventsWait = time.Second * 4
+
+// EventListener is a function listening to the events reported by the nodes of a cluster.
+type EventListener func(event common.Event) (keep bool)
+
+// Events will return the events the node at addr reported after since, waiting at most wait for one if there are none.
+// A negative since returns no events, only the sequence number of the last event of the node.
+func (self *Conn) Events(addr string, since int64, wait time.Duration) (result common.Events, err error) {
+	err = common.Switch.Call(addr, "DHash.Events", common.EventsRequest{Since: since, Wait: wait}, &result)
+	return
+}
+
+// Expiries will return the common.EventExpired events the node at addr reported after since, waiting at most wait for one if there are none.
+// They are kept apart from the other events of the node, with their own sequence numbers.
+func (self *Conn) Expiries(addr string, since int64, wait time.Duration) (result common.Events, err error) {
+	err = common.Switch.Call(addr, "DHash.Expiries", common.EventsRequest{Since: since, Wait: wait}, &result)
+	return
+}
+
+// subscription follows the nodes of a Conn, and delivers their events from one stream to its listener one at a time.
+type subscription struct {
+	conn        *Conn
+	events      func(addr string, since int64, wait time.Duration) (common.Events, error)
+	listener    EventListener
+	deliverLock *sync.Mutex
+	lock        *sync.Mutex
+	following   map[string]bool
+	stopped     bool
+}
+
+// Subscribe will make l get the events reported by the known nodes from now on, one at a time, until it returns false.
+// Nodes that join the ring of this Conn later are followed from their first event, so l will get their common.EventJoined.
+// Events from different nodes are delivered in the order they arrive. Call Start to keep the ring of this Conn up to date.
+// When a node has dropped events before they were delivered, l gets a common.EventMissed with the number of dropped events.
+func (self *Conn) Subscribe(l EventListener) {
+	self.subscribe(self.Events, l)
+}
+
+// SubscribeExpiries will make l get the common.EventExpired events reported by the known nodes from now on, like Subscribe.
+func (self *Conn) SubscribeExpiries(l EventListener) {
+	self.subscribe(self.Expiries, l)
+}
+
+func (self *Conn) subscribe(events func(addr string, since int64, wait time.Duration) (common.Events, error), l EventListener) {
+	sub := &subscription{
+		conn:        self,
+		events:      events,
+		listener:    l,
+		deliverLock: new(sync.Mutex),
+		lock:        new(sync.Mutex),
+		following:   make(map[string]bool),
+	}
+	for _, node := range self.ring.Nodes() {
+		since := int64(-1)
+		if current, err := events(node.Addr, -1, 0); err == nil {
+			since = current.Seq
+		}
+		sub.follow(node.Addr, since)
+	}
+	self.ring.AddChangeListener(func(ring *common.Ring) bool {
+		for _, node := range ring.Nodes() {
+			sub.follow(node.Addr, 0)
+		}
+		return !sub.isStopped()
+	})
+}
+func (self *subscription) isStopped() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stopped
+}
+func (self *subscription) deliver(event common.Event) bool {
+	self.deliverLock.Lock()
+	defer self.deliverLock.Unlock()
+	if self.isStopped() {
+		return false
+	}
+	if !self.listener(event) {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		self.stopped = true
+		return false
+	}
+	return true
+}
+func (self *subscription) known(addr string) bool {
+	for _, node := range self.conn.ring.Nodes() {
+		if node.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+func (self *subscription) follow(addr string, since int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped || self.following[addr] {
+		return
+	}
+	self.following[addr] = true
+	go func() {
+		defer func() {
+			self.lock.Lock()
+			defer self.lock.Unlock()
+			delete(self.following, addr)
+		}()
+		for !self.isStopped() {
+			events, err := self.events(addr, since, eventsWait)
+			if err != nil {
+				if !self.known(addr) {
+					return
+				}
+				time.Sleep(common.PingInterval)
+				continue
+			}
+			if events.Seq < since {
+				// the node restarted, and its events start over
+				since = 0
+				continue
+			}
+			if events.Missed > 0 && !self.deliver(common.Event{
+				Time:   time.Now(),
+				Node:   addr,
+				Type:   common.EventMissed,
+				Detail: fmt.Sprint(events.Missed),
+			}) {
+				return
+			}
+			for _, event := range events.Events {
+				if !self.deliver(event) {
+					return
+				}
+				since = event.Seq
+			}
+			if since < 0 {
+				since = events.Seq
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"github.com/zond/setop"
+)
+
+// KV is the byte value part of the data API, implemented by Conn, dhash.Local and fake.Fake, to let applications replace the cluster with a fake.Fake in their tests.
+type KV interface {
+	Get(key []byte) (value []byte, existed bool)
+	Put(key, value []byte)
+	SPut(key, value []byte)
+	TryPut(key, value []byte) error
+	Del(key []byte)
+	SDel(key []byte)
+	TryDel(key []byte) error
+	Next(key []byte) (nextKey, nextValue []byte, existed bool)
+	Prev(key []byte) (prevKey, prevValue []byte, existed bool)
+	Size() int
+}
+
+// SubKV is the sub tree part of the data API, implemented by Conn, dhash.Local and fake.Fake.
+type SubKV interface {
+	SubGet(key, subKey []byte) (value []byte, existed bool)
+	SubPut(key, subKey, value []byte)
+	SSubPut(key, subKey, value []byte)
+	TrySubPut(key, subKey, value []byte) error
+	SubDel(key, subKey []byte)
+	SSubDel(key, subKey []byte)
+	TrySubDel(key, subKey []byte) error
+	SubClear(key []byte)
+	SSubClear(key []byte)
+	TrySubClear(key []byte) error
+	SubSize(key []byte) int
+	First(key []byte) (firstKey, firstValue []byte, existed bool)
+	Last(key []byte) (lastKey, lastValue []byte, existed bool)
+	SubNext(key, subKey []byte) (nextKey, nextValue []byte, existed bool)
+	SubPrev(key, subKey []byte) (prevKey, prevValue []byte, existed bool)
+	Count(key, min, max []byte, mininc, maxinc bool) int
+	IndexOf(key, subKey []byte) (index int, existed bool)
+	Slice(key, min, max []byte, mininc, maxinc bool) []common.Item
+	ReverseSlice(key, min, max []byte, mininc, maxinc bool) []common.Item
+	SliceLen(key, min []byte, mininc bool, maxRes int) []common.Item
+	ReverseSliceLen(key, max []byte, maxinc bool, maxRes int) []common.Item
+	SliceIndex(key []byte, min, max *int) []common.Item
+	ReverseSliceIndex(key []byte, min, max *int) []common.Item
+}
+
+// SetOps is the set operation part of the data API, implemented by Conn, dhash.Local and fake.Fake.
+type SetOps interface {
+	SetExpression(expr setop.SetExpression) []setop.SetOpResult
+}
+
+// WriteListener is a function listening to each value and tombstone written, with the value it replaced, until it returns false.
+type WriteListener func(write radix.Write) (keep bool)
+
+// Watcher is something reporting the writes to its data as they happen. Unlike KV, SubKV and SetOps it is not implemented by Conn, which doesn't see
+// the writes of the nodes, but only by the in-process dhash.Node, dhash.Local and fake.Fake. Remote clients get cluster events and expiries using Conn.Subscribe
+// and Conn.SubscribeExpiries instead.
+type Watcher interface {
+	AddWriteListener(l WriteListener)
+}
+
+var (
+	_ KV     = (*Conn)(nil)
+	_ SubKV  = (*Conn)(nil)
+	_ SetOps = (*Conn)(nil)
+)
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"sort"
+
+	"github.com/zond/god/common"
+)
+
+// SystemGet will return the value under key in the system namespace.
+func (self *Conn) SystemGet(namespace string, key []byte) (value []byte, existed bool) {
+	data := common.Item{
+		Key:    common.SystemKey(namespace),
+		SubKey: key,
+		QoS:    self.QoS(),
+	}
+	result := self.findRecent("DHash.SystemGet", data)
+	if result.Value != nil {
+		value, existed = result.Value, result.Exists
+	}
+	return
+}
+
+// SystemEntries returns all keys and values in the system namespace.
+func (self *Conn) SystemEntries(namespace string) []common.Item {
+	return self.mergeRecent("DHash.SystemSlice", common.Range{
+		Key:    common.SystemKey(namespace),
+		MinInc: true,
+		MaxInc: true,
+		QoS:    self.QoS(),
+	}, true)
+}
+
+// SystemNamespaces returns the sorted names of the system namespaces with entries, as reported by all currently known nodes.
+func (self *Conn) SystemNamespaces() (result []string, err error) {
+	found := make(map[string]bool)
+	for _, node := range self.ring.Nodes() {
+		var namespaces []string
+		if err = node.Call("DHash.SystemNamespaces", 0, &namespaces); err != nil {
+			return
+		}
+		for _, namespace := range namespaces {
+			found[namespace] = true
+		}
+	}
+	for namespace, _ := range found {
+		result = append(result, namespace)
+	}
+	sort.Strings(result)
+	return
+}
@@ -0,0 +1,410 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+// hashTreeItem has the key fields of dhash.HashTreeItem, which the client can't import, to call the HashTree service of the nodes.
+type hashTreeItem struct {
+	Key    []radix.Nibble
+	SubKey []radix.Nibble
+}
+
+// remoteTree is the cluster side of one owned range of a TreeSync, remembering the first error since radix.HashTree methods can't return them.
+//
+// It compares prints using the HashTree service of the owner, but reads and writes values using the regular operations of the Conn, so that writes are
+// replicated, checked and stamped with the time of the cluster like any other write. Since the cluster decides the timestamps of the writes, the local copy of each
+// written value is given the timestamp the cluster stored it with, so that both sides agree on its version.
+type remoteTree struct {
+	conn  *Conn
+	node  common.Remote
+	local *radix.Tree
+	lock  *sync.Mutex
+	err   error
+}
+
+// fail will remember err if it is the first error.
+func (self *remoteTree) fail(err error) {
+	if err != nil {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		if self.err == nil {
+			self.err = err
+		}
+	}
+}
+
+// Err returns the first error of this remoteTree.
+func (self *remoteTree) Err() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.err
+}
+func (self *remoteTree) call(service string, args, reply interface{}) {
+	self.fail(self.node.Call(service, args, reply))
+}
+func (self *remoteTree) Hash() (result []byte) {
+	self.call("HashTree.Hash", 0, &result)
+	return
+}
+func (self *remoteTree) Configuration() (conf map[string]string, timestamp int64) {
+	var result common.Conf
+	self.call("DHash.Configuration", 0, &result)
+	return result.Data, result.Timestamp
+}
+
+// Configure does nothing, since the configuration of the cluster is only copied to the local tree.
+func (self *remoteTree) Configure(conf map[string]string, timestamp int64) {
+}
+func (self *remoteTree) Finger(key []radix.Nibble) (result *radix.Print) {
+	result = &radix.Print{}
+	self.call("HashTree.Finger", key, result)
+	return
+}
+
+// Fingers will fetch the prints of keys in one call, or one at a time from nodes too old to support it.
+func (self *remoteTree) Fingers(keys [][]radix.Nibble) (result []*radix.Print) {
+	if err := self.node.Call("HashTree.Fingers", keys, &result); err != nil || len(result) != len(keys) {
+		result = make([]*radix.Print, len(keys))
+		for index, key := range keys {
+			result[index] = self.Finger(key)
+		}
+	}
+	return
+}
+func (self *remoteTree) get(key, subKey []radix.Nibble) (value []byte, timestamp int64, present bool) {
+	data := common.Item{Key: radix.Stitch(key)}
+	operation := "DHash.Get"
+	if subKey != nil {
+		data.SubKey, operation = radix.Stitch(subKey), "DHash.SubGet"
+	}
+	var result common.Item
+	self.call(operation, data, &result)
+	return result.Value, result.Timestamp, result.Exists
+}
+func (self *remoteTree) GetTimestamp(key []radix.Nibble) (value []byte, timestamp int64, present bool) {
+	return self.get(key, nil)
+}
+func (self *remoteTree) SubGetTimestamp(key, subKey []radix.Nibble) (value []byte, timestamp int64, present bool) {
+	return self.get(key, subKey)
+}
+
+// write will write value, or remove it if it isn't present, under subKey in the sub tree of key, or under key if subKey is nil, unless the cluster already has it.
+// It then gives the local copy the timestamp the cluster stored it with, and returns whether the cluster was changed.
+func (self *remoteTree) write(key, subKey []radix.Nibble, value []byte, present bool) (changed bool) {
+	current, _, currentPresent := self.get(key, subKey)
+	if self.Err() != nil {
+		return
+	}
+	if currentPresent != present || bytes.Compare(current, value) != 0 {
+		stitched := radix.Stitch(key)
+		var err error
+		switch {
+		case subKey == nil && present:
+			err = self.conn.TryPut(stitched, value)
+		case subKey == nil:
+			err = self.conn.TryDel(stitched)
+		case present:
+			err = self.conn.TrySubPut(stitched, radix.Stitch(subKey), value)
+		default:
+			err = self.conn.TrySubDel(stitched, radix.Stitch(subKey))
+		}
+		if err != nil {
+			self.fail(err)
+			return
+		}
+		changed = true
+	}
+	_, timestamp, _ := self.get(key, subKey)
+	if self.Err() == nil {
+		if subKey == nil {
+			_, localTimestamp, _ := self.local.GetTimestamp(key)
+			self.local.PutTimestamp(key, value, present, localTimestamp, timestamp)
+		} else {
+			_, localTimestamp, _ := self.local.SubGetTimestamp(key, subKey)
+			self.local.SubPutTimestamp(key, subKey, value, present, localTimestamp, timestamp)
+		}
+	}
+	return
+}
+func (self *remoteTree) PutTimestamp(key []radix.Nibble, value []byte, present bool, expected, timestamp int64) bool {
+	return self.write(key, nil, value, present)
+}
+func (self *remoteTree) SubPutTimestamp(key, subKey []radix.Nibble, value []byte, present bool, subExpected, subTimestamp int64) bool {
+	return self.write(key, subKey, value, present)
+}
+
+// DelTimestamp fails, since a TreeSync never deletes what it copies.
+func (self *remoteTree) DelTimestamp(key []radix.Nibble, expected int64) bool {
+	self.fail(fmt.Errorf("A TreeSync can't delete %v from the cluster", radix.Stitch(key)))
+	return false
+}
+func (self *remoteTree) SubConfiguration(key []byte) (conf map[string]string, timestamp int64) {
+	var result common.Conf
+	self.call("DHash.SubConfiguration", key, &result)
+	return result.Data, result.Timestamp
+}
+
+// SubConfigure does nothing, since the configuration of the cluster is only copied to the local tree.
+func (self *remoteTree) SubConfigure(key []byte, conf map[string]string, timestamp int64) {
+}
+func (self *remoteTree) SubFinger(key, subKey []radix.Nibble) (result *radix.Print) {
+	result = &radix.Print{}
+	self.call("HashTree.SubFinger", hashTreeItem{Key: key, SubKey: subKey}, result)
+	return
+}
+
+// SubDelTimestamp fails, since a TreeSync never deletes what it copies.
+func (self *remoteTree) SubDelTimestamp(key, subKey []radix.Nibble, subExpected int64) bool {
+	self.fail(fmt.Errorf("A TreeSync can't delete %v/%v from the cluster", radix.Stitch(key), radix.Stitch(subKey)))
+	return false
+}
+func (self *remoteTree) SubClearTimestamp(key []radix.Nibble, expected, timestamp int64) (deleted int) {
+	if err := self.conn.TrySubClear(radix.Stitch(key)); err != nil {
+		self.fail(err)
+		return
+	}
+	return 1
+}
+
+// SubKillTimestamp fails, since a TreeSync never deletes what it copies.
+func (self *remoteTree) SubKillTimestamp(key []radix.Nibble, expected int64) (deleted int) {
+	self.fail(fmt.Errorf("A TreeSync can't delete %v from the cluster", radix.Stitch(key)))
+	return
+}
+
+// TreeVersion is the version of a value on one side of a TreeSync.
+type TreeVersion struct {
+	Value     []byte
+	Timestamp int64
+	// Present is false for deleted values.
+	Present bool
+}
+
+// TreeConflict is a value that differs between the local tree of a TreeSync and the cluster.
+type TreeConflict struct {
+	Key []byte
+	// SubKey is the key of the value within the sub tree of Key, or nil if the value is the byte value of Key.
+	SubKey []byte
+	Local  TreeVersion
+	Remote TreeVersion
+}
+
+// ConflictResolver returns the version to keep on both sides for conflict, or false to keep the newest version.
+// The Timestamp of the returned version is ignored, it is stored as newer than both conflicting versions.
+type ConflictResolver func(conflict TreeConflict) (resolved TreeVersion, ok bool)
+
+// TreeSyncProgress describes how far a TreeSync has come.
+type TreeSyncProgress struct {
+	// Ranges is the number of owned ranges of the cluster overlapping the synchronized keys, and Done how many of them are synchronized.
+	Ranges int
+	Done   int
+	// Pushed and Pulled are the number of entries copied to the cluster and to the local tree.
+	Pushed int
+	Pulled int
+	// Conflicts is the number of conflicts the ConflictResolver resolved.
+	Conflicts int
+}
+
+// TreeSync synchronizes a key range of a local radix.Tree with a cluster in both directions, so that applications can keep a local copy of some data
+// that they read and write while offline, or as an edge cache. Each entry is synchronized with its owner, and the newest version of each value wins unless
+// a ConflictResolver decides otherwise.
+//
+// Values are pushed to the cluster with the regular writes of the Conn, so they are replicated, mirrored, checked against frozen ranges and write-once keys,
+// and encoded and chunked like any other write, and the local tree gets the decoded values. The cluster stamps the pushed values with its own time,
+// and the local copies are given the same timestamps, so a local clock that is ahead only wins the conflicts of the values written while it was offline.
+// The configuration of the cluster is copied to the local tree, but never the other way.
+type TreeSync struct {
+	conn     *Conn
+	tree     *radix.Tree
+	from     []byte
+	to       []byte
+	resolver ConflictResolver
+	progress func(TreeSyncProgress)
+	limiter  *radix.Limiter
+}
+
+// TreeSync returns a TreeSync of tree with the cluster of this Conn, covering all keys until limited with From and To.
+func (self *Conn) TreeSync(tree *radix.Tree) *TreeSync {
+	return &TreeSync{
+		conn: self,
+		tree: tree,
+	}
+}
+
+// From defines from what key, inclusive, this TreeSync will synchronize.
+func (self *TreeSync) From(from []byte) *TreeSync {
+	self.from = from
+	return self
+}
+
+// To defines to what key, exclusive, this TreeSync will synchronize.
+func (self *TreeSync) To(to []byte) *TreeSync {
+	self.to = to
+	return self
+}
+
+// Resolve defines that this TreeSync will let resolver decide which version to keep of values that differ between the local tree and the cluster.
+func (self *TreeSync) Resolve(resolver ConflictResolver) *TreeSync {
+	self.resolver = resolver
+	return self
+}
+
+// Progress defines that this TreeSync will call f each time it has synchronized an owned range of the cluster.
+func (self *TreeSync) Progress(f func(TreeSyncProgress)) *TreeSync {
+	self.progress = f
+	return self
+}
+
+// Limit defines that this TreeSync will copy entries no faster than limiter allows.
+func (self *TreeSync) Limit(limiter *radix.Limiter) *TreeSync {
+	self.limiter = limiter
+	return self
+}
+
+// keyRange is a range of keys from a key, inclusive, to a key, exclusive, where a nil from is the first key and a nil to is past the last key.
+type keyRange struct {
+	from []byte
+	to   []byte
+}
+
+// intersect returns the part of this keyRange within other, and whether it is non empty.
+func (self keyRange) intersect(other keyRange) (result keyRange, ok bool) {
+	result = self
+	if other.from != nil && (result.from == nil || bytes.Compare(other.from, result.from) > 0) {
+		result.from = other.from
+	}
+	if other.to != nil && (result.to == nil || bytes.Compare(other.to, result.to) < 0) {
+		result.to = other.to
+	}
+	return result, result.to == nil || result.from == nil || bytes.Compare(result.from, result.to) < 0
+}
+
+// owned returns the ranges of the keys owned by node, whose predecessor is at pred, split where the ring wraps around.
+func owned(pred, node common.Remote) []keyRange {
+	switch bytes.Compare(pred.Pos, node.Pos) {
+	case -1:
+		return []keyRange{{pred.Pos, node.Pos}}
+	case 1:
+		return []keyRange{{pred.Pos, nil}, {nil, node.Pos}}
+	}
+	return []keyRange{{}}
+}
+
+// limit will make sync cover r. A nil from or to is given as the empty key, which radix.Sync treats as the wrap around point of the ring.
+func (self keyRange) limit(sync *radix.Sync) *radix.Sync {
+	if self.from == nil && self.to == nil {
+		return sync
+	}
+	from, to := self.from, self.to
+	if from == nil {
+		from = []byte{}
+	}
+	if to == nil {
+		to = []byte{}
+	}
+	return sync.From(from).To(to)
+}
+
+// Run will synchronize the local tree with the cluster, and return the progress made and the first error encountered, if any.
+// Ranges whose owner fails are skipped, and the synchronization of the others continues.
+func (self *TreeSync) Run() (result TreeSyncProgress, err error) {
+	wanted := keyRange{self.from, self.to}
+	nodes := self.conn.ring.Nodes()
+	type part struct {
+		node common.Remote
+		keys keyRange
+	}
+	var parts []part
+	for index, node := range nodes {
+		for _, r := range owned(nodes[(index+len(nodes)-1)%len(nodes)], node) {
+			if keys, ok := wanted.intersect(r); ok {
+				parts = append(parts, part{node, keys})
+			}
+		}
+	}
+	result.Ranges = len(parts)
+	for _, p := range parts {
+		remote := &remoteTree{conn: self.conn, node: p.node, local: self.tree, lock: new(sync.Mutex)}
+		push := p.keys.limit(radix.NewSync(self.tree, &resolvingTree{BatchHashTree: remote, sync: self, source: self.tree, local: false, progress: &result})).Limit(self.limiter).Run()
+		pull := p.keys.limit(radix.NewSync(remote, &resolvingTree{BatchHashTree: self.tree, sync: self, source: remote, local: true, progress: &result})).Limit(self.limiter).Run()
+		result.Pushed += push.PutCount()
+		result.Pulled += pull.PutCount()
+		result.Done++
+		if remote.Err() != nil && err == nil {
+			err = remote.Err()
+		}
+		if self.progress != nil {
+			self.progress(result)
+		}
+	}
+	return
+}
+
+// resolvingTree is the destination of one direction of a TreeSync, that lets the ConflictResolver of the TreeSync decide what to store when a value is copied over a different value.
+// A resolved version is stored in both the destination and the source, so that the other direction of the TreeSync finds them equal.
+type resolvingTree struct {
+	radix.BatchHashTree
+	sync     *TreeSync
+	source   radix.HashTree
+	local    bool
+	progress *TreeSyncProgress
+}
+
+// resolve returns the version to store instead of incoming, if the ConflictResolver of the TreeSync resolves its conflict with existing.
+func (self *resolvingTree) resolve(key, subKey []byte, incoming, existing TreeVersion) (resolved TreeVersion, ok bool) {
+	if self.sync.resolver == nil || existing.Timestamp == 0 || (incoming.Present == existing.Present && bytes.Compare(incoming.Value, existing.Value) == 0) {
+		return
+	}
+	conflict := TreeConflict{Key: key, SubKey: subKey, Local: existing, Remote: incoming}
+	if !self.local {
+		conflict.Local, conflict.Remote = incoming, existing
+	}
+	if resolved, ok = self.sync.resolver(conflict); ok {
+		resolved.Timestamp = incoming.Timestamp
+		if existing.Timestamp > resolved.Timestamp {
+			resolved.Timestamp = existing.Timestamp
+		}
+		resolved.Timestamp++
+		self.progress.Conflicts++
+	}
+	return
+}
+
+// unchanged returns whether incoming has the same value as existing, and isn't newer, which happens when the cluster stores the value encoded or chunked.
+func unchanged(incoming, existing TreeVersion) bool {
+	return incoming.Present == existing.Present && bytes.Compare(incoming.Value, existing.Value) == 0 && incoming.Timestamp <= existing.Timestamp
+}
+func (self *resolvingTree) PutTimestamp(key []radix.Nibble, value []byte, present bool, expected, timestamp int64) bool {
+	existingValue, existingTimestamp, existingPresent := self.BatchHashTree.GetTimestamp(key)
+	if unchanged(TreeVersion{value, timestamp, present}, TreeVersion{existingValue, existingTimestamp, existingPresent}) {
+		return false
+	}
+	if resolved, ok := self.resolve(radix.Stitch(key), nil, TreeVersion{value, timestamp, present}, TreeVersion{existingValue, existingTimestamp, existingPresent}); ok {
+		if !self.BatchHashTree.PutTimestamp(key, resolved.Value, resolved.Present, expected, resolved.Timestamp) {
+			return false
+		}
+		self.source.PutTimestamp(key, resolved.Value, resolved.Present, timestamp, resolved.Timestamp)
+		return true
+	}
+	return self.BatchHashTree.PutTimestamp(key, value, present, expected, timestamp)
+}
+func (self *resolvingTree) SubPutTimestamp(key, subKey []radix.Nibble, value []byte, present bool, subExpected, subTimestamp int64) bool {
+	existingValue, existingTimestamp, existingPresent := self.BatchHashTree.SubGetTimestamp(key, subKey)
+	if unchanged(TreeVersion{value, subTimestamp, present}, TreeVersion{existingValue, existingTimestamp, existingPresent}) {
+		return false
+	}
+	if resolved, ok := self.resolve(radix.Stitch(key), radix.Stitch(subKey), TreeVersion{value, subTimestamp, present}, TreeVersion{existingValue, existingTimestamp, existingPresent}); ok {
+		if !self.BatchHashTree.SubPutTimestamp(key, subKey, resolved.Value, resolved.Present, subExpected, resolved.Timestamp) {
+			return false
+		}
+		self.source.SubPutTimestamp(key, subKey, resolved.Value, resolved.Present, subTimestamp, resolved.Timestamp)
+		return true
+	}
+	return self.BatchHashTree.SubPutTimestamp(key, subKey, value, present, subExpected, subTimestamp)
+}
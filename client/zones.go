@@ -0,0 +1,139 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// latencyWeight is how much each new measurement weighs in the average latency to a node.
+const latencyWeight = 0.2
+
+// SetZone will make GetStale from this Conn prefer replicas in zone, as configured on the nodes with dhash.Node.SetZone.
+func (self *Conn) SetZone(zone string) {
+	self.zoneLock.Lock()
+	defer self.zoneLock.Unlock()
+	self.zone = zone
+}
+
+// Zone returns the zone of this Conn.
+func (self *Conn) Zone() string {
+	self.zoneLock.RLock()
+	defer self.zoneLock.RUnlock()
+	return self.zone
+}
+
+// observe will record that a call to node took latency, and that node is in zone.
+func (self *Conn) observe(node common.Remote, zone string, latency time.Duration) {
+	self.zoneLock.Lock()
+	defer self.zoneLock.Unlock()
+	if self.zones == nil {
+		self.zones = make(map[string]string)
+		self.latencies = make(map[string]time.Duration)
+	}
+	self.zones[node.Addr] = zone
+	if old, found := self.latencies[node.Addr]; found {
+		self.latencies[node.Addr] = time.Duration(float64(old)*(1-latencyWeight) + float64(latency)*latencyWeight)
+	} else {
+		self.latencies[node.Addr] = latency
+	}
+}
+
+// nodeZone returns the zone and average latency of node, asking node for its zone the first time.
+func (self *Conn) nodeZone(node common.Remote) (zone string, latency time.Duration, err error) {
+	self.zoneLock.RLock()
+	zone, found := self.zones[node.Addr]
+	latency = self.latencies[node.Addr]
+	self.zoneLock.RUnlock()
+	if found {
+		return
+	}
+	start := time.Now()
+	if err = node.Call("DHash.Zone", 0, &zone); err != nil {
+		return
+	}
+	latency = time.Now().Sub(start)
+	self.observe(node, zone, latency)
+	return
+}
+
+// ZoneLatencies returns the average latency to the nodes of each zone this Conn has talked to.
+func (self *Conn) ZoneLatencies() (result map[string]time.Duration) {
+	self.zoneLock.RLock()
+	defer self.zoneLock.RUnlock()
+	result = make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for addr, latency := range self.latencies {
+		result[self.zones[addr]] += latency
+		counts[self.zones[addr]]++
+	}
+	for zone, count := range counts {
+		result[zone] /= time.Duration(count)
+	}
+	return
+}
+
+type rankedReplica struct {
+	node    common.Remote
+	local   bool
+	latency time.Duration
+}
+
+type rankedReplicas []rankedReplica
+
+func (self rankedReplicas) Len() int {
+	return len(self)
+}
+func (self rankedReplicas) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self rankedReplicas) Less(i, j int) bool {
+	if self[i].local != self[j].local {
+		return self[i].local
+	}
+	return self[i].latency < self[j].latency
+}
+
+// nearestReplicas returns the replicas of key, with the ones in the zone of this Conn first, and the rest ordered by latency.
+// Replicas that don't answer when asked for their zone are left out.
+func (self *Conn) nearestReplicas(key []byte) (result common.Remotes) {
+	myZone := self.Zone()
+	var ranked rankedReplicas
+	nextKey := key
+	for i := 0; i < self.ring.Redundancy(); i++ {
+		_, _, successor := self.ring.Remotes(nextKey)
+		if zone, latency, err := self.nodeZone(*successor); err == nil {
+			ranked = append(ranked, rankedReplica{
+				node:    *successor,
+				local:   myZone != "" && zone == myZone,
+				latency: latency,
+			})
+		}
+		nextKey = successor.Pos
+	}
+	sort.Stable(ranked)
+	for _, replica := range ranked {
+		result = append(result, replica.node)
+	}
+	return
+}
+
+// GetStale will return the value under key from the nearest replica that answers, preferring replicas in the zone of this Conn.
+// Unlike Get it asks only one replica, so the value may be older than the most recent one, or missing if the replica hasn't received it yet.
+func (self *Conn) GetStale(key []byte) (value []byte, existed bool) {
+	data := common.Item{
+		Key: key,
+		QoS: self.QoS(),
+	}
+	for _, node := range self.nearestReplicas(key) {
+		result := common.Item{}
+		start := time.Now()
+		if err := node.Call("DHash.Get", data, &result); err == nil {
+			zone, _, _ := self.nodeZone(node)
+			self.observe(node, zone, time.Now().Sub(start))
+			return result.Value, result.Exists
+		}
+	}
+	return self.Get(key)
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"time"
+)
+
+// AdmissionLoad describes an expensive operation, like a range scan, set expression or bulk operation, waiting to run on a node, and how busy the node is.
+type AdmissionLoad struct {
+	// Operation is the name of the operation, like Slice or SetExpression.
+	Operation string
+	// Waited is for how long the operation has been queued.
+	Waited time.Duration
+	// InFlight and Queued are the numbers of other expensive operations running and waiting on the node.
+	InFlight int
+	Queued   int
+	// TreeLoad is the fraction of the recent time the tree of the node was locked for writing, as in DHashDescription.Load.
+	TreeLoad float64
+	// CPULoad is the fraction of the CPU time available to the node that it used, and GCLoad the fraction its garbage collector used,
+	// between the last two times it updated its request rates.
+	CPULoad float64
+	GCLoad  float64
+	// HeapBytes is the size of the heap of the node when it last updated its request rates.
+	HeapBytes uint64
+}
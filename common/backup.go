@@ -0,0 +1,60 @@
+package common
+
+import (
+	"time"
+)
+
+// BackupRequest asks a node to write a snapshot of the data it owns, as it was at At, to the backup file for ID.
+type BackupRequest struct {
+	ID string
+	At int64
+}
+
+// BackupFile describes the snapshot one node wrote for a cluster backup.
+type BackupFile struct {
+	Addr string
+	// From and To are the positions of the predecessor of the node and the node, delimiting the range it owned.
+	From []byte
+	To   []byte
+	// Path is the location of the file on the node.
+	Path    string
+	Entries int
+	// Newer is the number of entries written after the time of the backup. Their values at that time were replaced, so the file contains their values when it was written.
+	Newer    int
+	Bytes    int
+	Checksum []byte
+}
+
+// BackupChunk asks a node for the part of the backup file for ID starting at Offset.
+type BackupChunk struct {
+	ID     string
+	Offset int64
+}
+
+// BackupManifest describes a cluster backup, made of one snapshot file per node, all taken at the same timenet time.
+type BackupManifest struct {
+	ID    string
+	At    int64
+	Files []BackupFile
+	// Failed contains the errors returned by the nodes that didn't write their files, by address.
+	Failed map[string]string
+}
+
+// Time returns the time of the backup.
+func (self *BackupManifest) Time() time.Time {
+	return time.Unix(0, self.At)
+}
+
+// Complete returns whether all nodes wrote their files, making the backup cover the entire ring.
+func (self *BackupManifest) Complete() bool {
+	return len(self.Failed) == 0 && len(self.Files) > 0
+}
+
+// Newer returns the number of entries in the backup written after its time, with values the data didn't have at that time.
+// A backup without them is an exact copy of the data at its time, and a backup with them is fuzzy.
+func (self *BackupManifest) Newer() (result int) {
+	for _, file := range self.Files {
+		result += file.Newer
+	}
+	return
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -16,12 +18,88 @@ const (
 	PingInterval = time.Second
 )
 
-var (
-	Redundancy int = 3
+const (
+	// WireVersion is the version of the messages nodes exchange, sent with each ping to let the receiver detect peers it can't talk to.
+	WireVersion = 1
+	// MinWireVersion is the oldest WireVersion nodes of this version can talk to. Nodes from before wire versions were introduced send zero.
+	// Each version is responsible for talking to all versions down to its own MinWireVersion, so nodes only reject pings from versions older than that.
+	MinWireVersion = 0
 )
 
+// DefaultRedundancy is the number of copies of each key kept by a cluster whose redundancy hasn't been changed.
+const DefaultRedundancy = 3
+
+// redundancy is the number of copies of each key kept by the cluster, accessed atomically.
+var redundancy int64 = DefaultRedundancy
+
+// ErrReroute is returned by nodes asked to handle keys they are not responsible for according to their view of the ring.
+// Clients receiving it should refresh their view of the ring before retrying.
+var ErrReroute = errors.New("Not responsible for the key, refresh the ring and retry")
+
+// IsReroute returns whether err is ErrReroute, even after being sent over RPC.
+func IsReroute(err error) bool {
+	return err != nil && err.Error() == ErrReroute.Error()
+}
+
+// ErrImmutable is returned by nodes asked to change or delete values of write-once keys without overriding immutability.
+var ErrImmutable = errors.New("Key is immutable, override immutability to change it")
+
+// IsImmutable returns whether err is ErrImmutable, even after being sent over RPC.
+func IsImmutable(err error) bool {
+	return err != nil && err.Error() == ErrImmutable.Error()
+}
+
+// ErrOverloaded is returned by nodes that have too many connections or requests in flight to handle another request.
+var ErrOverloaded = errors.New("Node is overloaded, retry later")
+
+// IsOverloaded returns whether err is ErrOverloaded, even after being sent over RPC.
+func IsOverloaded(err error) bool {
+	return err != nil && err.Error() == ErrOverloaded.Error()
+}
+
+// ErrNoIDNode is returned by nodes asked to generate IDs without having been given a node id.
+var ErrNoIDNode = errors.New("Node has no node id to generate IDs with")
+
+// ErrNoQuorum is returned by nodes asked to write before their ring has reached the minimum number of nodes.
+var ErrNoQuorum = errors.New("Node is waiting for the cluster to reach its minimum size, retry later")
+
+// IsNoQuorum returns whether err is ErrNoQuorum, even after being sent over RPC.
+func IsNoQuorum(err error) bool {
+	return err != nil && err.Error() == ErrNoQuorum.Error()
+}
+
+// ErrFrozen is returned by nodes asked to write keys in a range frozen for maintenance.
+var ErrFrozen = errors.New("Key is in a frozen range, retry when the range is unfrozen")
+
+// IsFrozen returns whether err is ErrFrozen, even after being sent over RPC.
+func IsFrozen(err error) bool {
+	return err != nil && err.Error() == ErrFrozen.Error()
+}
+
+// ErrReserved is returned by nodes asked to write keys in the system keyspace by other means than the system keyspace APIs.
+var ErrReserved = errors.New("Key is in the reserved system keyspace")
+
+// IsReserved returns whether err is ErrReserved, even after being sent over RPC.
+func IsReserved(err error) bool {
+	return err != nil && err.Error() == ErrReserved.Error()
+}
+
+// ErrIncompatibleVersion is returned by nodes pinged by nodes with a WireVersion older than their MinWireVersion.
+var ErrIncompatibleVersion = errors.New("Wire version is too old, upgrade the node")
+
+// IsIncompatibleVersion returns whether err is ErrIncompatibleVersion, even after being sent over RPC.
+func IsIncompatibleVersion(err error) bool {
+	return err != nil && err.Error() == ErrIncompatibleVersion.Error()
+}
+
+// SetRedundancy will set the number of copies of each key kept by the cluster. Nodes set it from the redundancy in the cluster configuration.
 func SetRedundancy(r int) {
-	Redundancy = r
+	atomic.StoreInt64(&redundancy, int64(r))
+}
+
+// GetRedundancy returns the number of copies of each key kept by the cluster.
+func GetRedundancy() int {
+	return int(atomic.LoadInt64(&redundancy))
 }
 
 func MustParseFloat64(s string) (result float64) {
@@ -174,41 +252,56 @@ func MergeItems(arys []*[]Item, up bool) (result []Item) {
 
 // DHashDescription contains a description of a dhash node.
 type DHashDescription struct {
-	Addr         string
-	Pos          []byte
-	LastReroute  time.Time
-	LastSync     time.Time
-	LastMigrate  time.Time
-	Timer        time.Time
-	OwnedEntries int
-	HeldEntries  int
-	Load         float64
-	Nodes        Remotes
+	Addr            string
+	Pos             []byte
+	Zone            string
+	LastReroute     time.Time
+	LastSync        time.Time
+	LastMigrate     time.Time
+	Timer           time.Time
+	ClockOffset     time.Duration
+	ClockError      time.Duration
+	OwnedEntries    int
+	HeldEntries     int
+	Load            float64
+	SyncPaused      bool
+	MigrationPaused bool
+	Nodes           Remotes
 }
 
 // Describe will return a humanly readable string description of the dhash node.
 func (self DHashDescription) Describe() string {
 	return fmt.Sprintf("%+v", struct {
-		Addr         string
-		Pos          string
-		LastReroute  time.Time
-		LastSync     time.Time
-		LastMigrate  time.Time
-		Timer        time.Time
-		OwnedEntries int
-		HeldEntries  int
-		Load         float64
-		Nodes        string
+		Addr            string
+		Pos             string
+		Zone            string
+		LastReroute     time.Time
+		LastSync        time.Time
+		LastMigrate     time.Time
+		Timer           time.Time
+		ClockOffset     time.Duration
+		ClockError      time.Duration
+		OwnedEntries    int
+		HeldEntries     int
+		Load            float64
+		SyncPaused      bool
+		MigrationPaused bool
+		Nodes           string
 	}{
-		Addr:         self.Addr,
-		Pos:          HexEncode(self.Pos),
-		LastReroute:  self.LastReroute,
-		LastSync:     self.LastSync,
-		LastMigrate:  self.LastMigrate,
-		Timer:        self.Timer,
-		OwnedEntries: self.OwnedEntries,
-		HeldEntries:  self.HeldEntries,
-		Load:         self.Load,
-		Nodes:        fmt.Sprintf("\n%v", self.Nodes.Describe()),
+		Addr:            self.Addr,
+		Pos:             HexEncode(self.Pos),
+		Zone:            self.Zone,
+		LastReroute:     self.LastReroute,
+		LastSync:        self.LastSync,
+		LastMigrate:     self.LastMigrate,
+		Timer:           self.Timer,
+		ClockOffset:     self.ClockOffset,
+		ClockError:      self.ClockError,
+		OwnedEntries:    self.OwnedEntries,
+		HeldEntries:     self.HeldEntries,
+		Load:            self.Load,
+		SyncPaused:      self.SyncPaused,
+		MigrationPaused: self.MigrationPaused,
+		Nodes:           fmt.Sprintf("\n%v", self.Nodes.Describe()),
 	})
 }
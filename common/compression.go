@@ -0,0 +1,164 @@
+package common
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"sync/atomic"
+
+	"github.com/zond/god/persistence"
+)
+
+var compressionThreshold int64
+
+// SetCompressionThreshold will make the RPC clients and servers of this process compress values of at least threshold bytes before sending them.
+// Zero, the default, disables compression. Compressed values are flagged, so nodes always understand uncompressed values, but nodes older than
+// the compression support will not understand compressed values. Only enable compression when all nodes and clients understand it.
+func SetCompressionThreshold(threshold int) {
+	atomic.StoreInt64(&compressionThreshold, int64(threshold))
+}
+
+// CompressionThreshold returns the size above which the RPC clients and servers of this process compress values.
+func CompressionThreshold() int {
+	return int(atomic.LoadInt64(&compressionThreshold))
+}
+
+// Compress will return value compressed, and true, if threshold is positive, value is at least threshold bytes long and compressing it actually makes it smaller.
+// Otherwise it will return value and false.
+func Compress(value []byte, threshold int) ([]byte, bool) {
+	return persistence.Compress(value, threshold)
+}
+
+// Decompress will return value decompressed if it is compressed, and value otherwise.
+func Decompress(value []byte, compressed bool) ([]byte, error) {
+	return persistence.Decompress(value, compressed)
+}
+
+// Compressible is implemented by RPC arguments and replies that carry values worth compressing.
+// Compress returns a copy with the values compressed if they are longer than threshold.
+type Compressible interface {
+	Compress(threshold int) interface{}
+}
+
+// Decompressible is implemented by pointers to RPC arguments and replies that may carry compressed values.
+type Decompressible interface {
+	Decompress() error
+}
+
+func compressed(body interface{}) interface{} {
+	if threshold := CompressionThreshold(); threshold > 0 {
+		if compressible, ok := body.(Compressible); ok {
+			return compressible.Compress(threshold)
+		}
+	}
+	return body
+}
+func decompress(body interface{}) error {
+	if decompressible, ok := body.(Decompressible); ok {
+		return decompressible.Decompress()
+	}
+	return nil
+}
+
+// Compress returns a copy of this Item with the value compressed if it is longer than threshold.
+func (self Item) Compress(threshold int) interface{} {
+	if !self.Compressed {
+		self.Value, self.Compressed = Compress(self.Value, threshold)
+	}
+	return self
+}
+
+// Decompress will decompress the value of this Item if it is compressed.
+func (self *Item) Decompress() (err error) {
+	if self.Compressed {
+		if self.Value, err = Decompress(self.Value, true); err == nil {
+			self.Compressed = false
+		}
+	}
+	return
+}
+
+// gobCodec is a net/rpc codec using the same wire format as the default net/rpc codec, but compressing and decompressing
+// Compressible and Decompressible arguments and replies.
+type gobCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobCodec(rwc io.ReadWriteCloser) *gobCodec {
+	buf := bufio.NewWriter(rwc)
+	return &gobCodec{
+		rwc:    rwc,
+		dec:    gob.NewDecoder(rwc),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+func (self *gobCodec) write(header, body interface{}) (err error) {
+	if err = self.enc.Encode(header); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	if err = self.enc.Encode(compressed(body)); err != nil {
+		if self.encBuf.Flush() == nil {
+			self.Close()
+		}
+		return
+	}
+	return self.encBuf.Flush()
+}
+func (self *gobCodec) readBody(body interface{}) (err error) {
+	if err = self.dec.Decode(body); err != nil || body == nil {
+		return
+	}
+	return decompress(body)
+}
+func (self *gobCodec) Close() error {
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	return self.rwc.Close()
+}
+
+type clientCodec struct {
+	*gobCodec
+}
+
+// NewClientCodec returns a net/rpc.ClientCodec that compresses arguments and decompresses replies.
+func NewClientCodec(rwc io.ReadWriteCloser) rpc.ClientCodec {
+	return clientCodec{newGobCodec(rwc)}
+}
+func (self clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	return self.write(r, body)
+}
+func (self clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return self.dec.Decode(r)
+}
+func (self clientCodec) ReadResponseBody(body interface{}) error {
+	return self.readBody(body)
+}
+
+type serverCodec struct {
+	*gobCodec
+}
+
+// NewServerCodec returns a net/rpc.ServerCodec that decompresses arguments and compresses replies.
+func NewServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	return serverCodec{newGobCodec(rwc)}
+}
+func (self serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	return self.dec.Decode(r)
+}
+func (self serverCodec) ReadRequestBody(body interface{}) error {
+	return self.readBody(body)
+}
+func (self serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	return self.write(r, body)
+}
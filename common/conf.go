@@ -1,5 +1,37 @@
 package common
 
+const (
+	// ImmutableConf set to 'yes' in the configuration of a sub tree makes the key of the sub tree write-once.
+	ImmutableConf = "immutable"
+	// ImmutablePrefixConf followed by a hex encoded prefix and set to 'yes' in the cluster configuration makes all keys with that prefix write-once.
+	ImmutablePrefixConf = "immutable:"
+	// FrozenPrefixConf followed by a range, like '[6162,6364)' for the hex encoded keys from 'ab', inclusive, to 'cd', exclusive, and set to 'yes' in the cluster
+	// configuration makes the owners of keys in the range reject writes to them with ErrFrozen.
+	FrozenPrefixConf = "frozen:"
+	// RedundancyConf in the cluster configuration contains the number of copies of each key kept by the cluster.
+	RedundancyConf = "redundancy"
+	// RedundancyLoweredConf in the cluster configuration contains the cluster time, in nanoseconds, when the redundancy was last lowered.
+	RedundancyLoweredConf = "redundancyLowered"
+	// ContentRefsConf in the configuration of a sub tree contains the number of references to content put under the key of the sub tree.
+	ContentRefsConf = "contentRefs"
+	// ChunkConf set to 'yes' in the configuration of a sub tree marks content put under the key of the sub tree as a chunk, removed by garbage collection when no manifest refers to it.
+	ChunkConf = "chunk"
+	// UnreferencedConf in the configuration of a sub tree marked as a chunk contains the cluster time, in nanoseconds, when garbage collection first found the chunk unreferenced.
+	UnreferencedConf = "unreferenced"
+	// ManifestConf set to 'yes' in the configuration of a sub tree makes the values of the sub tree references to chunks.
+	ManifestConf = "manifest"
+	// ChunkedConf in the configuration of a sub tree contains the hex encoded murmur hash of the value of the key of the sub tree if the value
+	// is a manifest of a chunked value, that is the concatenated keys of its chunks.
+	ChunkedConf = "chunked"
+	// ChunkSizesConf in the configuration of a sub tree contains the hex encoded murmur hash of the manifest under the key of the sub tree, a colon,
+	// and the comma separated sizes of its chunks, to let readers of byte ranges know which chunks to fetch.
+	ChunkSizesConf = "chunkSizes"
+	// MaxMembersConf set to a positive number in the configuration of a sub tree makes the owner of the sub tree remove its oldest members beyond that number.
+	MaxMembersConf = "maxMembers"
+	// MaxAgeConf set to a positive duration, like '24h', in the configuration of a sub tree makes the owner of the sub tree remove the members put longer ago than that.
+	MaxAgeConf = "maxAge"
+)
+
 type ConfItem struct {
 	TreeKey   []byte
 	Key       string
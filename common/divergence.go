@@ -0,0 +1,36 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Divergence records a value that differed between two replicas, and was copied from one to the other by a sync.
+type Divergence struct {
+	Time time.Time
+	// Source is the address of the node the value was copied from, and Destination the address of the node it was copied to.
+	Source      string
+	Destination string
+	Key         []byte
+	// SubKey is the key of the value within the sub tree of Key, or nil if the value is the byte value of Key.
+	SubKey []byte
+	// SourceHash and DestinationHash are the murmur hashes of the values, and DestinationHash is nil if the destination had no value.
+	SourceTimestamp      int64
+	SourceHash           []byte
+	DestinationTimestamp int64
+	DestinationHash      []byte
+	// Deleted is whether the copied value was a tombstone.
+	Deleted bool
+}
+
+func (self Divergence) String() string {
+	key := HexEncode(self.Key)
+	if self.SubKey != nil {
+		key = fmt.Sprintf("%v/%v", key, HexEncode(self.SubKey))
+	}
+	deleted := ""
+	if self.Deleted {
+		deleted = " (deleted)"
+	}
+	return fmt.Sprintf("%v %v: %v@%v%v on %v replaced %v@%v on %v", self.Time, key, HexEncode(self.SourceHash), self.SourceTimestamp, deleted, self.Source, HexEncode(self.DestinationHash), self.DestinationTimestamp, self.Destination)
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmbeddedPrefix is the prefix of addresses served inside the process through a Switchboard, instead of over the network.
+// Nodes listening to such addresses can be used with the same API as nodes in a cluster, without opening any ports.
+const EmbeddedPrefix = "embedded:"
+
+// errListenerClosed has the same message as the error of a closed net.Listener, since accept loops look for it.
+var errListenerClosed = errors.New("use of closed network connection")
+
+// IsEmbedded returns whether addr is an address served inside the process.
+func IsEmbedded(addr string) bool {
+	return strings.HasPrefix(addr, EmbeddedPrefix)
+}
+
+type embeddedAddr string
+
+func (self embeddedAddr) Network() string {
+	return "embedded"
+}
+func (self embeddedAddr) String() string {
+	return string(self)
+}
+
+// embeddedListener is a net.Listener accepting in-memory connections dialed through a Switchboard.
+type embeddedListener struct {
+	addr        string
+	conns       chan net.Conn
+	closed      chan struct{}
+	once        *sync.Once
+	switchboard *Switchboard
+}
+
+func (self *embeddedListener) Accept() (conn net.Conn, err error) {
+	select {
+	case conn = <-self.conns:
+		return
+	case <-self.closed:
+		return nil, errListenerClosed
+	}
+}
+func (self *embeddedListener) Close() error {
+	self.once.Do(func() {
+		close(self.closed)
+		self.switchboard.lock.Lock()
+		defer self.switchboard.lock.Unlock()
+		if self.switchboard.listeners[self.addr] == self {
+			delete(self.switchboard.listeners, self.addr)
+		}
+	})
+	return nil
+}
+func (self *embeddedListener) Addr() net.Addr {
+	return embeddedAddr(self.addr)
+}
+
+// Listen will return a net.Listener accepting the connections this Switchboard makes to the embedded address addr.
+func (self *Switchboard) Listen(addr string) (result net.Listener, err error) {
+	if !IsEmbedded(addr) {
+		return nil, fmt.Errorf("%v is not an embedded address", addr)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, found := self.listeners[addr]; found {
+		return nil, fmt.Errorf("Something is already listening at %v", addr)
+	}
+	listener := &embeddedListener{
+		addr:        addr,
+		conns:       make(chan net.Conn),
+		closed:      make(chan struct{}),
+		once:        new(sync.Once),
+		switchboard: self,
+	}
+	self.listeners[addr] = listener
+	return listener, nil
+}
+
+// dial will connect to addr, in memory if it is an embedded address and over tcp otherwise.
+func (self *Switchboard) dial(addr string, timeout time.Duration) (conn net.Conn, err error) {
+	if !IsEmbedded(addr) {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	self.lock.RLock()
+	listener, found := self.listeners[addr]
+	self.lock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("Nothing is listening at %v", addr)
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.closed:
+		err = fmt.Errorf("Nothing is listening at %v", addr)
+	case <-expired:
+		err = fmt.Errorf("Connecting to %v timed out after %v", addr, timeout)
+	}
+	client.Close()
+	server.Close()
+	return
+}
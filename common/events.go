@@ -0,0 +1,83 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// The types of the cluster events reported by the nodes.
+const (
+	// EventJoined is reported by a node when it has joined a ring.
+	EventJoined = "Joined"
+	// EventLeft is reported by the successor of a node when it is removed from the ring.
+	EventLeft = "Left"
+	// EventDecommissioned is reported by a node when it starts handing its data over to its replicas before stopping.
+	EventDecommissioned = "Decommissioned"
+	// EventMigrated is reported by a node when it has changed position to balance the load, and will move data to or from its neighbours.
+	EventMigrated = "Migrated"
+	// EventMigrationPaused and EventMigrationResumed are reported by a node when PauseMigration and ResumeMigration change its state.
+	EventMigrationPaused  = "MigrationPaused"
+	EventMigrationResumed = "MigrationResumed"
+	// EventSyncPaused and EventSyncResumed are reported by a node when PauseSync and ResumeSync change its state.
+	EventSyncPaused  = "SyncPaused"
+	EventSyncResumed = "SyncResumed"
+	// EventFrozen and EventUnfrozen are reported by a node when it starts and stops rejecting writes to a range.
+	EventFrozen   = "Frozen"
+	EventUnfrozen = "Unfrozen"
+	// EventQuorumReached is reported by a node when it has seen the minimum number of nodes and starts serving writes.
+	EventQuorumReached = "QuorumReached"
+	// EventOverloaded is reported by a node when it has rejected connections or requests because of its limits.
+	EventOverloaded = "Overloaded"
+	// EventConvergenceMissed and EventConvergenceMet are reported by a node with a convergence bound when its oldest unsynced write or divergence
+	// becomes older than the bound, and when it is within the bound again.
+	EventConvergenceMissed = "ConvergenceMissed"
+	EventConvergenceMet    = "ConvergenceMet"
+	// EventExpired is reported by the owner of a sub tree, in a stream separate from the other events, when it removes a member put longer ago than the maxAge of the sub tree.
+	// Members removed because the sub tree has more than maxMembers, or by explicit deletes, are not reported.
+	EventExpired = "Expired"
+	// EventMissed is not reported by nodes, but delivered by subscriptions when the node in Node dropped events before they were delivered.
+	// Detail contains the number of missed events.
+	EventMissed = "Missed"
+)
+
+// Event is something that happened to a node or its ring.
+type Event struct {
+	// Seq is the sequence number of the event among the events reported by Node.
+	Seq  int64
+	Time time.Time
+	Node string
+	Type string
+	// Addr is the address of the node the event is about, if it isn't Node.
+	Addr string
+	// Detail describes the event, for example the positions of a migration or the number of rejected requests.
+	Detail string
+	// Key and SubKey are the sub tree and member the event is about, if it is about an entry.
+	Key    []byte
+	SubKey []byte
+}
+
+func (self Event) String() string {
+	if self.Key != nil {
+		return fmt.Sprintf("%v %v: %v %v/%v %v", self.Time, self.Node, self.Type, HexEncode(self.Key), HexEncode(self.SubKey), self.Detail)
+	}
+	if self.Addr != "" {
+		return fmt.Sprintf("%v %v: %v %v %v", self.Time, self.Node, self.Type, self.Addr, self.Detail)
+	}
+	return fmt.Sprintf("%v %v: %v %v", self.Time, self.Node, self.Type, self.Detail)
+}
+
+// EventsRequest asks a node for the events it reported after Since, waiting at most Wait for one if there are none.
+// A negative Since asks only for the current sequence number.
+type EventsRequest struct {
+	Since int64
+	Wait  time.Duration
+}
+
+// Events contains the events a node reported after a sequence number.
+type Events struct {
+	Events []Event
+	// Seq is the sequence number of the last event the node reported.
+	Seq int64
+	// Missed is the number of events after the asked sequence number that were dropped before they were asked for.
+	Missed int64
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// MaxHeatmapBuckets is the largest number of buckets a Heatmap can have.
+const MaxHeatmapBuckets = 4096
+
+// HeatmapBucket contains the number of keys, and the number of bytes in their keys and values, in one segment of the ring.
+type HeatmapBucket struct {
+	// From is the position where the segment starts.
+	From  []byte
+	Keys  int
+	Bytes int64
+}
+
+// Heatmap divides the ring in equally sized segments, and contains the number of keys and bytes in each.
+type Heatmap struct {
+	Buckets []HeatmapBucket
+	// Failed contains the errors returned by the nodes that didn't report their keys, by address.
+	Failed map[string]string
+}
+
+// NewHeatmap returns an empty Heatmap with n buckets, limited to between 1 and MaxHeatmapBuckets.
+func NewHeatmap(n int) (result Heatmap) {
+	if n < 1 {
+		n = 1
+	}
+	if n > MaxHeatmapBuckets {
+		n = MaxHeatmapBuckets
+	}
+	result.Buckets = make([]HeatmapBucket, n)
+	// Bucket uses the first 8 bytes of the keys, so the segments start at the first 8 byte prefix within them.
+	size := new(big.Int).Lsh(big.NewInt(1), 64)
+	for index := range result.Buckets {
+		from := new(big.Int).Mul(size, big.NewInt(int64(index)))
+		from = from.Add(from, big.NewInt(int64(n-1))).Div(from, big.NewInt(int64(n)))
+		result.Buckets[index].From = make([]byte, 16)
+		fromBytes := from.Bytes()
+		copy(result.Buckets[index].From[8-len(fromBytes):], fromBytes)
+	}
+	return
+}
+
+// Bucket returns the index of the bucket containing key.
+func (self *Heatmap) Bucket(key []byte) int {
+	var prefix uint64
+	for i := 0; i < 8; i++ {
+		prefix <<= 8
+		if i < len(key) {
+			prefix |= uint64(key[i])
+		}
+	}
+	hi, _ := bits.Mul64(prefix, uint64(len(self.Buckets)))
+	return int(hi)
+}
+
+// Record will add a key of the given number of bytes to the bucket containing it.
+func (self *Heatmap) Record(key []byte, bytes int) {
+	bucket := &self.Buckets[self.Bucket(key)]
+	bucket.Keys++
+	bucket.Bytes += int64(bytes)
+}
+
+// Fail will record that the node at addr didn't report its keys.
+func (self *Heatmap) Fail(addr string, err error) {
+	if self.Failed == nil {
+		self.Failed = make(map[string]string)
+	}
+	self.Failed[addr] = err.Error()
+}
+
+// Add will add the keys and bytes of other, which must have the same number of buckets, to this Heatmap.
+func (self *Heatmap) Add(other Heatmap) {
+	for index := range self.Buckets {
+		if index < len(other.Buckets) {
+			self.Buckets[index].Keys += other.Buckets[index].Keys
+			self.Buckets[index].Bytes += other.Buckets[index].Bytes
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestHeatmap(t *testing.T) {
+	heatmap := NewHeatmap(3)
+	for index, bucket := range heatmap.Buckets {
+		if found := heatmap.Bucket(bucket.From); found != index {
+			t.Errorf("wanted %v to be in bucket %v, got %v", HexEncode(bucket.From), index, found)
+		}
+		if index > 0 {
+			before := append([]byte{}, bucket.From[:8]...)
+			for i := 7; i >= 0; i-- {
+				before[i]--
+				if before[i] != 255 {
+					break
+				}
+			}
+			if found := heatmap.Bucket(before); found != index-1 {
+				t.Errorf("wanted %v to be in bucket %v, got %v", HexEncode(before), index-1, found)
+			}
+		}
+	}
+	heatmap.Record([]byte{0}, 10)
+	heatmap.Record([]byte{255, 255}, 5)
+	heatmap.Record([]byte{255}, 5)
+	other := NewHeatmap(3)
+	other.Record([]byte{128}, 1)
+	heatmap.Add(other)
+	if heatmap.Buckets[0].Keys != 1 || heatmap.Buckets[1].Bytes != 1 || heatmap.Buckets[2].Keys != 2 || heatmap.Buckets[2].Bytes != 10 {
+		t.Errorf("wrong buckets: %+v", heatmap)
+	}
+	if n := len(NewHeatmap(0).Buckets); n != 1 {
+		t.Errorf("wanted 1 bucket, got %v", n)
+	}
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"time"
+)
+
+const (
+	// IDNodeBits is the number of bits of the node id in IDs.
+	IDNodeBits = 10
+	// IDSequenceBits is the number of bits of the sequence number in IDs.
+	IDSequenceBits = 12
+	// IDEpoch is the time, in milliseconds since 1970, that the timestamps of IDs count from.
+	IDEpoch = 1356998400000
+)
+
+// EncodeID returns the ID made of the timestamp millis, in milliseconds since IDEpoch, the node id node, and the sequence number seq.
+// IDs sort by timestamp first, so they are roughly time ordered.
+func EncodeID(millis int64, node, seq int) int64 {
+	return millis<<(IDNodeBits+IDSequenceBits) | int64(node&(1<<IDNodeBits-1))<<IDSequenceBits | int64(seq&(1<<IDSequenceBits-1))
+}
+
+// DecodeID returns the time, node id and sequence number id was made of.
+func DecodeID(id int64) (t time.Time, node, seq int) {
+	millis := id>>(IDNodeBits+IDSequenceBits) + IDEpoch
+	t = time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+	node = int(id>>IDSequenceBits) & (1<<IDNodeBits - 1)
+	seq = int(id) & (1<<IDSequenceBits - 1)
+	return
+}
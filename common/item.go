@@ -9,4 +9,14 @@ type Item struct {
 	TTL       int
 	Index     int
 	Sync      bool
+	QoS       QoS
+	Immutable bool
+	Override  bool
+	// Compressed is set when Value is compressed on the wire.
+	Compressed bool
+	// Token is an optional idempotency token, making nodes ignore the write if they recently applied a write with the same token.
+	Token []byte
+	// Debug makes nodes measure where they spend the time handling the request. Get responses then contain the Timing of the answering node.
+	Debug  bool
+	Timing *Timing
 }
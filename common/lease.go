@@ -0,0 +1,14 @@
+package common
+
+import (
+	"time"
+)
+
+// Lease is a cluster wide lock on Key, held by the owner of Token until Expires (in timenet nanoseconds).
+type Lease struct {
+	Key      []byte
+	Token    []byte
+	Duration time.Duration
+	Expires  int64
+	Acquired bool
+}
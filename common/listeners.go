@@ -0,0 +1,60 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Listeners is a list of listeners of type L, each kept until a call to it returns false. The zero value is an empty list, safe for concurrent use.
+type Listeners[L any] struct {
+	lock      sync.RWMutex
+	listeners []*L
+	size      int32
+}
+
+// Add will add l to the list.
+func (self *Listeners[L]) Add(l L) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.listeners = append(self.listeners, &l)
+	atomic.StoreInt32(&self.size, int32(len(self.listeners)))
+}
+
+// Len returns the number of listeners in the list, without locking it.
+func (self *Listeners[L]) Len() int {
+	return int(atomic.LoadInt32(&self.size))
+}
+
+// Trigger will call call with each listener in the list, and remove the listeners it returned false for.
+// The list isn't locked while call runs, so listeners may add other listeners.
+func (self *Listeners[L]) Trigger(call func(l L) (keep bool)) {
+	if self.Len() == 0 {
+		return
+	}
+	self.lock.RLock()
+	listeners := self.listeners
+	self.lock.RUnlock()
+	var removed map[*L]bool
+	for _, l := range listeners {
+		if !call(*l) {
+			if removed == nil {
+				removed = make(map[*L]bool)
+			}
+			removed[l] = true
+		}
+	}
+	if removed == nil {
+		return
+	}
+	// Only remove the listeners that returned false, since others may have been added or removed while we called them.
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	newListeners := make([]*L, 0, len(self.listeners))
+	for _, l := range self.listeners {
+		if !removed[l] {
+			newListeners = append(newListeners, l)
+		}
+	}
+	self.listeners = newListeners
+	atomic.StoreInt32(&self.size, int32(len(self.listeners)))
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestListeners(t *testing.T) {
+	var listeners Listeners[func(string) bool]
+	var calls []string
+	listeners.Add(func(s string) bool {
+		calls = append(calls, "first "+s)
+		listeners.Add(func(s string) bool {
+			calls = append(calls, "second "+s)
+			return true
+		})
+		return false
+	})
+	trigger := func(s string) {
+		listeners.Trigger(func(l func(string) bool) bool {
+			return l(s)
+		})
+	}
+	trigger("a")
+	trigger("b")
+	if fmt.Sprint(calls) != "[first a second b]" {
+		t.Errorf("wanted the first listener to be removed and the one added while it was called to be kept, got %v", calls)
+	}
+	if n := listeners.Len(); n != 1 {
+		t.Errorf("wanted 1 listener, got %v", n)
+	}
+}
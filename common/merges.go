@@ -0,0 +1,117 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zond/setop"
+)
+
+// MergeFunc is a user defined merge function for set expressions. It will get all values found for key and return the merged value.
+type MergeFunc func(key []byte, values [][]byte) []byte
+
+var mergeNamePattern = regexp.MustCompile("^\\w+$")
+var mergePattern = regexp.MustCompile("\\(\\s*\\w+\\s*:\\s*(\\w+)")
+
+var merges = make(map[string]MergeFunc)
+var mergeLock sync.RWMutex
+
+// RegisterMerge will make f available to set expressions as the merge function name, for example in '(U:name a b)'.
+// It must be called on all nodes in the cluster, preferably before they are started.
+//
+// User defined merge functions are only allowed in the outermost operation of an expression.
+func RegisterMerge(name string, f MergeFunc) error {
+	if !mergeNamePattern.MatchString(name) {
+		return fmt.Errorf("Invalid merge function name %#v", name)
+	}
+	if f == nil {
+		return fmt.Errorf("Merge function %#v is nil", name)
+	}
+	if builtinMerge(name) {
+		return fmt.Errorf("Merge function %#v is already built in", name)
+	}
+	mergeLock.Lock()
+	defer mergeLock.Unlock()
+	if _, found := merges[name]; found {
+		return fmt.Errorf("Merge function %#v is already registered", name)
+	}
+	merges[name] = f
+	return nil
+}
+
+// Merges returns the names of all user defined merge functions.
+func Merges() (result []string) {
+	mergeLock.RLock()
+	defer mergeLock.RUnlock()
+	for name, _ := range merges {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return
+}
+
+func builtinMerge(name string) bool {
+	_, err := setop.NewSetOpParser(fmt.Sprintf("(U:%v a)", name)).Parse()
+	return err == nil
+}
+
+func lookupMerge(name string) (result MergeFunc) {
+	mergeLock.RLock()
+	defer mergeLock.RUnlock()
+	return merges[name]
+}
+
+// operations returns op and all operations nested in it, in the order they are parsed in, which is the order of their opening parentheses in the code.
+func operations(op *setop.SetOp) (result []*setop.SetOp) {
+	result = append(result, op)
+	for _, source := range op.Sources {
+		if source.SetOp != nil {
+			result = append(result, operations(source.SetOp)...)
+		}
+	}
+	return
+}
+
+// ParseExpression will parse expr.Code into expr.Op unless expr.Op is already set.
+// If the outermost operation uses a user defined merge function it will be parsed as Append, and the user defined function returned.
+func ParseExpression(expr *setop.SetExpression) (merge MergeFunc, err error) {
+	if expr.Op != nil {
+		return
+	}
+	code := expr.Code
+	var custom [][]int
+	for _, match := range mergePattern.FindAllStringSubmatchIndex(expr.Code, -1) {
+		name := expr.Code[match[2]:match[3]]
+		if builtinMerge(name) {
+			continue
+		}
+		found := lookupMerge(name)
+		if found == nil {
+			err = fmt.Errorf("Unknown merge function %#v, known user defined merge functions are %v", name, Merges())
+			return
+		}
+		merge = found
+		custom = append(custom, match)
+	}
+	// Replace the names from the end, to keep the positions of the earlier ones.
+	for index := len(custom) - 1; index >= 0; index-- {
+		code = code[:custom[index][2]] + "Append" + code[custom[index][3]:]
+	}
+	if expr.Op, err = setop.NewSetOpParser(code).Parse(); err != nil {
+		merge = nil
+		return
+	}
+	parsed := operations(expr.Op)
+	for _, match := range custom {
+		// The match starts with the opening parenthesis of its operation.
+		if parsed[strings.Count(expr.Code[:match[0]], "(")] != expr.Op {
+			err = fmt.Errorf("User defined merge function %#v is only allowed in the outermost operation of %#v", expr.Code[match[2]:match[3]], expr.Code)
+			expr.Op, merge = nil, nil
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,21 @@
+package common
+
+// QoS is the service class of a request. Nodes will let Interactive requests run ahead of Batch requests when loaded.
+type QoS int
+
+const (
+	// Interactive is the default class, for user facing requests where latency matters.
+	Interactive QoS = iota
+	// Batch is for bulk jobs, scans and sync traffic that may be delayed in favour of Interactive requests.
+	Batch
+)
+
+func (self QoS) String() string {
+	switch self {
+	case Interactive:
+		return "Interactive"
+	case Batch:
+		return "Batch"
+	}
+	return "Unknown"
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"bytes"
+)
+
+const (
+	KeyField       = "key"
+	ValueField     = "value"
+	ValueSizeField = "size(value)"
+)
+
+// Condition compares a field of an Item with a constant.
+// Conditions on ValueSizeField compare with Size, all other conditions compare with Value.
+type Condition struct {
+	Field string
+	Op    string
+	Value []byte
+	Size  int
+}
+
+func (self Condition) compare(item Item) int {
+	switch self.Field {
+	case KeyField:
+		return bytes.Compare(item.Key, self.Value)
+	case ValueField:
+		return bytes.Compare(item.Value, self.Value)
+	case ValueSizeField:
+		return len(item.Value) - self.Size
+	}
+	return 0
+}
+
+// Match returns whether item fulfills this Condition.
+func (self Condition) Match(item Item) bool {
+	cmp := self.compare(item)
+	switch self.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// Query is a Range with Conditions, executed by the nodes owning the Range.
+// Range.Key selects a sub tree, or the top level tree if nil. Range.Len limits the number of results, if positive.
+type Query struct {
+	Range      Range
+	Conditions []Condition
+}
+
+// Match returns whether item fulfills all Conditions of this Query.
+func (self Query) Match(item Item) bool {
+	for _, condition := range self.Conditions {
+		if !condition.Match(item) {
+			return false
+		}
+	}
+	return true
+}
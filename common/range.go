@@ -1,5 +1,9 @@
 package common
 
+import (
+	"bytes"
+)
+
 type Range struct {
 	Key      []byte
 	Min      []byte
@@ -9,4 +13,28 @@ type Range struct {
 	MinIndex int
 	MaxIndex int
 	Len      int
+	QoS      QoS
+}
+
+// Within returns whether key is between Min and Max of this Range, honoring MinInc and MaxInc. A nil Min or Max means the Range is unbounded in that direction.
+func (self Range) Within(key []byte) bool {
+	if self.Min != nil {
+		if cmp := bytes.Compare(key, self.Min); cmp < 0 || (cmp == 0 && !self.MinInc) {
+			return false
+		}
+	}
+	if self.Max != nil {
+		if cmp := bytes.Compare(key, self.Max); cmp > 0 || (cmp == 0 && !self.MaxInc) {
+			return false
+		}
+	}
+	return true
+}
+
+// ByteRange is a request for at most Length bytes of the value under Key, starting at Offset.
+type ByteRange struct {
+	Key    []byte
+	Offset int64
+	Length int64
+	QoS    QoS
 }
@@ -188,14 +188,14 @@ func (self *Ring) Add(r Remote) {
 	self.sendChanges(oldHash)
 }
 
-// Redundancy returns the minimum of the number of nodes present and the Redundancy const.
+// Redundancy returns the minimum of the number of nodes present and the redundancy of the cluster.
 func (self *Ring) Redundancy() int {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
-	if len(self.nodes) < Redundancy {
-		return len(self.nodes)
+	if r := GetRedundancy(); len(self.nodes) >= r {
+		return r
 	}
-	return Redundancy
+	return len(self.nodes)
 }
 
 // Remotes returns the predecessor of pos, any Remote at pos and the successor of pos.
@@ -218,6 +218,20 @@ func (self *Ring) Remotes(pos []byte) (before, at, after *Remote) {
 	return
 }
 
+// Replicas returns the n first Remotes responsible for pos, starting with the successor of pos.
+func (self *Ring) Replicas(pos []byte, n int) (result Remotes) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	_, _, after := self.byteIndices(pos)
+	if after == -1 {
+		return
+	}
+	for i := 0; i < n && i < len(self.nodes); i++ {
+		result = append(result, self.nodes[(after+i)%len(self.nodes)].Clone())
+	}
+	return
+}
+
 /*
 byteIndices searches the Ring for a position, and returns the last index before the position,
 the index where the positon can be found (or -1) and the first index after the position.
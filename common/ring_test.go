@@ -50,6 +50,19 @@ func buildRing() (*Ring, Remotes) {
 	return r, cmp
 }
 
+func TestRingReplicas(t *testing.T) {
+	r, cmp := buildRing()
+	if replicas, wanted := r.Replicas([]byte{5}, 3), (Remotes{cmp[5], cmp[6], cmp[0]}); !reflect.DeepEqual(replicas, wanted) {
+		t.Error(replicas, "should ==", wanted)
+	}
+	if replicas, wanted := r.Replicas([]byte{7}, 3), cmp[:3]; !reflect.DeepEqual(replicas, wanted) {
+		t.Error(replicas, "should ==", wanted)
+	}
+	if replicas := r.Replicas([]byte{3}, 10); len(replicas) != len(cmp) || !replicas[0].Equal(cmp[4]) {
+		t.Error(replicas)
+	}
+}
+
 func TestRingClean(t *testing.T) {
 	r, cmp := buildRing()
 	r.Clean(Remote{[]byte{0}, "a"}, Remote{[]byte{2}, "c"})
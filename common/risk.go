@@ -0,0 +1,82 @@
+package common
+
+import (
+	"math"
+	"net"
+	"time"
+)
+
+// NodeRisk describes how far the data owned by a node may be from being safely replicated.
+type NodeRisk struct {
+	Addr string
+	Zone string
+	// OldestUnsynced is the age of the oldest write to the range owned by the node that no sync with its replicas has covered yet, or zero if there is none.
+	OldestUnsynced time.Duration
+	// DivergenceAge is for how long the syncs of the node have kept finding differences between it and its replicas, or zero if the last sync found none.
+	DivergenceAge time.Duration
+	// ConvergenceBound is how soon the node aims to have every write to its range copied to its replicas, or zero if it has no such target.
+	ConvergenceBound time.Duration
+}
+
+// Lag returns the longer of OldestUnsynced and DivergenceAge, how long the replicas of the node may have been missing some write.
+func (self NodeRisk) Lag() time.Duration {
+	if self.DivergenceAge > self.OldestUnsynced {
+		return self.DivergenceAge
+	}
+	return self.OldestUnsynced
+}
+
+// ConvergenceMet returns whether the node has no convergence bound, or its Lag is within it.
+func (self NodeRisk) ConvergenceMet() bool {
+	return self.ConvergenceBound == 0 || self.Lag() <= self.ConvergenceBound
+}
+
+// RangeRisk describes the replicas of the range owned by one node.
+type RangeRisk struct {
+	// From and To are the positions of the predecessor of the owner and the owner.
+	From  []byte
+	To    []byte
+	Owner string
+	// Replicas are the addresses of the owner and its replicas that answered, Hosts and Zones the number of distinct hosts and zones among them.
+	Replicas []string
+	Hosts    int
+	Zones    int
+}
+
+// Host returns the host part of addr, or addr itself if it has no port.
+func Host(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// RiskReport estimates the data loss exposure of a cluster.
+type RiskReport struct {
+	// Redundancy is the target number of replicas of each range.
+	Redundancy int
+	Nodes      []NodeRisk
+	// Failed contains the errors returned by the nodes that didn't report, by address.
+	Failed map[string]string
+	Ranges []RangeRisk
+	// UnderReplicated contains the ranges with fewer answering replicas than Redundancy.
+	UnderReplicated []RangeRisk
+	// Colocated contains the ranges with several replicas on the same host, or in the same zone.
+	Colocated []RangeRisk
+	// SharedHosts contains the addresses of the nodes on each host running more than one node.
+	SharedHosts      map[string][]string
+	OldestUnsynced   time.Duration
+	MaxDivergenceAge time.Duration
+	// Lagging contains the addresses of the nodes not meeting their convergence bounds.
+	Lagging []string
+}
+
+// LossProbability estimates the probability that some range loses all its replicas, if each host fails independently with probability p.
+// Ranges without answering replicas count as already lost.
+func (self *RiskReport) LossProbability(p float64) float64 {
+	survival := 1.0
+	for _, r := range self.Ranges {
+		survival *= 1 - math.Pow(p, float64(r.Hosts))
+	}
+	return 1 - survival
+}
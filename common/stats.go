@@ -0,0 +1,81 @@
+package common
+
+import (
+	"time"
+)
+
+// Stats contains the size, activity and health of a dhash node, for capacity planning.
+type Stats struct {
+	Addr   string
+	Uptime time.Duration
+	// HeldEntries is the number of entries, including tombstones, held by the node, and OwnedEntries the number of them the node is responsible for.
+	HeldEntries  int
+	OwnedEntries int
+	// LogBytes is the size of the logfiles and snapshots of the node on disk.
+	LogBytes int64
+	// Requests is the number of requests handled for each service method since the node started, and RequestRates the recent number of requests per second.
+	Requests     map[string]int64
+	RequestRates map[string]float64
+	// Syncs, Cleans and Migrations are the number of times the node has synchronized with, or cleaned to, another node, and migrated, since it started.
+	// SyncedEntries and CleanedEntries are the number of entries copied or removed doing so.
+	Syncs          int64
+	SyncedEntries  int64
+	Cleans         int64
+	CleanedEntries int64
+	Migrations     int64
+	// RejectedConnections and RejectedRequests are the number of connections and requests rejected because the node was overloaded.
+	RejectedConnections int64
+	RejectedRequests    int64
+	ClockOffset         time.Duration
+	ClockError          time.Duration
+	// ReplayTokens is the number of idempotency tokens the node remembers, Replays the number of writes it didn't apply again because their tokens were remembered,
+	// and ReplayEvictions the number of tokens it forgot early to stay within the size of its replay window.
+	ReplayTokens    int
+	Replays         int64
+	ReplayEvictions int64
+	// PeerLatencies is the mean latency to each peer, by address.
+	PeerLatencies map[string]time.Duration
+}
+
+// ClusterStats contains the Stats of all nodes in a cluster, and their totals.
+type ClusterStats struct {
+	Nodes []Stats
+	// Failed contains the errors returned by the nodes that didn't report their Stats, by address.
+	Failed         map[string]string
+	HeldEntries    int
+	OwnedEntries   int
+	LogBytes       int64
+	RequestRates   map[string]float64
+	MaxClockError  time.Duration
+	MaxPeerLatency time.Duration
+}
+
+// Add will add the Stats of a node to this ClusterStats.
+func (self *ClusterStats) Add(stats Stats) {
+	self.Nodes = append(self.Nodes, stats)
+	self.HeldEntries += stats.HeldEntries
+	self.OwnedEntries += stats.OwnedEntries
+	self.LogBytes += stats.LogBytes
+	if self.RequestRates == nil {
+		self.RequestRates = make(map[string]float64)
+	}
+	for method, rate := range stats.RequestRates {
+		self.RequestRates[method] += rate
+	}
+	if stats.ClockError > self.MaxClockError {
+		self.MaxClockError = stats.ClockError
+	}
+	for _, latency := range stats.PeerLatencies {
+		if latency > self.MaxPeerLatency {
+			self.MaxPeerLatency = latency
+		}
+	}
+}
+
+// Fail will record that the node at addr didn't report its Stats.
+func (self *ClusterStats) Fail(addr string, err error) {
+	if self.Failed == nil {
+		self.Failed = make(map[string]string)
+	}
+	self.Failed[addr] = err.Error()
+}
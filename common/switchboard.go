@@ -1,72 +1,361 @@
 package common
 
 import (
+	"fmt"
+	"net"
 	"net/rpc"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+const (
+	defaultDialTimeout     = time.Second * 2
+	defaultCallTimeout     = time.Second * 10
+	defaultLongCallTimeout = time.Minute * 10
+	defaultPoolSize        = 4
+	defaultRetries         = 2
+	defaultBackoff         = time.Millisecond * 10
+	defaultResolveInterval = time.Second * 30
+)
+
+// longCalls are the services that scan, copy or wait for large parts of the data, and get defaultLongCallTimeout instead of the call timeout.
+var longCalls = []string{
+	"DHash.Snapshot",
+	"DHash.Restore",
+	"DHash.SetExpression",
+	"DHash.Query",
+	"DHash.Backup",
+	"DHash.BackupAt",
+	"DHash.ReadBackup",
+	"DHash.Heatmap",
+	"DHash.ClusterStats",
+	"DHash.VerifyReplicas",
+	"DHash.SetRedundancy",
+	"DHash.Decommission",
+	"DHash.CollectGarbage",
+}
+
 // Switch is the default Switchboard.
 var Switch = newSwitchboard()
 
-// Switchboard is a simple map of net/rpc.Clients, to avoid having to set up new connections for each remote call.
+// TimeoutError is returned when a remote call doesn't return within the call timeout of the Switchboard.
+type TimeoutError struct {
+	Addr    string
+	Service string
+	Timeout time.Duration
+}
+
+func (self TimeoutError) Error() string {
+	return fmt.Sprintf("%v to %v timed out after %v", self.Service, self.Addr, self.Timeout)
+}
+
+// dialError is returned when a connection for a call couldn't be set up, so the call was never sent and can safely be retried.
+type dialError struct {
+	err error
+}
+
+func (self dialError) Error() string {
+	return self.err.Error()
+}
+
+// pool is a bounded set of net/rpc.Clients to one address, used round robin.
+type pool struct {
+	lock    *sync.Mutex
+	clients []*rpc.Client
+	next    int
+	// dialing is the number of connections being set up, and dialed is signalled when one is done.
+	dialing int
+	dialed  *sync.Cond
+	// resolved contains the sorted IP addresses the host of the address resolved to when last checked.
+	resolved []string
+}
+
+func newPool() (result *pool) {
+	result = &pool{lock: new(sync.Mutex)}
+	result.dialed = sync.NewCond(result.lock)
+	return
+}
+
+// get will return a pooled client, or dial a new one without holding the lock if the pool isn't full, so that a slow dial doesn't block the calls using the pooled clients.
+func (self *pool) get(addr string, size int, timeout time.Duration, dial func(addr string, timeout time.Duration) (net.Conn, error)) (client *rpc.Client, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for len(self.clients) == 0 && self.dialing >= size {
+		self.dialed.Wait()
+	}
+	if len(self.clients)+self.dialing < size {
+		self.dialing++
+		self.lock.Unlock()
+		conn, e := dial(addr, timeout)
+		self.lock.Lock()
+		self.dialing--
+		self.dialed.Broadcast()
+		if e != nil {
+			err = dialError{e}
+			return
+		}
+		client = rpc.NewClientWithCodec(NewClientCodec(conn))
+		self.clients = append(self.clients, client)
+		return
+	}
+	self.next = (self.next + 1) % len(self.clients)
+	client = self.clients[self.next]
+	return
+}
+func (self *pool) evict(client *rpc.Client) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for index, current := range self.clients {
+		if current == client {
+			self.clients = append(self.clients[:index], self.clients[index+1:]...)
+			break
+		}
+	}
+	client.Close()
+}
+func (self *pool) close() (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, client := range self.clients {
+		if e := client.Close(); e != nil {
+			err = e
+		}
+	}
+	self.clients = nil
+	return
+}
+
+// Switchboard is a map of bounded pools of net/rpc.Clients, to avoid having to set up new connections for each remote call.
+// Calls that fail to connect are retried with exponential backoff. Other calls are never retried, since the remote service may have applied them.
+// Connections that break are evicted from the pools, but connections of calls that time out are kept, since the other calls using them may be fine.
+//
+// Calls time out after the call timeout, or after the timeout of their service if one is set.
+//
+// The host names of the addresses are regularly resolved again, and the pooled connections to addresses whose hosts resolve
+// to new IP addresses are closed, so that the next calls connect to the new addresses.
 type Switchboard struct {
-	lock    *sync.RWMutex
-	clients map[string]*rpc.Client
+	lock            *sync.RWMutex
+	pools           map[string]*pool
+	listeners       map[string]*embeddedListener
+	dialTimeout     time.Duration
+	callTimeout     time.Duration
+	serviceTimeouts map[string]time.Duration
+	poolSize        int
+	retries         int
+	backoff         time.Duration
+	resolveInterval time.Duration
+	resolving       bool
+	lookupHost      func(host string) ([]string, error)
+}
+
+func newSwitchboard() (result *Switchboard) {
+	result = &Switchboard{
+		lock:            new(sync.RWMutex),
+		pools:           make(map[string]*pool),
+		listeners:       make(map[string]*embeddedListener),
+		dialTimeout:     defaultDialTimeout,
+		callTimeout:     defaultCallTimeout,
+		poolSize:        defaultPoolSize,
+		retries:         defaultRetries,
+		backoff:         defaultBackoff,
+		resolveInterval: defaultResolveInterval,
+		serviceTimeouts: make(map[string]time.Duration),
+		lookupHost:      net.LookupHost,
+	}
+	for _, service := range longCalls {
+		result.serviceTimeouts[service] = defaultLongCallTimeout
+	}
+	return
+}
+
+// SetTimeouts will set how long to wait for new connections and for calls to return. Zero means wait forever.
+func (self *Switchboard) SetTimeouts(dial, call time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.dialTimeout, self.callTimeout = dial, call
+}
+
+// SetServiceTimeout will set how long to wait for calls to service to return, instead of the call timeout. Zero means wait forever.
+func (self *Switchboard) SetServiceTimeout(service string, timeout time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.serviceTimeouts[service] = timeout
+}
+
+// SetPoolSize will set the maximum number of connections kept to each remote address.
+func (self *Switchboard) SetPoolSize(size int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if size < 1 {
+		size = 1
+	}
+	self.poolSize = size
 }
 
-func newSwitchboard() *Switchboard {
-	return &Switchboard{new(sync.RWMutex), make(map[string]*rpc.Client)}
+// SetRetries will set how many times calls that failed to connect are retried, and how long to wait before the first retry.
+// The wait is doubled for each following retry.
+func (self *Switchboard) SetRetries(retries int, backoff time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.retries, self.backoff = retries, backoff
 }
-func (self *Switchboard) client(addr string) (client *rpc.Client, err error) {
+
+// SetResolveInterval will set how often the host names of the addresses are resolved again. Zero turns off resolving.
+func (self *Switchboard) SetResolveInterval(interval time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.resolveInterval = interval
+}
+
+// Resolve will resolve the host names of all addresses with pooled connections right away, and close the connections to those
+// resolving to new IP addresses.
+func (self *Switchboard) Resolve() {
 	self.lock.RLock()
-	client, ok := self.clients[addr]
+	pools := make(map[string]*pool, len(self.pools))
+	for addr, p := range self.pools {
+		pools[addr] = p
+	}
+	lookupHost := self.lookupHost
 	self.lock.RUnlock()
-	if !ok {
-		if client, err = rpc.Dial("tcp", addr); err != nil {
-			return
+	for addr, p := range pools {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil || IsEmbedded(addr) {
+			continue
+		}
+		resolved, err := lookupHost(host)
+		if err != nil {
+			continue
+		}
+		sort.Strings(resolved)
+		p.lock.Lock()
+		changed := p.resolved != nil && strings.Join(p.resolved, ",") != strings.Join(resolved, ",")
+		p.resolved = resolved
+		p.lock.Unlock()
+		if changed {
+			p.close()
 		}
-		self.lock.Lock()
-		self.clients[addr] = client
-		self.lock.Unlock()
 	}
-	return
 }
-func (self *Switchboard) Go(addr, service string, args, reply interface{}) (call *rpc.Call) {
-	if client, err := self.client(addr); err != nil {
-		call = &rpc.Call{
-			ServiceMethod: service,
-			Args:          args,
-			Reply:         reply,
-			Error:         err,
-			Done:          make(chan *rpc.Call, 1),
+func (self *Switchboard) resolvePeriodically() {
+	for {
+		self.lock.RLock()
+		interval := self.resolveInterval
+		self.lock.RUnlock()
+		if interval > 0 {
+			time.Sleep(interval)
+			self.Resolve()
+		} else {
+			time.Sleep(defaultResolveInterval)
+		}
+	}
+}
+func (self *Switchboard) pool(addr string) (result *pool) {
+	self.lock.RLock()
+	result, ok := self.pools[addr]
+	self.lock.RUnlock()
+	if !ok {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		if result, ok = self.pools[addr]; !ok {
+			result = newPool()
+			self.pools[addr] = result
+			if !self.resolving {
+				self.resolving = true
+				go self.resolvePeriodically()
+			}
 		}
-		call.Done <- call
-	} else {
-		call = client.Go(service, args, reply, nil)
 	}
 	return
 }
-func (self *Switchboard) Call(addr, service string, args, reply interface{}) (err error) {
-	var client *rpc.Client
-	if client, err = self.client(addr); err != nil {
+func (self *Switchboard) call(addr, service string, args, reply interface{}) (err error) {
+	self.lock.RLock()
+	dialTimeout, callTimeout, poolSize := self.dialTimeout, self.callTimeout, self.poolSize
+	if timeout, found := self.serviceTimeouts[service]; found {
+		callTimeout = timeout
+	}
+	self.lock.RUnlock()
+	p := self.pool(addr)
+	client, err := p.get(addr, poolSize, dialTimeout, self.dial)
+	if err != nil {
 		return
 	}
-	if err = client.Call(service, args, reply); err != nil {
-		if err.Error() == "connection is shut down" {
-			self.lock.Lock()
-			delete(self.clients, addr)
-			self.lock.Unlock()
+	// Decode into a reply of our own, since a call that times out may still complete, and must not write to reply after we returned.
+	fresh := reflect.New(reflect.TypeOf(reply).Elem())
+	call := client.Go(service, args, fresh.Interface(), make(chan *rpc.Call, 1))
+	var timeout <-chan time.Time
+	if callTimeout > 0 {
+		timer := time.NewTimer(callTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-call.Done:
+		if err = call.Error; err != nil {
+			if _, ok := err.(rpc.ServerError); !ok {
+				p.evict(client)
+			}
+		} else {
+			reflect.ValueOf(reply).Elem().Set(fresh.Elem())
+		}
+	case <-timeout:
+		err = TimeoutError{
+			Addr:    addr,
+			Service: service,
+			Timeout: callTimeout,
 		}
-		err = self.Call(addr, service, args, reply)
 	}
 	return
 }
 
+// Go will call service at addr asynchronously, using the same timeouts and retries as Call.
+// Like with Call, reply is only written if the call succeeds, before call is sent on call.Done.
+func (self *Switchboard) Go(addr, service string, args, reply interface{}) (call *rpc.Call) {
+	call = &rpc.Call{
+		ServiceMethod: service,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *rpc.Call, 1),
+	}
+	go func() {
+		call.Error = self.Call(addr, service, args, reply)
+		call.Done <- call
+	}()
+	return
+}
+
+// Call will call service at addr. Calls that fail to connect are retried, other errors are returned right away.
+// reply is only written if the call succeeds, so it is never written after a call that timed out has returned.
+func (self *Switchboard) Call(addr, service string, args, reply interface{}) (err error) {
+	self.lock.RLock()
+	retries, backoff := self.retries, self.backoff
+	self.lock.RUnlock()
+	for attempt := 0; ; attempt++ {
+		if err = self.call(addr, service, args, reply); err == nil {
+			return
+		}
+		dialErr, ok := err.(dialError)
+		if !ok {
+			return
+		}
+		if attempt >= retries {
+			return dialErr.err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Close will close all connections to addr.
 func (self *Switchboard) Close(addr string) error {
-  client, err := self.client(addr)
-  if err != nil {
-    return err
-  }
-  
-  return client.Close()
-}
\ No newline at end of file
+	self.lock.Lock()
+	p, ok := self.pools[addr]
+	delete(self.pools, addr)
+	self.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return p.close()
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testService struct {
+	calls int32
+}
+
+func (self *testService) Echo(s string, result *string) error {
+	atomic.AddInt32(&self.calls, 1)
+	*result = s
+	return nil
+}
+func (self *testService) Fail(s string, result *string) error {
+	atomic.AddInt32(&self.calls, 1)
+	return fmt.Errorf("failed %v", s)
+}
+func (self *testService) EchoItem(item Item, result *Item) error {
+	atomic.AddInt32(&self.calls, 1)
+	if item.Compressed {
+		return fmt.Errorf("item should have been decompressed")
+	}
+	*result = item
+	return nil
+}
+func (self *testService) Hang(d time.Duration, result *string) error {
+	atomic.AddInt32(&self.calls, 1)
+	time.Sleep(d)
+	return nil
+}
+
+func (self *testService) Late(d time.Duration, result *string) error {
+	atomic.AddInt32(&self.calls, 1)
+	time.Sleep(d)
+	*result = "late"
+	return nil
+}
+
+func startTestService(t *testing.T) (addr string, service *testService) {
+	service = &testService{}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", service); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+	return listener.Addr().String(), service
+}
+
+func TestSwitchboard(t *testing.T) {
+	addr, service := startTestService(t)
+	board := newSwitchboard()
+	board.SetPoolSize(2)
+	board.SetRetries(2, time.Millisecond)
+	board.SetTimeouts(time.Second, time.Millisecond*100)
+	var result string
+	for i := 0; i < 10; i++ {
+		if err := board.Call(addr, "Test.Echo", "hello", &result); err != nil || result != "hello" {
+			t.Errorf("wanted hello, got %#v, %v", result, err)
+		}
+	}
+	if n := len(board.pool(addr).clients); n != 2 {
+		t.Errorf("wanted 2 pooled connections, got %v", n)
+	}
+	atomic.StoreInt32(&service.calls, 0)
+	if err := board.Call(addr, "Test.Fail", "now", &result); err == nil {
+		t.Errorf("wanted an error")
+	}
+	if n := atomic.LoadInt32(&service.calls); n != 1 {
+		t.Errorf("service errors should not be retried, but the service was called %v times", n)
+	}
+	atomic.StoreInt32(&service.calls, 0)
+	if err := board.Call(addr, "Test.Hang", time.Second, &result); err == nil {
+		t.Errorf("wanted a timeout")
+	} else if _, ok := err.(TimeoutError); !ok {
+		t.Errorf("wanted a TimeoutError, got %v", err)
+	}
+	if n := atomic.LoadInt32(&service.calls); n != 1 {
+		t.Errorf("timeouts should not be retried, but the service was called %v times", n)
+	}
+	if n := len(board.pool(addr).clients); n != 2 {
+		t.Errorf("timeouts should not evict pooled connections, but got %v", n)
+	}
+	result = "mine"
+	if err := board.Call(addr, "Test.Late", time.Millisecond*200, &result); err == nil {
+		t.Errorf("wanted a timeout")
+	}
+	time.Sleep(time.Millisecond * 300)
+	if result != "mine" {
+		t.Errorf("calls that timed out should not write to the reply when they complete, got %#v", result)
+	}
+	board.SetServiceTimeout("Test.Hang", time.Second)
+	if err := board.Call(addr, "Test.Hang", time.Millisecond*200, &result); err != nil {
+		t.Errorf("the service timeout should override the call timeout, got %v", err)
+	}
+	call := <-board.Go(addr, "Test.Echo", "again", &result).Done
+	if call.Error != nil || result != "again" {
+		t.Errorf("wanted again, got %#v, %v", result, call.Error)
+	}
+	if err := board.Close(addr); err != nil {
+		t.Error(err)
+	}
+	board.SetRetries(2, time.Millisecond*50)
+	start := time.Now()
+	if err := board.Call("127.0.0.1:1", "Test.Echo", "hello", &result); err == nil {
+		t.Errorf("wanted an error calling a closed port")
+	} else if _, ok := err.(dialError); ok {
+		t.Errorf("wanted the underlying dial error, got %#v", err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < time.Millisecond*150 {
+		t.Errorf("calls that failed to connect should be retried twice with backoff, but gave up after %v", elapsed)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	value := bytes.Repeat([]byte("large value "), 100)
+	if compressed, ok := Compress(value, 16); !ok || len(compressed) >= len(value) {
+		t.Errorf("%v bytes should have been compressed, got %v bytes", len(value), len(compressed))
+	} else if decompressed, err := Decompress(compressed, true); err != nil || bytes.Compare(decompressed, value) != 0 {
+		t.Errorf("decompressing should restore the value, got %v", err)
+	}
+	if _, ok := Compress([]byte("small"), 16); ok {
+		t.Errorf("values below the threshold should not be compressed")
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Test", &testService{}); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(NewServerCodec(conn))
+		}
+	}()
+	SetCompressionThreshold(16)
+	defer SetCompressionThreshold(0)
+	board := newSwitchboard()
+	var result Item
+	if err := board.Call(listener.Addr().String(), "Test.EchoItem", Item{Key: []byte("a"), Value: value}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Compressed || bytes.Compare(result.Value, value) != 0 {
+		t.Errorf("wanted the value back decompressed, got %v bytes, compressed: %v", len(result.Value), result.Compressed)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	addr, _ := startTestService(t)
+	_, port, _ := net.SplitHostPort(addr)
+	hostAddr := net.JoinHostPort("localhost", port)
+	board := newSwitchboard()
+	board.SetResolveInterval(0)
+	resolved := []string{"127.0.0.1"}
+	board.lookupHost = func(host string) ([]string, error) {
+		return resolved, nil
+	}
+	var result string
+	if err := board.Call(hostAddr, "Test.Echo", "hello", &result); err != nil || result != "hello" {
+		t.Fatalf("wanted hello, got %#v, %v", result, err)
+	}
+	board.Resolve()
+	if n := len(board.pool(hostAddr).clients); n != 1 {
+		t.Errorf("wanted the connection kept when the address didn't change, got %v connections", n)
+	}
+	resolved = []string{"127.0.0.2", "127.0.0.1"}
+	board.Resolve()
+	if n := len(board.pool(hostAddr).clients); n != 0 {
+		t.Errorf("wanted the connection closed when the address changed, got %v connections", n)
+	}
+	if err := board.Call(hostAddr, "Test.Echo", "again", &result); err != nil || result != "again" {
+		t.Errorf("wanted again, got %#v, %v", result, err)
+	}
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"bytes"
+)
+
+// SystemPrefix marks the reserved system keyspace, where god and its subsystems keep their own data.
+// Each namespace of the system keyspace is a sub tree under the SystemPrefix followed by the name of the namespace, and can only be written by the subsystems of the nodes.
+// Namespaces kept per key, like SystemLocks, are sub trees under the key followed by the SystemPrefix and the name of the namespace, so that they are owned and replicated with the key.
+var SystemPrefix = []byte("\x00god/")
+
+const (
+	// SystemBackups is the system namespace for the manifests of cluster backups.
+	SystemBackups = "backups"
+	// SystemLocks is the system namespace for the leases of cluster wide locks, kept per key.
+	SystemLocks = "locks"
+)
+
+// SystemKey returns the key of the sub tree of the system namespace.
+func SystemKey(namespace string) []byte {
+	return append(append(make([]byte, 0, len(SystemPrefix)+len(namespace)), SystemPrefix...), namespace...)
+}
+
+// SystemKeyFor returns the key of the sub tree of the system namespace kept for key. It sorts right after key, so unless a node is positioned exactly at key it is owned by the owner of key.
+func SystemKeyFor(namespace string, key []byte) []byte {
+	return append(append(make([]byte, 0, len(key)+len(SystemPrefix)+len(namespace)), key...), SystemKey(namespace)...)
+}
+
+// IsSystemKey returns whether key is in the reserved system keyspace, that is whether it contains the SystemPrefix.
+func IsSystemKey(key []byte) bool {
+	return bytes.Contains(key, SystemPrefix)
+}
+
+// SystemNamespace returns the system namespace key is the sub tree of, if key is the sub tree of a namespace that isn't kept per key.
+func SystemNamespace(key []byte) (namespace string, ok bool) {
+	if !bytes.HasPrefix(key, SystemPrefix) {
+		return
+	}
+	return string(key[len(SystemPrefix):]), true
+}
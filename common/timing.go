@@ -0,0 +1,24 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timing is the breakdown of the time a node spent handling a request flagged with Debug.
+type Timing struct {
+	// Queue is the time spent waiting to be admitted by the QoS scheduler.
+	Queue time.Duration
+	// Lock is the time spent waiting for the lock of the tree.
+	Lock time.Duration
+	// Tree is the time spent in the tree operation.
+	Tree time.Duration
+	// Replication is the time spent waiting for the replicas to receive the write, which is only done for synchronous writes.
+	Replication time.Duration
+	// Total is the time from when the node received the request until it answered it.
+	Total time.Duration
+}
+
+func (self Timing) String() string {
+	return fmt.Sprintf("queue %v, lock %v, tree %v, replication %v, total %v", self.Queue, self.Lock, self.Tree, self.Replication, self.Total)
+}
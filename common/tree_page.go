@@ -0,0 +1,37 @@
+package common
+
+// TreePageRequest asks a node for a page of the entries it stores.
+type TreePageRequest struct {
+	// Prefix limits the page to keys starting with it.
+	Prefix []byte
+	// From is the key to start the page at, normally the Next of the previous page.
+	From []byte
+	// Depth is how many levels of sub trees to include the members of. Zero only includes the sizes of the sub trees.
+	Depth int
+	// Values makes the page include the values of the entries, not only their sizes.
+	Values bool
+	// Max is the maximum number of entries in the page, including the members of their sub trees. Zero means 100.
+	Max int
+	// Key makes the page contain the members of the sub tree of Key instead of the entries of the top level tree, normally the Key of an entry with a SubNext.
+	Key []byte
+}
+
+// TreeEntry is a key stored by a node, as stored, with its value and sub tree.
+type TreeEntry struct {
+	Key       []byte
+	Value     []byte
+	ValueSize int
+	Timestamp int64
+	// SubSize is the number of members of the sub tree of the key, and Sub the first of them if the depth of the request included them.
+	SubSize int
+	Sub     []TreeEntry
+	// SubNext is the member to continue at with a page of the sub tree of the key, or nil if Sub contains all remaining members.
+	SubNext []byte
+}
+
+// TreePage is a page of the entries stored by a node.
+type TreePage struct {
+	Entries []TreeEntry
+	// Next is the key to continue at with the next page, or nil if there are no more entries.
+	Next []byte
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// WorkerStatus describes what one of the background workers of a node is doing, and how its runs have gone.
+type WorkerStatus struct {
+	Name string
+	// Running is whether the worker is running its task right now, and Since when it started running or waiting.
+	Running bool
+	Since   time.Time
+	// LastRun is when the last run started, and LastDuration how long it took.
+	LastRun      time.Time
+	LastDuration time.Duration
+	Runs         int64
+	// Panics is the number of runs that panicked, and LastPanic the value of the last panic.
+	Panics    int64
+	LastPanic string
+}
+
+func (self WorkerStatus) String() string {
+	state := "waiting"
+	if self.Running {
+		state = "running"
+	}
+	return fmt.Sprintf("%v: %v since %v, %v runs, last at %v taking %v, %v panics", self.Name, state, self.Since, self.Runs, self.LastRun, self.LastDuration, self.Panics)
+}
@@ -0,0 +1,44 @@
+package dhash
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/zond/god/discord"
+)
+
+// AddAccessListener will add a listener to the sampled client operations this Node handles, which are all requests to the DHash service
+// except the replication of writes between replicas.
+func (self *Node) AddAccessListener(l discord.AccessListener) {
+	self.node.AddAccessListener(func(access discord.Access) bool {
+		if !strings.HasPrefix(access.Operation, "DHash.") || strings.HasPrefix(access.Operation, "DHash.Slave") {
+			return true
+		}
+		return l(access)
+	})
+}
+
+// SetAccessSampleRate will make this Node report the given fraction, between 0 and 1, of the client operations it handles to its access listeners.
+func (self *Node) SetAccessSampleRate(rate float64) *Node {
+	self.node.SetAccessSampleRate(rate)
+	return self
+}
+
+// LogAccess will make this Node write the sampled client operations it handles to w, one JSON object per line.
+// It stops logging if writing fails.
+func (self *Node) LogAccess(w io.Writer) {
+	lock := new(sync.Mutex)
+	encoder := json.NewEncoder(w)
+	self.AddAccessListener(func(access discord.Access) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := encoder.Encode(access); err != nil {
+			log.Printf("%v failed writing access log, stopping: %v", self.GetBroadcastAddr(), err)
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,255 @@
+package dhash
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// admissionPollInterval is how long a queued expensive operation waits before a Node asks its AdmissionController again.
+	admissionPollInterval = time.Millisecond * 10
+	defaultMaxTreeLoad    = 0.95
+	defaultMaxCPULoad     = 0.9
+	defaultMaxGCLoad      = 0.25
+	defaultMaxQueued      = 64
+	defaultMaxQueueWait   = time.Second
+	// defaultMaxHeapFraction is how much of the memory limit of the runtime, or of the physical memory, the heap may use by default.
+	defaultMaxHeapFraction = 0.9
+)
+
+// AdmissionController decides whether a Node runs an expensive operation now, queues it, or rejects it.
+type AdmissionController interface {
+	// Admit returns whether the operation described by load may run now. If it returns false and no error, the Node queues the operation and asks again a little later.
+	// If it returns an error, the operation fails with it.
+	Admit(load common.AdmissionLoad) (ok bool, err error)
+}
+
+// LoadAdmission is the default AdmissionController of a Node. It queues expensive operations while the node is near saturation,
+// and rejects them with common.ErrOverloaded when the heap is too big, too many are queued, or they have been queued for too long.
+// An operation is always admitted when no other expensive operation is running, unless the heap is too big, so that the node keeps making progress.
+type LoadAdmission struct {
+	// MaxInFlight is how many expensive operations may run at the same time. Zero means four per CPU.
+	MaxInFlight int
+	// MaxTreeLoad is the highest TreeLoad at which more expensive operations are started. Zero means 0.95.
+	MaxTreeLoad float64
+	// MaxCPULoad is the highest CPULoad at which more expensive operations are started. Zero means 0.9.
+	MaxCPULoad float64
+	// MaxGCLoad is the highest GCLoad at which more expensive operations are started. Zero means 0.25.
+	MaxGCLoad float64
+	// MaxHeapBytes is the largest heap at which expensive operations are run. Zero means 90% of the memory limit of the runtime, set with GOMEMLIMIT,
+	// or of the physical memory when there is no limit. If neither is known, the heap is unlimited.
+	MaxHeapBytes uint64
+	// MaxQueued is how many expensive operations may be queued. Zero means 64.
+	MaxQueued int
+	// MaxQueueWait is for how long an expensive operation may be queued. Zero means one second.
+	MaxQueueWait time.Duration
+}
+
+var physicalMemory struct {
+	once  sync.Once
+	bytes uint64
+}
+
+// physicalMemoryBytes returns the total memory of the machine, or zero if it is unknown.
+func physicalMemoryBytes() uint64 {
+	physicalMemory.once.Do(func() {
+		file, err := os.Open("/proc/meminfo")
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+				if kb, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+					physicalMemory.bytes = kb * 1024
+				}
+				return
+			}
+		}
+	})
+	return physicalMemory.bytes
+}
+
+// defaultMaxHeapBytes returns the default LoadAdmission.MaxHeapBytes, or zero if the heap is unlimited.
+func defaultMaxHeapBytes() uint64 {
+	limit := uint64(debug.SetMemoryLimit(-1))
+	if limit == math.MaxInt64 {
+		limit = physicalMemoryBytes()
+	}
+	return uint64(float64(limit) * defaultMaxHeapFraction)
+}
+
+func (self LoadAdmission) Admit(load common.AdmissionLoad) (ok bool, err error) {
+	maxHeapBytes := self.MaxHeapBytes
+	if maxHeapBytes == 0 {
+		maxHeapBytes = defaultMaxHeapBytes()
+	}
+	if maxHeapBytes > 0 && load.HeapBytes > maxHeapBytes {
+		return false, common.ErrOverloaded
+	}
+	if load.InFlight == 0 {
+		return true, nil
+	}
+	maxInFlight := self.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = runtime.NumCPU() * 4
+	}
+	maxTreeLoad := self.MaxTreeLoad
+	if maxTreeLoad == 0 {
+		maxTreeLoad = defaultMaxTreeLoad
+	}
+	maxCPULoad := self.MaxCPULoad
+	if maxCPULoad == 0 {
+		maxCPULoad = defaultMaxCPULoad
+	}
+	maxGCLoad := self.MaxGCLoad
+	if maxGCLoad == 0 {
+		maxGCLoad = defaultMaxGCLoad
+	}
+	if load.InFlight < maxInFlight && load.TreeLoad <= maxTreeLoad && load.CPULoad <= maxCPULoad && load.GCLoad <= maxGCLoad {
+		return true, nil
+	}
+	maxQueued := self.MaxQueued
+	if maxQueued == 0 {
+		maxQueued = defaultMaxQueued
+	}
+	maxQueueWait := self.MaxQueueWait
+	if maxQueueWait == 0 {
+		maxQueueWait = defaultMaxQueueWait
+	}
+	if load.Queued >= maxQueued || load.Waited >= maxQueueWait {
+		return false, common.ErrOverloaded
+	}
+	return false, nil
+}
+
+// SetAdmissionController will make this Node consult c before running range scans, queries, set expressions, tree pages and bulk deletes.
+// The default is a LoadAdmission with default limits, and nil admits all operations.
+func (self *Node) SetAdmissionController(c AdmissionController) *Node {
+	self.admissionLock.Lock()
+	defer self.admissionLock.Unlock()
+	self.admission = c
+	return self
+}
+
+// AdmissionController returns the AdmissionController of this Node.
+func (self *Node) AdmissionController() AdmissionController {
+	self.admissionLock.Lock()
+	defer self.admissionLock.Unlock()
+	return self.admission
+}
+
+// loadSampler reads the heap size and CPU time of the runtime without stopping the world, using runtime/metrics.
+type loadSampler struct {
+	samples []metrics.Sample
+	// total, idle and gc are the CPU seconds of the last sample.
+	total float64
+	idle  float64
+	gc    float64
+}
+
+func newLoadSampler() *loadSampler {
+	return &loadSampler{
+		samples: []metrics.Sample{
+			{Name: "/memory/classes/heap/objects:bytes"},
+			{Name: "/cpu/classes/total:cpu-seconds"},
+			{Name: "/cpu/classes/idle:cpu-seconds"},
+			{Name: "/cpu/classes/gc/total:cpu-seconds"},
+		},
+	}
+}
+
+// sample returns the size of the heap, and the fractions of the available CPU time used in total and by the garbage collector since the last sample.
+func (self *loadSampler) sample() (heapBytes uint64, cpuLoad, gcLoad float64) {
+	metrics.Read(self.samples)
+	if self.samples[0].Value.Kind() == metrics.KindUint64 {
+		heapBytes = self.samples[0].Value.Uint64()
+	}
+	for _, sample := range self.samples[1:] {
+		if sample.Value.Kind() != metrics.KindFloat64 {
+			return
+		}
+	}
+	total, idle, gc := self.samples[1].Value.Float64(), self.samples[2].Value.Float64(), self.samples[3].Value.Float64()
+	if elapsed := total - self.total; self.total > 0 && elapsed > 0 {
+		cpuLoad = 1 - (idle-self.idle)/elapsed
+		gcLoad = (gc - self.gc) / elapsed
+	}
+	self.total, self.idle, self.gc = total, idle, gc
+	return
+}
+
+// updateLoad will remember the size of the heap and the CPU load of this Node for its AdmissionController.
+func (self *Node) updateLoad() {
+	heapBytes, cpuLoad, gcLoad := self.loadSampler.sample()
+	atomic.StoreUint64(&self.heapBytes, heapBytes)
+	atomic.StoreUint64(&self.cpuLoad, math.Float64bits(cpuLoad))
+	atomic.StoreUint64(&self.gcLoad, math.Float64bits(gcLoad))
+}
+
+// admitExpensive will wait until the AdmissionController of this Node admits the expensive operation, schedule it with qos,
+// and return a function to call when the operation is done.
+func (self *Node) admitExpensive(operation string, qos common.QoS) (done func(), err error) {
+	start := time.Now()
+	queued := false
+	for {
+		self.admissionLock.Lock()
+		if self.admission == nil {
+			self.admissionLock.Unlock()
+			break
+		}
+		load := common.AdmissionLoad{
+			Operation: operation,
+			Waited:    time.Now().Sub(start),
+			InFlight:  int(atomic.LoadInt32(&self.expensive)),
+			Queued:    int(atomic.LoadInt32(&self.expensiveQueued)),
+			TreeLoad:  self.tree.Load(),
+			CPULoad:   math.Float64frombits(atomic.LoadUint64(&self.cpuLoad)),
+			GCLoad:    math.Float64frombits(atomic.LoadUint64(&self.gcLoad)),
+			HeapBytes: atomic.LoadUint64(&self.heapBytes),
+		}
+		if queued {
+			load.Queued--
+		}
+		var ok bool
+		if ok, err = self.admission.Admit(load); ok && err == nil {
+			atomic.AddInt32(&self.expensive, 1)
+		}
+		self.admissionLock.Unlock()
+		if err != nil || ok {
+			if queued {
+				atomic.AddInt32(&self.expensiveQueued, -1)
+			}
+			if err != nil {
+				atomic.AddInt64(&self.rejectedOps, 1)
+				return
+			}
+			scheduled := self.schedule(qos)
+			return func() {
+				scheduled()
+				atomic.AddInt32(&self.expensive, -1)
+			}, nil
+		}
+		if !queued {
+			atomic.AddInt32(&self.expensiveQueued, 1)
+			queued = true
+		}
+		time.Sleep(admissionPollInterval)
+	}
+	if queued {
+		atomic.AddInt32(&self.expensiveQueued, -1)
+	}
+	return self.schedule(qos), nil
+}
@@ -0,0 +1,114 @@
+package dhash
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestLoadAdmission(t *testing.T) {
+	policy := LoadAdmission{MaxInFlight: 2, MaxHeapBytes: 1000, MaxQueued: 1}
+	if ok, err := policy.Admit(common.AdmissionLoad{TreeLoad: 1}); !ok || err != nil {
+		t.Errorf("wanted an operation to be admitted when no others are running, got %v, %v", ok, err)
+	}
+	if ok, err := policy.Admit(common.AdmissionLoad{InFlight: 1}); !ok || err != nil {
+		t.Errorf("wanted an operation to be admitted below the limits, got %v, %v", ok, err)
+	}
+	if ok, err := policy.Admit(common.AdmissionLoad{InFlight: 2}); ok || err != nil {
+		t.Errorf("wanted an operation to be queued at the in flight limit, got %v, %v", ok, err)
+	}
+	if ok, err := policy.Admit(common.AdmissionLoad{InFlight: 1, TreeLoad: 0.99}); ok || err != nil {
+		t.Errorf("wanted an operation to be queued above the tree load limit, got %v, %v", ok, err)
+	}
+	if _, err := policy.Admit(common.AdmissionLoad{InFlight: 2, Queued: 1}); !common.IsOverloaded(err) {
+		t.Errorf("wanted an operation to be rejected when the queue is full, got %v", err)
+	}
+	if _, err := policy.Admit(common.AdmissionLoad{InFlight: 2, Waited: time.Second}); !common.IsOverloaded(err) {
+		t.Errorf("wanted an operation to be rejected after waiting too long, got %v", err)
+	}
+	if _, err := policy.Admit(common.AdmissionLoad{HeapBytes: 1001}); !common.IsOverloaded(err) {
+		t.Errorf("wanted an operation to be rejected when the heap is too big, got %v", err)
+	}
+	if ok, err := policy.Admit(common.AdmissionLoad{InFlight: 1, CPULoad: 0.95}); ok || err != nil {
+		t.Errorf("wanted an operation to be queued above the CPU load limit, got %v, %v", ok, err)
+	}
+	if ok, err := policy.Admit(common.AdmissionLoad{InFlight: 1, GCLoad: 0.3}); ok || err != nil {
+		t.Errorf("wanted an operation to be queued above the garbage collection load limit, got %v, %v", ok, err)
+	}
+	if defaultMaxHeapBytes() == 0 {
+		t.Logf("neither a memory limit nor the physical memory is known, so the heap is unlimited by default")
+	} else if _, err := (LoadAdmission{}).Admit(common.AdmissionLoad{HeapBytes: math.MaxUint64}); !common.IsOverloaded(err) {
+		t.Errorf("wanted an operation to be rejected when the heap is bigger than the default limit, got %v", err)
+	}
+}
+
+func TestLoadSampler(t *testing.T) {
+	sampler := newLoadSampler()
+	sampler.sample()
+	for i := 0; i < 1000000; i++ {
+		_ = make([]byte, 16)
+	}
+	heapBytes, cpuLoad, gcLoad := sampler.sample()
+	if heapBytes == 0 {
+		t.Errorf("wanted the size of the heap")
+	}
+	if cpuLoad < 0 || cpuLoad > 1 || gcLoad < 0 || gcLoad > cpuLoad {
+		t.Errorf("wanted loads between 0 and 1, with the garbage collection load within the CPU load, got %v and %v", cpuLoad, gcLoad)
+	}
+}
+
+func TestAdmitExpensive(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:15691", "127.0.0.1:15691", "").SetAdmissionController(LoadAdmission{MaxInFlight: 1, MaxQueueWait: time.Millisecond * 100})
+	first, err := node.admitExpensive("Slice", common.Interactive)
+	if err != nil {
+		t.Fatalf("wanted the first operation to be admitted, got %v", err)
+	}
+	if _, err = node.admitExpensive("Slice", common.Interactive); !common.IsOverloaded(err) {
+		t.Errorf("wanted the second operation to be rejected while the first was running, got %v", err)
+	}
+	if n := atomic.LoadInt64(&node.rejectedOps); n != 1 {
+		t.Errorf("wanted one rejected operation, got %v", n)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		first()
+	}()
+	second, err := node.admitExpensive("Slice", common.Interactive)
+	if err != nil {
+		t.Fatalf("wanted the second operation to be queued until the first was done, got %v", err)
+	}
+	second()
+	if n := atomic.LoadInt32(&node.expensive); n != 0 {
+		t.Errorf("wanted no operations in flight, got %v", n)
+	}
+	if n := atomic.LoadInt32(&node.expensiveQueued); n != 0 {
+		t.Errorf("wanted no operations queued, got %v", n)
+	}
+	node.SetAdmissionController(nil)
+	for i := 0; i < 3; i++ {
+		if _, err = node.admitExpensive("Slice", common.Interactive); err != nil {
+			t.Errorf("wanted all operations to be admitted without an admission controller, got %v", err)
+		}
+	}
+}
+
+type rejectAll struct{}
+
+func (self rejectAll) Admit(load common.AdmissionLoad) (bool, error) {
+	return false, common.ErrOverloaded
+}
+
+func TestSetOpSliceAdmission(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:16091", "127.0.0.1:16091", "").SetAdmissionController(rejectAll{})
+	var items []common.Item
+	r := common.Range{Key: []byte("set"), Len: 10, QoS: common.Batch}
+	if err := (*dhashServer)(node).SliceLen(r, &items); !common.IsOverloaded(err) {
+		t.Errorf("wanted SliceLen to be rejected, got %v", err)
+	}
+	if err := (*dhashServer)(node).SetOpSlice(r, &items); err != nil {
+		t.Errorf("wanted SetOpSlice to skip admission control, got %v", err)
+	}
+}
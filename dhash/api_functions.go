@@ -14,16 +14,21 @@ import (
 // Description will return a current description of the node.
 func (self *Node) Description() common.DHashDescription {
 	return common.DHashDescription{
-		Addr:         self.GetBroadcastAddr(),
-		Pos:          self.node.GetPosition(),
-		LastReroute:  time.Unix(0, atomic.LoadInt64(&self.lastReroute)),
-		LastSync:     time.Unix(0, atomic.LoadInt64(&self.lastSync)),
-		LastMigrate:  time.Unix(0, atomic.LoadInt64(&self.lastMigrate)),
-		Timer:        self.timer.ActualTime(),
-		OwnedEntries: self.Owned(),
-		HeldEntries:  self.tree.RealSize(),
-		Load:         self.tree.Load(),
-		Nodes:        self.node.GetNodes(),
+		Addr:            self.GetBroadcastAddr(),
+		Pos:             self.node.GetPosition(),
+		Zone:            self.Zone(),
+		LastReroute:     time.Unix(0, atomic.LoadInt64(&self.lastReroute)),
+		LastSync:        time.Unix(0, atomic.LoadInt64(&self.lastSync)),
+		LastMigrate:     time.Unix(0, atomic.LoadInt64(&self.lastMigrate)),
+		Timer:           self.timer.ActualTime(),
+		ClockOffset:     self.timer.Offset(),
+		ClockError:      self.timer.EstimatedError(),
+		OwnedEntries:    self.Owned(),
+		HeldEntries:     self.tree.RealSize(),
+		Load:            self.tree.Load(),
+		SyncPaused:      self.SyncPaused(),
+		MigrationPaused: self.MigrationPaused(),
+		Nodes:           self.node.GetNodes(),
 	}
 }
 
@@ -39,82 +44,180 @@ func (self *Node) DescribeTree() string {
 func (self *Node) client() *client.Conn {
 	return client.NewConnRing(common.NewRingNodes(self.node.Nodes()))
 }
-func (self *Node) Get(data common.Item, result *common.Item) error {
+func (self *Node) Get(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	*result = data
-	result.Value, result.Timestamp, result.Exists = self.tree.Get(data.Key)
-	return nil
+	timeTree(data.Timing, func() (wait time.Duration) {
+		result.Value, result.Timestamp, result.Exists, wait = self.tree.TimedGet(data.Key)
+		return
+	})
+	if result.Value, err = self.join(result.Key, result.Value); err == nil {
+		result.Value, err = self.decode(result.Key, nil, result.Value)
+	}
+	return
+}
+
+// GetRange will return at most r.Length bytes of the value under r.Key, starting at r.Offset, fetching only the chunks containing them if the value is chunked.
+func (self *Node) GetRange(r common.ByteRange, result *common.Item) (err error) {
+	if err = self.assertNotReserved(r.Key); err != nil {
+		return
+	}
+	if err = self.assertUncoded(r.Key); err != nil {
+		return
+	}
+	*result = common.Item{Key: r.Key}
+	var value []byte
+	value, result.Timestamp, result.Exists = self.tree.Get(r.Key)
+	result.Value, err = self.getRange(r.Key, value, r.Offset, r.Length)
+	return
 }
-func (self *Node) Prev(data common.Item, result *common.Item) error {
+func (self *Node) Prev(data common.Item, result *common.Item) (err error) {
 	*result = data
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Prev(data.Key)
-	return nil
+	for result.Exists && common.IsSystemKey(result.Key) {
+		result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Prev(result.Key)
+	}
+	if result.Value, err = self.join(result.Key, result.Value); err == nil {
+		result.Value, err = self.decode(result.Key, nil, result.Value)
+	}
+	return
 }
-func (self *Node) Next(data common.Item, result *common.Item) error {
+func (self *Node) Next(data common.Item, result *common.Item) (err error) {
 	*result = data
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Next(data.Key)
-	return nil
+	for result.Exists && common.IsSystemKey(result.Key) {
+		result.Key, result.Value, result.Timestamp, result.Exists = self.tree.Next(result.Key)
+	}
+	if result.Value, err = self.join(result.Key, result.Value); err == nil {
+		result.Value, err = self.decode(result.Key, nil, result.Value)
+	}
+	return
 }
 func (self *Node) RingHash(x int, ringHash *[]byte) error {
 	*ringHash = self.node.RingHash()
 	return nil
 }
 func (self *Node) MirrorCount(r common.Range, result *int) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if err := self.encodeMirrorRange(&r); err != nil {
+		return err
+	}
 	*result = self.tree.SubMirrorSizeBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc)
 	return nil
 }
 func (self *Node) Count(r common.Range, result *int) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	*result = self.tree.SubSizeBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc)
 	return nil
 }
 func (self *Node) MirrorLast(data common.Item, result *common.Item) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorLast(data.Key)
-	return nil
+	return self.decodeMirror(data.Key, result)
 }
 func (self *Node) MirrorFirst(data common.Item, result *common.Item) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorFirst(data.Key)
-	return nil
+	return self.decodeMirror(data.Key, result)
 }
-func (self *Node) Last(data common.Item, result *common.Item) error {
+func (self *Node) Last(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubLast(data.Key)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
-func (self *Node) First(data common.Item, result *common.Item) error {
+func (self *Node) First(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubFirst(data.Key)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
 func (self *Node) MirrorPrevIndex(data common.Item, result *common.Item) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.Key, result.Value, result.Timestamp, result.Index, result.Exists = self.tree.SubMirrorPrevIndex(data.Key, data.Index)
-	return nil
+	return self.decodeMirror(data.Key, result)
 }
 func (self *Node) MirrorNextIndex(data common.Item, result *common.Item) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.Key, result.Value, result.Timestamp, result.Index, result.Exists = self.tree.SubMirrorNextIndex(data.Key, data.Index)
-	return nil
+	return self.decodeMirror(data.Key, result)
 }
-func (self *Node) PrevIndex(data common.Item, result *common.Item) error {
+func (self *Node) PrevIndex(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Index, result.Exists = self.tree.SubPrevIndex(data.Key, data.Index)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
-func (self *Node) NextIndex(data common.Item, result *common.Item) error {
+func (self *Node) NextIndex(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Index, result.Exists = self.tree.SubNextIndex(data.Key, data.Index)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
 func (self *Node) SubMirrorPrev(data common.Item, result *common.Item) error {
-	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorPrev(data.Key, data.SubKey)
-	return nil
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
+	value, err := self.encodeMirror(data.Key, data.SubKey)
+	if err != nil {
+		return err
+	}
+	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorPrev(data.Key, value)
+	return self.decodeMirror(data.Key, result)
 }
 func (self *Node) SubMirrorNext(data common.Item, result *common.Item) error {
-	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorNext(data.Key, data.SubKey)
-	return nil
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
+	value, err := self.encodeMirror(data.Key, data.SubKey)
+	if err != nil {
+		return err
+	}
+	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubMirrorNext(data.Key, value)
+	return self.decodeMirror(data.Key, result)
 }
-func (self *Node) SubPrev(data common.Item, result *common.Item) error {
+func (self *Node) SubPrev(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubPrev(data.Key, data.SubKey)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
-func (self *Node) SubNext(data common.Item, result *common.Item) error {
+func (self *Node) SubNext(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	result.Key, result.Value, result.Timestamp, result.Exists = self.tree.SubNext(data.Key, data.SubKey)
-	return nil
+	result.Value, err = self.decode(data.Key, result.Key, result.Value)
+	return
 }
 func (self *Node) SliceIndex(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	min := &r.MinIndex
 	max := &r.MaxIndex
 	if !r.MinInc {
@@ -132,9 +235,12 @@ func (self *Node) SliceIndex(r common.Range, items *[]common.Item) error {
 		})
 		return true
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) ReverseSliceIndex(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	min := &r.MinIndex
 	max := &r.MaxIndex
 	if !r.MinInc {
@@ -152,9 +258,12 @@ func (self *Node) ReverseSliceIndex(r common.Range, items *[]common.Item) error
 		})
 		return true
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) ReverseSlice(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	self.tree.SubReverseEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -163,9 +272,12 @@ func (self *Node) ReverseSlice(r common.Range, items *[]common.Item) error {
 		})
 		return true
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) Slice(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	self.tree.SubEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -174,9 +286,12 @@ func (self *Node) Slice(r common.Range, items *[]common.Item) error {
 		})
 		return true
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) SliceLen(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	self.tree.SubEachBetween(r.Key, r.Min, nil, r.MinInc, false, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -185,9 +300,12 @@ func (self *Node) SliceLen(r common.Range, items *[]common.Item) error {
 		})
 		return len(*items) < r.Len
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) ReverseSliceLen(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	self.tree.SubReverseEachBetween(r.Key, nil, r.Max, false, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -196,9 +314,12 @@ func (self *Node) ReverseSliceLen(r common.Range, items *[]common.Item) error {
 		})
 		return len(*items) < r.Len
 	})
-	return nil
+	return self.decodeItems(r.Key, *items)
 }
 func (self *Node) MirrorSliceIndex(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	min := &r.MinIndex
 	max := &r.MaxIndex
 	if !r.MinInc {
@@ -216,9 +337,12 @@ func (self *Node) MirrorSliceIndex(r common.Range, items *[]common.Item) error {
 		})
 		return true
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorReverseSliceIndex(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
 	min := &r.MinIndex
 	max := &r.MaxIndex
 	if !r.MinInc {
@@ -236,9 +360,15 @@ func (self *Node) MirrorReverseSliceIndex(r common.Range, items *[]common.Item)
 		})
 		return true
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorReverseSlice(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if err := self.encodeMirrorRange(&r); err != nil {
+		return err
+	}
 	self.tree.SubMirrorReverseEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -247,9 +377,15 @@ func (self *Node) MirrorReverseSlice(r common.Range, items *[]common.Item) error
 		})
 		return true
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorSlice(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if err := self.encodeMirrorRange(&r); err != nil {
+		return err
+	}
 	self.tree.SubMirrorEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -258,9 +394,15 @@ func (self *Node) MirrorSlice(r common.Range, items *[]common.Item) error {
 		})
 		return true
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorSliceLen(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if err := self.encodeMirrorRange(&r); err != nil {
+		return err
+	}
 	self.tree.SubMirrorEachBetween(r.Key, r.Min, nil, r.MinInc, false, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -269,9 +411,15 @@ func (self *Node) MirrorSliceLen(r common.Range, items *[]common.Item) error {
 		})
 		return len(*items) < r.Len
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorReverseSliceLen(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if err := self.encodeMirrorRange(&r); err != nil {
+		return err
+	}
 	self.tree.SubMirrorReverseEachBetween(r.Key, nil, r.Max, false, r.MaxInc, func(key []byte, value []byte, version int64) bool {
 		*items = append(*items, common.Item{
 			Key:       key,
@@ -280,48 +428,254 @@ func (self *Node) MirrorReverseSliceLen(r common.Range, items *[]common.Item) er
 		})
 		return len(*items) < r.Len
 	})
-	return nil
+	return self.decodeMirrorItems(r.Key, *items)
 }
 func (self *Node) MirrorReverseIndexOf(data common.Item, result *common.Index) error {
-	result.N, result.Existed = self.tree.SubMirrorReverseIndexOf(data.Key, data.SubKey)
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
+	value, err := self.encodeMirror(data.Key, data.SubKey)
+	if err != nil {
+		return err
+	}
+	result.N, result.Existed = self.tree.SubMirrorReverseIndexOf(data.Key, value)
 	return nil
 }
 func (self *Node) MirrorIndexOf(data common.Item, result *common.Index) error {
-	result.N, result.Existed = self.tree.SubMirrorIndexOf(data.Key, data.SubKey)
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
+	value, err := self.encodeMirror(data.Key, data.SubKey)
+	if err != nil {
+		return err
+	}
+	result.N, result.Existed = self.tree.SubMirrorIndexOf(data.Key, value)
 	return nil
 }
 func (self *Node) ReverseIndexOf(data common.Item, result *common.Index) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.N, result.Existed = self.tree.SubReverseIndexOf(data.Key, data.SubKey)
 	return nil
 }
+func (self *Node) Rank(data common.Item, result *common.Index) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
+	result.N, result.Existed = self.tree.SubRank(data.Key, data.SubKey)
+	return nil
+}
+func (self *Node) TopN(r common.Range, items *[]common.Item) error {
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	if r.Len < 1 {
+		return nil
+	}
+	min, max := 0, r.Len-1
+	self.tree.SubMirrorReverseEachBetweenIndex(r.Key, &min, &max, func(key []byte, value []byte, version int64, index int) bool {
+		*items = append(*items, common.Item{
+			Key:       value,
+			Value:     key,
+			Timestamp: version,
+			Index:     index,
+		})
+		return true
+	})
+	return self.decodeItems(r.Key, *items)
+}
 func (self *Node) IndexOf(data common.Item, result *common.Index) error {
+	if err := self.assertNotReserved(data.Key); err != nil {
+		return err
+	}
 	result.N, result.Existed = self.tree.SubIndexOf(data.Key, data.SubKey)
 	return nil
 }
-func (self *Node) SubGet(data common.Item, result *common.Item) error {
+func (self *Node) SubGet(data common.Item, result *common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
 	*result = data
 	result.Value, result.Timestamp, result.Exists = self.tree.SubGet(data.Key, data.SubKey)
-	return nil
+	result.Value, err = self.decode(data.Key, data.SubKey, result.Value)
+	return
 }
-func (self *Node) SubClear(data common.Item) error {
+func (self *Node) SubClear(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "SubClear")
+	if err != nil {
+		return
+	}
+	defer unlock()
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subClear(data)
+	return self.apply(data, func() (err error) {
+		if err = self.subClear(data); err != nil {
+			return
+		}
+		self.triggerMutationListeners("SubClear", data)
+		return self.mirror(data, "DHash.SlaveSubClear")
+	})
+}
+func (self *Node) SubDel(data common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	return self.ownedSubDel(data)
 }
-func (self *Node) SubDel(data common.Item) error {
+
+// ownedSubDel will remove data as the owner of data.Key, also in the system keyspace, so it must only be used by the subsystems of this Node.
+func (self *Node) ownedSubDel(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "SubDel")
+	if err != nil {
+		return
+	}
+	defer unlock()
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subDel(data)
+	return self.apply(data, func() (err error) {
+		if err = self.subDel(data); err != nil {
+			return
+		}
+		self.triggerMutationListeners("SubDel", data)
+		return self.mirror(data, "DHash.SlaveSubDel")
+	})
+}
+func (self *Node) SubPut(data common.Item) (err error) {
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	return self.ownedSubPut(data)
 }
-func (self *Node) SubPut(data common.Item) error {
+
+// ownedSubPut will put data as the owner of data.Key, also in the system keyspace, so it must only be used by the subsystems of this Node.
+func (self *Node) ownedSubPut(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if data.Value, err = self.encode(data.Key, data.SubKey, data.Value); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "SubPut")
+	if err != nil {
+		return
+	}
+	defer unlock()
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.subPut(data)
+	if err = self.apply(data, func() (err error) {
+		if err = self.subPut(data); err != nil {
+			return
+		}
+		self.triggerMutationListeners("SubPut", data)
+		return self.mirror(data, "DHash.SlaveSubPut")
+	}); err == nil {
+		self.trimAfterPut(data.Key)
+	}
+	return
 }
-func (self *Node) Del(data common.Item) error {
+func (self *Node) Del(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "Del")
+	if err != nil {
+		return
+	}
+	defer unlock()
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.del(data)
+	return self.apply(data, func() (err error) {
+		if err = self.del(data); err != nil {
+			return
+		}
+		self.triggerMutationListeners("Del", data)
+		return self.mirror(data, "DHash.SlaveDel")
+	})
+}
+func (self *Node) Put(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	if data.Value, err = self.encode(data.Key, nil, data.Value); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "Put")
+	if err != nil {
+		return
+	}
+	defer unlock()
+	return self.apply(data, func() (err error) {
+		if data.Value, err = self.split(data.Key, data.Value); err != nil {
+			return
+		}
+		return self.store(data)
+	})
+}
+
+// AppendValue will append data.Value to the value under data.Key, putting only the new bytes as chunks if the value is chunked.
+func (self *Node) AppendValue(data common.Item) (err error) {
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	if err = self.assertUncoded(data.Key); err != nil {
+		return
+	}
+	unlock, err := self.lockMutable(data, "Put")
+	if err != nil {
+		return
+	}
+	defer unlock()
+	return self.apply(data, func() (err error) {
+		// Appends read the old value, so concurrent appends must not interleave.
+		self.appendLock.Lock()
+		defer self.appendLock.Unlock()
+		value, _, _ := self.tree.Get(data.Key)
+		if data.Value, err = self.appended(data.Key, value, data.Value); err != nil {
+			return
+		}
+		return self.store(data)
+	})
 }
-func (self *Node) Put(data common.Item) error {
+func (self *Node) store(data common.Item) (err error) {
 	data.TTL, data.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
-	return self.put(data)
+	if err = self.put(data); err != nil {
+		return
+	}
+	self.triggerMutationListeners("Put", data)
+	return self.mirror(data, "DHash.SlavePut")
 }
 func (self *Node) forwardOperation(data common.Item, operation string) {
 	data.TTL--
@@ -391,14 +745,24 @@ func (self *Node) del(data common.Item) error {
 	return nil
 }
 func (self *Node) put(data common.Item) error {
+	timing := data.Timing
+	data.Timing = nil
 	if data.TTL > 1 {
 		if data.Sync {
-			self.forwardOperation(data, "DHash.SlavePut")
+			timeReplication(timing, func() {
+				self.forwardOperation(data, "DHash.SlavePut")
+			})
 		} else {
 			go self.forwardOperation(data, "DHash.SlavePut")
 		}
 	}
-	self.tree.Put(data.Key, data.Value, data.Timestamp)
+	timeTree(timing, func() (wait time.Duration) {
+		_, _, wait = self.tree.TimedPut(data.Key, data.Value, data.Timestamp)
+		return
+	})
+	if data.Immutable {
+		self.tree.SubAddConfiguration(data.Key, data.Timestamp, common.ImmutableConf, "yes")
+	}
 	return nil
 }
 func (self *Node) Size() int {
@@ -416,49 +780,106 @@ func (self *Node) Size() int {
 	return self.tree.Size()
 }
 func (self *Node) SubSize(key []byte, result *int) error {
+	if err := self.assertNotReserved(key); err != nil {
+		return err
+	}
 	*result = self.tree.SubSize(key)
 	return nil
 }
 func (self *Node) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) (err error) {
+	forward := expr
+	var merge MergeFunc
+	if merge, err = parseExpression(&expr); err != nil {
+		return
+	}
 	if expr.Dest != nil {
-		if expr.Op.Merge == setop.Append {
+		if err = self.assertNotFrozen(expr.Dest); err != nil {
+			return
+		}
+		if err = self.assertNotReserved(expr.Dest); err != nil {
+			return
+		}
+		if merge == nil && expr.Op.Merge == setop.Append {
 			err = fmt.Errorf("When storing results of Set expressions the Append merge function is not allowed")
 			return
 		}
 		successor := self.node.GetSuccessorFor(expr.Dest)
 		if successor.Addr != self.node.GetBroadcastAddr() {
-			return successor.Call("DHash.SetExpression", expr, items)
+			return successor.Call("DHash.SetExpression", forward, items)
+		}
+		if err = self.assertQuorum(); err != nil {
+			return
 		}
 	}
 	data := common.Item{
 		Key: expr.Dest,
 	}
+	var writeErr error
 	err = expr.Each(func(b []byte) (result setop.Skipper, err error) {
-		succ := self.node.GetSuccessorFor(b)
-		res := &treeSkipper{
-			remote: succ,
-			key:    b,
+		if err = self.assertNotReserved(b); err != nil {
+			return
 		}
+		succ := self.node.GetSuccessorFor(b)
 		if succ.Addr == self.node.GetBroadcastAddr() {
-			res.tree = self.tree
+			result = &treeSkipper{
+				remote: succ,
+				key:    b,
+				tree:   self.tree,
+				codec:  self.codec(b),
+			}
+		} else {
+			result = self.subTreeCache.skipper(succ, b)
 		}
-		result = res
 		return
 	}, func(res *setop.SetOpResult) {
+		if merge != nil {
+			res.Values = [][]byte{merge(res.Key, res.Values)}
+		}
 		if expr.Dest == nil {
 			*items = append(*items, *res)
 		} else {
 			data.SubKey = res.Key
-			data.Value = res.Values[0]
+			value, e := self.encode(expr.Dest, res.Key, res.Values[0])
+			if e != nil {
+				if writeErr == nil {
+					writeErr = e
+				}
+				return
+			}
+			data.Value = value
+			unlock, e := self.lockMutable(data, "SubPut")
+			if e != nil {
+				if writeErr == nil {
+					writeErr = e
+				}
+				return
+			}
+			defer unlock()
 			data.TTL = self.node.Redundancy()
 			data.Timestamp = self.timer.ContinuousTime()
-			self.subPut(data)
+			if e := self.subPut(data); e != nil {
+				if writeErr == nil {
+					writeErr = e
+				}
+				return
+			}
+			self.triggerMutationListeners("SubPut", data)
+			if e := self.mirror(data, "DHash.SlaveSubPut"); e != nil && writeErr == nil {
+				writeErr = e
+			}
 		}
 	})
+	if expr.Dest != nil {
+		self.trimAfterPut(expr.Dest)
+	}
+	if err == nil {
+		err = writeErr
+	}
 	return
 }
 func (self *Node) AddConfiguration(c common.ConfItem) {
 	self.tree.AddConfiguration(self.timer.ContinuousTime(), c.Key, c.Value)
+	self.applyConfiguredRedundancy()
 }
 func (self *Node) forwardConfiguration(c common.ConfItem, operation string) {
 	c.TTL--
@@ -478,9 +899,13 @@ func (self *Node) subAddConfiguration(c common.ConfItem) {
 		}
 	}
 }
-func (self *Node) SubAddConfiguration(c common.ConfItem) {
+func (self *Node) SubAddConfiguration(c common.ConfItem) error {
+	if err := self.assertNotReserved(c.TreeKey); err != nil {
+		return err
+	}
 	c.TTL, c.Timestamp = self.node.Redundancy(), self.timer.ContinuousTime()
 	self.subAddConfiguration(c)
+	return self.mirrorConfiguration(c)
 }
 func (self *Node) Configuration(x int, result *common.Conf) error {
 	*result = common.Conf{}
@@ -488,6 +913,9 @@ func (self *Node) Configuration(x int, result *common.Conf) error {
 	return nil
 }
 func (self *Node) SubConfiguration(key []byte, result *common.Conf) error {
+	if err := self.assertNotReserved(key); err != nil {
+		return err
+	}
 	*result = common.Conf{TreeKey: key}
 	(*result).Data, (*result).Timestamp = self.tree.SubConfiguration(key)
 	return nil
@@ -0,0 +1,181 @@
+package dhash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/radix"
+)
+
+const (
+	// backupLead is how far into the future a cluster backup is scheduled, to let all nodes receive the request before their clocks reach the time of the backup.
+	backupLead = time.Second
+	// backupChunkSize is how many bytes of a backup file ReadBackup returns at most, to keep each call well within the call timeout.
+	backupChunkSize = 1 << 20
+)
+
+func checkBackupID(id string) error {
+	if id == "" || strings.ContainsAny(id, "/\\.") {
+		return fmt.Errorf("Illegal backup id %#v", id)
+	}
+	return nil
+}
+
+// backupPath returns the path of the backup file for id with extension in the data directory of this Node.
+func (self *Node) backupPath(id, extension string) (result string, err error) {
+	if self.dir == "" {
+		err = fmt.Errorf("%v has no data directory to write backups to", self.GetBroadcastAddr())
+		return
+	}
+	if err = checkBackupID(id); err != nil {
+		return
+	}
+	return filepath.Join(self.dir, "backups", id+extension), nil
+}
+
+// BackupAt will wait until the clock of this Node reaches req.At, and then write a snapshot of the data it owns to the backup file for req.ID in its data directory.
+// Entries written after req.At no longer have their values from that time, so they are written with their current values and counted as newer, making the backup fuzzy.
+func (self *Node) BackupAt(req common.BackupRequest) (result common.BackupFile, err error) {
+	if result.Path, err = self.backupPath(req.ID, ".snapshot"); err != nil {
+		return
+	}
+	if wait := time.Duration(req.At - self.timer.ContinuousTime()); wait > 0 {
+		time.Sleep(wait)
+	}
+	result.Addr, result.From, result.To = self.GetBroadcastAddr(), self.node.GetPredecessor().Pos, self.node.GetPosition()
+	snapshot := self.circularSnapshot(common.Range{Min: result.From, Max: result.To})
+	for _, entry := range snapshot {
+		if entry.Timestamp > req.At {
+			result.Newer++
+		}
+	}
+	encoded, err := radix.EncodeSnapshot(snapshot)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(result.Path), 0700); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(result.Path, encoded, 0600); err != nil {
+		return
+	}
+	result.Entries, result.Bytes, result.Checksum = len(snapshot), len(encoded), murmur.HashBytes(encoded)
+	return
+}
+
+// ReadBackup returns at most backupChunkSize bytes of the backup file for req.ID written by BackupAt, starting at req.Offset, and no bytes at the end of the file.
+func (self *Node) ReadBackup(req common.BackupChunk) (result []byte, err error) {
+	path, err := self.backupPath(req.ID, ".snapshot")
+	if err != nil {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	result = make([]byte, backupChunkSize)
+	n, err := file.ReadAt(result, req.Offset)
+	if err == io.EOF {
+		err = nil
+	}
+	return result[:n], err
+}
+
+// WriteBackupManifest will write manifest next to the backup file for manifest.ID in the data directory of this Node,
+// so that the backup can be restored from copies of the backup directories without the system keyspace of the cluster that made it.
+func (self *Node) WriteBackupManifest(manifest common.BackupManifest) (err error) {
+	path, err := self.backupPath(manifest.ID, ".manifest")
+	if err != nil {
+		return
+	}
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	return ioutil.WriteFile(path, encoded, 0600)
+}
+
+// Backup will make all Nodes in the ring of this Node write snapshots of the data they own as it was at the same timenet time, slightly in the future,
+// and record the manifest of the files next to them and in the backups namespace of the system keyspace.
+func (self *Node) Backup(id string) (result common.BackupManifest, err error) {
+	if err = checkBackupID(id); err != nil {
+		return
+	}
+	result.ID, result.At = id, self.timer.ContinuousTime()+int64(backupLead)
+	nodes := self.node.GetNodes()
+	files := make([]common.BackupFile, len(nodes))
+	errs := make([]error, len(nodes))
+	done := make(chan bool, len(nodes))
+	req := common.BackupRequest{ID: result.ID, At: result.At}
+	for index, node := range nodes {
+		go func(index int, node common.Remote) {
+			if node.Addr == self.GetBroadcastAddr() {
+				files[index], errs[index] = self.BackupAt(req)
+			} else {
+				errs[index] = node.Call("DHash.BackupAt", req, &files[index])
+			}
+			done <- true
+		}(index, node)
+	}
+	for _, _ = range nodes {
+		<-done
+	}
+	for index, node := range nodes {
+		if errs[index] != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[node.Addr] = errs[index].Error()
+		} else {
+			result.Files = append(result.Files, files[index])
+		}
+	}
+	for _, file := range result.Files {
+		if file.Addr == self.GetBroadcastAddr() {
+			err = self.WriteBackupManifest(result)
+		} else {
+			var x int
+			err = common.Remote{Addr: file.Addr}.Call("DHash.WriteBackupManifest", result, &x)
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = self.RecordBackup(result)
+	return
+}
+
+// RecordBackup will record manifest in the backups namespace of the system keyspace, by asking the owner of the namespace to put it there.
+func (self *Node) RecordBackup(manifest common.BackupManifest) (err error) {
+	if err = checkBackupID(manifest.ID); err != nil {
+		return
+	}
+	backupsKey := common.SystemKey(common.SystemBackups)
+	successor := self.node.GetSuccessorFor(backupsKey)
+	if successor.Addr != self.node.GetBroadcastAddr() {
+		var x int
+		return successor.Call("DHash.RecordBackup", manifest, &x)
+	}
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return
+	}
+	return self.ownedSubPut(common.Item{
+		Key:    backupsKey,
+		SubKey: []byte(manifest.ID),
+		Value:  encoded,
+		Sync:   true,
+	})
+}
@@ -0,0 +1,96 @@
+package dhash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "god_backup")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	node1 := NewNodeDir("127.0.0.1:14291", "127.0.0.1:14291", dir+"/1")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:14391", "127.0.0.1:14391", dir+"/2")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:14291")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2
+	}, time.Second*10)
+	conn := client.MustConn("127.0.0.1:14291")
+	for i := 0; i < 20; i++ {
+		conn.SPut([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)))
+	}
+	manifest, err := conn.Backup("b1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	entries := 0
+	for _, file := range manifest.Files {
+		entries += file.Entries
+	}
+	if !manifest.Complete() || len(manifest.Files) != 2 || entries != 20 {
+		t.Errorf("wanted 2 files with 20 entries, got %+v", manifest)
+	}
+	if _, err := conn.Backup("../b2"); err == nil {
+		t.Errorf("wanted illegal backup ids to be rejected")
+	}
+	recorded, existed, err := conn.GetBackup("b1")
+	if err != nil || !existed || recorded.At != manifest.At {
+		t.Errorf("wanted the manifest recorded, got %+v, %v, %v", recorded, existed, err)
+	}
+	newer := 0
+	for _, node := range []*Node{node1, node2} {
+		fuzzy, err := node.BackupAt(common.BackupRequest{ID: "b3", At: 1})
+		if err != nil || fuzzy.Newer != fuzzy.Entries {
+			t.Errorf("wanted all entries of a backup in the past to be included as newer, got %+v, %v", fuzzy, err)
+		}
+		newer += fuzzy.Newer
+		if chunk, err := node.ReadBackup(common.BackupChunk{ID: "b3", Offset: int64(fuzzy.Bytes)}); err != nil || len(chunk) != 0 {
+			t.Errorf("wanted no bytes after the end of the file, got %v, %v", chunk, err)
+		}
+	}
+	// the entries and the manifest of b1
+	if newer != 21 {
+		t.Errorf("wanted 21 newer entries, got %v", newer)
+	}
+	restored := NewEmbeddedNode("backup", "")
+	restored.MustStart()
+	defer restored.Stop()
+	restoredConn := client.MustConn(common.EmbeddedPrefix + "backup")
+	if changed, err := restoredConn.RestoreBackup(recorded); err != nil || changed != 20 {
+		t.Errorf("wanted 20 restored entries, got %v, %v", changed, err)
+	}
+	if value, _ := restoredConn.Get([]byte("7")); string(value) != "7" {
+		t.Errorf("wanted 7 restored, got %s", value)
+	}
+	node1.Stop()
+	node2.Stop()
+	copied := NewEmbeddedNode("backup_copy", "")
+	copied.MustStart()
+	defer copied.Stop()
+	copiedConn := client.MustConn(common.EmbeddedPrefix + "backup_copy")
+	copiedManifest, err := client.ReadBackupManifest(dir, "b1")
+	if err != nil || copiedManifest.At != recorded.At || len(copiedManifest.Files) != 2 {
+		t.Errorf("wanted the manifest next to the copied files, got %+v, %v", copiedManifest, err)
+	}
+	if _, err := client.ReadBackupManifest(dir, "b3"); err == nil {
+		t.Errorf("wanted an error for a backup without a manifest")
+	}
+	if changed, err := copiedConn.RestoreBackupWith(copiedManifest, client.BackupDir(dir)); err != nil || changed != 20 {
+		t.Errorf("wanted 20 entries restored from the copied files, got %v, %v", changed, err)
+	}
+	if _, err := copiedConn.RestoreBackupWith(recorded, client.BackupDir(dir+"/1")); err == nil {
+		t.Errorf("wanted an error when files are missing")
+	}
+}
@@ -0,0 +1,184 @@
+package dhash
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestIdempotency(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:12591", "127.0.0.1:12591", "")
+	node.MustStart()
+	defer node.Stop()
+	data := common.Item{Key: []byte("k"), Value: []byte("v"), Sync: true, Token: []byte("token")}
+	if err := node.Put(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := node.Del(common.Item{Key: []byte("k"), Sync: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := node.Put(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, existed := node.tree.Get([]byte("k")); existed {
+		t.Errorf("a write with an already applied token should be ignored")
+	}
+}
+
+func TestReplicatedTokens(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:16591", "127.0.0.1:16591", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:16691", "127.0.0.1:16691", "")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:16591")
+	common.AssertWithin(t, func() (string, bool) {
+		return "", len(node1.node.GetNodes()) == 2 && len(node2.node.GetNodes()) == 2
+	}, time.Second*10)
+	owner, replica := node1, node2
+	if node2.node.GetSuccessorFor([]byte("k")).Addr == node2.GetBroadcastAddr() {
+		owner, replica = node2, node1
+	}
+	data := common.Item{Key: []byte("k"), Value: []byte("v"), Sync: true, Token: []byte("token")}
+	if err := owner.Put(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := replica.Del(common.Item{Key: []byte("k"), Sync: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// A retry reaching the replica after the owner has failed.
+	if err := replica.Put(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, _, existed := replica.tree.Get([]byte("k")); existed {
+		t.Errorf("a write with a token applied by the owner should be ignored by its replicas")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:15991", "127.0.0.1:15991", "").SetReplayWindow(2, time.Hour)
+	writes := 0
+	write := func() error {
+		writes++
+		return nil
+	}
+	started, release := make(chan bool), make(chan bool)
+	go node.apply(common.Item{Token: []byte("a")}, func() error {
+		writes++
+		started <- true
+		<-release
+		return nil
+	})
+	<-started
+	replayed := make(chan error)
+	go func() {
+		replayed <- node.apply(common.Item{Token: []byte("a")}, write)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	release <- true
+	if err := <-replayed; err != nil || writes != 1 {
+		t.Errorf("wanted a write with the token of a write in flight to wait for it, got %v and %v writes", err, writes)
+	}
+	failure := errors.New("failure")
+	if err := node.apply(common.Item{Token: []byte("b")}, func() error { return failure }); err != failure {
+		t.Errorf("wanted %v, got %v", failure, err)
+	}
+	node.apply(common.Item{Token: []byte("b")}, write)
+	if writes != 2 {
+		t.Errorf("wanted the token of a failed write to be forgotten, got %v writes", writes)
+	}
+	node.apply(common.Item{Token: []byte("c")}, write)
+	node.apply(common.Item{Token: []byte("a")}, write)
+	if writes != 4 {
+		t.Errorf("wanted the oldest token to be evicted, got %v writes", writes)
+	}
+	if tokens, replays, evictions := node.replayStats(); tokens != 2 || replays != 1 || evictions != 2 {
+		t.Errorf("wanted 2 tokens, 1 replay and 2 evictions, got %v, %v and %v", tokens, replays, evictions)
+	}
+	node.SetReplayWindow(2, time.Millisecond)
+	node.apply(common.Item{Token: []byte("d")}, write)
+	time.Sleep(time.Millisecond * 5)
+	node.apply(common.Item{Token: []byte("d")}, write)
+	if writes != 6 {
+		t.Errorf("wanted the token to expire, got %v writes", writes)
+	}
+	node.SetReplayWindow(1, time.Hour)
+	go node.apply(common.Item{Token: []byte("e")}, func() error {
+		started <- true
+		<-release
+		return nil
+	})
+	<-started
+	node.apply(common.Item{Token: []byte("f")}, write)
+	node.apply(common.Item{Token: []byte("g")}, write)
+	node.replays.lock.Lock()
+	_, found := node.replays.entries["e"]
+	node.replays.lock.Unlock()
+	if !found {
+		t.Errorf("wanted the token of a write in flight to never be evicted")
+	}
+	release <- true
+	node.SetReplayWindow(2, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	node.apply(common.Item{Token: []byte("h")}, write)
+	if tokens, _, _ := node.replayStats(); tokens != 1 {
+		t.Errorf("wanted the tokens remembered before the ttl was shortened to expire with it, got %v tokens", tokens)
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "god_buffer")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "buffer")
+	node := NewNodeDir("127.0.0.1:12691", "127.0.0.1:12691", "")
+	node.MustStart()
+	conn := client.MustConn("127.0.0.1:12691")
+	if err := conn.SetWriteBuffer(path, 1<<10); err != nil {
+		t.Fatalf("%v", err)
+	}
+	conn.SPut([]byte("a"), []byte("1"))
+	if conn.Buffered() != 0 {
+		t.Errorf("writes should not be buffered while the cluster is available")
+	}
+	node.Stop()
+	conn.SPut([]byte("b"), []byte("2"))
+	conn.SDel([]byte("a"))
+	if n := conn.Buffered(); n != 2 {
+		t.Errorf("wanted 2 buffered writes, got %v", n)
+	}
+	if err := conn.TryPut([]byte("c"), make([]byte, 1<<10)); err != client.ErrBufferFull {
+		t.Errorf("wanted %v, got %v", client.ErrBufferFull, err)
+	}
+	if err := conn.SetWriteBuffer("", 0); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// A new process should flush the writes left in the buffer.
+	node2 := NewNodeDir("127.0.0.1:12791", "127.0.0.1:12791", "")
+	node2.MustStart()
+	defer node2.Stop()
+	conn2 := client.MustConn("127.0.0.1:12791")
+	if err := conn2.SetWriteBuffer(path, 1<<10); err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer conn2.SetWriteBuffer("", 0)
+	common.AssertWithin(t, func() (string, bool) {
+		return "", conn2.Buffered() == 0
+	}, time.Second*10)
+	if value, existed := conn2.Get([]byte("b")); !existed || bytes.Compare(value, []byte("2")) != 0 {
+		t.Errorf("wanted 2 under b, got %v, %v", value, existed)
+	}
+	if _, existed := conn2.Get([]byte("a")); existed {
+		t.Errorf("a should have been deleted")
+	}
+}
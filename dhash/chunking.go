@@ -0,0 +1,205 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+// manifestChunks returns the chunk keys in the value of key, if it is the manifest of a chunked value.
+func (self *Node) manifestChunks(key, value []byte) (chunks [][]byte, ok bool) {
+	if value == nil || len(value)%murmur.Size != 0 {
+		return
+	}
+	conf, _ := self.tree.SubConfiguration(key)
+	// The configuration contains the hash of the manifest, so that a plain value put over the manifest is never mistaken for it.
+	if hash := conf[common.ChunkedConf]; hash == "" || hash != common.HexEncode(murmur.HashBytes(value)) {
+		return
+	}
+	for i := 0; i < len(value); i += murmur.Size {
+		chunks = append(chunks, value[i:i+murmur.Size])
+	}
+	return chunks, true
+}
+
+// chunkSizes returns the sizes of chunks, the chunks in the manifest value of key, if they were recorded when the manifest was made.
+func (self *Node) chunkSizes(key, value []byte, chunks [][]byte) (sizes []int, ok bool) {
+	conf, _ := self.tree.SubConfiguration(key)
+	parts := strings.SplitN(conf[common.ChunkSizesConf], ":", 2)
+	if len(parts) != 2 || parts[0] != common.HexEncode(murmur.HashBytes(value)) {
+		return
+	}
+	fields := strings.Split(parts[1], ",")
+	if len(fields) != len(chunks) {
+		return
+	}
+	for _, field := range fields {
+		size, err := strconv.Atoi(field)
+		if err != nil {
+			return
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, true
+}
+
+// split will put value as chunks around the ring and return a manifest of the chunks to put under key instead, if value is longer than the chunk size.
+// Otherwise it will return value.
+func (self *Node) split(key, value []byte) (result []byte, err error) {
+	size := self.ChunkSize()
+	if size < 1 || len(value) <= size {
+		return value, nil
+	}
+	return self.chunk(key, nil, nil, value)
+}
+
+// chunk will put value as chunks around the ring, and return a manifest of chunks, of the given sizes, followed by the new chunks to put under key.
+func (self *Node) chunk(key []byte, chunks [][]byte, sizes []int, value []byte) (result []byte, err error) {
+	size := self.ChunkSize()
+	client := self.client()
+	for len(value) > 0 {
+		n := len(value)
+		if size > 0 && n > size {
+			n = size
+		}
+		var chunkKey []byte
+		if chunkKey, err = client.PutChunk(value[:n]); err != nil {
+			return
+		}
+		chunks, sizes, value = append(chunks, chunkKey), append(sizes, n), value[n:]
+	}
+	result = bytes.Join(chunks, nil)
+	hash := common.HexEncode(murmur.HashBytes(result))
+	sizeStrings := make([]string, len(sizes))
+	for index, size := range sizes {
+		sizeStrings[index] = strconv.Itoa(size)
+	}
+	// Configure the manifest before putting it, so that reads never see the manifest without knowing it is one.
+	if err = self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     common.ChunkSizesConf,
+		Value:   hash + ":" + strings.Join(sizeStrings, ","),
+	}); err != nil {
+		return
+	}
+	err = self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     common.ChunkedConf,
+		Value:   hash,
+	})
+	return
+}
+
+// join will return the chunked value value is a manifest of, fetched from around the ring, or value if it is not a manifest.
+func (self *Node) join(key, value []byte) (result []byte, err error) {
+	chunks, ok := self.manifestChunks(key, value)
+	if !ok {
+		return value, nil
+	}
+	client := self.client()
+	buffer := new(bytes.Buffer)
+	for _, chunkKey := range chunks {
+		chunk, existed := client.Get(chunkKey)
+		if !existed {
+			err = fmt.Errorf("Chunk %v of %v is missing", common.HexEncode(chunkKey), common.HexEncode(key))
+			return
+		}
+		buffer.Write(chunk)
+	}
+	return buffer.Bytes(), nil
+}
+
+// assertUncoded returns an error if key has a codec, since byte ranges of encoded values are meaningless.
+func (self *Node) assertUncoded(key []byte) error {
+	if self.codec(key) != nil {
+		return fmt.Errorf("%v has a codec, and can not be read or appended to by byte range", common.HexEncode(key))
+	}
+	return nil
+}
+
+// getRange returns at most length bytes from offset of the value value of key is, or is a manifest of.
+// Only the chunks containing the bytes are fetched, unless the manifest was made without recording the sizes of its chunks.
+func (self *Node) getRange(key, value []byte, offset, length int64) (result []byte, err error) {
+	if offset < 0 || length < 0 {
+		err = fmt.Errorf("Invalid byte range of %v bytes from %v", length, offset)
+		return
+	}
+	end := offset + length
+	chunks, ok := self.manifestChunks(key, value)
+	if !ok {
+		if end > int64(len(value)) {
+			end = int64(len(value))
+		}
+		if offset >= end {
+			return
+		}
+		return value[offset:end], nil
+	}
+	sizes, ok := self.chunkSizes(key, value, chunks)
+	if !ok {
+		if value, err = self.join(key, value); err != nil {
+			return
+		}
+		return self.getRange(key, value, offset, length)
+	}
+	client := self.client()
+	buffer := new(bytes.Buffer)
+	var pos int64
+	for index, chunkKey := range chunks {
+		if pos >= end {
+			break
+		}
+		size := int64(sizes[index])
+		if pos+size > offset {
+			chunk, existed := client.Get(chunkKey)
+			if !existed || int64(len(chunk)) != size {
+				err = fmt.Errorf("Chunk %v of %v is missing", common.HexEncode(chunkKey), common.HexEncode(key))
+				return
+			}
+			from, to := offset-pos, end-pos
+			if from < 0 {
+				from = 0
+			}
+			if to > size {
+				to = size
+			}
+			buffer.Write(chunk[from:to])
+		}
+		pos += size
+	}
+	return buffer.Bytes(), nil
+}
+
+// appendChunks will put data as chunks around the ring, and return a manifest of the chunks of value, the manifest under key, followed by the new chunks.
+// A last chunk of value shorter than the chunk size is replaced by a chunk containing it followed by the start of data, to avoid leaving many small chunks behind.
+func (self *Node) appendChunks(key, value []byte, chunks [][]byte, data []byte) (result []byte, err error) {
+	sizes, ok := self.chunkSizes(key, value, chunks)
+	if !ok {
+		if value, err = self.join(key, value); err != nil {
+			return
+		}
+		return self.chunk(key, nil, nil, append(value, data...))
+	}
+	last := len(chunks) - 1
+	if size := self.ChunkSize(); size < 1 || sizes[last] < size {
+		chunk, existed := self.client().Get(chunks[last])
+		if !existed {
+			err = fmt.Errorf("Chunk %v of %v is missing", common.HexEncode(chunks[last]), common.HexEncode(key))
+			return
+		}
+		chunks, sizes, data = chunks[:last], sizes[:last], append(chunk, data...)
+	}
+	return self.chunk(key, chunks, sizes, data)
+}
+
+// appended returns what to store under key when appending data to value, the value under key.
+func (self *Node) appended(key, value, data []byte) (result []byte, err error) {
+	if chunks, ok := self.manifestChunks(key, value); ok {
+		return self.appendChunks(key, value, chunks, data)
+	}
+	return self.split(key, append(append([]byte{}, value...), data...))
+}
@@ -0,0 +1,81 @@
+package dhash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func TestChunking(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:12391", "127.0.0.1:12391", "")
+	node.SetChunkSize(10).SetGCGracePeriod(0)
+	node.MustStart()
+	defer node.Stop()
+	value := []byte("a value that is longer than ten bytes")
+	if err := node.Put(common.Item{Key: []byte("large"), Value: value, Sync: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	manifest, _, _ := node.tree.Get([]byte("large"))
+	if len(manifest) != murmur.Size*4 {
+		t.Errorf("wanted a manifest of 4 chunks, got %v bytes", len(manifest))
+	}
+	var result common.Item
+	if err := node.Get(common.Item{Key: []byte("large")}, &result); err != nil || bytes.Compare(result.Value, value) != 0 {
+		t.Errorf("wanted %s, got %s and %v", value, result.Value, err)
+	}
+	var items []common.Item
+	if err := node.Query(common.Query{Range: common.Range{Min: []byte("large"), MinInc: true, Max: []byte("large"), MaxInc: true}}, &items); err != nil || len(items) != 1 || bytes.Compare(items[0].Value, value) != 0 {
+		t.Errorf("wanted a query to return %s, got %v and %v", value, items, err)
+	}
+	if err := node.Put(common.Item{Key: []byte("large"), Value: []byte("small"), Sync: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := node.Get(common.Item{Key: []byte("large")}, &result); err != nil || string(result.Value) != "small" {
+		t.Errorf("wanted small, got %s and %v", result.Value, err)
+	}
+	// The first collection only records that the chunks became unreferenced.
+	node.CollectGarbage()
+	if removed := node.CollectGarbage(); removed != 4 {
+		t.Errorf("wanted the 4 replaced chunks removed, got %v", removed)
+	}
+}
+
+func TestByteRanges(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:14891", "127.0.0.1:14891", "")
+	node.SetChunkSize(10)
+	node.MustStart()
+	defer node.Stop()
+	for _, data := range []string{"short", " and now longer", " than ten bytes", "!"} {
+		if err := node.AppendValue(common.Item{Key: []byte("grown"), Value: []byte(data), Sync: true}); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	value := []byte("short and now longer than ten bytes!")
+	manifest, _, _ := node.tree.Get([]byte("grown"))
+	if len(manifest) != murmur.Size*4 {
+		t.Errorf("wanted a manifest of 4 chunks, with the short last chunk replaced when appending, got %v bytes", len(manifest))
+	}
+	var result common.Item
+	if err := node.Get(common.Item{Key: []byte("grown")}, &result); err != nil || bytes.Compare(result.Value, value) != 0 {
+		t.Errorf("wanted %s, got %s and %v", value, result.Value, err)
+	}
+	for _, r := range [][2]int64{{0, 5}, {8, 5}, {12, 100}, {9, 11}, {40, 5}, {0, 0}} {
+		var wanted []byte
+		if r[0]+r[1] < int64(len(value)) {
+			wanted = value[r[0] : r[0]+r[1]]
+		} else if r[0] < int64(len(value)) {
+			wanted = value[r[0]:]
+		}
+		if err := node.GetRange(common.ByteRange{Key: []byte("grown"), Offset: r[0], Length: r[1]}, &result); err != nil || !result.Exists || string(result.Value) != string(wanted) {
+			t.Errorf("wanted %q from %v, got %q and %v", wanted, r, result.Value, err)
+		}
+	}
+	if err := node.GetRange(common.ByteRange{Key: []byte("grown"), Offset: -1, Length: 2}, &result); err == nil {
+		t.Errorf("wanted an error for a negative offset")
+	}
+	if err := node.GetRange(common.ByteRange{Key: []byte("missing"), Length: 2}, &result); err != nil || result.Exists || result.Value != nil {
+		t.Errorf("wanted nothing from a missing key, got %v and %v", result, err)
+	}
+}
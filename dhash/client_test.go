@@ -110,8 +110,19 @@ func BenchmarkClientAndServer(b *testing.B) {
 	}
 }
 
+func TestConnTime(t *testing.T) {
+	node := NewEmbeddedNode("conn_time", "")
+	node.MustStart()
+	defer node.Stop()
+	node.timer.Skew(time.Hour)
+	conn := client.MustConn(common.EmbeddedPrefix + "conn_time")
+	if offset := conn.Time().Sub(time.Now()); offset < time.Minute*59 || offset > time.Minute*61 {
+		t.Errorf("wanted the time of the cluster to be an hour ahead, got %v", offset)
+	}
+}
+
 func TestClient(t *testing.T) {
-	dhashes := testStartup(t, common.Redundancy*2, 11191)
+	dhashes := testStartup(t, common.GetRedundancy()*2, 11191)
 	testGOBClient(t, dhashes)
 	testJSONClient(t, dhashes)
 	stopServers(dhashes)
@@ -0,0 +1,125 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/zond/god/common"
+)
+
+// Codec validates, canonicalizes or otherwise transforms the values stored under a namespace.
+// Encode is called by the owner of a key with each value a client puts under it, before it is stored and replicated, and an error rejects the put.
+// Decode is called with each stored value before it is returned to a client. subKey is nil for values in the main tree.
+type Codec interface {
+	Encode(key, subKey, value []byte) ([]byte, error)
+	Decode(key, subKey, value []byte) ([]byte, error)
+}
+
+type prefixCodec struct {
+	prefix []byte
+	codec  Codec
+}
+
+// SetCodec will make this Node run codec on the values put to and read from keys starting with prefix, and the sub trees under them.
+// When prefixes overlap the longest one wins, and a nil codec removes the codec for prefix.
+// Since any node may become the owner of a key, all nodes of a cluster must use the same codecs.
+func (self *Node) SetCodec(prefix []byte, codec Codec) *Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	newCodecs := make([]prefixCodec, 0, len(self.codecs)+1)
+	for _, c := range self.codecs {
+		if bytes.Compare(c.prefix, prefix) != 0 {
+			newCodecs = append(newCodecs, c)
+		}
+	}
+	if codec != nil {
+		newCodecs = append(newCodecs, prefixCodec{prefix: append([]byte(nil), prefix...), codec: codec})
+	}
+	self.codecs = newCodecs
+	atomic.StoreInt32(&self.nCodecs, int32(len(self.codecs)))
+	return self
+}
+
+// codec returns the Codec for key, if any.
+func (self *Node) codec(key []byte) (result Codec) {
+	if atomic.LoadInt32(&self.nCodecs) == 0 {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	longest := -1
+	for _, c := range self.codecs {
+		if len(c.prefix) > longest && bytes.HasPrefix(key, c.prefix) {
+			result, longest = c.codec, len(c.prefix)
+		}
+	}
+	return
+}
+
+func (self *Node) encode(key, subKey, value []byte) (result []byte, err error) {
+	codec := self.codec(key)
+	if codec == nil {
+		return value, nil
+	}
+	if result, err = codec.Encode(key, subKey, value); err != nil {
+		err = fmt.Errorf("%v rejected by codec: %v", common.HexEncode(key), err)
+	}
+	return
+}
+
+func (self *Node) decode(key, subKey, value []byte) (result []byte, err error) {
+	codec := self.codec(key)
+	if codec == nil || value == nil {
+		return value, nil
+	}
+	return codec.Decode(key, subKey, value)
+}
+
+// decodeItems will decode the values of items, found in the sub tree defined by key.
+func (self *Node) decodeItems(key []byte, items []common.Item) (err error) {
+	codec := self.codec(key)
+	if codec == nil {
+		return
+	}
+	for index := range items {
+		if items[index].Value, err = codec.Decode(key, items[index].Key, items[index].Value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// decodeMirror will decode the value in result, found as the key of an entry in the mirror of the sub tree defined by key.
+func (self *Node) decodeMirror(key []byte, result *common.Item) (err error) {
+	result.Key, err = self.decode(key, result.Value, result.Key)
+	return
+}
+
+// decodeMirrorItems will decode the values in items, found as the keys of entries in the mirror of the sub tree defined by key.
+func (self *Node) decodeMirrorItems(key []byte, items []common.Item) (err error) {
+	for index := range items {
+		if err = self.decodeMirror(key, &items[index]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// encodeMirror returns value encoded like the values of the sub tree defined by key, to look it up in the mirror of the sub tree.
+// The sub key of value is unknown, so the codec gets a nil sub key.
+func (self *Node) encodeMirror(key, value []byte) (result []byte, err error) {
+	if value == nil {
+		return
+	}
+	return self.encode(key, nil, value)
+}
+
+// encodeMirrorRange will encode the bounds of r, which are values of the sub tree defined by r.Key, to look them up in the mirror of the sub tree.
+func (self *Node) encodeMirrorRange(r *common.Range) (err error) {
+	if r.Min, err = self.encodeMirror(r.Key, r.Min); err != nil {
+		return
+	}
+	r.Max, err = self.encodeMirror(r.Key, r.Max)
+	return
+}
@@ -0,0 +1,65 @@
+package dhash
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+// compactJSON rejects values that aren't JSON, stores them compacted, and returns them indented.
+type compactJSON struct{}
+
+func (self compactJSON) Encode(key, subKey, value []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := json.Compact(buf, value)
+	return buf.Bytes(), err
+}
+func (self compactJSON) Decode(key, subKey, value []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := json.Indent(buf, value, "", " ")
+	return buf.Bytes(), err
+}
+
+func TestCodecs(t *testing.T) {
+	node := NewEmbeddedNode("codecs", "").SetCodec([]byte("json/"), compactJSON{})
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "codecs")
+	if err := conn.TryPut([]byte("json/a"), []byte("{\"a\":")); err == nil {
+		t.Errorf("wanted invalid JSON to be rejected")
+	}
+	if err := conn.TryPut([]byte("json/a"), []byte("{ \"a\" : 1 }")); err != nil {
+		t.Errorf("%v", err)
+	}
+	if value, _, _ := node.tree.Get([]byte("json/a")); string(value) != "{\"a\":1}" {
+		t.Errorf("wanted the value stored compacted, got %s", value)
+	}
+	if value, _ := conn.Get([]byte("json/a")); string(value) != "{\n \"a\": 1\n}" {
+		t.Errorf("wanted the value returned indented, got %s", value)
+	}
+	conn.SubAddConfiguration([]byte("json/tree"), "mirrored", "yes")
+	if err := conn.TrySubPut([]byte("json/tree"), []byte("x"), []byte("[1, 2]")); err != nil {
+		t.Errorf("%v", err)
+	}
+	if items := conn.Slice([]byte("json/tree"), nil, nil, true, false); len(items) != 1 || string(items[0].Value) != "[\n 1,\n 2\n]" {
+		t.Errorf("wanted the sub tree value returned indented, got %v", items)
+	}
+	if items := conn.MirrorSlice([]byte("json/tree"), []byte("[1,2]"), nil, true, false); len(items) != 1 || string(items[0].Key) != "[\n 1,\n 2\n]" {
+		t.Errorf("wanted the mirrored value returned indented, got %v", items)
+	}
+	skipper := &treeSkipper{key: []byte("json/tree"), tree: node.tree, codec: node.codec([]byte("json/tree"))}
+	if res, err := skipper.Skip(nil, true); err != nil || res == nil || string(res.Values[0]) != "[\n 1,\n 2\n]" {
+		t.Errorf("wanted the set operation value returned indented, got %v, %v", res, err)
+	}
+	conn.Put([]byte("other"), []byte("not json"))
+	if value, _ := conn.Get([]byte("other")); string(value) != "not json" {
+		t.Errorf("wanted values outside the namespace untouched, got %s", value)
+	}
+	node.SetCodec([]byte("json/"), nil)
+	if value, _ := conn.Get([]byte("json/a")); string(value) != "{\"a\":1}" {
+		t.Errorf("wanted the stored value after removing the codec, got %s", value)
+	}
+}
@@ -0,0 +1,114 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func (self *Node) contentRefs(key []byte) int {
+	conf, _ := self.tree.SubConfiguration(key)
+	refs, _ := strconv.Atoi(conf[common.ContentRefsConf])
+	return refs
+}
+func (self *Node) setContentRefs(key []byte, refs int) error {
+	return self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     common.ContentRefsConf,
+		Value:   fmt.Sprint(refs),
+	})
+}
+
+// PutContent will put data.Value under the murmur hash of the value, or just add a reference to it if it is already stored, and return the key.
+// Content keys are write-once, so they can only be removed by removing all references to them using DelContent.
+func (self *Node) PutContent(data common.Item) (key []byte, err error) {
+	key = murmur.HashBytes(data.Value)
+	if err = self.assertNotFrozen(key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(key); err != nil {
+		return
+	}
+	self.contentLock.Lock()
+	defer self.contentLock.Unlock()
+	if value, _, existed := self.tree.Get(key); existed {
+		if value, err = self.join(key, value); err != nil {
+			return
+		}
+		if bytes.Compare(value, data.Value) != 0 {
+			err = fmt.Errorf("%v already contains different content", common.HexEncode(key))
+			return
+		}
+	} else {
+		data.Key, data.Sync, data.Immutable = key, true, true
+		if err = self.Put(data); err != nil {
+			return
+		}
+	}
+	err = self.setContentRefs(key, self.contentRefs(key)+1)
+	return
+}
+
+// DelContent will remove a reference to the content under data.Key, and remove the content when no references remain.
+func (self *Node) DelContent(data common.Item) (err error) {
+	if err = self.assertNotFrozen(data.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(data.Key); err != nil {
+		return
+	}
+	self.contentLock.Lock()
+	defer self.contentLock.Unlock()
+	refs := self.contentRefs(data.Key)
+	if refs < 1 {
+		return fmt.Errorf("%v contains no referenced content", common.HexEncode(data.Key))
+	}
+	if refs == 1 {
+		data.Sync, data.Override = true, true
+		if err = self.Del(data); err != nil {
+			return
+		}
+	}
+	err = self.setContentRefs(data.Key, refs-1)
+	return
+}
+
+// PutChunk will put data.Value under the murmur hash of the value, mark it as a chunk and return the key.
+// Chunks are not reference counted, but removed by garbage collection when no manifest has referred to them for the garbage collection grace period.
+// Putting an existing chunk again will make garbage collection forget when it found the chunk unreferenced.
+func (self *Node) PutChunk(data common.Item) (key []byte, err error) {
+	key = murmur.HashBytes(data.Value)
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if err = self.assertNotFrozen(key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(key); err != nil {
+		return
+	}
+	self.contentLock.Lock()
+	defer self.contentLock.Unlock()
+	if value, _, existed := self.tree.Get(key); existed && bytes.Compare(value, data.Value) != 0 {
+		err = fmt.Errorf("%v already contains different content", common.HexEncode(key))
+		return
+	}
+	data.Key, data.Sync, data.Immutable, data.Override = key, true, true, true
+	// Chunks are stored as is, even if they are longer than the chunk size of this Node.
+	if err = self.store(data); err != nil {
+		return
+	}
+	err = self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     common.ChunkConf,
+		Value:   "yes",
+	})
+	if err != nil {
+		return
+	}
+	err = self.setUnreferencedSince(key, 0)
+	return
+}
@@ -0,0 +1,47 @@
+package dhash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func TestContent(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:12191", "127.0.0.1:12191", "")
+	node.MustStart()
+	defer node.Stop()
+	value := []byte("blob")
+	key, err := node.PutContent(common.Item{Value: value})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if bytes.Compare(key, murmur.HashBytes(value)) != 0 {
+		t.Errorf("wanted key %v, got %v", common.HexEncode(murmur.HashBytes(value)), common.HexEncode(key))
+	}
+	if again, err := node.PutContent(common.Item{Value: value}); err != nil || bytes.Compare(again, key) != 0 {
+		t.Errorf("wanted %v and no error, got %v and %v", common.HexEncode(key), common.HexEncode(again), err)
+	}
+	if refs := node.contentRefs(key); refs != 2 {
+		t.Errorf("wanted 2 references, got %v", refs)
+	}
+	if err := node.Del(common.Item{Key: key}); !common.IsImmutable(err) {
+		t.Errorf("wanted %v, got %v", common.ErrImmutable, err)
+	}
+	if err := node.DelContent(common.Item{Key: key}); err != nil {
+		t.Errorf("%v", err)
+	}
+	if _, _, existed := node.tree.Get(key); !existed {
+		t.Errorf("content should remain while referenced")
+	}
+	if err := node.DelContent(common.Item{Key: key}); err != nil {
+		t.Errorf("%v", err)
+	}
+	if _, _, existed := node.tree.Get(key); existed {
+		t.Errorf("content should be removed when no longer referenced")
+	}
+	if err := node.DelContent(common.Item{Key: key}); err == nil {
+		t.Errorf("removing unreferenced content should fail")
+	}
+}
@@ -0,0 +1,53 @@
+package dhash
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// convergenceSyncsPerBound is how many syncs a Node with a convergence bound runs within the bound, so that a write missed by one sync is copied by the next ones.
+const convergenceSyncsPerBound = 3
+
+// SetConvergenceBound will make this Node aim to have every write to its owned range copied to its replicas within d.
+// The Node then syncs convergenceSyncsPerBound times per d, stops enforcing its sync limits while writes have been unsynced for more than half of d,
+// and reports common.EventConvergenceMissed and common.EventConvergenceMet when it stops and starts meeting d again. Zero, the default, turns this off.
+func (self *Node) SetConvergenceBound(d time.Duration) *Node {
+	atomic.StoreInt64(&self.convergenceBound, int64(d))
+	if d > 0 {
+		interval := d / convergenceSyncsPerBound
+		if interval == 0 {
+			// a bound of a few nanoseconds still needs a positive sync interval
+			interval = 1
+		}
+		self.SetSyncInterval(interval)
+	} else {
+		self.limiter.SetLifted(false)
+	}
+	return self
+}
+
+// ConvergenceBound returns how soon this Node aims to have every write to its owned range copied to its replicas.
+func (self *Node) ConvergenceBound() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.convergenceBound))
+}
+
+// checkConvergence will lift the sync limits of this Node while it risks missing its convergence bound, and report when it starts or stops meeting it.
+func (self *Node) checkConvergence() {
+	bound := self.ConvergenceBound()
+	if bound == 0 {
+		return
+	}
+	risk := self.Risk()
+	lag := risk.Lag()
+	self.limiter.SetLifted(lag > bound/2)
+	if risk.ConvergenceMet() {
+		if atomic.CompareAndSwapInt32(&self.convergenceMissed, 1, 0) {
+			self.report(common.EventConvergenceMet, "", fmt.Sprintf("within %v", bound))
+		}
+	} else if atomic.CompareAndSwapInt32(&self.convergenceMissed, 0, 1) {
+		self.report(common.EventConvergenceMissed, "", fmt.Sprintf("replicas %v behind, more than %v", lag, bound))
+	}
+}
@@ -0,0 +1,51 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func TestConvergenceBound(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:15491", "127.0.0.1:15491", "").SetConvergenceBound(time.Millisecond * 300)
+	node.MustStart()
+	defer node.Stop()
+	replica := NewNodeDir("127.0.0.1:15591", "127.0.0.1:15591", "")
+	replica.MustStart()
+	defer replica.Stop()
+	replica.MustJoin("127.0.0.1:15491")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node.node.GetNodes()), len(node.node.GetNodes()) == 2
+	}, time.Second*10)
+	if i := node.SyncInterval(); i != time.Millisecond*100 {
+		t.Errorf("wanted a sync interval of 100ms, got %v", i)
+	}
+	node.PauseSync()
+	replica.PauseSync()
+	key := murmur.HashString("k")
+	for !common.BetweenIE(key, node.node.GetPredecessor().Pos, node.node.GetPosition()) {
+		key = murmur.HashBytes(key)
+	}
+	node.Put(common.Item{Key: key, Value: []byte("v")})
+	common.AssertWithin(t, func() (string, bool) {
+		events := node.Events(0, 0)
+		return fmt.Sprint(events), hasEvent(events.Events, common.EventConvergenceMissed, "")
+	}, time.Second*5)
+	if !node.limiter.Lifted() {
+		t.Errorf("wanted the sync limits to be lifted while missing the bound")
+	}
+	if risk := node.Risk(); risk.ConvergenceMet() {
+		t.Errorf("wanted %+v to miss its bound", risk)
+	}
+	node.Sync()
+	common.AssertWithin(t, func() (string, bool) {
+		events := node.Events(0, 0)
+		return fmt.Sprint(events), hasEvent(events.Events, common.EventConvergenceMet, "")
+	}, time.Second*5)
+	if node.limiter.Lifted() {
+		t.Errorf("wanted the sync limits to be enforced again when meeting the bound")
+	}
+}
@@ -0,0 +1,48 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestDelMulti(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:15091", "127.0.0.1:15091", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:15191", "127.0.0.1:15191", "")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:15091")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2
+	}, time.Second*10)
+	conn := client.MustConn("127.0.0.1:15091")
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint("key", i))
+		conn.SPut(key, []byte("value"))
+		keys = append(keys, key)
+	}
+	if err := conn.PutImmutable([]byte("key50"), []byte("value")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for index, err := range conn.DelMulti(keys, false) {
+		if index == 50 {
+			if !common.IsImmutable(err) {
+				t.Errorf("wanted key50 to be immutable, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("wanted %s removed, got %v", keys[index], err)
+		}
+	}
+	if size := conn.Size(); size != 1 {
+		t.Errorf("wanted only key50 left, got %v keys", size)
+	}
+	if _, existed := conn.Get([]byte("key50")); !existed {
+		t.Errorf("wanted key50 left")
+	}
+}
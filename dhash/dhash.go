@@ -3,13 +3,16 @@ package dhash
 import (
 	"bytes"
 	"fmt"
+	"log"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/zond/god/client"
 	"github.com/zond/god/common"
 	"github.com/zond/god/discord"
 	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
 	"github.com/zond/god/radix"
 	"github.com/zond/god/timenet"
 )
@@ -26,6 +29,13 @@ type MigrateListener func(dhash *Node, source, destination []byte) (keep bool)
 // CommListener is a function listening to generic communications between two dhash.Nodes.
 type CommListener func(comm Comm) (keep bool)
 
+// WriteListener is a function listening to the values and tombstones a dhash.Node puts in its tree, both when receiving writes from clients and replicas and when synchronizing.
+// It is the same type as client.WriteListener, so that Node implements client.Watcher.
+type WriteListener = client.WriteListener
+
+// mutationListener is a function listening to the writes this dhash.Node receives as primary owner of the written key.
+type mutationListener func(operation string, data common.Item) (keep bool)
+
 type commListenerContainer struct {
 	channel  chan Comm
 	listener CommListener
@@ -48,9 +58,13 @@ type Comm struct {
 }
 
 const (
-	syncInterval      = time.Second
-	migrateHysteresis = 1.5
-	migrateWaitFactor = 2
+	defaultSyncInterval      = time.Second
+	defaultCleanInterval     = time.Second
+	defaultMigrateHysteresis = 1.5
+	defaultMigrateWaitFactor = 2
+	defaultGCInterval        = time.Minute
+	defaultGCGracePeriod     = time.Hour
+	defaultChunkSize         = 1 << 22
 )
 
 const (
@@ -62,19 +76,95 @@ const (
 // Node is a node in the database. It contains a discord.Node containing routing and rpc functionality,
 // a timenet.Timer containing time synchronization functionality and a radix.Tree containing the actual data.
 type Node struct {
-	lastSync         int64
-	lastMigrate      int64
-	lastReroute      int64
-	state            int32
-	lock             *sync.RWMutex
-	syncListeners    []SyncListener
-	cleanListeners   []CleanListener
-	migrateListeners []MigrateListener
-	commListeners    map[*commListenerContainer]bool
-	nCommListeners   int32
-	node             *discord.Node
-	timer            *timenet.Timer
-	tree             *radix.Tree
+	lastSync          int64
+	lastMigrate       int64
+	lastReroute       int64
+	syncInterval      int64
+	cleanInterval     int64
+	migrateWaitFactor int64
+	migrateHysteresis uint64
+	gcInterval        int64
+	gcGracePeriod     int64
+	chunkSize         int64
+	syncFanout        int64
+	incrementalSyncs  int64
+	syncRounds        int64
+	redundancy        int64
+	redundancyGrace   int64
+	redundancyLowered int64
+	startedAt         int64
+	syncs             int64
+	syncedEntries     int64
+	firstUnsynced     int64
+	divergedSince     int64
+	convergenceBound  int64
+	convergenceMissed int32
+	forensics         int64
+	idNode            int64
+	idTime            int64
+	idSequence        int64
+	idReserved        int64
+	cleans            int64
+	cleanedEntries    int64
+	migrations        int64
+	minNodes          int64
+	heapBytes         uint64
+	cpuLoad           uint64
+	gcLoad            uint64
+	rejectedOps       int64
+	quorum            int32
+	syncPaused        int32
+	migrationPaused   int32
+	state             int32
+	expensive         int32
+	expensiveQueued   int32
+	dir               string
+	zone              string
+	mirrorAddr        string
+	verify            bool
+	lock              *sync.RWMutex
+	leaseLock         *sync.Mutex
+	scheduler         *scheduler
+	loadSampler       *loadSampler
+	contentLock       *sync.Mutex
+	statsLock         *sync.Mutex
+	changeLock        *sync.Mutex
+	forensicsLock     *sync.Mutex
+	appendLock        *sync.Mutex
+	idLock            *sync.Mutex
+	mutableLocks      []sync.Mutex
+	admissionLock     *sync.Mutex
+	admission         AdmissionController
+	changes           *radix.Bloom
+	previousChanges   *radix.Bloom
+	divergent         []common.Divergence
+	lastRequests      map[string]int64
+	requestRates      map[string]float64
+	syncListeners     []SyncListener
+	cleanListeners    []CleanListener
+	migrateListeners  []MigrateListener
+	commListeners     map[*commListenerContainer]bool
+	nCommListeners    int32
+	mutationListeners common.Listeners[mutationListener]
+	writeListeners    common.Listeners[WriteListener]
+	codecs            []prefixCodec
+	workers           []*worker
+	events            *eventLog
+	expiries          *eventStream
+	replays           *replayWindow
+	subTreeCache      *subTreeCache
+	nCodecs           int32
+	node              *discord.Node
+	timer             *timenet.Timer
+	tree              *radix.Tree
+	limiter           *radix.Limiter
+}
+
+// NewEmbeddedNode returns a Node that is only reachable inside the process, at the address common.EmbeddedPrefix + name, and stores its data in dir.
+// It opens no ports, but can be used through client.Conn like any other node. To scale out, start a regular Node with the same dir and let other nodes join it.
+func NewEmbeddedNode(name, dir string) *Node {
+	addr := common.EmbeddedPrefix + name
+	return NewNodeDir(addr, addr, dir)
 }
 
 func NewNode(listenAddr, broadcastAddr string) *Node {
@@ -86,9 +176,40 @@ func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
 	result = &Node{
 		node:          discord.NewNode(listenAddr, broadcastAddr),
 		lock:          new(sync.RWMutex),
+		leaseLock:     new(sync.Mutex),
+		contentLock:   new(sync.Mutex),
+		statsLock:     new(sync.Mutex),
+		changeLock:    new(sync.Mutex),
+		forensicsLock: new(sync.Mutex),
+		appendLock:    new(sync.Mutex),
+		idLock:        new(sync.Mutex),
+		mutableLocks:  make([]sync.Mutex, mutableLockStripes),
+		admissionLock: new(sync.Mutex),
+		scheduler:     newScheduler(),
+		loadSampler:   newLoadSampler(),
+		admission:     LoadAdmission{},
+		requestRates:  make(map[string]float64),
+		limiter:       radix.NewLimiter(0, 0),
 		commListeners: make(map[*commListenerContainer]bool),
+		events:        newEventLog(),
+		expiries:      newEventStream(),
+		replays:       newReplayWindow(),
+		subTreeCache:  newSubTreeCache(),
+		redundancy:    int64(common.GetRedundancy()),
+		idNode:        -1,
 		state:         created,
 	}
+	result.SetSyncInterval(defaultSyncInterval)
+	result.SetCleanInterval(defaultCleanInterval)
+	result.SetMigrateHysteresis(defaultMigrateHysteresis)
+	result.SetMigrateWaitFactor(defaultMigrateWaitFactor)
+	result.SetGCInterval(defaultGCInterval)
+	result.SetGCGracePeriod(defaultGCGracePeriod)
+	result.SetChunkSize(defaultChunkSize)
+	result.SetRedundancyGracePeriod(defaultRedundancyGracePeriod)
+	result.SetSyncFanout(defaultSyncFanout)
+	result.rotateChanges()
+	result.rotateChanges()
 	result.node.AddCommListener(func(source, dest common.Remote, typ string) bool {
 		if result.hasState(started) {
 			if result.hasCommListeners() {
@@ -103,12 +224,14 @@ func NewNodeDir(listenAddr, broadcastAddr, dir string) (result *Node) {
 	})
 	result.AddChangeListener(func(r *common.Ring) bool {
 		atomic.StoreInt64(&result.lastReroute, time.Now().UnixNano())
+		result.reportLeft(r)
 		return true
 	})
 	result.timer = timenet.NewTimer((*dhashPeerProducer)(result))
 	result.tree = radix.NewTreeTimer(result.timer)
 	if dir != "" {
-		result.tree.Log(dir).Restore()
+		result.dir = dir
+		result.tree.Log(dir)
 	}
 	result.node.Export("Timenet", (*timerServer)(result.timer))
 	result.node.Export("DHash", (*dhashServer)(result))
@@ -151,6 +274,25 @@ func (self *Node) triggerCommListeners(comm Comm) {
 	}
 	self.lock.RUnlock()
 }
+func (self *Node) addMutationListener(l mutationListener) {
+	self.mutationListeners.Add(l)
+}
+func (self *Node) triggerMutationListeners(operation string, data common.Item) {
+	self.mutationListeners.Trigger(func(l mutationListener) bool {
+		return l(operation, data)
+	})
+}
+
+// AddWriteListener will make l get notified of each value and tombstone this Node puts in its tree, with the value it replaced, until it returns false.
+// Writes restored from the logfiles when the Node starts are not reported.
+func (self *Node) AddWriteListener(l WriteListener) {
+	self.writeListeners.Add(l)
+}
+func (self *Node) triggerWriteListeners(write radix.Write) {
+	self.writeListeners.Trigger(func(l WriteListener) bool {
+		return l(write)
+	})
+}
 func (self *Node) AddCleanListener(l CleanListener) {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -187,22 +329,56 @@ func (self *Node) Stop() {
 	if self.changeState(started, stopped) {
 		self.node.Stop()
 		self.timer.Stop()
+		if self.dir != "" {
+			self.tree.Seal()
+		}
+	}
+}
+
+// Verify will make Start compare the restored data with the hash saved when the node was last stopped.
+// Checksums of the logged operations are always verified. Must be called before Start.
+func (self *Node) Verify() *Node {
+	self.verify = true
+	return self
+}
+
+// RestoreReport returns the report of what was found when the persisted data of this node was restored in Start.
+func (self *Node) RestoreReport() persistence.Report {
+	return self.tree.RestoreReport()
+}
+
+// restore will restore the persisted data of this node, before it takes ownership of any part of the ring.
+func (self *Node) restore() {
+	if self.verify {
+		self.tree.Verify()
+	}
+	self.tree.Restore()
+	if report := self.tree.RestoreReport(); !report.Clean() {
+		log.Printf("%v restored with problems: %v", self.GetBroadcastAddr(), report)
 	}
 }
 
 // Start will spin up this dhash.Node, including its discord.Node and timenet.Timer.
-// It will also start the sync, clean and migrate jobs.
+// It will also restore any persisted data, and start the sync, clean and migrate jobs.
 func (self *Node) Start() (err error) {
 	if !self.changeState(created, started) {
 		return fmt.Errorf("%v can only be started when in state 'created'", self)
 	}
+	if self.dir != "" {
+		self.restore()
+	}
+	self.applyConfiguredRedundancy()
+	self.tree.SetWriteListener(func(write radix.Write) {
+		self.recordChange(write.Key)
+		self.recordUnsynced(write.Key)
+		self.triggerWriteListeners(write)
+	})
 	if err = self.node.Start(); err != nil {
 		return
 	}
 	self.timer.Start()
-	go self.syncPeriodically()
-	go self.cleanPeriodically()
-	go self.migratePeriodically()
+	atomic.StoreInt64(&self.startedAt, time.Now().UnixNano())
+	self.startWorkers()
 	self.startJson()
 	return
 }
@@ -222,35 +398,77 @@ func (self *Node) triggerSyncListeners(source, dest common.Remote, pulled, pushe
 	self.syncListeners = newListeners
 }
 func (self *Node) sync() {
+	self.synchronize(false)
+}
+
+// synchronize will synchronize the data owned by this node with its replicas. If incremental, only the keys changed recently on either side are visited.
+func (self *Node) synchronize(incremental bool) {
+	self.applyConfiguredRedundancy()
 	var pulled int
 	var pushed int
+	var pushFilter, pullFilter *radix.Bloom
+	if incremental {
+		pushFilter = self.ChangeFilter()
+	}
+	syncStart := time.Now().UnixNano()
+	diverged := false
+	failed := false
 	selfRemote := self.node.Remote()
 	nextSuccessor := self.node.GetSuccessor()
 	for i := 0; i < self.node.Redundancy()-1; i++ {
 		myPos := self.node.GetPosition()
-		remoteHash := remoteHashTree{
-			source:      selfRemote,
-			destination: nextSuccessor,
-			node:        self,
+		remoteHash := newRemoteHashTree(self, selfRemote, nextSuccessor)
+		pullFilter = nil
+		if incremental {
+			pullFilter = &radix.Bloom{}
+			if err := nextSuccessor.Call("DHash.ChangeFilter", 0, pullFilter); err != nil {
+				pullFilter = nil
+			}
+		}
+		var shipped, fetched int
+		var err error
+		if !incremental {
+			shipped, fetched, err = self.shipSnapshot(nextSuccessor, self.node.GetPredecessor().Pos, myPos)
 		}
-		pushed = radix.NewSync(self.tree, remoteHash).From(self.node.GetPredecessor().Pos).To(myPos).Run().PutCount()
-		pulled = radix.NewSync(remoteHash, self.tree).From(self.node.GetPredecessor().Pos).To(myPos).Run().PutCount()
+		push := radix.NewSync(self.tree, remoteHash).From(self.node.GetPredecessor().Pos).To(myPos).Limit(self.limiter).Fanout(self.SyncFanout()).Filter(pushFilter).Diverged(self.divergenceRecorder(selfRemote.Addr, nextSuccessor.Addr)).Run()
+		pull := radix.NewSync(remoteHash, self.tree).From(self.node.GetPredecessor().Pos).To(myPos).Limit(self.limiter).Fanout(self.SyncFanout()).Filter(pullFilter).Diverged(self.divergenceRecorder(nextSuccessor.Addr, selfRemote.Addr)).Run()
+		if err != nil || push.Err() != nil || pull.Err() != nil {
+			failed = true
+		}
+		pushed = shipped + push.PutCount()
+		pulled = fetched + pull.PutCount()
+		atomic.AddInt64(&self.syncs, 1)
+		atomic.AddInt64(&self.syncedEntries, int64(pulled+pushed))
 		if pushed != 0 || pulled != 0 {
+			diverged = true
 			self.triggerSyncListeners(selfRemote, nextSuccessor, pulled, pushed)
 		}
 		nextSuccessor = self.node.GetSuccessorForRemote(nextSuccessor)
 	}
-}
-func (self *Node) syncPeriodically() {
-	for self.hasState(started) {
-		self.sync()
-		time.Sleep(syncInterval)
+	if self.node.Redundancy() > 1 {
+		self.recordSynced(syncStart, diverged, !failed)
 	}
 }
-func (self *Node) cleanPeriodically() {
-	for self.hasState(started) {
-		self.clean()
-		time.Sleep(syncInterval)
+
+// Sync will synchronize the data owned by this node with its replicas right away, instead of waiting for the next periodic sync.
+func (self *Node) Sync() {
+	self.sync()
+}
+
+// Decommission will push the data owned by this node to its replicas and then stop it.
+// The remaining nodes will restore the redundancy of the data using their regular sync.
+func (self *Node) Decommission() {
+	self.report(common.EventDecommissioned, "", "")
+	self.sync()
+	self.Stop()
+}
+func (self *Node) periodicSync() {
+	if !self.SyncPaused() {
+		incremental := self.incremental()
+		if self.IncrementalSyncs() > 0 {
+			self.rotateChanges()
+		}
+		self.synchronize(incremental)
 	}
 }
 func (self *Node) triggerMigrateListeners(oldPos, newPos []byte) {
@@ -276,20 +494,21 @@ func (self *Node) changePosition(newPos []byte) {
 	if bytes.Compare(newPos, oldPos) != 0 {
 		self.node.SetPosition(newPos)
 		atomic.StoreInt64(&self.lastMigrate, time.Now().UnixNano())
+		atomic.AddInt64(&self.migrations, 1)
+		self.report(common.EventMigrated, "", fmt.Sprintf("from %v to %v", common.HexEncode(oldPos), common.HexEncode(newPos)))
 		self.triggerMigrateListeners(oldPos, newPos)
 	}
 }
 func (self *Node) isLeader() bool {
 	return bytes.Compare(self.node.GetPredecessor().Pos, self.node.GetPosition()) > 0
 }
-func (self *Node) migratePeriodically() {
-	for self.hasState(started) {
+func (self *Node) periodicMigrate() {
+	if !self.MigrationPaused() && self.HasQuorum() {
 		self.migrate()
-		time.Sleep(syncInterval)
 	}
 }
 func (self *Node) migrate() {
-	lastAllowedChange := time.Now().Add(-1 * migrateWaitFactor * syncInterval).UnixNano()
+	lastAllowedChange := time.Now().Add(-1 * time.Duration(self.MigrateWaitFactor()) * self.SyncInterval()).UnixNano()
 	if lastAllowedChange > common.Max64(atomic.LoadInt64(&self.lastSync), atomic.LoadInt64(&self.lastReroute), atomic.LoadInt64(&self.lastMigrate)) {
 		var succSize int
 		succ := self.node.GetSuccessor()
@@ -297,7 +516,7 @@ func (self *Node) migrate() {
 			self.node.RemoveNode(succ)
 		} else {
 			mySize := self.Owned()
-			if mySize > 10 && float64(mySize) > float64(succSize)*migrateHysteresis {
+			if mySize > 10 && float64(mySize) > float64(succSize)*self.MigrateHysteresis() {
 				wantedDelta := (mySize - succSize) / 2
 				var existed bool
 				var wantedPos []byte
@@ -372,17 +591,16 @@ func (self *Node) clean() {
 		if owners, isOwner := self.owners(nextKey); !isOwner {
 			var sync *radix.Sync
 			for index, owner := range owners {
-				sync = radix.NewSync(self.tree, remoteHashTree{
-					source:      selfRemote,
-					destination: owner,
-					node:        self,
-				}).From(nextKey).To(owners[0].Pos)
-				if index == len(owners)-2 {
+				sync = radix.NewSync(self.tree, newRemoteHashTree(self, selfRemote, owner)).From(nextKey).To(owners[0].Pos).Limit(self.limiter).Fanout(self.SyncFanout())
+				// After the redundancy was lowered, the excess replicas are kept for a while in case it was a mistake.
+				if index == len(owners)-2 && !self.withinRedundancyGracePeriod() {
 					sync.Destroy()
 				}
 				sync.Run()
 				cleaned = sync.DelCount()
 				pushed = sync.PutCount()
+				atomic.AddInt64(&self.cleans, 1)
+				atomic.AddInt64(&self.cleanedEntries, int64(cleaned))
 				if cleaned != 0 || pushed != 0 {
 					self.triggerCleanListeners(selfRemote, owner, cleaned, pushed)
 				}
@@ -399,11 +617,18 @@ func (self *Node) MustStart() *Node {
 func (self *Node) MustJoin(addr string) {
 	self.timer.Conform(remotePeer(common.Remote{Addr: addr}))
 	self.node.MustJoin(addr)
+	self.report(common.EventJoined, "", fmt.Sprintf("via %v", addr))
 }
 func (self *Node) Time() time.Time {
 	return time.Unix(0, self.timer.ContinuousTime())
 }
 
+// SetClockSource will make the timer of this Node weight the time of source into the time of the cluster, to keep the cluster from drifting from the real time.
+func (self *Node) SetClockSource(source timenet.ClockSource, weight float64) *Node {
+	self.timer.SetClockSource(source, weight)
+	return self
+}
+
 // Owned returns the number of items, including tombstones, that this node has responsibility for.
 func (self *Node) Owned() int {
 	pred := self.node.GetPredecessor()
@@ -2,6 +2,9 @@ package dhash
 
 import (
 	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/persistence"
+	"github.com/zond/god/radix"
 	"github.com/zond/setop"
 )
 
@@ -12,56 +15,169 @@ func (self *dhashServer) Clear(x int, y *int) error {
 	return nil
 }
 func (self *dhashServer) SlaveSubPut(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	(*Node)(self).remember(data.Token)
 	return (*Node)(self).subPut(data)
 }
 func (self *dhashServer) SlaveSubClear(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	(*Node)(self).remember(data.Token)
 	return (*Node)(self).subClear(data)
 }
 func (self *dhashServer) SlaveSubDel(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	(*Node)(self).remember(data.Token)
 	return (*Node)(self).subDel(data)
 }
 func (self *dhashServer) SlaveDel(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	(*Node)(self).remember(data.Token)
 	return (*Node)(self).del(data)
 }
 func (self *dhashServer) SlavePut(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	(*Node)(self).remember(data.Token)
 	return (*Node)(self).put(data)
 }
 func (self *dhashServer) SubDel(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
 	return (*Node)(self).SubDel(data)
 }
 func (self *dhashServer) SubClear(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
 	return (*Node)(self).SubClear(data)
 }
 func (self *dhashServer) SubPut(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
 	return (*Node)(self).SubPut(data)
 }
 func (self *dhashServer) Del(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
 	return (*Node)(self).Del(data)
 }
+func (self *dhashServer) DelMulti(items []common.Item, results *[]string) error {
+	admitted, err := (*Node)(self).admitExpensive("DelMulti", common.Batch)
+	if err != nil {
+		return err
+	}
+	defer admitted()
+	*results = make([]string, len(items))
+	for index, data := range items {
+		done := (*Node)(self).schedule(data.QoS)
+		err := (*Node)(self).assertOwner(data.Key)
+		if err == nil {
+			err = (*Node)(self).Del(data)
+		}
+		done()
+		if err != nil {
+			(*results)[index] = err.Error()
+		}
+	}
+	return nil
+}
 func (self *dhashServer) Put(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
 	return (*Node)(self).Put(data)
 }
+func (self *dhashServer) DebugPut(data common.Item, timing *common.Timing) (err error) {
+	data.Debug = true
+	done := (*Node)(self).scheduleTimed(&data)
+	if err = (*Node)(self).assertOwner(data.Key); err == nil {
+		err = (*Node)(self).Put(data)
+	}
+	done()
+	*timing = *data.Timing
+	return
+}
+func (self *dhashServer) PutContent(data common.Item, key *[]byte) (err error) {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err = (*Node)(self).assertOwner(murmur.HashBytes(data.Value)); err != nil {
+		return
+	}
+	*key, err = (*Node)(self).PutContent(data)
+	return
+}
+func (self *dhashServer) DelContent(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
+	return (*Node)(self).DelContent(data)
+}
+func (self *dhashServer) PutChunk(data common.Item, key *[]byte) (err error) {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err = (*Node)(self).assertOwner(murmur.HashBytes(data.Value)); err != nil {
+		return
+	}
+	*key, err = (*Node)(self).PutChunk(data)
+	return
+}
+func (self *dhashServer) ReferencedChunks(chunks [][]byte, result *[][]byte) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result = (*Node)(self).ReferencedChunks(chunks)
+	return nil
+}
+func (self *dhashServer) Trim(x int, removed *int) error {
+	*removed = (*Node)(self).Trim()
+	return nil
+}
+func (self *dhashServer) CollectGarbage(x int, removed *int) error {
+	*removed = (*Node)(self).CollectGarbage()
+	return nil
+}
 func (self *dhashServer) RingHash(x int, result *[]byte) error {
 	return (*Node)(self).RingHash(x, result)
 }
 func (self *dhashServer) MirrorCount(r common.Range, result *int) error {
+	defer (*Node)(self).schedule(r.QoS)()
 	return (*Node)(self).MirrorCount(r, result)
 }
 func (self *dhashServer) Count(r common.Range, result *int) error {
+	defer (*Node)(self).schedule(r.QoS)()
 	return (*Node)(self).Count(r, result)
 }
 func (self *dhashServer) Next(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).Next(data, result)
 }
 func (self *dhashServer) Prev(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).Prev(data, result)
 }
 func (self *dhashServer) SubGet(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).SubGet(data, result)
 }
 func (self *dhashServer) Get(data common.Item, result *common.Item) error {
+	defer (*Node)(self).scheduleTimed(&data)()
 	return (*Node)(self).Get(data, result)
 }
+func (self *dhashServer) GetRange(r common.ByteRange, result *common.Item) error {
+	defer (*Node)(self).schedule(r.QoS)()
+	return (*Node)(self).GetRange(r, result)
+}
+func (self *dhashServer) AppendValue(data common.Item, x *int) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	if err := (*Node)(self).assertOwner(data.Key); err != nil {
+		return err
+	}
+	return (*Node)(self).AppendValue(data)
+}
 func (self *dhashServer) Size(x int, result *int) error {
 	*result = (*Node)(self).Size()
 	return nil
@@ -69,105 +185,383 @@ func (self *dhashServer) Size(x int, result *int) error {
 func (self *dhashServer) SubSize(key []byte, result *int) error {
 	return (*Node)(self).SubSize(key, result)
 }
+func (self *dhashServer) Sync(x int, y *int) error {
+	(*Node)(self).Sync()
+	return nil
+}
+func (self *dhashServer) Decommission(x int, y *int) error {
+	go (*Node)(self).Decommission()
+	return nil
+}
+func (self *dhashServer) PauseMigration(x int, y *int) error {
+	(*Node)(self).PauseMigration()
+	return nil
+}
+func (self *dhashServer) ResumeMigration(x int, y *int) error {
+	(*Node)(self).ResumeMigration()
+	return nil
+}
+func (self *dhashServer) VerifyReplicas(r int, verified *bool) error {
+	*verified = (*Node)(self).VerifyReplicas(r)
+	return nil
+}
+func (self *dhashServer) ApplyRedundancy(r int, y *int) error {
+	(*Node)(self).ApplyRedundancy(r)
+	return nil
+}
+func (self *dhashServer) SetRedundancy(r int, y *int) error {
+	return (*Node)(self).SetRedundancy(r)
+}
+func (self *dhashServer) ChangeFilter(x int, result *radix.Bloom) error {
+	if (*Node)(self).IncrementalSyncs() == 0 {
+		return errNoChangeFilter
+	}
+	*result = *(*Node)(self).ChangeFilter()
+	return nil
+}
+func (self *dhashServer) Freeze(r common.Range, y *int) error {
+	(*Node)(self).Freeze(r)
+	return nil
+}
+func (self *dhashServer) Unfreeze(r common.Range, y *int) error {
+	(*Node)(self).Unfreeze(r)
+	return nil
+}
+func (self *dhashServer) Frozen(x int, result *[]common.Range) error {
+	*result = (*Node)(self).Frozen()
+	return nil
+}
+func (self *dhashServer) SystemNamespaces(x int, result *[]string) error {
+	*result = (*Node)(self).SystemNamespaces()
+	return nil
+}
+func (self *dhashServer) SystemGet(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	return (*Node)(self).SystemGet(data, result)
+}
+func (self *dhashServer) SystemSlice(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("SystemSlice", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return (*Node)(self).SystemSlice(r, result)
+}
+func (self *dhashServer) Zone(x int, result *string) error {
+	*result = (*Node)(self).Zone()
+	return nil
+}
+func (self *dhashServer) PauseSync(x int, y *int) error {
+	(*Node)(self).PauseSync()
+	return nil
+}
+func (self *dhashServer) ResumeSync(x int, y *int) error {
+	(*Node)(self).ResumeSync()
+	return nil
+}
+func (self *dhashServer) Snapshot(x int, result *[]byte) (err error) {
+	*result, err = (*Node)(self).Snapshot()
+	return
+}
+func (self *dhashServer) Restore(encoded []byte, changed *int) (err error) {
+	*changed, err = (*Node)(self).Restore(encoded)
+	return
+}
+func (self *dhashServer) RestoreReport(x int, result *persistence.Report) error {
+	*result = (*Node)(self).RestoreReport()
+	return nil
+}
 func (self *dhashServer) Owned(x int, result *int) error {
 	*result = (*Node)(self).Owned()
 	return nil
 }
+func (self *dhashServer) Stats(x int, result *common.Stats) error {
+	*result = (*Node)(self).Stats()
+	return nil
+}
+func (self *dhashServer) ClusterStats(x int, result *common.ClusterStats) error {
+	*result = (*Node)(self).ClusterStats()
+	return nil
+}
+func (self *dhashServer) Backup(id string, result *common.BackupManifest) (err error) {
+	*result, err = (*Node)(self).Backup(id)
+	return
+}
+func (self *dhashServer) BackupAt(req common.BackupRequest, result *common.BackupFile) (err error) {
+	*result, err = (*Node)(self).BackupAt(req)
+	return
+}
+func (self *dhashServer) WriteBackupManifest(manifest common.BackupManifest, x *int) error {
+	return (*Node)(self).WriteBackupManifest(manifest)
+}
+func (self *dhashServer) RecordBackup(manifest common.BackupManifest, x *int) error {
+	return (*Node)(self).RecordBackup(manifest)
+}
+func (self *dhashServer) ReadBackup(req common.BackupChunk, result *[]byte) (err error) {
+	*result, err = (*Node)(self).ReadBackup(req)
+	return
+}
+func (self *dhashServer) Workers(x int, result *[]common.WorkerStatus) error {
+	*result = (*Node)(self).Workers()
+	return nil
+}
+func (self *dhashServer) Divergences(key []byte, result *[]common.Divergence) error {
+	*result = (*Node)(self).Divergences(key)
+	return nil
+}
+func (self *dhashServer) Events(r common.EventsRequest, result *common.Events) error {
+	*result = (*Node)(self).Events(r.Since, r.Wait)
+	return nil
+}
+func (self *dhashServer) Expiries(r common.EventsRequest, result *common.Events) error {
+	*result = (*Node)(self).Expiries(r.Since, r.Wait)
+	return nil
+}
+func (self *dhashServer) SubTreeHash(key []byte, result *[]byte) error {
+	*result = (*Node)(self).SubTreeHash(key)
+	return nil
+}
+func (self *dhashServer) NextID(x int, result *int64) (err error) {
+	*result, err = (*Node)(self).NextID()
+	return
+}
+func (self *dhashServer) OwnedHeatmap(n int, result *common.Heatmap) error {
+	*result = (*Node)(self).OwnedHeatmap(n)
+	return nil
+}
+func (self *dhashServer) Heatmap(n int, result *common.Heatmap) error {
+	*result = (*Node)(self).Heatmap(n)
+	return nil
+}
+func (self *dhashServer) Risk(x int, result *common.NodeRisk) error {
+	*result = (*Node)(self).Risk()
+	return nil
+}
+func (self *dhashServer) RiskReport(x int, result *common.RiskReport) error {
+	*result = (*Node)(self).RiskReport()
+	return nil
+}
 func (self *dhashServer) Describe(x int, result *common.DHashDescription) error {
 	*result = (*Node)(self).Description()
 	return nil
 }
+func (self *dhashServer) TreePage(r common.TreePageRequest, result *common.TreePage) error {
+	done, err := (*Node)(self).admitExpensive("TreePage", common.Batch)
+	if err != nil {
+		return err
+	}
+	defer done()
+	*result = (*Node)(self).TreePage(r)
+	return nil
+}
 func (self *dhashServer) DescribeTree(x int, result *string) error {
 	*result = (*Node)(self).DescribeTree()
 	return nil
 }
 func (self *dhashServer) MirrorPrevIndex(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorPrevIndex(data, result)
 }
 func (self *dhashServer) MirrorNextIndex(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorNextIndex(data, result)
 }
 func (self *dhashServer) PrevIndex(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).PrevIndex(data, result)
 }
 func (self *dhashServer) NextIndex(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).NextIndex(data, result)
 }
 func (self *dhashServer) MirrorReverseIndexOf(data common.Item, result *common.Index) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorReverseIndexOf(data, result)
 }
 func (self *dhashServer) MirrorIndexOf(data common.Item, result *common.Index) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorIndexOf(data, result)
 }
 func (self *dhashServer) ReverseIndexOf(data common.Item, result *common.Index) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).ReverseIndexOf(data, result)
 }
+func (self *dhashServer) Rank(data common.Item, result *common.Index) error {
+	defer (*Node)(self).schedule(data.QoS)()
+	return (*Node)(self).Rank(data, result)
+}
+func (self *dhashServer) TopN(r common.Range, result *[]common.Item) error {
+	defer (*Node)(self).schedule(r.QoS)()
+	return (*Node)(self).TopN(r, result)
+}
 func (self *dhashServer) IndexOf(data common.Item, result *common.Index) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).IndexOf(data, result)
 }
 func (self *dhashServer) SubMirrorPrev(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).SubMirrorPrev(data, result)
 }
 func (self *dhashServer) SubMirrorNext(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).SubMirrorNext(data, result)
 }
 func (self *dhashServer) SubPrev(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).SubPrev(data, result)
 }
 func (self *dhashServer) SubNext(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).SubNext(data, result)
 }
 func (self *dhashServer) MirrorFirst(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorFirst(data, result)
 }
 func (self *dhashServer) MirrorLast(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).MirrorLast(data, result)
 }
 func (self *dhashServer) First(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).First(data, result)
 }
 func (self *dhashServer) Last(data common.Item, result *common.Item) error {
+	defer (*Node)(self).schedule(data.QoS)()
 	return (*Node)(self).Last(data, result)
 }
 func (self *dhashServer) MirrorReverseSlice(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorReverseSlice", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorReverseSlice(r, result)
 }
 func (self *dhashServer) MirrorSlice(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorSlice", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorSlice(r, result)
 }
 func (self *dhashServer) MirrorSliceIndex(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorSliceIndex", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorSliceIndex(r, result)
 }
 func (self *dhashServer) MirrorReverseSliceIndex(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorReverseSliceIndex", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorReverseSliceIndex(r, result)
 }
 func (self *dhashServer) MirrorSliceLen(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorSliceLen", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorSliceLen(r, result)
 }
 func (self *dhashServer) MirrorReverseSliceLen(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("MirrorReverseSliceLen", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).MirrorReverseSliceLen(r, result)
 }
 func (self *dhashServer) ReverseSlice(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("ReverseSlice", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).ReverseSlice(r, result)
 }
 func (self *dhashServer) Slice(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("Slice", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).Slice(r, result)
 }
+func (self *dhashServer) Query(q common.Query, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("Query", q.Range.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return (*Node)(self).Query(q, result)
+}
 func (self *dhashServer) SliceIndex(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("SliceIndex", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).SliceIndex(r, result)
 }
 func (self *dhashServer) ReverseSliceIndex(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("ReverseSliceIndex", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).ReverseSliceIndex(r, result)
 }
 func (self *dhashServer) SliceLen(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("SliceLen", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return (*Node)(self).SliceLen(r, result)
+}
+
+// SetOpSlice is SliceLen for the set expressions evaluated by other nodes. They were already admitted there, so it only waits for its QoS class,
+// to not get set expressions rejected halfway through by the admission control of the nodes owning their sources.
+func (self *dhashServer) SetOpSlice(r common.Range, result *[]common.Item) error {
+	defer (*Node)(self).schedule(r.QoS)()
 	return (*Node)(self).SliceLen(r, result)
 }
+
+// SetOpSegment is SetOpSlice for nodes caching the segments, and also returns the hash of the sub tree.
+func (self *dhashServer) SetOpSegment(r common.Range, result *SetOpSegment) (err error) {
+	defer (*Node)(self).schedule(r.QoS)()
+	*result, err = (*Node)(self).setOpSegment(r)
+	return
+}
 func (self *dhashServer) ReverseSliceLen(r common.Range, result *[]common.Item) error {
+	done, err := (*Node)(self).admitExpensive("ReverseSliceLen", r.QoS)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).ReverseSliceLen(r, result)
 }
 func (self *dhashServer) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) error {
+	done, err := (*Node)(self).admitExpensive("SetExpression", common.Batch)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return (*Node)(self).SetExpression(expr, items)
 }
+func (self *dhashServer) Lock(lease common.Lease, result *common.Lease) error {
+	return (*Node)(self).Lock(lease, result)
+}
+func (self *dhashServer) Unlock(lease common.Lease, result *bool) error {
+	return (*Node)(self).Unlock(lease, result)
+}
 
 func (self *dhashServer) AddConfiguration(c common.ConfItem, x *int) error {
 	(*Node)(self).AddConfiguration(c)
@@ -178,8 +572,11 @@ func (self *dhashServer) SlaveSubAddConfiguration(c common.ConfItem, x *int) err
 	return nil
 }
 func (self *dhashServer) SubAddConfiguration(c common.ConfItem, x *int) error {
-	(*Node)(self).SubAddConfiguration(c)
-	return nil
+	// Sub tree configurations are kept by the owner of the sub tree, and its replicas.
+	if successor := (*Node)(self).node.GetSuccessorFor(c.TreeKey); successor.Addr != (*Node)(self).GetBroadcastAddr() {
+		return successor.Call("DHash.SubAddConfiguration", c, x)
+	}
+	return (*Node)(self).SubAddConfiguration(c)
 }
 func (self *dhashServer) Configuration(x int, result *common.Conf) error {
 	*result = common.Conf{}
@@ -187,6 +584,9 @@ func (self *dhashServer) Configuration(x int, result *common.Conf) error {
 	return nil
 }
 func (self *dhashServer) SubConfiguration(key []byte, result *common.Conf) error {
+	if successor := (*Node)(self).node.GetSuccessorFor(key); successor.Addr != (*Node)(self).GetBroadcastAddr() {
+		return successor.Call("DHash.SubConfiguration", key, result)
+	}
 	*result = common.Conf{TreeKey: key}
 	(*result).Data, (*result).Timestamp = (*Node)(self).tree.SubConfiguration(key)
 	return nil
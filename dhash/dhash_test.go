@@ -58,7 +58,7 @@ func testSync(t *testing.T, dhashes []*Node) {
 	dhashes[0].tree.Put([]byte{3}, []byte{0}, 1)
 	common.AssertWithin(t, func() (string, bool) {
 		having := countHaving(t, dhashes, []byte{3}, []byte{0})
-		return fmt.Sprint(having), having == common.Redundancy
+		return fmt.Sprint(having), having == common.GetRedundancy()
 	}, time.Second*10)
 }
 
@@ -68,7 +68,7 @@ func testClean(t *testing.T, dhashes []*Node) {
 	}
 	common.AssertWithin(t, func() (string, bool) {
 		having := countHaving(t, dhashes, []byte{1}, []byte{1})
-		return fmt.Sprint(having), having == common.Redundancy
+		return fmt.Sprint(having), having == common.GetRedundancy()
 	}, time.Second*20)
 }
 
@@ -82,7 +82,7 @@ func testPut(t *testing.T, dhashes []*Node) {
 			count := countHaving(t, dhashes, []byte{byte(index + 100)}, []byte{byte(index + 100)})
 			haves[count] = true
 		}
-		return fmt.Sprint(haves), len(haves) == 1 && haves[common.Redundancy] == true
+		return fmt.Sprint(haves), len(haves) == 1 && haves[common.GetRedundancy()] == true
 	}, time.Second*10)
 }
 
@@ -107,7 +107,7 @@ func testMigrate(t *testing.T, dhashes []*Node) {
 		for _, d := range ordered {
 			sum += d.Owned()
 			fmt.Fprintf(status, "%v %v %v\n", d.node.GetBroadcastAddr(), common.HexEncode(d.node.GetPosition()), d.Owned())
-			if float64(lastOwned)/float64(d.Owned()) > migrateHysteresis {
+			if float64(lastOwned)/float64(d.Owned()) > d.MigrateHysteresis() {
 				ok = false
 			}
 			if d.Owned() == 0 {
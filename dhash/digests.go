@@ -0,0 +1,106 @@
+package dhash
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	digestSamples = 8
+)
+
+// Digest is a summary of the changes to keys with a given prefix that a dhash.Node received as primary owner during a period.
+type Digest struct {
+	Prefix       []byte
+	From         time.Time
+	To           time.Time
+	Count        int
+	SampleKeys   [][]byte
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+// DigestListener is a function listening for periodic digests of changes to keys with a given prefix.
+type DigestListener func(digest Digest) (keep bool)
+
+type digester struct {
+	lock    *sync.Mutex
+	node    *Node
+	prefix  []byte
+	current Digest
+	stopped bool
+}
+
+func (self *digester) record(operation string, data common.Item) (keep bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		return false
+	}
+	if !bytes.HasPrefix(data.Key, self.prefix) {
+		return true
+	}
+	self.current.Count++
+	if len(self.current.SampleKeys) < digestSamples {
+		self.current.SampleKeys = append(self.current.SampleKeys, data.Key)
+	} else if i := rand.Intn(self.current.Count); i < digestSamples {
+		self.current.SampleKeys[i] = data.Key
+	}
+	if self.current.MinTimestamp == 0 || data.Timestamp < self.current.MinTimestamp {
+		self.current.MinTimestamp = data.Timestamp
+	}
+	if data.Timestamp > self.current.MaxTimestamp {
+		self.current.MaxTimestamp = data.Timestamp
+	}
+	return true
+}
+
+func (self *digester) swap() (result Digest) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	result = self.current
+	result.To = self.node.Time()
+	self.current = Digest{
+		Prefix: self.prefix,
+		From:   result.To,
+	}
+	return
+}
+
+func (self *digester) stop() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stopped = true
+}
+
+func (self *digester) run(interval time.Duration, l DigestListener) {
+	defer self.stop()
+	for !self.node.hasState(stopped) {
+		time.Sleep(interval)
+		if digest := self.swap(); digest.Count > 0 {
+			if !l(digest) {
+				return
+			}
+		}
+	}
+}
+
+// AddDigestListener will make l receive a Digest of the changes to keys with prefix every interval, instead of being notified about every single change.
+// Only changes that this dhash.Node receives as primary owner of the changed keys are included, and intervals without changes are skipped.
+func (self *Node) AddDigestListener(prefix []byte, interval time.Duration, l DigestListener) {
+	d := &digester{
+		lock:   new(sync.Mutex),
+		node:   self,
+		prefix: prefix,
+		current: Digest{
+			Prefix: prefix,
+			From:   self.Time(),
+		},
+	}
+	self.addMutationListener(d.record)
+	go d.run(interval, l)
+}
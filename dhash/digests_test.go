@@ -0,0 +1,31 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestDigestListener(t *testing.T) {
+	node := NewNode("127.0.0.1:10291", "127.0.0.1:10291")
+	digests := make(chan Digest, 10)
+	node.AddDigestListener([]byte("a/"), time.Millisecond*50, func(digest Digest) bool {
+		digests <- digest
+		return false
+	})
+	for i := 0; i < 20; i++ {
+		node.triggerMutationListeners("Put", common.Item{Key: []byte(fmt.Sprintf("a/%v", i)), Timestamp: int64(i + 1)})
+		node.triggerMutationListeners("Put", common.Item{Key: []byte(fmt.Sprintf("b/%v", i)), Timestamp: int64(i + 1)})
+	}
+	digest := <-digests
+	if digest.Count != 20 || len(digest.SampleKeys) != digestSamples || digest.MinTimestamp != 1 || digest.MaxTimestamp != 20 {
+		t.Errorf("wrong digest %+v", digest)
+	}
+	time.Sleep(time.Millisecond * 10)
+	node.triggerMutationListeners("Put", common.Item{Key: []byte("a/x")})
+	if n := node.mutationListeners.Len(); n != 0 {
+		t.Errorf("wanted the listener to be removed, but %v remain", n)
+	}
+}
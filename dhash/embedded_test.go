@@ -0,0 +1,28 @@
+package dhash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestEmbedded(t *testing.T) {
+	node := NewEmbeddedNode("test", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "test")
+	conn.SPut([]byte("k"), []byte("v"))
+	if value, existed := conn.Get([]byte("k")); !existed || bytes.Compare(value, []byte("v")) != 0 {
+		t.Errorf("wanted v under k, got %v, %v", value, existed)
+	}
+	conn.SSubPut([]byte("tree"), []byte("b"), []byte("2"))
+	conn.SSubPut([]byte("tree"), []byte("a"), []byte("1"))
+	if items := conn.Slice([]byte("tree"), nil, nil, true, true); len(items) != 2 || bytes.Compare(items[0].Key, []byte("a")) != 0 {
+		t.Errorf("wanted a and b in tree, got %v", items)
+	}
+	if err := NewEmbeddedNode("test", "").Start(); err == nil {
+		t.Errorf("starting two nodes at the same embedded address should fail")
+	}
+}
@@ -0,0 +1,172 @@
+package dhash
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// maxEvents is how many events of each stream a Node keeps for its subscribers.
+	maxEvents = 1024
+	// maxEventsWait is the longest a Node lets a subscriber wait for events, to stay well within the call timeout.
+	maxEventsWait = time.Second * 5
+)
+
+// eventStream contains the latest events of one stream of a Node.
+type eventStream struct {
+	lock    *sync.Mutex
+	events  []common.Event
+	seq     int64
+	changed chan struct{}
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		lock:    new(sync.Mutex),
+		changed: make(chan struct{}),
+	}
+}
+
+// eventLog contains the latest events of a Node, and what it last knew about its ring to detect the changes worth reporting.
+type eventLog struct {
+	*eventStream
+	ring             common.Remotes
+	rejectedConns    int64
+	rejectedRequests int64
+	rejectedOps      int64
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		eventStream: newEventStream(),
+	}
+}
+
+func (self *Node) report(typ, addr, detail string) {
+	self.publish(common.Event{
+		Type:   typ,
+		Addr:   addr,
+		Detail: detail,
+	})
+}
+
+// reportExpired will report that the member subKey of the sub tree key, put at timestamp, was removed for being older than maxAge.
+// Expirations are published in their own stream, so that a wave of them doesn't push the other events out.
+func (self *Node) reportExpired(key, subKey []byte, timestamp int64, maxAge time.Duration) {
+	self.expiries.publish(self.GetBroadcastAddr(), common.Event{
+		Type:   common.EventExpired,
+		Key:    key,
+		SubKey: subKey,
+		Detail: fmt.Sprintf("put at %v, more than %v ago", time.Unix(0, timestamp), maxAge),
+	})
+}
+
+// publish will add event to the events of this Node, and wake up the subscribers waiting for it.
+func (self *Node) publish(event common.Event) {
+	self.events.publish(self.GetBroadcastAddr(), event)
+}
+
+// publish will add event, reported by the node at addr, to this stream, and wake up the subscribers waiting for it.
+func (self *eventStream) publish(addr string, event common.Event) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.seq++
+	event.Seq, event.Time, event.Node = self.seq, time.Now(), addr
+	self.events = append(self.events, event)
+	if len(self.events) > maxEvents {
+		self.events = append(self.events[:0:0], self.events[len(self.events)-maxEvents:]...)
+	}
+	close(self.changed)
+	self.changed = make(chan struct{})
+}
+
+// reportLeft will report the nodes that have left the ring since the last change, if this Node was the first of their successors to remain.
+func (self *Node) reportLeft(ring *common.Ring) {
+	log := self.events
+	log.lock.Lock()
+	old := log.ring
+	nodes := ring.Nodes()
+	log.ring = nodes
+	log.lock.Unlock()
+	remaining := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		remaining[node.Addr] = true
+	}
+	me := self.GetBroadcastAddr()
+	for index, node := range old {
+		if !remaining[node.Addr] {
+			for next := 1; next < len(old); next++ {
+				if succ := old[(index+next)%len(old)]; remaining[succ.Addr] {
+					if succ.Addr == me {
+						self.report(common.EventLeft, node.Addr, "")
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+// reportOverload will report the connections, requests and expensive operations this Node has rejected since it last checked.
+func (self *Node) reportOverload() {
+	conns, requests := self.node.Rejected()
+	ops := atomic.LoadInt64(&self.rejectedOps)
+	log := self.events
+	log.lock.Lock()
+	newConns, newRequests, newOps := conns-log.rejectedConns, requests-log.rejectedRequests, ops-log.rejectedOps
+	log.rejectedConns, log.rejectedRequests, log.rejectedOps = conns, requests, ops
+	log.lock.Unlock()
+	if newConns > 0 || newRequests > 0 || newOps > 0 {
+		self.report(common.EventOverloaded, "", fmt.Sprintf("rejected %v connections, %v requests and %v expensive operations", newConns, newRequests, newOps))
+	}
+}
+
+// Events returns the events this Node reported after since, waiting at most wait for one if there are none.
+// A negative since returns no events, only the sequence number of the last event.
+func (self *Node) Events(since int64, wait time.Duration) common.Events {
+	return self.events.since(since, wait)
+}
+
+// Expiries returns the common.EventExpired events this Node reported after since, waiting at most wait for one if there are none.
+// They have their own sequence numbers, and are not returned by Events.
+func (self *Node) Expiries(since int64, wait time.Duration) common.Events {
+	return self.expiries.since(since, wait)
+}
+
+// since returns the events of this stream after since, waiting at most wait for one if there are none.
+func (self *eventStream) since(since int64, wait time.Duration) (result common.Events) {
+	if wait > maxEventsWait {
+		wait = maxEventsWait
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		self.lock.Lock()
+		result.Seq = self.seq
+		if since < 0 {
+			self.lock.Unlock()
+			return
+		}
+		if len(self.events) > 0 && since+1 < self.events[0].Seq {
+			result.Missed = self.events[0].Seq - since - 1
+		}
+		for _, event := range self.events {
+			if event.Seq > since {
+				result.Events = append(result.Events, event)
+			}
+		}
+		changed := self.changed
+		self.lock.Unlock()
+		left := deadline.Sub(time.Now())
+		if len(result.Events) > 0 || left <= 0 {
+			return
+		}
+		select {
+		case <-changed:
+		case <-time.After(left):
+		}
+	}
+}
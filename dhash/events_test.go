@@ -0,0 +1,114 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func hasEvent(events []common.Event, typ, addr string) bool {
+	for _, event := range events {
+		if event.Type == typ && event.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEvents(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:14491", "127.0.0.1:14491", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:14591", "127.0.0.1:14591", "")
+	node2.MustStart()
+	conn := client.MustConn("127.0.0.1:14491")
+	received := make(chan common.Event, 16)
+	conn.Subscribe(func(event common.Event) bool {
+		received <- event
+		return event.Type != common.EventMigrationResumed
+	})
+	node1.PauseMigration()
+	node1.PauseMigration()
+	node1.Freeze(common.Range{Min: []byte("a"), Max: []byte("b"), MinInc: true})
+	node1.Unfreeze(common.Range{Min: []byte("a"), Max: []byte("b"), MinInc: true})
+	node1.ResumeMigration()
+	events := node1.Events(0, 0)
+	var types []string
+	for _, event := range events.Events {
+		types = append(types, event.Type)
+	}
+	if fmt.Sprint(types) != fmt.Sprint([]string{common.EventMigrationPaused, common.EventFrozen, common.EventUnfrozen, common.EventMigrationResumed}) {
+		t.Errorf("wanted paused, frozen, unfrozen and resumed migration, got %v", events)
+	}
+	if events = node1.Events(events.Seq, time.Millisecond*10); len(events.Events) != 0 {
+		t.Errorf("wanted no new events, got %v", events)
+	}
+	for _, typ := range types {
+		select {
+		case event := <-received:
+			if event.Type != typ || event.Node != node1.GetBroadcastAddr() {
+				t.Errorf("wanted %v from %v, got %v", typ, node1.GetBroadcastAddr(), event)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("wanted %v to be delivered", typ)
+		}
+	}
+	node2.MustJoin("127.0.0.1:14491")
+	if events = node2.Events(0, 0); !hasEvent(events.Events, common.EventJoined, "") {
+		t.Errorf("wanted %v to report joining, got %v", node2, events)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2
+	}, time.Second*10)
+	node2.Stop()
+	common.AssertWithin(t, func() (string, bool) {
+		events := node1.Events(0, 0)
+		return fmt.Sprint(events), hasEvent(events.Events, common.EventLeft, "127.0.0.1:14591")
+	}, time.Second*10)
+}
+
+func TestExpiries(t *testing.T) {
+	node := NewEmbeddedNode("expiries", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "expiries")
+	node.PauseMigration()
+	received := make(chan common.Event)
+	published := make(chan struct{})
+	conn.SubscribeExpiries(func(event common.Event) bool {
+		received <- event
+		// keep the subscription from asking for more until the node has dropped some expirations
+		<-published
+		return event.Type != common.EventMissed
+	})
+	node.reportExpired([]byte("a"), []byte("b"), 0, time.Second)
+	select {
+	case event := <-received:
+		if event.Type != common.EventExpired || string(event.Key) != "a" {
+			t.Errorf("wanted the expiration of a/b, got %v", event)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("wanted the expiration to be delivered")
+	}
+	for i := 0; i < maxEvents+10; i++ {
+		node.reportExpired([]byte("a"), []byte(fmt.Sprint(i)), 0, time.Second)
+	}
+	close(published)
+	select {
+	case event := <-received:
+		if event.Type != common.EventMissed || event.Detail != "10" {
+			t.Errorf("wanted 10 missed expirations, got %v", event)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("wanted the missed expirations to be delivered")
+	}
+	if events := node.Expiries(0, 0); events.Missed != 11 || len(events.Events) != maxEvents {
+		t.Errorf("wanted the 11 first expirations to be dropped, got %v missed", events.Missed)
+	}
+	if events := node.Events(0, 0); len(events.Events) != 1 || events.Events[0].Type != common.EventMigrationPaused {
+		t.Errorf("wanted the expirations not to push out the other events, got %v", events)
+	}
+}
@@ -0,0 +1,66 @@
+package dhash
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+// SetForensics will make this Node keep the last n divergent values its syncs find and copy between it and its replicas, with their timestamps, hashes and nodes,
+// to let users debugging lost updates see which replica diverged and when. Zero, the default, turns off the recording.
+func (self *Node) SetForensics(n int) *Node {
+	atomic.StoreInt64(&self.forensics, int64(n))
+	self.forensicsLock.Lock()
+	defer self.forensicsLock.Unlock()
+	if len(self.divergent) > n {
+		self.divergent = append(self.divergent[:0:0], self.divergent[len(self.divergent)-n:]...)
+	}
+	return self
+}
+
+// Forensics returns how many divergent values this Node keeps.
+func (self *Node) Forensics() int {
+	return int(atomic.LoadInt64(&self.forensics))
+}
+
+// Divergences returns the divergent values this Node has kept, oldest first. If key is not nil, only the values of key, or within the sub tree of key, are returned.
+func (self *Node) Divergences(key []byte) (result []common.Divergence) {
+	self.forensicsLock.Lock()
+	defer self.forensicsLock.Unlock()
+	for _, divergence := range self.divergent {
+		if key == nil || bytes.Compare(key, divergence.Key) == 0 {
+			result = append(result, divergence)
+		}
+	}
+	return
+}
+
+// divergenceRecorder returns a function recording the values a sync copies from source to destination, or nil if forensics are turned off.
+func (self *Node) divergenceRecorder(source, destination string) func(radix.Divergence) {
+	if self.Forensics() == 0 {
+		return nil
+	}
+	return func(d radix.Divergence) {
+		n := self.Forensics()
+		self.forensicsLock.Lock()
+		defer self.forensicsLock.Unlock()
+		self.divergent = append(self.divergent, common.Divergence{
+			Time:                 time.Now(),
+			Source:               source,
+			Destination:          destination,
+			Key:                  d.Key,
+			SubKey:               d.SubKey,
+			SourceTimestamp:      d.SourceTimestamp,
+			SourceHash:           d.SourceHash,
+			DestinationTimestamp: d.DestinationTimestamp,
+			DestinationHash:      d.DestinationHash,
+			Deleted:              d.Deleted,
+		})
+		if len(self.divergent) > n {
+			self.divergent = append(self.divergent[:0:0], self.divergent[len(self.divergent)-n:]...)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func TestForensics(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:14691", "127.0.0.1:14691", "").SetForensics(10)
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:14791", "127.0.0.1:14791", "").SetForensics(10)
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:14691")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2 && len(node2.node.GetNodes()) == 2
+	}, time.Second*10)
+	node1.PauseSync()
+	node2.PauseSync()
+	node1.tree.Put([]byte("k"), []byte("old"), 1)
+	node2.tree.Put([]byte("k"), []byte("new"), 2)
+	node1.Sync()
+	node2.Sync()
+	conn := client.MustConn("127.0.0.1:14691")
+	result, err := conn.Divergences([]byte("k"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var found []common.Divergence
+	for _, divergences := range result {
+		found = append(found, divergences...)
+	}
+	if len(found) != 1 {
+		t.Fatalf("wanted one divergence of k, got %v", found)
+	}
+	d := found[0]
+	if d.Source != "127.0.0.1:14791" || d.Destination != "127.0.0.1:14691" || d.SourceTimestamp != 2 || d.DestinationTimestamp != 1 ||
+		string(d.SourceHash) != string(murmur.HashBytes([]byte("new"))) || string(d.DestinationHash) != string(murmur.HashBytes([]byte("old"))) {
+		t.Errorf("wanted the new value of k to be copied from 14791 to 14691, got %v", d)
+	}
+	node1.SetForensics(0)
+	if divergences := node1.Divergences(nil); len(divergences) != 0 {
+		t.Errorf("wanted no divergences to be kept, got %v", divergences)
+	}
+}
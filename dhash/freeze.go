@@ -0,0 +1,121 @@
+package dhash
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func describeRange(r common.Range) string {
+	left, right := "(", ")"
+	if r.MinInc {
+		left = "["
+	}
+	if r.MaxInc {
+		right = "]"
+	}
+	return fmt.Sprintf("%v%v, %v%v", left, common.HexEncode(r.Min), common.HexEncode(r.Max), right)
+}
+
+// frozenConf returns the name of the cluster configuration entry freezing r.
+func frozenConf(r common.Range) string {
+	left, right := "(", ")"
+	if r.MinInc {
+		left = "["
+	}
+	if r.MaxInc {
+		right = "]"
+	}
+	return fmt.Sprintf("%v%v%v,%v%v", common.FrozenPrefixConf, left, hex.EncodeToString(r.Min), hex.EncodeToString(r.Max), right)
+}
+
+// parseFrozenConf returns the range frozen by the cluster configuration entry name, if it is one.
+func parseFrozenConf(name string) (result common.Range, ok bool) {
+	if !strings.HasPrefix(name, common.FrozenPrefixConf) {
+		return
+	}
+	desc := name[len(common.FrozenPrefixConf):]
+	if len(desc) < 3 {
+		return
+	}
+	parts := strings.Split(desc[1:len(desc)-1], ",")
+	if len(parts) != 2 {
+		return
+	}
+	var err error
+	if result.Min, err = hex.DecodeString(parts[0]); err != nil {
+		return
+	}
+	if result.Max, err = hex.DecodeString(parts[1]); err != nil {
+		return
+	}
+	result.MinInc, result.MaxInc = desc[0] == '[', desc[len(desc)-1] == ']'
+	return result, true
+}
+
+// Freeze will make this Node reject writes to keys within the Min and Max of r with common.ErrFrozen until Unfreeze is called with the same range.
+// Use it to keep a range stable during application migrations or repairs.
+// The range is stored in the cluster configuration, so it survives restarts and reaches the other nodes when they synchronize.
+func (self *Node) Freeze(r common.Range) {
+	name := frozenConf(r)
+	self.lock.Lock()
+	changed := self.tree.AddConfiguration(self.timer.ContinuousTime(), name, "yes")
+	self.lock.Unlock()
+	if changed {
+		self.report(common.EventFrozen, "", describeRange(r))
+	}
+}
+
+// Unfreeze will let this Node accept writes to keys within r again after Freeze.
+func (self *Node) Unfreeze(r common.Range) {
+	name := frozenConf(r)
+	self.lock.Lock()
+	conf, _ := self.tree.Configuration()
+	_, found := conf[name]
+	if found {
+		delete(conf, name)
+		self.tree.Configure(conf, self.timer.ContinuousTime())
+	}
+	self.lock.Unlock()
+	if found {
+		self.report(common.EventUnfrozen, "", describeRange(r))
+	}
+}
+
+// Frozen returns the ranges this Node rejects writes to.
+func (self *Node) Frozen() (result []common.Range) {
+	conf, _ := self.tree.Configuration()
+	for name, value := range conf {
+		if r, ok := parseFrozenConf(name); ok && value == "yes" {
+			result = append(result, r)
+		}
+	}
+	return
+}
+func (self *Node) assertNotFrozen(key []byte) error {
+	for _, frozen := range self.Frozen() {
+		if frozen.Within(key) {
+			return common.ErrFrozen
+		}
+	}
+	return nil
+}
+
+// assertSnapshotNotFrozen will return common.ErrFrozen if any entry of snapshot is in a frozen range.
+func (self *Node) assertSnapshotNotFrozen(snapshot []radix.SnapshotEntry) error {
+	frozen := self.Frozen()
+	if len(frozen) == 0 {
+		return nil
+	}
+	for _, entry := range snapshot {
+		for _, r := range frozen {
+			if r.Within(entry.Key) {
+				return common.ErrFrozen
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package dhash
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func TestFreeze(t *testing.T) {
+	node := NewEmbeddedNode("freeze", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "freeze")
+	if err := conn.Freeze([]byte("b"), []byte("d")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := conn.TryPut([]byte("c"), []byte("1")); !common.IsFrozen(err) {
+		t.Errorf("wanted %v when writing a frozen key, got %v", common.ErrFrozen, err)
+	}
+	if err := conn.TrySubPut([]byte("b"), []byte("x"), []byte("1")); !common.IsFrozen(err) {
+		t.Errorf("wanted %v when writing the sub tree of a frozen key, got %v", common.ErrFrozen, err)
+	}
+	if err := conn.TryPut([]byte("d"), []byte("1")); err != nil {
+		t.Errorf("the end of a frozen range should not be frozen, got %v", err)
+	}
+	if ranges, err := conn.Frozen(); err != nil || len(ranges) != 1 {
+		t.Errorf("wanted 1 frozen range, got %v, %v", ranges, err)
+	}
+	var lease common.Lease
+	if err := node.Lock(common.Lease{Key: []byte("c"), Duration: time.Minute}, &lease); !common.IsFrozen(err) {
+		t.Errorf("wanted %v when locking a frozen key, got %v", common.ErrFrozen, err)
+	}
+	snapshot, err := radix.EncodeSnapshot([]radix.SnapshotEntry{{Key: []byte("c"), Value: []byte("2"), Timestamp: 1, Present: true}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := node.Restore(snapshot); !common.IsFrozen(err) {
+		t.Errorf("wanted %v when restoring a frozen key, got %v", common.ErrFrozen, err)
+	}
+	if conf := conn.Configuration(); conf["frozen:[62,64)"] != "yes" {
+		t.Errorf("wanted the frozen range in the cluster configuration, got %v", conf)
+	}
+	if err := conn.Unfreeze([]byte("b"), []byte("d")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := conn.TryPut([]byte("c"), []byte("1")); err != nil {
+		t.Errorf("unfrozen keys should be writable, got %v", err)
+	}
+}
+
+func TestFreezeRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "god_freeze")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	node := NewNodeDir("127.0.0.1:16791", "127.0.0.1:16791", dir)
+	node.MustStart()
+	node.Freeze(common.Range{Min: []byte("b"), Max: []byte("d"), MinInc: true})
+	// Let the logger write the configuration.
+	time.Sleep(time.Millisecond * 100)
+	node.Stop()
+	restarted := NewNodeDir("127.0.0.1:16891", "127.0.0.1:16891", dir)
+	restarted.MustStart()
+	defer restarted.Stop()
+	if err := restarted.Put(common.Item{Key: []byte("c"), Value: []byte("1")}); !common.IsFrozen(err) {
+		t.Errorf("wanted the range to stay frozen after a restart, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+// eachSubConfigurationBetween will call f with the key and configuration of every configured sub tree from min, inclusive, to max, exclusive, considering the namespace circular.
+func (self *Node) eachSubConfigurationBetween(min, max []byte, f func(key []byte, conf map[string]string) bool) {
+	if bytes.Compare(min, max) < 0 {
+		self.tree.EachSubConfigurationBetween(min, max, true, false, f)
+	} else {
+		self.tree.EachSubConfigurationBetween(min, nil, true, false, f)
+		self.tree.EachSubConfigurationBetween(nil, max, true, false, f)
+	}
+}
+
+// eachEntryBetween will call f with each key having a byte value, sub tree or both from min, inclusive, to max, exclusive, considering the namespace circular.
+func (self *Node) eachEntryBetween(min, max []byte, f radix.EntryIterator) {
+	if bytes.Compare(min, max) < 0 {
+		self.tree.EachEntryBetween(min, max, true, false, f)
+	} else {
+		self.tree.EachEntryBetween(min, nil, true, false, f)
+		self.tree.EachEntryBetween(nil, max, true, false, f)
+	}
+}
+
+// ReferencedChunks will return the keys in chunks referred to by any manifest, or chunked value, held by this Node, owned or not.
+func (self *Node) ReferencedChunks(chunks [][]byte) (result [][]byte) {
+	wanted := make(map[string]bool)
+	for _, chunk := range chunks {
+		wanted[string(chunk)] = true
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	var manifests [][]byte
+	var chunked [][]byte
+	self.tree.EachSubConfigurationBetween(nil, nil, true, true, func(key []byte, conf map[string]string) bool {
+		if conf[common.ManifestConf] == "yes" {
+			manifests = append(manifests, key)
+		}
+		if conf[common.ChunkedConf] != "" {
+			chunked = append(chunked, key)
+		}
+		return true
+	})
+	for _, key := range chunked {
+		value, _, _ := self.tree.Get(key)
+		chunks, _ := self.manifestChunks(key, value)
+		for _, chunk := range chunks {
+			if wanted[string(chunk)] {
+				delete(wanted, string(chunk))
+				result = append(result, chunk)
+			}
+		}
+	}
+	for _, manifest := range manifests {
+		self.tree.SubEachBetween(manifest, nil, nil, true, true, func(key, value []byte, timestamp int64) bool {
+			if wanted[string(value)] {
+				delete(wanted, string(value))
+				result = append(result, value)
+			}
+			return len(wanted) > 0
+		})
+	}
+	return
+}
+
+// unreferencedSince returns the cluster time when garbage collection first found the chunk under key unreferenced, or zero if it hasn't.
+func (self *Node) unreferencedSince(key []byte) int64 {
+	conf, _ := self.tree.SubConfiguration(key)
+	since, _ := strconv.ParseInt(conf[common.UnreferencedConf], 10, 64)
+	return since
+}
+
+// setUnreferencedSince will record that the chunk under key has been unreferenced since the cluster time since, or forget it if since is zero.
+func (self *Node) setUnreferencedSince(key []byte, since int64) error {
+	value := ""
+	if since != 0 {
+		value = fmt.Sprint(since)
+	}
+	if self.unreferencedSince(key) == since {
+		return nil
+	}
+	return self.SubAddConfiguration(common.ConfItem{
+		TreeKey: key,
+		Key:     common.UnreferencedConf,
+		Value:   value,
+	})
+}
+
+// ringConverged returns whether every node in the ring of this Node agrees with it about the ring.
+func (self *Node) ringConverged() bool {
+	mine := self.node.RingHash()
+	for _, node := range self.node.GetNodes() {
+		var hash []byte
+		if err := node.Call("DHash.RingHash", 0, &hash); err != nil || bytes.Compare(hash, mine) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CollectGarbage will remove the chunks owned by this Node that no manifest held by any Node has referred to for the grace period.
+// Since it can't know which manifests nodes missing from the ring hold, it does nothing while the nodes disagree about the ring.
+// The first time it finds a chunk unreferenced it records the time in the configuration of the chunk, and it removes the chunk when it finds it
+// still unreferenced longer than the grace period after that. It returns the number of removed chunks.
+func (self *Node) CollectGarbage() (removed int) {
+	now := self.timer.ContinuousTime()
+	deadline := now - int64(self.GCGracePeriod())
+	var candidates [][]byte
+	self.eachSubConfigurationBetween(self.node.GetPredecessor().Pos, self.node.GetPosition(), func(key []byte, conf map[string]string) bool {
+		if conf[common.ChunkConf] == "yes" {
+			candidates = append(candidates, key)
+		}
+		return true
+	})
+	var orphans [][]byte
+	for _, key := range candidates {
+		if _, _, existed := self.tree.Get(key); existed && self.contentRefs(key) == 0 {
+			orphans = append(orphans, key)
+		}
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	if !self.ringConverged() {
+		return
+	}
+	referenced := make(map[string]bool)
+	for _, node := range self.node.GetNodes() {
+		var found [][]byte
+		// If we can't ask every node, we can't know that the chunks are garbage.
+		if err := node.Call("DHash.ReferencedChunks", orphans, &found); err != nil {
+			return
+		}
+		for _, key := range found {
+			referenced[string(key)] = true
+		}
+	}
+	self.contentLock.Lock()
+	defer self.contentLock.Unlock()
+	for _, key := range orphans {
+		if referenced[string(key)] {
+			self.setUnreferencedSince(key, 0)
+			continue
+		}
+		// The chunk may have been put again, which forgets when it became unreferenced, while we asked the other nodes.
+		since := self.unreferencedSince(key)
+		if since == 0 {
+			self.setUnreferencedSince(key, now)
+		} else if since < deadline {
+			if err := self.Del(common.Item{Key: key, Sync: true, Override: true, QoS: common.Batch}); err == nil {
+				removed++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package dhash
+
+import (
+	"testing"
+
+	"github.com/zond/god/common"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:12291", "127.0.0.1:12291", "")
+	node.SetGCGracePeriod(0)
+	node.MustStart()
+	defer node.Stop()
+	referenced, err := node.PutChunk(common.Item{Value: []byte("referenced")})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	orphan, err := node.PutChunk(common.Item{Value: []byte("orphan")})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	content, err := node.PutContent(common.Item{Value: []byte("content")})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	node.SubPut(common.Item{Key: []byte("manifest"), SubKey: []byte{0}, Value: referenced})
+	node.SubAddConfiguration(common.ConfItem{TreeKey: []byte("manifest"), Key: common.ManifestConf, Value: "yes"})
+	if removed := node.CollectGarbage(); removed != 0 {
+		t.Errorf("wanted no removed chunks before the orphan has been unreferenced for the grace period, got %v", removed)
+	}
+	if node.unreferencedSince(orphan) == 0 || node.unreferencedSince(referenced) != 0 {
+		t.Errorf("wanted only the orphan recorded as unreferenced")
+	}
+	if removed := node.CollectGarbage(); removed != 1 {
+		t.Errorf("wanted 1 removed chunk, got %v", removed)
+	}
+	for key, wanted := range map[string]bool{string(referenced): true, string(orphan): false, string(content): true} {
+		if _, _, existed := node.tree.Get([]byte(key)); existed != wanted {
+			t.Errorf("%v: wanted existence %v, got %v", common.HexEncode([]byte(key)), wanted, existed)
+		}
+	}
+	node.SubDel(common.Item{Key: []byte("manifest"), SubKey: []byte{0}})
+	node.CollectGarbage()
+	if _, err := node.PutChunk(common.Item{Value: []byte("referenced")}); err != nil || node.unreferencedSince(referenced) != 0 {
+		t.Errorf("wanted putting the chunk again to forget when it became unreferenced, got %v", err)
+	}
+	if removed := node.CollectGarbage(); removed != 0 {
+		t.Errorf("wanted no removed chunks right after putting the chunk again, got %v", removed)
+	}
+	if removed := node.CollectGarbage(); removed != 1 {
+		t.Errorf("wanted 1 removed chunk after removing the reference, got %v", removed)
+	}
+}
@@ -14,71 +14,140 @@ type HashTreeItem struct {
 	Expected  int64
 	Value     []byte
 	Exists    bool
+	// Compressed is set when Value is compressed on the wire.
+	Compressed bool
+}
+
+// Compress returns a copy of this HashTreeItem with the value compressed if it is longer than threshold.
+func (self HashTreeItem) Compress(threshold int) interface{} {
+	if !self.Compressed {
+		self.Value, self.Compressed = common.Compress(self.Value, threshold)
+	}
+	return self
+}
+
+// Decompress will decompress the value of this HashTreeItem if it is compressed.
+func (self *HashTreeItem) Decompress() (err error) {
+	if self.Compressed {
+		if self.Value, err = common.Decompress(self.Value, true); err == nil {
+			self.Compressed = false
+		}
+	}
+	return
 }
 
 type hashTreeServer Node
 
 func (self *hashTreeServer) Configure(conf common.Conf, x *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	(*Node)(self).tree.Configure(conf.Data, conf.Timestamp)
+	(*Node)(self).applyConfiguredRedundancy()
 	return nil
 }
 func (self *hashTreeServer) SubConfigure(conf common.Conf, x *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	(*Node)(self).tree.SubConfigure(conf.TreeKey, conf.Data, conf.Timestamp)
 	return nil
 }
 func (self *hashTreeServer) Hash(x int, result *[]byte) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	*result = (*Node)(self).tree.Hash()
 	return nil
 }
 func (self *hashTreeServer) Finger(key []radix.Nibble, result *radix.Print) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	*result = *((*Node)(self).tree.Finger(key))
 	return nil
 }
+func (self *hashTreeServer) Fingers(keys [][]radix.Nibble, result *[]*radix.Print) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result = (*Node)(self).tree.Fingers(keys)
+	return nil
+}
 func (self *hashTreeServer) GetTimestamp(key []radix.Nibble, result *HashTreeItem) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*result = HashTreeItem{Key: key}
 	result.Value, result.Timestamp, result.Exists = (*Node)(self).tree.GetTimestamp(key)
 	return nil
 }
 func (self *hashTreeServer) PutTimestamp(data HashTreeItem, changed *bool) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.PutTimestamp(data.Key, data.Value, data.Exists, data.Expected, data.Timestamp)
 	return nil
 }
 func (self *hashTreeServer) DelTimestamp(data HashTreeItem, changed *bool) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.DelTimestamp(data.Key, data.Expected)
 	return nil
 }
 func (self *hashTreeServer) SubFinger(data HashTreeItem, result *radix.Print) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	*result = *((*Node)(self).tree.SubFinger(data.Key, data.SubKey))
 	return nil
 }
 func (self *hashTreeServer) SubGetTimestamp(data HashTreeItem, result *HashTreeItem) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*result = data
 	result.Value, result.Timestamp, result.Exists = (*Node)(self).tree.SubGetTimestamp(data.Key, data.SubKey)
 	return nil
 }
 func (self *hashTreeServer) SubPutTimestamp(data HashTreeItem, changed *bool) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.SubPutTimestamp(data.Key, data.SubKey, data.Value, data.Exists, data.Expected, data.Timestamp)
 	return nil
 }
 func (self *hashTreeServer) SubDelTimestamp(data HashTreeItem, changed *bool) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.SubDelTimestamp(data.Key, data.SubKey, data.Expected)
 	return nil
 }
 func (self *hashTreeServer) SubClearTimestamp(data HashTreeItem, changed *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.SubClearTimestamp(data.Key, data.Expected, data.Timestamp)
 	return nil
 }
 func (self *hashTreeServer) SubKillTimestamp(data HashTreeItem, changed *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
 	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
 	*changed = (*Node)(self).tree.SubKillTimestamp(data.Key, data.Expected)
 	return nil
 }
+func (self *hashTreeServer) RealSize(r common.Range, result *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result = (*Node)(self).circularRealSize(r)
+	return nil
+}
+func (self *hashTreeServer) SnapshotChunks(r common.Range, result *SnapshotChunks) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result = (*Node)(self).snapshotChunks(r)
+	return nil
+}
+func (self *hashTreeServer) SnapshotHashes(chunks SnapshotChunks, result *[][]byte) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result = (*Node)(self).snapshotHashes(chunks)
+	return nil
+}
+func (self *hashTreeServer) Snapshot(r common.Range, result *[]byte) (err error) {
+	defer (*Node)(self).schedule(common.Batch)()
+	*result, err = radix.EncodeSnapshot((*Node)(self).circularSnapshot(r))
+	return
+}
+func (self *hashTreeServer) ApplySnapshot(encoded []byte, changed *int) error {
+	defer (*Node)(self).schedule(common.Batch)()
+	atomic.StoreInt64(&(*Node)(self).lastSync, time.Now().UnixNano())
+	snapshot, err := radix.DecodeSnapshot(encoded)
+	if err != nil {
+		return err
+	}
+	*changed = (*Node)(self).tree.ApplySnapshot(snapshot)
+	return nil
+}
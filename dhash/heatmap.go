@@ -0,0 +1,54 @@
+package dhash
+
+import (
+	"bytes"
+
+	"github.com/zond/god/common"
+)
+
+// OwnedHeatmap returns a Heatmap with n buckets of the keys owned by this Node.
+func (self *Node) OwnedHeatmap(n int) (result common.Heatmap) {
+	result = common.NewHeatmap(n)
+	record := func(key []byte, bytes int) bool {
+		result.Record(key, bytes)
+		return true
+	}
+	pred, me := self.node.GetPredecessor().Pos, self.node.GetPosition()
+	if bytes.Compare(pred, me) < 0 {
+		self.tree.EachSizeBetween(pred, me, true, false, record)
+	} else {
+		self.tree.EachSizeBetween(pred, nil, true, false, record)
+		self.tree.EachSizeBetween(nil, me, true, false, record)
+	}
+	return
+}
+
+// Heatmap collects the OwnedHeatmap of all Nodes in the ring of this Node in parallel, and sums them up.
+func (self *Node) Heatmap(n int) (result common.Heatmap) {
+	result = common.NewHeatmap(n)
+	nodes := self.node.GetNodes()
+	heatmaps := make([]common.Heatmap, len(nodes))
+	errs := make([]error, len(nodes))
+	done := make(chan bool, len(nodes))
+	for index, node := range nodes {
+		go func(index int, node common.Remote) {
+			if node.Addr == self.GetBroadcastAddr() {
+				heatmaps[index] = self.OwnedHeatmap(n)
+			} else {
+				errs[index] = node.Call("DHash.OwnedHeatmap", n, &heatmaps[index])
+			}
+			done <- true
+		}(index, node)
+	}
+	for _, _ = range nodes {
+		<-done
+	}
+	for index, node := range nodes {
+		if errs[index] != nil {
+			result.Fail(node.Addr, errs[index])
+		} else {
+			result.Add(heatmaps[index])
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestHeatmap(t *testing.T) {
+	node := NewEmbeddedNode("heatmap", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "heatmap")
+	wantBytes := int64(0)
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i))
+		conn.Put(key, value)
+		wantBytes += int64(len(key) + len(value))
+	}
+	heatmap, err := conn.Heatmap(16)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(heatmap.Buckets) != 16 {
+		t.Errorf("wanted 16 buckets, got %v", len(heatmap.Buckets))
+	}
+	keys, bytes := 0, int64(0)
+	for _, bucket := range heatmap.Buckets {
+		keys += bucket.Keys
+		bytes += bucket.Bytes
+	}
+	if keys != 100 || bytes != wantBytes {
+		t.Errorf("wanted 100 keys and %v bytes, got %v and %v", wantBytes, keys, bytes)
+	}
+}
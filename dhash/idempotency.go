@@ -0,0 +1,165 @@
+package dhash
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// defaultReplayTTL is for how long a Node remembers the tokens of the writes it has applied by default.
+	defaultReplayTTL = time.Minute * 10
+	// defaultReplaySize is how many tokens a Node remembers by default.
+	defaultReplaySize = 1 << 20
+)
+
+// replayEntry is the token of a write in the replay window of a Node, and the result of the write once it is done.
+type replayEntry struct {
+	token    string
+	element  *list.Element
+	finished time.Time
+	done     chan struct{}
+	err      error
+}
+
+// replayWindow remembers the tokens of the writes a Node has applied, or is applying, so that writes retried or hedged with the same token are only applied once.
+// The entries of finished writes are kept in the order they finished in, so that the oldest are expired or evicted first. Since they all expire the ttl
+// after they finished, that is also the order they expire in, even after the ttl changes. The entries of writes in flight are never evicted, since forgetting them
+// would let a retry apply the write again while it is being applied.
+type replayWindow struct {
+	lock      *sync.Mutex
+	size      int
+	ttl       time.Duration
+	entries   map[string]*replayEntry
+	order     *list.List
+	replays   int64
+	evictions int64
+}
+
+func newReplayWindow() *replayWindow {
+	return &replayWindow{
+		lock:    new(sync.Mutex),
+		size:    defaultReplaySize,
+		ttl:     defaultReplayTTL,
+		entries: make(map[string]*replayEntry),
+		order:   list.New(),
+	}
+}
+
+func (self *replayWindow) remove(entry *replayEntry) {
+	if entry.element != nil {
+		self.order.Remove(entry.element)
+	}
+	delete(self.entries, entry.token)
+}
+
+func (self *replayWindow) expired(entry *replayEntry, now time.Time) bool {
+	return !entry.finished.IsZero() && entry.finished.Add(self.ttl).Before(now)
+}
+
+// trim will remove the expired entries, and the oldest entries of finished writes beyond the size of this replayWindow.
+func (self *replayWindow) trim(now time.Time) {
+	for element := self.order.Front(); element != nil; element = self.order.Front() {
+		entry := element.Value.(*replayEntry)
+		if self.expired(entry, now) {
+			self.remove(entry)
+		} else if len(self.entries) > self.size {
+			self.remove(entry)
+			self.evictions++
+		} else {
+			return
+		}
+	}
+}
+
+// SetReplayWindow will make this Node remember the tokens of the writes it has applied for ttl, but at most size tokens.
+// Writes with remembered tokens are not applied again, and writes with the same token as a write in flight wait for it and return its result.
+// When more than size tokens are remembered, the oldest tokens of finished writes are forgotten before their ttl, and counted as ReplayEvictions in the Stats of the Node.
+// Changing the ttl also changes when the already remembered tokens expire.
+func (self *Node) SetReplayWindow(size int, ttl time.Duration) *Node {
+	window := self.replays
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	window.size, window.ttl = size, ttl
+	window.trim(time.Now())
+	return self
+}
+
+// ReplayWindow returns how many tokens of applied writes this Node remembers, and for how long.
+func (self *Node) ReplayWindow() (size int, ttl time.Duration) {
+	window := self.replays
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	return window.size, window.ttl
+}
+
+// replayStats returns the number of tokens this Node remembers, the number of writes it didn't apply because their tokens were remembered,
+// and the number of tokens forgotten before their ttl to stay within the size of the replay window.
+func (self *Node) replayStats() (tokens int, replays, evictions int64) {
+	window := self.replays
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	return len(window.entries), window.replays, window.evictions
+}
+
+// apply will perform write unless this Node has applied, or is applying, a write with the token of data within the replay window, and remember the token until
+// the write is done, or for the ttl of the replay window if it succeeds. Writes with the token of a write in flight wait for it, and return its result.
+func (self *Node) apply(data common.Item, write func() error) (err error) {
+	if len(data.Token) == 0 {
+		return write()
+	}
+	window := self.replays
+	window.lock.Lock()
+	now := time.Now()
+	window.trim(now)
+	if entry, found := window.entries[string(data.Token)]; found {
+		window.replays++
+		window.lock.Unlock()
+		<-entry.done
+		return entry.err
+	}
+	entry := &replayEntry{
+		token: string(data.Token),
+		done:  make(chan struct{}),
+	}
+	window.entries[entry.token] = entry
+	window.trim(now)
+	window.lock.Unlock()
+	err = write()
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	entry.err, entry.finished = err, time.Now()
+	close(entry.done)
+	if err == nil {
+		entry.element = window.order.PushBack(entry)
+	} else {
+		delete(window.entries, entry.token)
+	}
+	window.trim(entry.finished)
+	return
+}
+
+// remember will make this Node remember token as the token of a write it has applied, unless it already does.
+// Replicas remember the tokens of the writes forwarded to them, so that a retry reaching one of them after the owner has failed isn't applied again.
+func (self *Node) remember(token []byte) {
+	if len(token) == 0 {
+		return
+	}
+	window := self.replays
+	window.lock.Lock()
+	defer window.lock.Unlock()
+	if _, found := window.entries[string(token)]; found {
+		return
+	}
+	entry := &replayEntry{
+		token:    string(token),
+		finished: time.Now(),
+		done:     make(chan struct{}),
+	}
+	close(entry.done)
+	window.entries[entry.token] = entry
+	entry.element = window.order.PushBack(entry)
+	window.trim(entry.finished)
+}
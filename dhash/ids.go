@@ -0,0 +1,102 @@
+package dhash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// idReserve is how many milliseconds of IDs a Node with a data directory reserves at a time, by recording the end of the reservation in its data directory.
+// A restarted Node continues after the end of its last reservation, so it never generates the IDs it generated before, even if its clock went back.
+const idReserve = int64(time.Second / time.Millisecond)
+
+// SetIDNode will make this Node use id, between 0 and 1023, as node id in the IDs it generates. Each node of a cluster must have its own, and a negative id
+// makes NextID fail. It panics if id is too big to fit in an ID, since masking it could make it collide with the node id of another node.
+func (self *Node) SetIDNode(id int) *Node {
+	if id >= 1<<common.IDNodeBits {
+		panic(fmt.Errorf("Node id %v is not below %v", id, 1<<common.IDNodeBits))
+	}
+	if id < 0 {
+		id = -1
+	}
+	atomic.StoreInt64(&self.idNode, int64(id))
+	return self
+}
+
+// IDNode returns the node id this Node uses in the IDs it generates, or -1 if it has none.
+func (self *Node) IDNode() int {
+	return int(atomic.LoadInt64(&self.idNode))
+}
+
+func (self *Node) idPath() string {
+	return filepath.Join(self.dir, "ids")
+}
+
+// reserveIDs will record that this Node may generate IDs until idReserve milliseconds after self.idTime. The first time, it will continue after the reservation recorded before.
+func (self *Node) reserveIDs() (err error) {
+	if self.idReserved == 0 {
+		if encoded, err := ioutil.ReadFile(self.idPath()); err == nil {
+			reserved, err := strconv.ParseInt(strings.TrimSpace(string(encoded)), 10, 64)
+			if err != nil {
+				return err
+			}
+			if reserved > self.idTime {
+				self.idTime, self.idSequence = reserved, 0
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if self.idTime < self.idReserved {
+		return
+	}
+	reserved := self.idTime + idReserve
+	if err = os.MkdirAll(self.dir, 0700); err != nil {
+		return
+	}
+	tmp := self.idPath() + ".tmp"
+	if err = ioutil.WriteFile(tmp, []byte(strconv.FormatInt(reserved, 10)), 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, self.idPath()); err != nil {
+		return
+	}
+	self.idReserved = reserved
+	return
+}
+
+// NextID will return a unique ID made of the time of the cluster in milliseconds, the node id of this Node and a sequence number, so that IDs generated
+// by the same Node always increase, and IDs generated by different Nodes are roughly time ordered. Use common.DecodeID to find the parts of an ID.
+// It returns common.ErrNoIDNode if this Node has no node id.
+func (self *Node) NextID() (id int64, err error) {
+	node := self.IDNode()
+	if node < 0 {
+		err = common.ErrNoIDNode
+		return
+	}
+	now := self.timer.ContinuousTime()/int64(time.Millisecond) - common.IDEpoch
+	self.idLock.Lock()
+	defer self.idLock.Unlock()
+	if now > self.idTime {
+		self.idTime, self.idSequence = now, 0
+	} else {
+		self.idSequence++
+		if self.idSequence >= 1<<common.IDSequenceBits {
+			// Rather than waiting for the next millisecond when the sequence runs out, borrow it.
+			self.idTime, self.idSequence = self.idTime+1, 0
+		}
+	}
+	if self.dir != "" {
+		if err = self.reserveIDs(); err != nil {
+			return
+		}
+	}
+	return common.EncodeID(self.idTime, node, int(self.idSequence)), nil
+}
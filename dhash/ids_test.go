@@ -0,0 +1,70 @@
+package dhash
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestNextID(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:14991", "127.0.0.1:14991", "").SetIDNode(17)
+	var last int64
+	for i := 0; i < 10000; i++ {
+		id, err := node.NextID()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if id <= last {
+			t.Fatalf("wanted %v to be greater than %v", id, last)
+		}
+		last = id
+	}
+	when, idNode, _ := common.DecodeID(last)
+	if idNode != 17 {
+		t.Errorf("wanted node id 17, got %v", idNode)
+	}
+	if d := when.Sub(time.Now()); d > time.Second || d < -time.Second {
+		t.Errorf("wanted %v to be close to now", when)
+	}
+	if id := common.EncodeID(4711, 17, 12); fmt.Sprint(common.DecodeID(id)) != fmt.Sprint(time.Unix(0, (4711+common.IDEpoch)*int64(time.Millisecond)), 17, 12) {
+		t.Errorf("wanted %v to decode to what it was made of, got %v", id, fmt.Sprint(common.DecodeID(id)))
+	}
+	if _, err := NewNodeDir("127.0.0.1:14992", "127.0.0.1:14992", "").NextID(); err != common.ErrNoIDNode {
+		t.Errorf("wanted nodes without node id to refuse generating IDs, got %v", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("wanted a node id above 1023 to be rejected")
+			}
+		}()
+		NewNodeDir("127.0.0.1:14993", "127.0.0.1:14993", "").SetIDNode(1024)
+	}()
+}
+
+func TestNextIDRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "god_ids")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	node := NewNodeDir("127.0.0.1:14994", "127.0.0.1:14994", dir).SetIDNode(3)
+	var last int64
+	for i := 0; i < 100; i++ {
+		if last, err = node.NextID(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	restarted := NewNodeDir("127.0.0.1:14994", "127.0.0.1:14994", dir).SetIDNode(3)
+	id, err := restarted.NextID()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if id <= last || restarted.idTime < node.idReserved {
+		t.Errorf("wanted %v to be after the reservation %v of %v", id, node.idReserved, last)
+	}
+}
@@ -0,0 +1,85 @@
+package dhash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/radix"
+)
+
+// mutableLockStripes is the number of locks serializing the checks and writes of writes to write-once keys, chosen by the hash of the key.
+const mutableLockStripes = 64
+
+// writeOnce returns whether key was put as immutable, or has a prefix configured to be immutable.
+func (self *Node) writeOnce(key []byte) bool {
+	if conf, _ := self.tree.SubConfiguration(key); conf[common.ImmutableConf] == "yes" {
+		return true
+	}
+	conf, _ := self.tree.Configuration()
+	for name, value := range conf {
+		if value == "yes" && strings.HasPrefix(name, common.ImmutablePrefixConf) {
+			if prefix, err := hex.DecodeString(name[len(common.ImmutablePrefixConf):]); err == nil && bytes.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assertMutable will return common.ErrImmutable if operation would change or delete a value of a write-once key, unless data overrides immutability.
+// Write-once keys, and their sub trees, can still be written when the written key or sub key doesn't exist, so immutable sub trees can be used as append only logs.
+func (self *Node) assertMutable(data common.Item, operation string) error {
+	if data.Override || !self.writeOnce(data.Key) {
+		return nil
+	}
+	switch operation {
+	case "Put":
+		if _, _, existed := self.tree.Get(data.Key); !existed {
+			return nil
+		}
+	case "SubPut":
+		if _, _, existed := self.tree.SubGet(data.Key, data.SubKey); !existed {
+			return nil
+		}
+	}
+	return common.ErrImmutable
+}
+
+// lockMutable will check data like assertMutable, and if the key of data is write-once, or made write-once by data, lock it until unlock is called,
+// so that no concurrent write can give the key or sub key a value between the check and the write.
+func (self *Node) lockMutable(data common.Item, operation string) (unlock func(), err error) {
+	unlock = func() {}
+	if data.Override || !(data.Immutable || self.writeOnce(data.Key)) {
+		return
+	}
+	hash := murmur.HashBytes(data.Key)
+	lock := &self.mutableLocks[(int(hash[0])<<8|int(hash[1]))%mutableLockStripes]
+	lock.Lock()
+	if err = self.assertMutable(data, operation); err != nil {
+		lock.Unlock()
+		return
+	}
+	unlock = lock.Unlock
+	return
+}
+
+// mutableSnapshot returns the entries of snapshot that don't change or delete values of write-once keys, for applying restored snapshots.
+func (self *Node) mutableSnapshot(snapshot []radix.SnapshotEntry) (result []radix.SnapshotEntry) {
+	result = make([]radix.SnapshotEntry, 0, len(snapshot))
+	for _, entry := range snapshot {
+		if self.writeOnce(entry.Key) {
+			if entry.Sub {
+				if _, _, existed := self.tree.SubGet(entry.Key, entry.SubKey); existed {
+					continue
+				}
+			} else if _, _, existed := self.tree.Get(entry.Key); existed {
+				continue
+			}
+		}
+		result = append(result, entry)
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package dhash
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func assertMutable(t *testing.T, node *Node, data common.Item, operation string, wanted bool) {
+	if err := node.assertMutable(data, operation); (err == nil) != wanted {
+		t.Errorf("%v of %q/%q: wanted mutable %v, got %v", operation, data.Key, data.SubKey, wanted, err)
+	} else if err != nil && !common.IsImmutable(err) {
+		t.Errorf("%v of %q/%q: wanted %v, got %v", operation, data.Key, data.SubKey, common.ErrImmutable, err)
+	}
+}
+
+func TestImmutability(t *testing.T) {
+	node := &Node{tree: radix.NewTree()}
+	node.tree.Put([]byte("log"), []byte("v"), 1)
+	node.tree.SubPut([]byte("log"), []byte("1"), []byte("a"), 1)
+	assertMutable(t, node, common.Item{Key: []byte("log")}, "Put", true)
+	node.tree.SubAddConfiguration([]byte("log"), 2, common.ImmutableConf, "yes")
+	assertMutable(t, node, common.Item{Key: []byte("log")}, "Put", false)
+	assertMutable(t, node, common.Item{Key: []byte("log")}, "Del", false)
+	assertMutable(t, node, common.Item{Key: []byte("log")}, "SubClear", false)
+	assertMutable(t, node, common.Item{Key: []byte("log"), SubKey: []byte("1")}, "SubPut", false)
+	assertMutable(t, node, common.Item{Key: []byte("log"), SubKey: []byte("1")}, "SubDel", false)
+	assertMutable(t, node, common.Item{Key: []byte("log"), SubKey: []byte("2")}, "SubPut", true)
+	assertMutable(t, node, common.Item{Key: []byte("log"), Override: true}, "Del", true)
+	node.tree.SubAddConfiguration([]byte("log"), 3, common.ImmutableConf, "no")
+	assertMutable(t, node, common.Item{Key: []byte("log")}, "Del", true)
+
+	node.tree.AddConfiguration(4, common.ImmutablePrefixConf+hex.EncodeToString([]byte("blob/")), "yes")
+	assertMutable(t, node, common.Item{Key: []byte("blob/1")}, "Put", true)
+	node.tree.Put([]byte("blob/1"), []byte("x"), 5)
+	assertMutable(t, node, common.Item{Key: []byte("blob/1")}, "Put", false)
+	assertMutable(t, node, common.Item{Key: []byte("blob/1")}, "Del", false)
+	assertMutable(t, node, common.Item{Key: []byte("blo")}, "Del", true)
+	node.tree.AddConfiguration(6, common.ImmutablePrefixConf+hex.EncodeToString([]byte("blob/")), "no")
+	assertMutable(t, node, common.Item{Key: []byte("blob/1")}, "Put", true)
+}
+
+func TestConcurrentImmutability(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:16191", "127.0.0.1:16191", "")
+	node.MustStart()
+	defer node.Stop()
+	wait := new(sync.WaitGroup)
+	lock := new(sync.Mutex)
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if err := node.Put(common.Item{Key: []byte("once"), Value: []byte(fmt.Sprint(i)), Immutable: true, Sync: true}); err == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wait.Wait()
+	if succeeded != 1 {
+		t.Errorf("wanted exactly one concurrent put of a write-once key to succeed, got %v", succeeded)
+	}
+	value, timestamp, _ := node.tree.Get([]byte("once"))
+	encoded, err := radix.EncodeSnapshot([]radix.SnapshotEntry{
+		{Key: []byte("once"), Value: []byte("changed"), Timestamp: timestamp + 1, Present: true},
+		{Key: []byte("other"), Value: []byte("new"), Timestamp: 1, Present: true},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if changed, err := node.Restore(encoded); err != nil || changed != 1 {
+		t.Errorf("wanted only the mutable entry restored, got %v, %v", changed, err)
+	}
+	if restored, _, _ := node.tree.Get([]byte("once")); string(restored) != string(value) {
+		t.Errorf("wanted restoring to leave the write-once key at %q, got %q", value, restored)
+	}
+}
@@ -0,0 +1,76 @@
+package dhash
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/zond/god/radix"
+)
+
+const (
+	defaultSyncFanout = 16
+	// changeFilterBits and changeFilterHashes define the size of the filters of changed keys, large enough to prune most of the tree when around a thousand keys change per sync interval.
+	changeFilterBits   = 1 << 18
+	changeFilterHashes = 4
+)
+
+// errNoChangeFilter is returned when asking for the changed keys of a Node that doesn't record them, to make the asking Node do a full sync instead.
+var errNoChangeFilter = errors.New("Node doesn't do incremental syncs")
+
+// SetSyncFanout will make this Node fetch the prints of up to n keys per request when synchronizing and cleaning.
+func (self *Node) SetSyncFanout(n int) *Node {
+	atomic.StoreInt64(&self.syncFanout, int64(n))
+	return self
+}
+
+// SyncFanout returns how many prints this Node fetches per request when synchronizing and cleaning.
+func (self *Node) SyncFanout() int {
+	return int(atomic.LoadInt64(&self.syncFanout))
+}
+
+// SetIncrementalSyncs will make this Node, between each full periodic sync with its replicas, do n periodic syncs that only visit the keys changed on either side during the last two sync intervals.
+// Changes missed by the incremental syncs, for example when a replica was unavailable, are found by the next full sync. Zero makes all periodic syncs full syncs.
+func (self *Node) SetIncrementalSyncs(n int) *Node {
+	atomic.StoreInt64(&self.incrementalSyncs, int64(n))
+	return self
+}
+
+// IncrementalSyncs returns how many incremental syncs this Node does between each full periodic sync.
+func (self *Node) IncrementalSyncs() int {
+	return int(atomic.LoadInt64(&self.incrementalSyncs))
+}
+
+// recordChange will add key to the filter of keys changed during the current sync interval, if this Node does incremental syncs.
+func (self *Node) recordChange(key []byte) {
+	if self.IncrementalSyncs() == 0 {
+		return
+	}
+	self.changeLock.Lock()
+	defer self.changeLock.Unlock()
+	self.changes.AddKey(key)
+}
+
+// rotateChanges will start a new sync interval, forgetting the keys changed before the previous one.
+func (self *Node) rotateChanges() {
+	self.changeLock.Lock()
+	defer self.changeLock.Unlock()
+	self.previousChanges, self.changes = self.changes, radix.NewBloom(changeFilterBits, changeFilterHashes)
+}
+
+// ChangeFilter returns a filter of the keys changed in this Node during the current and the previous sync interval.
+func (self *Node) ChangeFilter() *radix.Bloom {
+	self.changeLock.Lock()
+	defer self.changeLock.Unlock()
+	return self.changes.Union(self.previousChanges)
+}
+
+// incremental returns whether the next periodic sync of this Node should only visit changed keys.
+// The first periodic sync after incremental syncs are turned on is a full sync, since no changes were recorded before.
+func (self *Node) incremental() bool {
+	n := atomic.LoadInt64(&self.incrementalSyncs)
+	if n == 0 {
+		atomic.StoreInt64(&self.syncRounds, 0)
+		return false
+	}
+	return (atomic.AddInt64(&self.syncRounds, 1)-1)%(n+1) != 0
+}
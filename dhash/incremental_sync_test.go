@@ -0,0 +1,29 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestIncrementalSync(t *testing.T) {
+	dhashes := testStartup(t, 2, 13791)
+	defer stopServers(dhashes)
+	for _, d := range dhashes {
+		d.SetIncrementalSyncs(1000).SetSyncFanout(4)
+	}
+	// Let the first, full, sync pass.
+	time.Sleep(time.Second * 2)
+	for i := byte(0); i < 10; i++ {
+		dhashes[0].tree.Put([]byte{i}, []byte{i}, 1)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, []byte{9}, []byte{9})
+		return fmt.Sprint(having), having == 2
+	}, time.Second*10)
+	if filter := dhashes[0].ChangeFilter(); !filter.MayContain(nil) {
+		t.Errorf("the change filter should contain the changed keys")
+	}
+}
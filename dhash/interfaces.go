@@ -0,0 +1,34 @@
+package dhash
+
+import (
+	"github.com/zond/god/client"
+)
+
+// Local is the data API of a Node, implementing client.KV, client.SubKV and client.SetOps, so that code embedding a Node can depend on
+// the same interfaces as code using a client.Conn, and be tested with a fake.Fake. Like client.Conn it sends each operation to the owner of its key.
+// It also implements client.Watcher, which client.Conn doesn't, by reporting the writes to the Node.
+type Local struct {
+	*client.Conn
+	node *Node
+}
+
+// Local returns the data API of this Node. It must be called after the Node has started.
+func (self *Node) Local() *Local {
+	return &Local{
+		Conn: client.MustConn(self.GetBroadcastAddr()),
+		node: self,
+	}
+}
+
+// AddWriteListener will make l get notified of each value and tombstone the Node puts in its tree, like Node.AddWriteListener.
+func (self *Local) AddWriteListener(l WriteListener) {
+	self.node.AddWriteListener(l)
+}
+
+var (
+	_ client.KV      = (*Local)(nil)
+	_ client.SubKV   = (*Local)(nil)
+	_ client.SetOps  = (*Local)(nil)
+	_ client.Watcher = (*Local)(nil)
+	_ client.Watcher = (*Node)(nil)
+)
@@ -3,6 +3,7 @@ package dhash
 import (
 	"github.com/zond/god/common"
 	"github.com/zond/setop"
+	"time"
 )
 
 type Nothing struct{}
@@ -39,9 +40,10 @@ type SubIndex struct {
 	Index int
 }
 type ValueOp struct {
-	Key   []byte
-	Value []byte
-	Sync  bool
+	Key       []byte
+	Value     []byte
+	Sync      bool
+	Immutable bool
 }
 type ValueRes struct {
 	Key    []byte
@@ -78,6 +80,12 @@ type SubConf struct {
 	Key     string
 	Value   string
 }
+type NodeReq struct {
+	Addr string
+}
+type HeatmapReq struct {
+	Buckets int
+}
 type Conf struct {
 	Key   string
 	Value string
@@ -96,8 +104,22 @@ func (self *JSONApi) convert(items []common.Item, result *[]ValueRes) {
 }
 func (self *JSONApi) forwardUnlessMe(cmd string, key []byte, in, out interface{}) (forwarded bool, err error) {
 	succ := (*Node)(self).node.GetSuccessorFor(key)
-	if succ.Addr != (*Node)(self).node.GetBroadcastAddr() {
-		forwarded, err = true, succ.Call(cmd, in, out)
+	for attempt := 0; attempt < rerouteAttempts; attempt++ {
+		if succ.Addr == (*Node)(self).node.GetBroadcastAddr() {
+			return false, nil
+		}
+		if forwarded, err = true, succ.Call(cmd, in, out); !common.IsReroute(err) {
+			return
+		}
+		// Our ring is outdated, so ask the node that rerouted us who it thinks is responsible for the key.
+		var next common.Remote
+		if e := succ.Call("Discord.GetSuccessorFor", key, &next); e != nil {
+			return
+		}
+		if next.Addr == succ.Addr {
+			time.Sleep(rerouteBackoff)
+		}
+		succ = next
 	}
 	return
 }
@@ -164,9 +186,10 @@ func (self *JSONApi) Del(d KeyOp, n *Nothing) (err error) {
 }
 func (self *JSONApi) Put(d ValueOp, n *Nothing) (err error) {
 	data := common.Item{
-		Key:   d.Key,
-		Value: d.Value,
-		Sync:  d.Sync,
+		Key:       d.Key,
+		Value:     d.Value,
+		Sync:      d.Sync,
+		Immutable: d.Immutable,
 	}
 	var x int
 	var f bool
@@ -275,6 +298,38 @@ func (self *JSONApi) Describe(x Nothing, result *common.DHashDescription) (err e
 	*result = (*Node)(self).Description()
 	return nil
 }
+func (self *JSONApi) Sync(n NodeReq, x *Nothing) (err error) {
+	if n.Addr == "" || n.Addr == (*Node)(self).GetBroadcastAddr() {
+		(*Node)(self).Sync()
+		return nil
+	}
+	var y int
+	return common.Switch.Call(n.Addr, "DHash.Sync", 0, &y)
+}
+func (self *JSONApi) Decommission(n NodeReq, x *Nothing) (err error) {
+	if n.Addr == "" || n.Addr == (*Node)(self).GetBroadcastAddr() {
+		go (*Node)(self).Decommission()
+		return nil
+	}
+	var y int
+	return common.Switch.Call(n.Addr, "DHash.Decommission", 0, &y)
+}
+func (self *JSONApi) Events(r common.EventsRequest, result *common.Events) (err error) {
+	*result = (*Node)(self).Events(r.Since, r.Wait)
+	return nil
+}
+func (self *JSONApi) Expiries(r common.EventsRequest, result *common.Events) (err error) {
+	*result = (*Node)(self).Expiries(r.Since, r.Wait)
+	return nil
+}
+func (self *JSONApi) Heatmap(r HeatmapReq, result *common.Heatmap) (err error) {
+	*result = (*Node)(self).Heatmap(r.Buckets)
+	return nil
+}
+func (self *JSONApi) TreePage(r common.TreePageRequest, result *common.TreePage) (err error) {
+	*result = (*Node)(self).TreePage(r)
+	return nil
+}
 func (self *JSONApi) DescribeTree(x Nothing, result *string) (err error) {
 	*result = (*Node)(self).DescribeTree()
 	return nil
@@ -760,12 +815,6 @@ func (self *JSONApi) ReverseSliceLen(pr PageRange, result *[]ValueRes) (err erro
 	return
 }
 func (self *JSONApi) SetExpression(expr setop.SetExpression, items *[]setop.SetOpResult) (err error) {
-	if expr.Op == nil {
-		var err error
-		if expr.Op, err = setop.NewSetOpParser(expr.Code).Parse(); err != nil {
-			return err
-		}
-	}
 	return (*Node)(self).SetExpression(expr, items)
 }
 
@@ -783,8 +832,7 @@ func (self *JSONApi) SubAddConfiguration(co SubConf, x *Nothing) (err error) {
 		Key:     co.Key,
 		Value:   co.Value,
 	}
-	(*Node)(self).SubAddConfiguration(c)
-	return nil
+	return (*Node)(self).SubAddConfiguration(c)
 }
 func (self *JSONApi) Configuration(x Nothing, result *common.Conf) (err error) {
 	*result = common.Conf{}
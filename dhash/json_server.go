@@ -210,6 +210,20 @@ func (self *Node) startJson() {
 				}
 				return websocket.Message.Send(ws, string(b)) == nil
 			})
+			self.AddMigrateListener(func(dhash *Node, source, destination []byte) bool {
+				b, err := json.Marshal(socketMessage{
+					Type: "Migrate",
+					Data: map[string]interface{}{
+						"addr":        dhash.GetBroadcastAddr(),
+						"source":      source,
+						"destination": destination,
+					},
+				})
+				if err != nil {
+					panic(err)
+				}
+				return websocket.Message.Send(ws, string(b)) == nil
+			})
 			self.AddCleanListener(func(source, dest common.Remote, cleaned, pushed int) bool {
 				b, err := json.Marshal(socketMessage{
 					Type: "Clean",
@@ -0,0 +1,30 @@
+package dhash
+
+import (
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestLeaderboard(t *testing.T) {
+	node := NewEmbeddedNode("leaderboard", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "leaderboard")
+	board := []byte("board")
+	conn.SubAddConfiguration(board, "mirrored", "yes")
+	for member, score := range map[string]string{"a": "3", "b": "1", "c": "5", "d": "2"} {
+		conn.SSubPut(board, []byte(member), []byte(score))
+	}
+	if rank, existed := conn.Rank(board, []byte("a")); !existed || rank != 1 {
+		t.Errorf("wanted a at rank 1, got %v, %v", rank, existed)
+	}
+	top := conn.TopN(board, 2)
+	if len(top) != 2 || string(top[0].Key) != "c" || string(top[0].Value) != "5" || top[0].Index != 0 || string(top[1].Key) != "a" || top[1].Index != 1 {
+		t.Errorf("wanted c and a, got %v", top)
+	}
+	if top := conn.TopN(board, 10); len(top) != 4 {
+		t.Errorf("wanted all 4 members, got %v", top)
+	}
+}
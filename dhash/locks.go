@@ -0,0 +1,148 @@
+package dhash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+const (
+	lockTokenSize = 16
+)
+
+// locksSuffix ends the keys of the sub trees of the locks namespace of the system keyspace, kept per key.
+var locksSuffix = common.SystemKey(common.SystemLocks)
+
+// leaseKey returns the key of the sub tree where the lease on key is kept, with key as sub key, next to key so that the owner of key keeps it.
+func leaseKey(key []byte) []byte {
+	return common.SystemKeyFor(common.SystemLocks, key)
+}
+
+// leasedKey returns the key whose lease is kept in the sub tree of key, if key is the sub tree of a lease.
+func leasedKey(key []byte) (leased []byte, ok bool) {
+	if !bytes.HasSuffix(key, locksSuffix) {
+		return
+	}
+	return key[:len(key)-len(locksSuffix)], true
+}
+
+func encodeLease(token []byte, expires int64) []byte {
+	result := make([]byte, 8, 8+len(token))
+	binary.BigEndian.PutUint64(result, uint64(expires))
+	return append(result, token...)
+}
+
+func decodeLease(b []byte) (token []byte, expires int64, ok bool) {
+	if len(b) < 8 {
+		return
+	}
+	return b[8:], int64(binary.BigEndian.Uint64(b)), true
+}
+
+// Lock will try to acquire the lease on lease.Key for lease.Duration, or renew it if lease.Token is the token of the current lease.
+// The lease is kept in the locks namespace of the system keyspace, next to lease.Key, by the owner of lease.Key, and replicated with it to survive migrations.
+func (self *Node) Lock(lease common.Lease, result *common.Lease) (err error) {
+	key := leaseKey(lease.Key)
+	successor := self.node.GetSuccessorFor(key)
+	if successor.Addr != self.node.GetBroadcastAddr() {
+		return successor.Call("DHash.Lock", lease, result)
+	}
+	if err = self.assertQuorum(); err != nil {
+		return
+	}
+	if lease.Duration <= 0 {
+		return fmt.Errorf("Lease duration must be positive, got %v", lease.Duration)
+	}
+	if err = self.assertNotFrozen(lease.Key); err != nil {
+		return
+	}
+	if err = self.assertNotReserved(lease.Key); err != nil {
+		return
+	}
+	self.leaseLock.Lock()
+	defer self.leaseLock.Unlock()
+	now := self.timer.ContinuousTime()
+	*result = lease
+	result.Acquired = false
+	if value, _, existed := self.tree.SubGet(key, lease.Key); existed {
+		if token, expires, ok := decodeLease(value); ok && expires > now && bytes.Compare(token, lease.Token) != 0 {
+			result.Token = nil
+			result.Expires = expires
+			return
+		}
+	}
+	if result.Token == nil {
+		result.Token = make([]byte, lockTokenSize)
+		if _, err = rand.Read(result.Token); err != nil {
+			return
+		}
+	}
+	result.Expires = now + int64(lease.Duration)
+	if err = self.ownedSubPut(common.Item{
+		Key:    key,
+		SubKey: lease.Key,
+		Value:  encodeLease(result.Token, result.Expires),
+		Sync:   true,
+	}); err != nil {
+		return
+	}
+	result.Acquired = true
+	return
+}
+
+// Unlock will release the lease on lease.Key if lease.Token is the token of the current lease.
+func (self *Node) Unlock(lease common.Lease, result *bool) (err error) {
+	key := leaseKey(lease.Key)
+	successor := self.node.GetSuccessorFor(key)
+	if successor.Addr != self.node.GetBroadcastAddr() {
+		return successor.Call("DHash.Unlock", lease, result)
+	}
+	self.leaseLock.Lock()
+	defer self.leaseLock.Unlock()
+	*result = false
+	if value, _, existed := self.tree.SubGet(key, lease.Key); existed {
+		if token, expires, ok := decodeLease(value); ok && expires > self.timer.ContinuousTime() && bytes.Compare(token, lease.Token) == 0 {
+			if err = self.ownedSubDel(common.Item{
+				Key:    key,
+				SubKey: lease.Key,
+				Sync:   true,
+			}); err != nil {
+				return
+			}
+			*result = true
+		}
+	}
+	return
+}
+
+// expireLeases will remove the leases kept by this Node that have expired without being released, and return the number of removed leases.
+func (self *Node) expireLeases() (removed int) {
+	self.leaseLock.Lock()
+	defer self.leaseLock.Unlock()
+	now := self.timer.ContinuousTime()
+	var expired [][]byte
+	self.eachEntryBetween(self.node.GetPredecessor().Pos, self.node.GetPosition(), func(key, value []byte, timestamp int64, sub *radix.Tree) bool {
+		if leased, ok := leasedKey(key); ok && sub != nil {
+			if value, _, existed := sub.Get(leased); existed {
+				if _, expires, ok := decodeLease(value); !ok || expires <= now {
+					expired = append(expired, leased)
+				}
+			}
+		}
+		return true
+	})
+	for _, key := range expired {
+		if err := self.ownedSubDel(common.Item{
+			Key:    leaseKey(key),
+			SubKey: key,
+			QoS:    common.Batch,
+		}); err == nil {
+			removed++
+		}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+func TestLocks(t *testing.T) {
+	node := NewEmbeddedNode("locks", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "locks")
+	token, acquired := conn.Lock([]byte("k"), time.Minute)
+	if !acquired {
+		t.Fatalf("wanted to acquire the lock")
+	}
+	if _, acquired := conn.Lock([]byte("k"), time.Minute); acquired {
+		t.Errorf("wanted the lock to be held")
+	}
+	if key, _, existed := conn.Next([]byte("k")); existed {
+		t.Errorf("wanted no leases next to the locked key, got %q", key)
+	}
+	if _, _, existed := node.tree.SubGet(leaseKey([]byte("k")), []byte("k")); !existed {
+		t.Errorf("wanted the lease in the locks namespace of the key")
+	}
+	if !conn.Unlock([]byte("k"), token) {
+		t.Errorf("wanted to release the lock")
+	}
+	if _, acquired = conn.Lock([]byte("k2"), time.Millisecond); !acquired {
+		t.Fatalf("wanted to acquire the lock")
+	}
+	time.Sleep(time.Millisecond * 10)
+	node.Trim()
+	for _, key := range []string{"k", "k2"} {
+		if _, _, existed := node.tree.SubGet(leaseKey([]byte(key)), []byte(key)); existed {
+			t.Errorf("wanted the released and expired leases to be removed, but the lease on %v remains", key)
+		}
+	}
+}
+
+func TestLocksFollowKeys(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:17291", "127.0.0.1:17291", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:17391", "127.0.0.1:17391", "")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:17291")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2 && len(node2.node.GetNodes()) == 2
+	}, time.Second*10)
+	conn := client.MustConn("127.0.0.1:17291")
+	owners := map[string]*Node{}
+	for i := 0; len(owners) < 2; i++ {
+		key := murmur.HashString(fmt.Sprint(i))
+		owner := node1
+		if node1.node.GetSuccessorFor(key).Addr == node2.node.GetBroadcastAddr() {
+			owner = node2
+		}
+		if _, found := owners[owner.node.GetBroadcastAddr()]; found {
+			continue
+		}
+		owners[owner.node.GetBroadcastAddr()] = owner
+		if _, acquired := conn.Lock(key, time.Minute); !acquired {
+			t.Fatalf("wanted to acquire the lock on %v", key)
+		}
+		if _, _, existed := owner.tree.SubGet(leaseKey(key), key); !existed {
+			t.Errorf("wanted the lease on %v kept by %v, the owner of the key", key, owner)
+		}
+	}
+}
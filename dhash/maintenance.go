@@ -0,0 +1,204 @@
+package dhash
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// positiveInterval returns d, or def if d isn't positive, since a worker with a non-positive interval would run without pause.
+func positiveInterval(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// SetSyncInterval will set how long this Node waits between synchronizing with its replicas, and between trying to migrate.
+// If d isn't positive the default of one second is used.
+func (self *Node) SetSyncInterval(d time.Duration) *Node {
+	atomic.StoreInt64(&self.syncInterval, int64(positiveInterval(d, defaultSyncInterval)))
+	return self
+}
+
+// SyncInterval returns how long this Node waits between synchronizing with its replicas, and between trying to migrate.
+func (self *Node) SyncInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.syncInterval))
+}
+
+// SetCleanInterval will set how long this Node waits between cleaning out data it is no longer responsible for.
+// If d isn't positive the default of one second is used.
+func (self *Node) SetCleanInterval(d time.Duration) *Node {
+	atomic.StoreInt64(&self.cleanInterval, int64(positiveInterval(d, defaultCleanInterval)))
+	return self
+}
+
+// CleanInterval returns how long this Node waits between cleaning out data it is no longer responsible for.
+func (self *Node) CleanInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.cleanInterval))
+}
+
+// SetMigrateHysteresis will set how many times more entries than its successor this Node must own before it migrates.
+// Values below 1, which would make nodes owning fewer entries than their successors migrate, are raised to 1.
+func (self *Node) SetMigrateHysteresis(h float64) *Node {
+	if h < 1 || math.IsNaN(h) {
+		h = 1
+	}
+	atomic.StoreUint64(&self.migrateHysteresis, math.Float64bits(h))
+	return self
+}
+
+// MigrateHysteresis returns how many times more entries than its successor this Node must own before it migrates.
+func (self *Node) MigrateHysteresis() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&self.migrateHysteresis))
+}
+
+// SetMigrateWaitFactor will set for how many sync intervals after the last sync, ring change or migration this Node waits before it migrates.
+// Negative values are raised to 0, which makes it migrate without waiting.
+func (self *Node) SetMigrateWaitFactor(f int) *Node {
+	if f < 0 {
+		f = 0
+	}
+	atomic.StoreInt64(&self.migrateWaitFactor, int64(f))
+	return self
+}
+
+// MigrateWaitFactor returns for how many sync intervals after the last sync, ring change or migration this Node waits before it migrates.
+func (self *Node) MigrateWaitFactor() int {
+	return int(atomic.LoadInt64(&self.migrateWaitFactor))
+}
+
+// SetSyncLimits will limit the number of keys and bytes per second this Node copies when synchronizing, cleaning and shipping snapshots. Zero means unlimited.
+// Regardless of the limits, this Node will slow down when the latency of its peers rises.
+func (self *Node) SetSyncLimits(keysPerSecond, bytesPerSecond float64) *Node {
+	self.limiter.SetRates(keysPerSecond, bytesPerSecond)
+	return self
+}
+
+// SyncLimits returns the number of keys and bytes per second this Node copies when synchronizing, cleaning and shipping snapshots.
+func (self *Node) SyncLimits() (keysPerSecond, bytesPerSecond float64) {
+	return self.limiter.Rates()
+}
+
+// SyncSlowdown returns the factor this Node currently slows down synchronization with because of rising peer latency.
+func (self *Node) SyncSlowdown() float64 {
+	return self.limiter.Slowdown()
+}
+
+// CompressLog will make this Node compress values of at least threshold bytes in its logfiles. Zero disables compression.
+// Use common.SetCompressionThreshold to compress values on the wire.
+func (self *Node) CompressLog(threshold int) *Node {
+	self.tree.CompressLog(threshold)
+	return self
+}
+
+// SetMaxConnections will limit the number of client connections this Node serves at the same time. Zero means unlimited.
+// Connections above the limit get their requests rejected with common.ErrOverloaded.
+func (self *Node) SetMaxConnections(n int) *Node {
+	self.node.SetMaxConnections(n)
+	return self
+}
+
+// SetMaxInFlight will limit the number of requests this Node handles at the same time for each connection. Zero means unlimited.
+// Requests above the limit are rejected with common.ErrOverloaded.
+func (self *Node) SetMaxInFlight(n int) *Node {
+	self.node.SetMaxInFlight(n)
+	return self
+}
+
+// SetGCInterval will set how long this Node waits between collecting garbage chunks.
+// If d isn't positive the default of one minute is used.
+func (self *Node) SetGCInterval(d time.Duration) *Node {
+	atomic.StoreInt64(&self.gcInterval, int64(positiveInterval(d, defaultGCInterval)))
+	return self
+}
+
+// GCInterval returns how long this Node waits between collecting garbage chunks.
+func (self *Node) GCInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.gcInterval))
+}
+
+// SetGCGracePeriod will set for how long chunks must have been unreferenced before this Node removes them.
+// It must be longer than it takes writers to put the manifests referring to the chunks they put. Negative periods are raised to 0.
+func (self *Node) SetGCGracePeriod(d time.Duration) *Node {
+	if d < 0 {
+		d = 0
+	}
+	atomic.StoreInt64(&self.gcGracePeriod, int64(d))
+	return self
+}
+
+// GCGracePeriod returns for how long chunks must have been unreferenced before this Node removes them.
+func (self *Node) GCGracePeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.gcGracePeriod))
+}
+
+// SetChunkSize will make this Node split values longer than size bytes put to keys it owns into chunks of at most size bytes. Zero, or a negative size, disables chunking.
+func (self *Node) SetChunkSize(size int) *Node {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&self.chunkSize, int64(size))
+	return self
+}
+
+// ChunkSize returns the maximum size of the values this Node stores without chunking.
+func (self *Node) ChunkSize() int {
+	return int(atomic.LoadInt64(&self.chunkSize))
+}
+
+// SetZone will place this Node in zone, like a datacenter, to let clients prefer replicas in their own zone for stale reads.
+func (self *Node) SetZone(zone string) *Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.zone = zone
+	return self
+}
+
+// Zone returns the zone of this Node.
+func (self *Node) Zone() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.zone
+}
+
+// PauseMigration will stop this Node from migrating until ResumeMigration is called, for example during maintenance or bulk loads.
+func (self *Node) PauseMigration() {
+	if atomic.CompareAndSwapInt32(&self.migrationPaused, 0, 1) {
+		self.report(common.EventMigrationPaused, "", "")
+	}
+}
+
+// ResumeMigration will let this Node migrate again after PauseMigration.
+func (self *Node) ResumeMigration() {
+	if atomic.CompareAndSwapInt32(&self.migrationPaused, 1, 0) {
+		self.report(common.EventMigrationResumed, "", "")
+	}
+}
+
+// MigrationPaused returns whether migration is paused for this Node.
+func (self *Node) MigrationPaused() bool {
+	return atomic.LoadInt32(&self.migrationPaused) == 1
+}
+
+// PauseSync will stop this Node from periodically synchronizing with its replicas until ResumeSync is called.
+// Explicit calls to Sync and Decommission will still synchronize.
+func (self *Node) PauseSync() {
+	if atomic.CompareAndSwapInt32(&self.syncPaused, 0, 1) {
+		self.report(common.EventSyncPaused, "", "")
+	}
+}
+
+// ResumeSync will let this Node synchronize periodically again after PauseSync.
+func (self *Node) ResumeSync() {
+	if atomic.CompareAndSwapInt32(&self.syncPaused, 1, 0) {
+		self.report(common.EventSyncResumed, "", "")
+	}
+}
+
+// SyncPaused returns whether periodic synchronization is paused for this Node.
+func (self *Node) SyncPaused() bool {
+	return atomic.LoadInt32(&self.syncPaused) == 1
+}
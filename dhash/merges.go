@@ -0,0 +1,27 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+)
+
+// MergeFunc is a user defined merge function for set expressions. It will get all values found for key and return the merged value.
+type MergeFunc func(key []byte, values [][]byte) []byte
+
+// RegisterMerge will make f available to set expressions as the merge function name, for example in '(U:name a b)'.
+// It must be called on all nodes in the cluster, preferably before they are started. It is the same as common.RegisterMerge.
+//
+// User defined merge functions are only allowed in the outermost operation of an expression.
+func RegisterMerge(name string, f MergeFunc) error {
+	return common.RegisterMerge(name, common.MergeFunc(f))
+}
+
+// Merges returns the names of all user defined merge functions.
+func Merges() []string {
+	return common.Merges()
+}
+
+func parseExpression(expr *setop.SetExpression) (merge MergeFunc, err error) {
+	found, err := common.ParseExpression(expr)
+	return MergeFunc(found), err
+}
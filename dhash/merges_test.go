@@ -0,0 +1,61 @@
+package dhash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/setop"
+)
+
+func TestRegisterMerge(t *testing.T) {
+	longest := func(key []byte, values [][]byte) (result []byte) {
+		for _, value := range values {
+			if len(value) > len(result) {
+				result = value
+			}
+		}
+		return
+	}
+	if err := RegisterMerge("TestLongest", longest); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := RegisterMerge("TestLongest", longest); err == nil {
+		t.Errorf("registering TestLongest twice should fail")
+	}
+	if err := RegisterMerge("IntegerSum", longest); err == nil {
+		t.Errorf("registering a built in merge function should fail")
+	}
+	if err := RegisterMerge("Test Longest", longest); err == nil {
+		t.Errorf("registering an invalid name should fail")
+	}
+	expr := setop.SetExpression{Code: "(U:TestLongest a (I:IntegerSum b c))"}
+	merge, err := parseExpression(&expr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if merge == nil {
+		t.Fatalf("wanted TestLongest to be found")
+	}
+	if expr.Op.Merge != setop.Append {
+		t.Errorf("wanted the outermost merge to be parsed as Append, got %v", expr.Op.Merge)
+	}
+	if res := merge(nil, [][]byte{[]byte("a"), []byte("abc"), []byte("ab")}); bytes.Compare(res, []byte("abc")) != 0 {
+		t.Errorf("wanted abc, got %s", res)
+	}
+	expr = setop.SetExpression{Code: "(U:IntegerSum a (I:TestLongest b c))"}
+	if _, err = parseExpression(&expr); err == nil {
+		t.Errorf("user defined merge functions in inner operations should fail")
+	}
+	expr = setop.SetExpression{Code: "(U a (I:TestLongest b c))"}
+	if _, err = parseExpression(&expr); err == nil {
+		t.Errorf("user defined merge functions in inner operations should fail even when the outermost operation has no merge function")
+	}
+	expr = setop.SetExpression{Code: "  (U (I b c) (I:TestLongest d e))"}
+	if _, err = parseExpression(&expr); err == nil {
+		t.Errorf("user defined merge functions in later inner operations should fail")
+	}
+	expr = setop.SetExpression{Code: "(U:TestUnknown a b)"}
+	if _, err = parseExpression(&expr); err == nil {
+		t.Errorf("unknown merge functions should fail")
+	}
+}
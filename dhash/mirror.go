@@ -0,0 +1,47 @@
+package dhash
+
+import (
+	"fmt"
+
+	"github.com/zond/god/common"
+)
+
+// SetMirror will make this Node send each write, and each sub tree configuration, it stores as owner of the key to the node at addr, for example in another datacenter,
+// and wait for the mirror to store it before acknowledging the write. Writes the mirror doesn't accept fail with an error. The empty string turns off mirroring.
+func (self *Node) SetMirror(addr string) *Node {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.mirrorAddr = addr
+	return self
+}
+
+// Mirror returns the address of the node this Node sends its writes to, or the empty string if it has none.
+func (self *Node) Mirror() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.mirrorAddr
+}
+
+// mirror will make the mirror of this Node, if it has one, store data using the slave operation, without forwarding it to any replicas of its own.
+func (self *Node) mirror(data common.Item, operation string) error {
+	data.TTL, data.Timing = 1, nil
+	return self.mirrorCall(data.Key, operation, data)
+}
+
+// mirrorConfiguration will make the mirror of this Node, if it has one, store the sub tree configuration c, without forwarding it to any replicas of its own.
+func (self *Node) mirrorConfiguration(c common.ConfItem) error {
+	c.TTL = 1
+	return self.mirrorCall(c.TreeKey, "DHash.SlaveSubAddConfiguration", c)
+}
+
+func (self *Node) mirrorCall(key []byte, operation string, arg interface{}) (err error) {
+	addr := self.Mirror()
+	if addr == "" {
+		return
+	}
+	var x int
+	if err = common.Switch.Call(addr, operation, arg, &x); err != nil {
+		err = fmt.Errorf("Unable to mirror %v to %v: %v", common.HexEncode(key), addr, err)
+	}
+	return
+}
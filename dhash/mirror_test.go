@@ -0,0 +1,45 @@
+package dhash
+
+import (
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestMirror(t *testing.T) {
+	mirror := NewEmbeddedNode("mirror", "")
+	mirror.MustStart()
+	node := NewEmbeddedNode("mirrored", "").SetMirror(common.EmbeddedPrefix + "mirror")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "mirrored")
+	mirrorConn := client.MustConn(common.EmbeddedPrefix + "mirror")
+	conn.Put([]byte("k"), []byte("v"))
+	conn.SubAddConfiguration([]byte("s"), "mirrored", "yes")
+	conn.SubPut([]byte("s"), []byte("sk"), []byte("sv"))
+	if value, existed := mirrorConn.Get([]byte("k")); !existed || string(value) != "v" {
+		t.Errorf("wanted the mirror to have k => v, got %q", value)
+	}
+	if value, existed := mirrorConn.SubGet([]byte("s"), []byte("sk")); !existed || string(value) != "sv" {
+		t.Errorf("wanted the mirror to have s/sk => sv, got %q", value)
+	}
+	if conf := mirrorConn.SubConfiguration([]byte("s")); conf["mirrored"] != "yes" {
+		t.Errorf("wanted the sub tree configuration to be mirrored, got %v", conf)
+	}
+	conn.Del([]byte("k"))
+	if _, existed := mirrorConn.Get([]byte("k")); existed {
+		t.Errorf("wanted the delete to be mirrored")
+	}
+	mirror.Stop()
+	if err := conn.TryPut([]byte("k2"), []byte("v2")); err == nil {
+		t.Errorf("wanted writes to be rejected while the mirror is down")
+	}
+	if value, existed := conn.Get([]byte("k2")); !existed || string(value) != "v2" {
+		t.Errorf("wanted the write to be stored before it was mirrored, got %q", value)
+	}
+	node.SetMirror("")
+	if err := conn.TryPut([]byte("k2"), []byte("v2")); err != nil {
+		t.Errorf("wanted writes to be accepted without a mirror, got %v", err)
+	}
+}
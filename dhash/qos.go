@@ -0,0 +1,89 @@
+package dhash
+
+import (
+	"sync"
+
+	"github.com/zond/god/common"
+)
+
+// batchReserved is how many batch requests may run while interactive requests are in flight, so that bulk jobs and sync traffic always get a share of the node.
+const batchReserved = 2
+
+// scheduler admits interactive requests at once, and batch requests when no interactive requests are in flight or fewer than batchReserved batch requests are running.
+// Batch requests that can't run are queued, and admitted in order as soon as the interactive requests drain or a running batch request is done.
+type scheduler struct {
+	lock        *sync.Mutex
+	interactive int
+	batch       int
+	queue       []chan bool
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		lock: new(sync.Mutex),
+	}
+}
+
+// admit will wait until a request of the given class may run, and return a function to call when the request is done.
+func (self *scheduler) admit(qos common.QoS) (done func()) {
+	self.lock.Lock()
+	if qos != common.Batch {
+		self.interactive++
+		self.lock.Unlock()
+		return self.interactiveDone
+	}
+	if len(self.queue) == 0 && self.batchMayRun() {
+		self.batch++
+		self.lock.Unlock()
+		return self.batchDone
+	}
+	admitted := make(chan bool, 1)
+	self.queue = append(self.queue, admitted)
+	self.lock.Unlock()
+	<-admitted
+	return self.batchDone
+}
+func (self *scheduler) batchMayRun() bool {
+	return self.interactive == 0 || self.batch < batchReserved
+}
+
+// wake will admit the queued batch requests that may run. The lock must be held.
+func (self *scheduler) wake() {
+	for len(self.queue) > 0 && self.batchMayRun() {
+		self.batch++
+		self.queue[0] <- true
+		self.queue = self.queue[1:]
+	}
+}
+func (self *scheduler) interactiveDone() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.interactive--
+	self.wake()
+}
+func (self *scheduler) batchDone() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.batch--
+	self.wake()
+}
+
+// inFlight returns the number of interactive and batch requests running, and the number of batch requests queued.
+func (self *scheduler) inFlight() (interactive, batch, queued int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.interactive, self.batch, len(self.queue)
+}
+
+// schedule will admit a request of the given class, and return a function to call when the request is done.
+// Batch requests wait while there are interactive requests in flight, unless fewer than batchReserved batch requests are running,
+// so that bulk jobs and sync traffic never starve.
+func (self *Node) schedule(qos common.QoS) (done func()) {
+	return self.scheduler.admit(qos)
+}
+
+// Interactive returns the number of interactive requests currently in flight on this node.
+func (self *Node) Interactive() int {
+	interactive, _, _ := self.scheduler.inFlight()
+	return interactive
+}
@@ -0,0 +1,60 @@
+package dhash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestSchedule(t *testing.T) {
+	node := &Node{scheduler: newScheduler()}
+	done := node.schedule(common.Interactive)
+	if node.Interactive() != 1 {
+		t.Errorf("wanted 1 interactive request, got %v", node.Interactive())
+	}
+	// The reserved batch requests run even while interactive requests are in flight.
+	var reserved []func()
+	for i := 0; i < batchReserved; i++ {
+		reserved = append(reserved, node.schedule(common.Batch))
+	}
+	admitted := make(chan func())
+	go func() {
+		admitted <- node.schedule(common.Batch)
+	}()
+	select {
+	case <-admitted:
+		t.Errorf("batch request should wait for interactive requests when the reserved batch requests are running")
+	case <-time.After(time.Millisecond * 50):
+	}
+	reserved[0]()
+	select {
+	case reserved[0] = <-admitted:
+	case <-time.After(time.Second):
+		t.Fatalf("batch request should be admitted when a reserved batch request is done")
+	}
+	go func() {
+		admitted <- node.schedule(common.Batch)
+	}()
+	select {
+	case <-admitted:
+		t.Errorf("batch request should wait for interactive requests when the reserved batch requests are running")
+	case <-time.After(time.Millisecond * 50):
+	}
+	done()
+	if node.Interactive() != 0 {
+		t.Errorf("wanted 0 interactive requests, got %v", node.Interactive())
+	}
+	select {
+	case batchDone := <-admitted:
+		batchDone()
+	case <-time.After(time.Second):
+		t.Fatalf("batch request should be admitted when the interactive requests are done")
+	}
+	for _, batchDone := range reserved {
+		batchDone()
+	}
+	if interactive, batch, queued := node.scheduler.inFlight(); interactive != 0 || batch != 0 || queued != 0 {
+		t.Errorf("wanted nothing in flight, got %v interactive, %v batch and %v queued requests", interactive, batch, queued)
+	}
+}
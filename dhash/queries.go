@@ -0,0 +1,48 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+)
+
+// Query will return the items in q.Range matching q.Conditions, up to q.Range.Len items if positive.
+// Queries of the top level tree only return the items owned by this node, so that the results of all nodes can be merged, and never the system keyspace.
+func (self *Node) Query(q common.Query, items *[]common.Item) error {
+	r := q.Range
+	if err := self.assertNotReserved(r.Key); err != nil {
+		return err
+	}
+	collect := func(key, value []byte, timestamp int64) bool {
+		item := common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: timestamp,
+		}
+		if q.Match(item) {
+			*items = append(*items, item)
+		}
+		return r.Len < 1 || len(*items) < r.Len
+	}
+	if r.Key != nil {
+		self.tree.SubEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, collect)
+		return nil
+	}
+	// Values in the top level tree may be manifests of chunked values, fetched from around the ring, so they are joined after the iteration.
+	pred, me := self.node.GetPredecessor().Pos, self.node.GetPosition()
+	var owned []common.Item
+	self.tree.EachBetween(r.Min, r.Max, r.MinInc, r.MaxInc, func(key, value []byte, timestamp int64) bool {
+		if common.BetweenIE(key, pred, me) && !common.IsSystemKey(key) {
+			owned = append(owned, common.Item{Key: key, Value: value, Timestamp: timestamp})
+		}
+		return true
+	})
+	for _, item := range owned {
+		value, err := self.join(item.Key, item.Value)
+		if err != nil {
+			return err
+		}
+		if !collect(item.Key, value, item.Timestamp) {
+			break
+		}
+	}
+	return nil
+}
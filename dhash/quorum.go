@@ -0,0 +1,41 @@
+package dhash
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/zond/god/common"
+)
+
+// SetMinNodes will make this Node reject writes with common.ErrNoQuorum, and not migrate, until it has seen at least n nodes in its ring.
+// Use it when restarting a cluster, to avoid the first node to start owning, and later migrating, the entire keyspace.
+// Once the ring has reached n nodes the Node keeps serving writes, even if nodes leave the ring again.
+func (self *Node) SetMinNodes(n int) *Node {
+	atomic.StoreInt64(&self.minNodes, int64(n))
+	return self
+}
+
+// MinNodes returns how many nodes this Node must see in its ring before it starts serving writes.
+func (self *Node) MinNodes() int {
+	return int(atomic.LoadInt64(&self.minNodes))
+}
+
+// HasQuorum returns whether this Node has seen the minimum number of nodes in its ring, and serves writes.
+func (self *Node) HasQuorum() bool {
+	if atomic.LoadInt32(&self.quorum) == 1 {
+		return true
+	}
+	if min := self.MinNodes(); len(self.node.GetNodes()) >= min {
+		if atomic.CompareAndSwapInt32(&self.quorum, 0, 1) && min > 0 {
+			self.report(common.EventQuorumReached, "", fmt.Sprintf("%v nodes", min))
+		}
+		return true
+	}
+	return false
+}
+func (self *Node) assertQuorum() error {
+	if !self.HasQuorum() {
+		return common.ErrNoQuorum
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package dhash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/setop"
+)
+
+func TestQuorum(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:13091", "127.0.0.1:13091", "").SetMinNodes(2)
+	node1.MustStart()
+	defer node1.Stop()
+	data := common.Item{Key: []byte("k"), Value: []byte("v"), Sync: true}
+	if err := node1.Put(data); err != common.ErrNoQuorum {
+		t.Errorf("wanted %v before the ring reached 2 nodes, got %v", common.ErrNoQuorum, err)
+	}
+	var results []setop.SetOpResult
+	if err := node1.SetExpression(setop.SetExpression{Code: "(U:ConCat a b)", Dest: []byte("d")}, &results); err != common.ErrNoQuorum {
+		t.Errorf("wanted %v when storing set expression results before the ring reached 2 nodes, got %v", common.ErrNoQuorum, err)
+	}
+	node2 := NewNodeDir("127.0.0.1:13191", "127.0.0.1:13191", "").SetMinNodes(2)
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:13091")
+	common.AssertWithin(t, func() (string, bool) {
+		return "", node1.HasQuorum() && node2.HasQuorum()
+	}, time.Second*10)
+	if err := node1.Put(data); err != nil && !common.IsReroute(err) {
+		t.Errorf("wanted writes to be accepted after the ring reached 2 nodes, got %v", err)
+	}
+}
+
+func TestQuorumTimeout(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:16291", "127.0.0.1:16291", "").SetMinNodes(2)
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn("127.0.0.1:16291")
+	conn.SetQuorumTimeout(time.Millisecond * 300)
+	start := time.Now()
+	if err := conn.TryPut([]byte("k"), []byte("v")); !common.IsNoQuorum(err) {
+		t.Errorf("wanted %v after the quorum timeout, got %v", common.ErrNoQuorum, err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < time.Millisecond*300 || elapsed > time.Second*5 {
+		t.Errorf("wanted the write to be retried until the quorum timeout, but it returned after %v", elapsed)
+	}
+}
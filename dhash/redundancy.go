@@ -0,0 +1,124 @@
+package dhash
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+const (
+	defaultRedundancyGracePeriod = time.Hour
+	// verifyPasses is how many times VerifyReplicas pushes the owned range to a replica before giving up on it converging under concurrent writes.
+	verifyPasses = 3
+)
+
+// SetRedundancyGracePeriod will set for how long after the redundancy is lowered this Node keeps the replicas it no longer needs, before its cleaning starts removing them.
+func (self *Node) SetRedundancyGracePeriod(d time.Duration) *Node {
+	atomic.StoreInt64(&self.redundancyGrace, int64(d))
+	return self
+}
+
+// RedundancyGracePeriod returns for how long after the redundancy is lowered this Node keeps the replicas it no longer needs.
+func (self *Node) RedundancyGracePeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.redundancyGrace))
+}
+
+// withinRedundancyGracePeriod returns whether the redundancy was lowered too recently for this Node to remove excess replicas.
+// The time the redundancy was lowered is kept in the cluster configuration, so the grace period survives restarts.
+func (self *Node) withinRedundancyGracePeriod() bool {
+	loweredAt := atomic.LoadInt64(&self.redundancyLowered)
+	conf, _ := self.tree.Configuration()
+	if confLoweredAt, err := strconv.ParseInt(conf[common.RedundancyLoweredConf], 10, 64); err == nil && confLoweredAt > loweredAt {
+		loweredAt = confLoweredAt
+	}
+	return loweredAt != 0 && self.timer.ContinuousTime()-loweredAt < int64(self.RedundancyGracePeriod())
+}
+
+// applyConfiguredRedundancy will make this Node use the redundancy in the cluster configuration, if there is one.
+func (self *Node) applyConfiguredRedundancy() {
+	conf, _ := self.tree.Configuration()
+	if r, err := strconv.Atoi(conf[common.RedundancyConf]); err == nil && r > 0 && int64(r) != atomic.LoadInt64(&self.redundancy) {
+		self.ApplyRedundancy(r)
+	}
+}
+
+// VerifyReplicas returns whether the data owned by this Node is present on the replicas it would have with redundancy r.
+// It pushes the owned data to each of those replicas until a push finds nothing missing.
+func (self *Node) VerifyReplicas(r int) bool {
+	selfRemote := self.node.Remote()
+	pred := self.node.GetPredecessor()
+	myPos := self.node.GetPosition()
+	nextSuccessor := self.node.GetSuccessor()
+	for i := 0; i < r-1 && i < len(self.node.GetNodes())-1; i++ {
+		var x int
+		if err := nextSuccessor.Call("DHash.Owned", 0, &x); err != nil {
+			return false
+		}
+		remoteHash := newRemoteHashTree(self, selfRemote, nextSuccessor)
+		converged := false
+		for pass := 0; pass < verifyPasses && !converged; pass++ {
+			sync := radix.NewSync(self.tree, remoteHash).From(pred.Pos).To(myPos).Limit(self.limiter).Run()
+			if sync.Err() != nil {
+				return false
+			}
+			converged = sync.PutCount() == 0
+		}
+		if !converged {
+			return false
+		}
+		nextSuccessor = self.node.GetSuccessorForRemote(nextSuccessor)
+	}
+	return true
+}
+
+// ApplyRedundancy will make this Node use redundancy r. If r is lower than the redundancy it used before, the excess replicas are kept for the redundancy grace period.
+func (self *Node) ApplyRedundancy(r int) {
+	if int64(r) < atomic.SwapInt64(&self.redundancy, int64(r)) {
+		atomic.StoreInt64(&self.redundancyLowered, self.timer.ContinuousTime())
+	}
+	common.SetRedundancy(r)
+}
+
+// SetRedundancy will change the redundancy of the entire cluster to r.
+// Before lowering the redundancy it verifies that all nodes have their data on the replicas they would have with r, and refuses to lower it if any node fails.
+// The redundancy, and when it was lowered, is stored in the cluster configuration of every node. Nodes that can't be reached get it through the
+// regular sync of the configuration, and restarted nodes restore it from their persisted configuration.
+// After lowering it, the excess replicas are kept for the redundancy grace period of each node, and then removed gradually by their regular cleaning.
+func (self *Node) SetRedundancy(r int) (err error) {
+	if r < 1 {
+		return fmt.Errorf("Redundancy must be at least 1, not %v", r)
+	}
+	nodes := self.node.GetNodes()
+	lowering := r < common.GetRedundancy()
+	if lowering {
+		for _, node := range nodes {
+			var verified bool
+			if err = node.Call("DHash.VerifyReplicas", r, &verified); err != nil {
+				return
+			}
+			if !verified {
+				return fmt.Errorf("%v doesn't have its data on %v replicas, refusing to lower the redundancy", node, r)
+			}
+		}
+	}
+	items := []common.ConfItem{common.ConfItem{Key: common.RedundancyConf, Value: strconv.Itoa(r)}}
+	if lowering {
+		items = append([]common.ConfItem{common.ConfItem{Key: common.RedundancyLoweredConf, Value: strconv.FormatInt(self.timer.ContinuousTime(), 10)}}, items...)
+	}
+	for _, node := range nodes {
+		var x int
+		for _, item := range items {
+			if callErr := node.Call("DHash.AddConfiguration", item, &x); callErr != nil {
+				if err == nil {
+					err = callErr
+				}
+				break
+			}
+		}
+	}
+	return
+}
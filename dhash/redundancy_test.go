@@ -0,0 +1,48 @@
+package dhash
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+func TestRedundancy(t *testing.T) {
+	dhashes := testStartup(t, 3, 13491)
+	defer stopServers(dhashes)
+	defer common.SetRedundancy(3)
+	dhashes[0].tree.Put([]byte{3}, []byte{0}, 1)
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, []byte{3}, []byte{0})
+		return fmt.Sprint(having), having == 3
+	}, time.Second*10)
+	if err := dhashes[0].SetRedundancy(0); err == nil {
+		t.Errorf("a redundancy of 0 should be refused")
+	}
+	if err := dhashes[0].SetRedundancy(2); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, d := range dhashes {
+		conf, _ := d.tree.Configuration()
+		if conf[common.RedundancyConf] != "2" || conf[common.RedundancyLoweredConf] == "" {
+			t.Errorf("%v should have the lowered redundancy in its configuration, but has %v", d, conf)
+		}
+		atomic.StoreInt64(&d.redundancyLowered, 0)
+		if !d.withinRedundancyGracePeriod() {
+			t.Errorf("%v should keep the grace period from its configuration when it forgets when the redundancy was lowered", d)
+		}
+	}
+	time.Sleep(time.Second * 3)
+	if having := countHaving(t, dhashes, []byte{3}, []byte{0}); having != 3 {
+		t.Errorf("the excess replica should be kept during the grace period, but %v nodes have the key", having)
+	}
+	for _, d := range dhashes {
+		d.SetRedundancyGracePeriod(0)
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		having := countHaving(t, dhashes, []byte{3}, []byte{0})
+		return fmt.Sprint(having), having == 2
+	}, time.Second*10)
+}
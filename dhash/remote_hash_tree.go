@@ -1,19 +1,57 @@
 package dhash
 
 import (
+	"sync"
+
 	"github.com/zond/god/common"
 	"github.com/zond/god/radix"
 )
 
+// remoteHashTree is a radix.FallibleHashTree calling the HashTree service of destination, on behalf of node at source.
 type remoteHashTree struct {
 	destination common.Remote
 	source      common.Remote
 	node        *Node
+	failure     *remoteFailure
+}
+
+// remoteFailure is the first error of the calls of a remoteHashTree.
+type remoteFailure struct {
+	lock *sync.Mutex
+	err  error
+}
+
+func newRemoteHashTree(node *Node, source, destination common.Remote) remoteHashTree {
+	return remoteHashTree{
+		destination: destination,
+		source:      source,
+		node:        node,
+		failure:     &remoteFailure{lock: new(sync.Mutex)},
+	}
+}
+
+// call will call service at the destination, and remember the error if it is the first one.
+func (self remoteHashTree) call(service string, args, reply interface{}) (err error) {
+	if err = self.destination.Call(service, args, reply); err != nil {
+		self.failure.lock.Lock()
+		defer self.failure.lock.Unlock()
+		if self.failure.err == nil {
+			self.failure.err = err
+		}
+	}
+	return
+}
+
+// Err returns the first error of the calls of this remoteHashTree.
+func (self remoteHashTree) Err() error {
+	self.failure.lock.Lock()
+	defer self.failure.lock.Unlock()
+	return self.failure.err
 }
 
 func (self remoteHashTree) Configuration() (conf map[string]string, timestamp int64) {
 	var result common.Conf
-	if err := self.destination.Call("DHash.Configuration", 0, &result); err != nil {
+	if err := self.call("DHash.Configuration", 0, &result); err != nil {
 		conf = make(map[string]string)
 	} else {
 		conf, timestamp = result.Data, result.Timestamp
@@ -22,7 +60,7 @@ func (self remoteHashTree) Configuration() (conf map[string]string, timestamp in
 }
 func (self remoteHashTree) SubConfiguration(key []byte) (conf map[string]string, timestamp int64) {
 	var result common.Conf
-	if err := self.destination.Call("DHash.SubConfiguration", key, &result); err != nil {
+	if err := self.call("DHash.SubConfiguration", key, &result); err != nil {
 		conf = make(map[string]string)
 	} else {
 		conf, timestamp = result.Data, result.Timestamp
@@ -31,31 +69,42 @@ func (self remoteHashTree) SubConfiguration(key []byte) (conf map[string]string,
 }
 func (self remoteHashTree) Configure(conf map[string]string, timestamp int64) {
 	var x int
-	self.destination.Call("HashTree.Configure", common.Conf{
+	self.call("HashTree.Configure", common.Conf{
 		Data:      conf,
 		Timestamp: timestamp,
 	}, &x)
 }
 func (self remoteHashTree) SubConfigure(key []byte, conf map[string]string, timestamp int64) {
 	var x int
-	self.destination.Call("HashTree.SubConfigure", common.Conf{
+	self.call("HashTree.SubConfigure", common.Conf{
 		TreeKey:   key,
 		Data:      conf,
 		Timestamp: timestamp,
 	}, &x)
 }
 func (self remoteHashTree) Hash() (result []byte) {
-	self.destination.Call("HashTree.Hash", 0, &result)
+	self.call("HashTree.Hash", 0, &result)
 	return
 }
 func (self remoteHashTree) Finger(key []radix.Nibble) (result *radix.Print) {
 	result = &radix.Print{}
-	self.destination.Call("HashTree.Finger", key, result)
+	self.call("HashTree.Finger", key, result)
+	return
+}
+
+// Fingers will fetch the prints of keys in one call, or one at a time from nodes too old to support it.
+func (self remoteHashTree) Fingers(keys [][]radix.Nibble) (result []*radix.Print) {
+	if err := self.destination.Call("HashTree.Fingers", keys, &result); err != nil || len(result) != len(keys) {
+		result = make([]*radix.Print, len(keys))
+		for index, key := range keys {
+			result[index] = self.Finger(key)
+		}
+	}
 	return
 }
 func (self remoteHashTree) GetTimestamp(key []radix.Nibble) (value []byte, timestamp int64, present bool) {
 	result := HashTreeItem{}
-	self.destination.Call("HashTree.GetTimestamp", key, &result)
+	self.call("HashTree.GetTimestamp", key, &result)
 	value, timestamp, present = result.Value, result.Timestamp, result.Exists
 	return
 }
@@ -76,7 +125,7 @@ func (self remoteHashTree) PutTimestamp(key []radix.Nibble, value []byte, presen
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &changed)
+	self.call(op, data, &changed)
 	return
 }
 func (self remoteHashTree) DelTimestamp(key []radix.Nibble, expected int64) (changed bool) {
@@ -93,7 +142,7 @@ func (self remoteHashTree) DelTimestamp(key []radix.Nibble, expected int64) (cha
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &changed)
+	self.call(op, data, &changed)
 	return
 }
 func (self remoteHashTree) SubFinger(key, subKey []radix.Nibble) (result *radix.Print) {
@@ -102,7 +151,7 @@ func (self remoteHashTree) SubFinger(key, subKey []radix.Nibble) (result *radix.
 		SubKey: subKey,
 	}
 	result = &radix.Print{}
-	self.destination.Call("HashTree.SubFinger", data, result)
+	self.call("HashTree.SubFinger", data, result)
 	return
 }
 func (self remoteHashTree) SubGetTimestamp(key, subKey []radix.Nibble) (value []byte, timestamp int64, present bool) {
@@ -110,7 +159,7 @@ func (self remoteHashTree) SubGetTimestamp(key, subKey []radix.Nibble) (value []
 		Key:    key,
 		SubKey: subKey,
 	}
-	self.destination.Call("HashTree.SubGetTimestamp", data, &data)
+	self.call("HashTree.SubGetTimestamp", data, &data)
 	value, timestamp, present = data.Value, data.Timestamp, data.Exists
 	return
 }
@@ -133,7 +182,7 @@ func (self remoteHashTree) SubPutTimestamp(key, subKey []radix.Nibble, value []b
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &changed)
+	self.call(op, data, &changed)
 	return
 }
 func (self remoteHashTree) SubDelTimestamp(key, subKey []radix.Nibble, subExpected int64) (changed bool) {
@@ -152,7 +201,7 @@ func (self remoteHashTree) SubDelTimestamp(key, subKey []radix.Nibble, subExpect
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &changed)
+	self.call(op, data, &changed)
 	return
 }
 func (self remoteHashTree) SubClearTimestamp(key []radix.Nibble, expected, timestamp int64) (deleted int) {
@@ -170,7 +219,7 @@ func (self remoteHashTree) SubClearTimestamp(key []radix.Nibble, expected, times
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &deleted)
+	self.call(op, data, &deleted)
 	return
 }
 func (self remoteHashTree) SubKillTimestamp(key []radix.Nibble, expected int64) (deleted int) {
@@ -187,6 +236,6 @@ func (self remoteHashTree) SubKillTimestamp(key []radix.Nibble, expected int64)
 			Type:        op,
 		})
 	}
-	self.destination.Call(op, data, &deleted)
+	self.call(op, data, &deleted)
 	return
 }
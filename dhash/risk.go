@@ -0,0 +1,135 @@
+package dhash
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// recordUnsynced will remember when this Node first received a write to its owned range since its last sync with its replicas.
+func (self *Node) recordUnsynced(key []byte) {
+	if atomic.LoadInt64(&self.firstUnsynced) != 0 {
+		return
+	}
+	if common.BetweenIE(key, self.node.GetPredecessor().Pos, self.node.GetPosition()) {
+		atomic.CompareAndSwapInt64(&self.firstUnsynced, 0, time.Now().UnixNano())
+	}
+}
+
+// recordSynced will remember when the syncs started at syncStart started finding differences.
+// If the syncs with all replicas succeeded, it will also forget the unsynced writes received before syncStart, and that the syncs found differences if they found none.
+func (self *Node) recordSynced(syncStart int64, diverged, succeeded bool) {
+	if diverged {
+		atomic.CompareAndSwapInt64(&self.divergedSince, 0, syncStart)
+	}
+	if !succeeded {
+		return
+	}
+	if first := atomic.LoadInt64(&self.firstUnsynced); first != 0 && first < syncStart {
+		atomic.CompareAndSwapInt64(&self.firstUnsynced, first, 0)
+	}
+	if !diverged {
+		atomic.StoreInt64(&self.divergedSince, 0)
+	}
+}
+
+// Risk returns how far the data owned by this Node may be from being safely replicated.
+func (self *Node) Risk() (result common.NodeRisk) {
+	result = common.NodeRisk{
+		Addr:             self.GetBroadcastAddr(),
+		Zone:             self.Zone(),
+		ConvergenceBound: self.ConvergenceBound(),
+	}
+	now := time.Now().UnixNano()
+	if first := atomic.LoadInt64(&self.firstUnsynced); first != 0 {
+		result.OldestUnsynced = time.Duration(now - first)
+	}
+	if since := atomic.LoadInt64(&self.divergedSince); since != 0 {
+		result.DivergenceAge = time.Duration(now - since)
+	}
+	return
+}
+
+// RiskReport collects the Risk of all Nodes in the ring of this Node, and estimates the data loss exposure of each range owned by them.
+func (self *Node) RiskReport() (result common.RiskReport) {
+	nodes := self.node.GetNodes()
+	risks := make([]common.NodeRisk, len(nodes))
+	errs := make([]error, len(nodes))
+	done := make(chan bool, len(nodes))
+	for index, node := range nodes {
+		go func(index int, node common.Remote) {
+			if node.Addr == self.GetBroadcastAddr() {
+				risks[index] = self.Risk()
+			} else {
+				errs[index] = node.Call("DHash.Risk", 0, &risks[index])
+			}
+			done <- true
+		}(index, node)
+	}
+	for _, _ = range nodes {
+		<-done
+	}
+	result.Redundancy = common.GetRedundancy()
+	hosts := make(map[string][]string)
+	for index, node := range nodes {
+		if errs[index] != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[node.Addr] = errs[index].Error()
+			continue
+		}
+		risk := risks[index]
+		result.Nodes = append(result.Nodes, risk)
+		hosts[common.Host(node.Addr)] = append(hosts[common.Host(node.Addr)], node.Addr)
+		if risk.OldestUnsynced > result.OldestUnsynced {
+			result.OldestUnsynced = risk.OldestUnsynced
+		}
+		if risk.DivergenceAge > result.MaxDivergenceAge {
+			result.MaxDivergenceAge = risk.DivergenceAge
+		}
+		if !risk.ConvergenceMet() {
+			result.Lagging = append(result.Lagging, risk.Addr)
+		}
+	}
+	for host, addrs := range hosts {
+		if len(addrs) > 1 {
+			if result.SharedHosts == nil {
+				result.SharedHosts = make(map[string][]string)
+			}
+			sort.Strings(addrs)
+			result.SharedHosts[host] = addrs
+		}
+	}
+	for index, node := range nodes {
+		r := common.RangeRisk{
+			From:  nodes[(index+len(nodes)-1)%len(nodes)].Pos,
+			To:    node.Pos,
+			Owner: node.Addr,
+		}
+		rangeHosts := make(map[string]bool)
+		rangeZones := make(map[string]bool)
+		zonesKnown := true
+		for i := 0; i < result.Redundancy && i < len(nodes); i++ {
+			replica := (index + i) % len(nodes)
+			if errs[replica] != nil {
+				continue
+			}
+			r.Replicas = append(r.Replicas, nodes[replica].Addr)
+			rangeHosts[common.Host(nodes[replica].Addr)] = true
+			rangeZones[risks[replica].Zone] = true
+			zonesKnown = zonesKnown && risks[replica].Zone != ""
+		}
+		r.Hosts, r.Zones = len(rangeHosts), len(rangeZones)
+		result.Ranges = append(result.Ranges, r)
+		if len(r.Replicas) < result.Redundancy {
+			result.UnderReplicated = append(result.UnderReplicated, r)
+		}
+		if r.Hosts < len(r.Replicas) || (zonesKnown && r.Zones < len(r.Replicas)) {
+			result.Colocated = append(result.Colocated, r)
+		}
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package dhash
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestRiskReport(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:14091", "127.0.0.1:14091", "").SetZone("a")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:14191", "127.0.0.1:14191", "").SetZone("b")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:14091")
+	conn := client.MustConn("127.0.0.1:14091")
+	var report common.RiskReport
+	common.AssertWithin(t, func() (string, bool) {
+		var err error
+		report, err = conn.RiskReport()
+		return fmt.Sprint(err, report), err == nil && len(report.Nodes) == 2 && len(report.Ranges) == 2
+	}, time.Second*10)
+	if len(report.UnderReplicated) != 2 {
+		t.Errorf("wanted both ranges to have fewer replicas than %v, got %+v", report.Redundancy, report.UnderReplicated)
+	}
+	if len(report.Colocated) != 2 || len(report.SharedHosts["127.0.0.1"]) != 2 {
+		t.Errorf("wanted both ranges and nodes to share 127.0.0.1, got %+v and %+v", report.Colocated, report.SharedHosts)
+	}
+	if p := report.LossProbability(0.5); p != 0.75 {
+		t.Errorf("wanted a loss probability of 0.75, got %v", p)
+	}
+	node1.PauseSync()
+	node2.PauseSync()
+	conn.SPut([]byte("k"), []byte("v"))
+	if risk := node1.Risk(); risk.OldestUnsynced == 0 {
+		if risk = node2.Risk(); risk.OldestUnsynced == 0 {
+			t.Errorf("wanted the owner of k to report an unsynced write")
+		}
+	}
+	node1.Sync()
+	node2.Sync()
+	if report, _ = conn.RiskReport(); report.OldestUnsynced != 0 || report.MaxDivergenceAge != 0 {
+		t.Errorf("wanted no unsynced writes or divergence after syncing, got %+v", report)
+	}
+}
+
+func TestRecordSynced(t *testing.T) {
+	node := NewNodeDir("127.0.0.1:17191", "127.0.0.1:17191", "")
+	atomic.StoreInt64(&node.firstUnsynced, 1)
+	node.recordSynced(2, true, false)
+	if risk := node.Risk(); risk.OldestUnsynced == 0 || risk.DivergenceAge == 0 {
+		t.Errorf("wanted a failed sync to keep the unsynced write and record the divergence, got %+v", risk)
+	}
+	node.recordSynced(3, false, true)
+	if risk := node.Risk(); risk.OldestUnsynced != 0 || risk.DivergenceAge != 0 {
+		t.Errorf("wanted a successful sync to forget the unsynced write and the divergence, got %+v", risk)
+	}
+}
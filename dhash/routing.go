@@ -0,0 +1,22 @@
+package dhash
+
+import (
+	"github.com/zond/god/common"
+)
+
+const (
+	// rerouteAttempts is how many times operations forwarded on behalf of JSON clients are retried when rerouted.
+	rerouteAttempts = 8
+	// rerouteBackoff is how long to wait for the ring to converge before retrying a rerouted operation.
+	rerouteBackoff = common.PingInterval / 4
+)
+
+// assertOwner will return common.ErrReroute unless this node is the primary owner of key according to its view of the ring.
+// Clients with an outdated ring will then refresh it, instead of writing data to a node that won't keep it.
+// Reads are not checked, since they are sent to all replicas and the most recent answer wins anyway.
+func (self *Node) assertOwner(key []byte) error {
+	if replicas := self.node.Replicas(key); len(replicas) > 0 && replicas[0].Addr != self.node.GetBroadcastAddr() {
+		return common.ErrReroute
+	}
+	return nil
+}
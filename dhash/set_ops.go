@@ -15,8 +15,12 @@ type treeSkipper struct {
 	key          []byte
 	tree         *radix.Tree
 	remote       common.Remote
+	cache        *subTreeCache
+	hash         []byte
 	buffer       []setop.SetOpResult
 	currentIndex int
+	// codec decodes the values read from tree, since the values read from remote nodes are decoded by them.
+	codec Codec
 }
 
 func (self *treeSkipper) Skip(min []byte, inc bool) (result *setop.SetOpResult, err error) {
@@ -80,27 +84,55 @@ func (self *treeSkipper) refill(min []byte, inc bool) (err error) {
 }
 
 func (self *treeSkipper) remoteRefill(min []byte, inc bool) (err error) {
+	if self.cache != nil {
+		if segment, ok := self.cache.get(self.key, self.hash, min, inc); ok {
+			self.buffer = append(self.buffer, segment...)
+			return
+		}
+	}
 	r := common.Range{
 		Key:    self.key,
 		Min:    min,
 		MinInc: inc,
 		Len:    setOpBufferSize,
+		QoS:    common.Batch,
+	}
+	if self.cache == nil {
+		var items []common.Item
+		if err = self.remote.Call("DHash.SetOpSlice", r, &items); err != nil {
+			return
+		}
+		self.fill(items)
+		return
 	}
-	var items []common.Item
-	if err = self.remote.Call("DHash.SliceLen", r, &items); err != nil {
+	var segment SetOpSegment
+	if err = self.remote.Call("DHash.SetOpSegment", r, &segment); err != nil {
 		return
 	}
+	self.fill(segment.Items)
+	// Only cache the segment if the sub tree didn't change since we got its hash.
+	if bytes.Compare(segment.Hash, self.hash) == 0 {
+		self.cache.put(self.key, self.hash, min, inc, append([]setop.SetOpResult(nil), self.buffer...))
+	}
+	return
+}
+
+func (self *treeSkipper) fill(items []common.Item) {
 	for _, item := range items {
 		self.buffer = append(self.buffer, setop.SetOpResult{item.Key, [][]byte{item.Value}})
 	}
-	return
 }
 
-func (self *treeSkipper) treeRefill(min []byte, inc bool) error {
+func (self *treeSkipper) treeRefill(min []byte, inc bool) (err error) {
 	filler := func(key, value []byte, timestamp int64) bool {
+		if self.codec != nil {
+			if value, err = self.codec.Decode(self.key, key, value); err != nil {
+				return false
+			}
+		}
 		self.buffer = append(self.buffer, setop.SetOpResult{key, [][]byte{value}})
 		return len(self.buffer) < setOpBufferSize
 	}
 	self.tree.SubEachBetween(self.key, min, nil, inc, false, filler)
-	return nil
+	return
 }
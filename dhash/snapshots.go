@@ -0,0 +1,219 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/radix"
+)
+
+const (
+	// snapshotDivergence is the fraction of the entries of a range that must differ between two replicas, first as estimated by a walk of the top levels of their Merkle trees
+	// and then as measured by the hashes of the chunks of the range, before compressed snapshots of the differing chunks are shipped instead of walking the Merkle trees key by key.
+	snapshotDivergence = 0.5
+	// snapshotProbeDepth is how many levels of the Merkle trees are walked to estimate how much two replicas differ.
+	snapshotProbeDepth = 2
+	// snapshotMinSize is the smallest range size where shipping snapshots is considered.
+	snapshotMinSize = 1024
+	// snapshotChunkSize is the approximate number of entries in each chunk of a range compared and shipped as a snapshot.
+	snapshotChunkSize = 1024
+)
+
+// SnapshotChunks divides Range into chunks, the first starting at Range.Min and the others at each of Bounds.
+type SnapshotChunks struct {
+	Range  common.Range
+	Bounds [][]byte
+}
+
+// ranges returns the range of each chunk.
+func (self SnapshotChunks) ranges() (result []common.Range) {
+	min := self.Range.Min
+	for _, bound := range self.Bounds {
+		result = append(result, common.Range{Min: min, Max: bound, QoS: self.Range.QoS})
+		min = bound
+	}
+	return append(result, common.Range{Min: min, Max: self.Range.Max, QoS: self.Range.QoS})
+}
+
+// circularSnapshot will return a snapshot of the range from r.Min, inclusive, to r.Max, exclusive, considering the namespace circular.
+func (self *Node) circularSnapshot(r common.Range) (result []radix.SnapshotEntry) {
+	if bytes.Compare(r.Min, r.Max) < 0 {
+		return self.tree.SnapshotBetween(r.Min, r.Max, true, false)
+	}
+	return append(self.tree.SnapshotBetween(r.Min, nil, true, false), self.tree.SnapshotBetween(nil, r.Max, true, false)...)
+}
+
+// circularEachSnapshotHash will call f with each key in the range from r.Min, inclusive, to r.Max, exclusive, considering the namespace circular,
+// and the number and hash of its snapshot entries, like radix.Tree.EachSnapshotHashBetween.
+func (self *Node) circularEachSnapshotHash(r common.Range, f func(key []byte, entries int, hash []byte) bool) {
+	if bytes.Compare(r.Min, r.Max) < 0 {
+		self.tree.EachSnapshotHashBetween(r.Min, r.Max, true, false, f)
+		return
+	}
+	self.tree.EachSnapshotHashBetween(r.Min, nil, true, false, f)
+	self.tree.EachSnapshotHashBetween(nil, r.Max, true, false, f)
+}
+
+// circularRealSize will return the real size of the range from r.Min, inclusive, to r.Max, exclusive, considering the namespace circular.
+func (self *Node) circularRealSize(r common.Range) int {
+	if bytes.Compare(r.Min, r.Max) < 0 {
+		return self.tree.RealSizeBetween(r.Min, r.Max, true, false)
+	}
+	return self.tree.RealSizeBetween(r.Min, nil, true, false) + self.tree.RealSizeBetween(nil, r.Max, true, false)
+}
+
+// snapshotChunks will divide r into chunks of about snapshotChunkSize entries in this node. Sub tree values of the same key always end up in the same chunk.
+func (self *Node) snapshotChunks(r common.Range) (result SnapshotChunks) {
+	result.Range = r
+	entries := 0
+	self.circularEachSnapshotHash(r, func(key []byte, n int, hash []byte) bool {
+		if entries >= snapshotChunkSize {
+			result.Bounds = append(result.Bounds, key)
+			entries = 0
+		}
+		entries += n
+		return true
+	})
+	return
+}
+
+// snapshotHashes will return the hash of the entries in each of chunks in this node, made from the cached hashes of the values and sub trees.
+func (self *Node) snapshotHashes(chunks SnapshotChunks) (result [][]byte) {
+	for _, r := range chunks.ranges() {
+		hash := murmur.New()
+		self.circularEachSnapshotHash(r, func(key []byte, entries int, entryHash []byte) bool {
+			hash.MustWrite(entryHash)
+			return true
+		})
+		result = append(result, hash.Get())
+	}
+	return
+}
+
+// shipSnapshot will, if a walk of the top snapshotProbeDepth levels of the Merkle trees of this node and remote estimates that more than snapshotDivergence
+// of the entries of the range from, to differ, compare the hashes of the chunks of the range in this node and in remote, and if more than snapshotDivergence
+// of them differ exchange snapshots of the differing chunks, one chunk per call, keeping the newest version of each entry on both sides.
+// The chunks are defined by the bigger side, so that no chunk is much bigger than snapshotChunkSize on either side.
+// It returns the number of entries pushed to and pulled from remote.
+func (self *Node) shipSnapshot(remote common.Remote, from, to []byte) (pushed, pulled int, err error) {
+	remoteHash := newRemoteHashTree(self, self.node.Remote(), remote)
+	probe := radix.NewSync(self.tree, remoteHash)
+	if bytes.Compare(from, to) != 0 {
+		// equal limits mean the whole namespace here, but an empty range to a radix.Sync
+		probe.From(from).To(to)
+	}
+	if probe.Divergence(snapshotProbeDepth) < snapshotDivergence {
+		err = remoteHash.Err()
+		return
+	}
+	r := common.Range{
+		Min: from,
+		Max: to,
+		QoS: common.Batch,
+	}
+	localSize := self.circularRealSize(r)
+	var remoteSize int
+	if err = remote.Call("HashTree.RealSize", r, &remoteSize); err != nil {
+		return
+	}
+	if common.Max(localSize, remoteSize) < snapshotMinSize {
+		return
+	}
+	var chunks SnapshotChunks
+	if localSize >= remoteSize {
+		chunks = self.snapshotChunks(r)
+	} else if err = remote.Call("HashTree.SnapshotChunks", r, &chunks); err != nil {
+		return
+	}
+	localHashes := self.snapshotHashes(chunks)
+	var remoteHashes [][]byte
+	if err = remote.Call("HashTree.SnapshotHashes", chunks, &remoteHashes); err != nil {
+		return
+	}
+	if len(remoteHashes) != len(localHashes) {
+		err = fmt.Errorf("%v returned %v snapshot hashes for %v chunks", remote, len(remoteHashes), len(localHashes))
+		return
+	}
+	var differing []common.Range
+	for index, chunk := range chunks.ranges() {
+		if bytes.Compare(localHashes[index], remoteHashes[index]) != 0 {
+			differing = append(differing, chunk)
+		}
+	}
+	if float64(len(differing))/float64(len(localHashes)) < snapshotDivergence {
+		return
+	}
+	for _, chunk := range differing {
+		var n int
+		if n, err = self.pushSnapshot(remote, chunk); err != nil {
+			return
+		}
+		pushed += n
+		if n, err = self.pullSnapshot(remote, chunk); err != nil {
+			return
+		}
+		pulled += n
+	}
+	return
+}
+
+// pushSnapshot will ship a snapshot of the range r in this node to remote, and return the number of entries remote changed.
+func (self *Node) pushSnapshot(remote common.Remote, r common.Range) (changed int, err error) {
+	snapshot := self.circularSnapshot(r)
+	if len(snapshot) == 0 {
+		return
+	}
+	encoded, err := radix.EncodeSnapshot(snapshot)
+	if err != nil {
+		return
+	}
+	self.limiter.Wait(len(snapshot), len(encoded))
+	err = remote.Call("HashTree.ApplySnapshot", encoded, &changed)
+	return
+}
+
+// pullSnapshot will fetch a snapshot of the range r in remote, apply it to this node, and return the number of entries changed.
+// The entries are put in the tree like any other synchronized entries, so the write listeners of this node are notified of them,
+// and like them they are applied even if they are in frozen ranges or change write-once keys, to let the replicas converge.
+func (self *Node) pullSnapshot(remote common.Remote, r common.Range) (changed int, err error) {
+	var encoded []byte
+	if err = remote.Call("HashTree.Snapshot", r, &encoded); err != nil {
+		return
+	}
+	snapshot, err := radix.DecodeSnapshot(encoded)
+	if err != nil {
+		return
+	}
+	self.limiter.Wait(len(snapshot), len(encoded))
+	changed = self.tree.ApplySnapshot(snapshot)
+	return
+}
+
+// Snapshot will return a compressed snapshot of the data owned by this node, outside the system keyspace.
+func (self *Node) Snapshot() ([]byte, error) {
+	return radix.EncodeSnapshot(self.unreservedSnapshot(self.circularSnapshot(common.Range{
+		Min: self.node.GetPredecessor().Pos,
+		Max: self.node.GetPosition(),
+	})))
+}
+
+// Restore will apply a compressed snapshot to this node, keeping only the entries newer than the ones already present,
+// and skipping the entries that would change or delete values of write-once keys. It returns common.ErrFrozen, without applying anything, if any entry is in a frozen range,
+// and common.ErrReserved if any entry is in the system keyspace.
+// The entries will reach the replicas of this node during the next sync.
+func (self *Node) Restore(encoded []byte) (changed int, err error) {
+	snapshot, err := radix.DecodeSnapshot(encoded)
+	if err != nil {
+		return
+	}
+	if err = self.assertSnapshotNotFrozen(snapshot); err != nil {
+		return
+	}
+	if err = self.assertSnapshotNotReserved(snapshot); err != nil {
+		return
+	}
+	changed = self.tree.ApplySnapshot(self.mutableSnapshot(snapshot))
+	return
+}
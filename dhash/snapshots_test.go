@@ -0,0 +1,61 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zond/god/murmur"
+	"github.com/zond/god/radix"
+)
+
+func TestShipSnapshot(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:16391", "127.0.0.1:16391", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:16491", "127.0.0.1:16491", "")
+	node2.MustStart()
+	defer node2.Stop()
+	// Both nodes have the same keys, but node2 has newer values for the first half and node1 for the second.
+	size := snapshotChunkSize * 3
+	for i := 0; i < size; i++ {
+		key := murmur.HashString(fmt.Sprint(i))
+		if i < size/2 {
+			node1.tree.Put(key, []byte("old"), 1)
+			node2.tree.Put(key, []byte("new"), 2)
+		} else {
+			node1.tree.Put(key, []byte("new"), 2)
+			node2.tree.Put(key, []byte("old"), 1)
+		}
+	}
+	if node1.tree.RealSize() != node2.tree.RealSize() {
+		t.Fatalf("wanted equally sized trees, got %v and %v", node1.tree.RealSize(), node2.tree.RealSize())
+	}
+	var written int32
+	node1.AddWriteListener(func(write radix.Write) bool {
+		atomic.AddInt32(&written, 1)
+		return true
+	})
+	pushed, pulled, err := node1.shipSnapshot(node2.node.Remote(), node1.node.GetPosition(), node1.node.GetPosition())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if pushed != size/2 || pulled != size/2 {
+		t.Errorf("wanted %v entries pushed and pulled, got %v and %v", size/2, pushed, pulled)
+	}
+	if bytes.Compare(node1.tree.Hash(), node2.tree.Hash()) != 0 {
+		t.Errorf("wanted the trees to be equal after shipping snapshots")
+	}
+	if n := atomic.LoadInt32(&written); n != int32(size/2) {
+		t.Errorf("wanted the write listeners to be notified of %v pulled entries, got %v", size/2, n)
+	}
+	if pushed, pulled, err = node1.shipSnapshot(node2.node.Remote(), node1.node.GetPosition(), node1.node.GetPosition()); err != nil || pushed != 0 || pulled != 0 {
+		t.Errorf("wanted nothing shipped between equal trees, got %v, %v and %v", pushed, pulled, err)
+	}
+	// A few differing keys are left for the Merkle tree walk.
+	node2.tree.Put(murmur.HashString("0"), []byte("newer"), 3)
+	if pushed, pulled, err = node1.shipSnapshot(node2.node.Remote(), node1.node.GetPosition(), node1.node.GetPosition()); err != nil || pushed != 0 || pulled != 0 {
+		t.Errorf("wanted nothing shipped between slightly different trees, got %v, %v and %v", pushed, pulled, err)
+	}
+}
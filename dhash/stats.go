@@ -0,0 +1,103 @@
+package dhash
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// statsInterval is how often a Node updates its request rates.
+	statsInterval = time.Second
+	// rateAlpha is the weight of the last interval in the request rates of a Node.
+	rateAlpha = 0.25
+)
+
+func (self *Node) logBytes() (result int64) {
+	if self.dir == "" {
+		return
+	}
+	filepath.Walk(self.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			result += info.Size()
+		}
+		return nil
+	})
+	return
+}
+func (self *Node) updateRequestRates() {
+	requests := self.node.Requests()
+	self.statsLock.Lock()
+	defer self.statsLock.Unlock()
+	for method, count := range requests {
+		rate := float64(count-self.lastRequests[method]) / statsInterval.Seconds()
+		self.requestRates[method] += rateAlpha * (rate - self.requestRates[method])
+	}
+	self.lastRequests = requests
+}
+
+// Stats returns the size, activity and health of this Node.
+func (self *Node) Stats() (result common.Stats) {
+	self.statsLock.Lock()
+	rates := make(map[string]float64, len(self.requestRates))
+	for method, rate := range self.requestRates {
+		rates[method] = rate
+	}
+	self.statsLock.Unlock()
+	rejectedConns, rejectedRequests := self.node.Rejected()
+	replayTokens, replays, replayEvictions := self.replayStats()
+	return common.Stats{
+		Addr:                self.GetBroadcastAddr(),
+		Uptime:              time.Now().Sub(time.Unix(0, atomic.LoadInt64(&self.startedAt))),
+		HeldEntries:         self.tree.RealSize(),
+		OwnedEntries:        self.Owned(),
+		LogBytes:            self.logBytes(),
+		Requests:            self.node.Requests(),
+		RequestRates:        rates,
+		Syncs:               atomic.LoadInt64(&self.syncs),
+		SyncedEntries:       atomic.LoadInt64(&self.syncedEntries),
+		Cleans:              atomic.LoadInt64(&self.cleans),
+		CleanedEntries:      atomic.LoadInt64(&self.cleanedEntries),
+		Migrations:          atomic.LoadInt64(&self.migrations),
+		RejectedConnections: rejectedConns,
+		RejectedRequests:    rejectedRequests,
+		ReplayTokens:        replayTokens,
+		Replays:             replays,
+		ReplayEvictions:     replayEvictions,
+		ClockOffset:         self.timer.Offset(),
+		ClockError:          self.timer.EstimatedError(),
+		PeerLatencies:       self.timer.Latencies(),
+	}
+}
+
+// ClusterStats returns the Stats of all Nodes in the ring of this Node, and their totals.
+func (self *Node) ClusterStats() (result common.ClusterStats) {
+	nodes := self.node.GetNodes()
+	stats := make([]common.Stats, len(nodes))
+	errs := make([]error, len(nodes))
+	done := make(chan bool, len(nodes))
+	for index, node := range nodes {
+		go func(index int, node common.Remote) {
+			if node.Addr == self.GetBroadcastAddr() {
+				stats[index] = self.Stats()
+			} else {
+				errs[index] = node.Call("DHash.Stats", 0, &stats[index])
+			}
+			done <- true
+		}(index, node)
+	}
+	for _, _ = range nodes {
+		<-done
+	}
+	for index, node := range nodes {
+		if errs[index] != nil {
+			result.Fail(node.Addr, errs[index])
+		} else {
+			result.Add(stats[index])
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package dhash
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "god_stats")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	node := NewNodeDir("127.0.0.1:12491", "127.0.0.1:12491", dir)
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn("127.0.0.1:12491")
+	for i := 0; i < 10; i++ {
+		conn.Put([]byte{byte(i)}, []byte("value"))
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		stats := node.Stats()
+		return stats.Addr, stats.Requests["DHash.Put"] == 10 && stats.RequestRates["DHash.Put"] > 0 && stats.HeldEntries == 10 && stats.LogBytes > 0
+	}, time.Second*5)
+	cluster, err := conn.ClusterStats()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(cluster.Nodes) != 1 || cluster.HeldEntries != 10 || cluster.OwnedEntries != 10 || len(cluster.Failed) != 0 {
+		t.Errorf("wanted stats of 1 node with 10 entries, got %+v", cluster)
+	}
+}
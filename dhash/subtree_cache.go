@@ -0,0 +1,175 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"github.com/zond/setop"
+)
+
+// subTreeCache contains the segments of remote sub trees fetched when evaluating set expressions, each valid as long as the hash of its sub tree is unchanged.
+type subTreeCache struct {
+	lock     *sync.Mutex
+	max      int
+	segments int
+	trees    map[string]*cachedSubTree
+	hits     int64
+	misses   int64
+}
+
+type cachedSubTree struct {
+	hash     []byte
+	used     time.Time
+	segments map[string][]setop.SetOpResult
+}
+
+func newSubTreeCache() *subTreeCache {
+	return &subTreeCache{
+		lock:  new(sync.Mutex),
+		trees: make(map[string]*cachedSubTree),
+	}
+}
+
+// segmentKey returns the key of the segment of a sub tree starting at min.
+func segmentKey(min []byte, inc bool) string {
+	if inc {
+		return "i" + string(min)
+	}
+	return "e" + string(min)
+}
+
+// SetSubTreeCacheSize will make this Node cache up to segments segments, of up to 128 members each, of the remote sub trees it reads when evaluating set expressions.
+// Before using a cached sub tree the Node compares its hash to the hash of the sub tree on its owner, so that repeated expressions over unchanged sub trees
+// cost one call per sub tree instead of one per segment, and changed sub trees one call more. Zero, the default, turns off the cache. It panics if segments is negative.
+func (self *Node) SetSubTreeCacheSize(segments int) *Node {
+	if segments < 0 {
+		panic(fmt.Errorf("Sub tree cache size %v is negative", segments))
+	}
+	cache := self.subTreeCache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.max = segments
+	cache.evict()
+	return self
+}
+
+// SubTreeCacheSize returns how many segments of remote sub trees this Node caches.
+func (self *Node) SubTreeCacheSize() int {
+	cache := self.subTreeCache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.max
+}
+
+// SubTreeHash returns the hash of the sub tree under key, which changes whenever the sub tree changes, or nil if there is no sub tree under key.
+func (self *Node) SubTreeHash(key []byte) []byte {
+	if print := self.tree.Finger(radix.Rip(key)); print != nil && print.SubTree {
+		return print.TreeHash
+	}
+	return nil
+}
+
+// SetOpSegment is a segment of a sub tree read for a set expression, with the hash the sub tree had while the segment was read.
+type SetOpSegment struct {
+	Items []common.Item
+	// Hash is nil if the sub tree changed while the segment was read.
+	Hash []byte
+}
+
+// setOpSegment will return the segment of the sub tree of r.Key defined by r, and the hash of the sub tree if it didn't change while the segment was read.
+func (self *Node) setOpSegment(r common.Range) (result SetOpSegment, err error) {
+	hash := self.SubTreeHash(r.Key)
+	if err = self.SliceLen(r, &result.Items); err != nil {
+		return
+	}
+	if bytes.Compare(self.SubTreeHash(r.Key), hash) == 0 {
+		result.Hash = hash
+	}
+	return
+}
+
+// remoteSubTreeHash returns the hash of the sub tree under key on remote.
+func remoteSubTreeHash(remote common.Remote, key []byte) (result []byte, err error) {
+	err = remote.Call("DHash.SubTreeHash", key, &result)
+	return
+}
+
+// skipper will return a treeSkipper reading the sub tree under key from remote through this cache, if the cache is turned on and the owner tells the hash of the sub tree.
+func (self *subTreeCache) skipper(remote common.Remote, key []byte) *treeSkipper {
+	result := &treeSkipper{
+		remote: remote,
+		key:    key,
+	}
+	self.lock.Lock()
+	enabled := self.max > 0
+	self.lock.Unlock()
+	if enabled {
+		if hash, err := remoteSubTreeHash(remote, key); err == nil && hash != nil {
+			result.cache, result.hash = self, hash
+		}
+	}
+	return result
+}
+
+// evict will forget the least recently used sub trees until the cache contains at most max segments.
+func (self *subTreeCache) evict() {
+	for self.segments > self.max {
+		var oldestKey string
+		var oldest *cachedSubTree
+		for key, tree := range self.trees {
+			if oldest == nil || tree.used.Before(oldest.used) {
+				oldestKey, oldest = key, tree
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		self.segments -= len(oldest.segments)
+		delete(self.trees, oldestKey)
+	}
+}
+
+// get returns the cached segment starting at min of the sub tree under key, if the sub tree had hash when it was cached.
+func (self *subTreeCache) get(key, hash []byte, min []byte, inc bool) (result []setop.SetOpResult, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if tree, found := self.trees[string(key)]; found && bytes.Compare(tree.hash, hash) == 0 {
+		if result, ok = tree.segments[segmentKey(min, inc)]; ok {
+			tree.used = time.Now()
+			self.hits++
+			return
+		}
+	}
+	self.misses++
+	return
+}
+
+// put will cache segment as the segment starting at min of the sub tree under key, having hash, replacing the segments cached for other hashes of the sub tree.
+func (self *subTreeCache) put(key, hash []byte, min []byte, inc bool, segment []setop.SetOpResult) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.max < 1 {
+		return
+	}
+	tree, found := self.trees[string(key)]
+	if !found || bytes.Compare(tree.hash, hash) != 0 {
+		if found {
+			self.segments -= len(tree.segments)
+		}
+		tree = &cachedSubTree{
+			hash:     hash,
+			segments: make(map[string][]setop.SetOpResult),
+		}
+		self.trees[string(key)] = tree
+	}
+	tree.used = time.Now()
+	if _, found = tree.segments[segmentKey(min, inc)]; !found {
+		self.segments++
+	}
+	tree.segments[segmentKey(min, inc)] = segment
+	self.evict()
+}
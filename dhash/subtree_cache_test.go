@@ -0,0 +1,80 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func skipAll(t *testing.T, skipper *treeSkipper) (keys []string) {
+	result, err := skipper.Skip(nil, true)
+	for ; result != nil && err == nil; result, err = skipper.Skip(result.Key, false) {
+		keys = append(keys, string(result.Key))
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return
+}
+
+func TestSubTreeCache(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:15291", "127.0.0.1:15291", "").SetSubTreeCacheSize(10)
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:15391", "127.0.0.1:15391", "").SetSubTreeCacheSize(10)
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:15291")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2
+	}, time.Second*10)
+	conn := client.MustConn("127.0.0.1:15291")
+	key := []byte("set")
+	for i := 0; i < 200; i++ {
+		conn.SSubPut(key, []byte(fmt.Sprintf("member%03d", i)), []byte("x"))
+	}
+	owner := node1.node.GetSuccessorFor(key)
+	evaluator := node1
+	if owner.Addr == node1.GetBroadcastAddr() {
+		evaluator = node2
+	}
+	cache := evaluator.subTreeCache
+	if keys := skipAll(t, cache.skipper(owner, key)); len(keys) != 200 {
+		t.Fatalf("wanted 200 members, got %v", len(keys))
+	}
+	// The last, empty, segment tells that there are no more members.
+	if cache.hits != 0 || cache.segments != 3 {
+		t.Errorf("wanted 3 cached segments and no hits, got %v segments and %v hits", cache.segments, cache.hits)
+	}
+	if keys := skipAll(t, cache.skipper(owner, key)); len(keys) != 200 {
+		t.Fatalf("wanted 200 members, got %v", len(keys))
+	}
+	if cache.hits != 3 {
+		t.Errorf("wanted all segments read from the cache, got %v hits", cache.hits)
+	}
+	conn.SSubPut(key, []byte("member200"), []byte("x"))
+	if keys := skipAll(t, cache.skipper(owner, key)); len(keys) != 201 || keys[200] != "member200" {
+		t.Errorf("wanted the changed sub tree read again, got %v members", len(keys))
+	}
+	if cache.hits != 3 || cache.segments != 3 {
+		t.Errorf("wanted the segments of the old hash replaced, got %v segments and %v hits", cache.segments, cache.hits)
+	}
+	evaluator.SetSubTreeCacheSize(1)
+	if cache.segments != 0 {
+		t.Errorf("wanted the sub tree evicted when shrinking the cache, got %v segments", cache.segments)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("wanted a negative cache size to be rejected")
+			}
+		}()
+		evaluator.SetSubTreeCacheSize(-1)
+	}()
+	if size := evaluator.SubTreeCacheSize(); size != 1 {
+		t.Errorf("wanted the cache size unchanged, got %v", size)
+	}
+}
@@ -0,0 +1,82 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+// assertNotReserved will return common.ErrReserved if key is in the system keyspace.
+// The public API of the Node uses it for all keys it reads or writes, so that only the subsystems of the Node itself can write the system keyspace,
+// and it can only be read through SystemGet and SystemSlice.
+func (self *Node) assertNotReserved(key []byte) error {
+	if common.IsSystemKey(key) {
+		return common.ErrReserved
+	}
+	return nil
+}
+
+// assertSnapshotNotReserved will return common.ErrReserved if any entry of snapshot is in the system keyspace.
+func (self *Node) assertSnapshotNotReserved(snapshot []radix.SnapshotEntry) error {
+	for _, entry := range snapshot {
+		if common.IsSystemKey(entry.Key) {
+			return common.ErrReserved
+		}
+	}
+	return nil
+}
+
+// unreservedSnapshot returns the entries of snapshot outside the system keyspace.
+func (self *Node) unreservedSnapshot(snapshot []radix.SnapshotEntry) (result []radix.SnapshotEntry) {
+	result = make([]radix.SnapshotEntry, 0, len(snapshot))
+	for _, entry := range snapshot {
+		if !common.IsSystemKey(entry.Key) {
+			result = append(result, entry)
+		}
+	}
+	return
+}
+
+// SystemGet will return the value under data.SubKey in the system namespace with the key data.Key.
+func (self *Node) SystemGet(data common.Item, result *common.Item) error {
+	if !common.IsSystemKey(data.Key) {
+		return fmt.Errorf("%v is not a system namespace", common.HexEncode(data.Key))
+	}
+	*result = data
+	result.Value, result.Timestamp, result.Exists = self.tree.SubGet(data.Key, data.SubKey)
+	return nil
+}
+
+// SystemSlice will return the entries in r of the system namespace with the key r.Key.
+func (self *Node) SystemSlice(r common.Range, items *[]common.Item) error {
+	if !common.IsSystemKey(r.Key) {
+		return fmt.Errorf("%v is not a system namespace", common.HexEncode(r.Key))
+	}
+	self.tree.SubEachBetween(r.Key, r.Min, r.Max, r.MinInc, r.MaxInc, func(key []byte, value []byte, version int64) bool {
+		*items = append(*items, common.Item{
+			Key:       key,
+			Value:     value,
+			Timestamp: version,
+		})
+		return true
+	})
+	return nil
+}
+
+// SystemNamespaces returns the system namespaces with entries in this Node.
+func (self *Node) SystemNamespaces() (result []string) {
+	key := common.SystemPrefix
+	for {
+		next, existed := self.tree.NextMarker(key)
+		if !existed || !bytes.HasPrefix(next, common.SystemPrefix) {
+			return
+		}
+		if self.tree.SubSize(next) > 0 {
+			namespace, _ := common.SystemNamespace(next)
+			result = append(result, namespace)
+		}
+		key = next
+	}
+}
@@ -0,0 +1,84 @@
+package dhash
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func TestSystemKeyspace(t *testing.T) {
+	node := NewEmbeddedNode("system", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "system")
+	locksKey := common.SystemKey(common.SystemLocks)
+	if err := conn.TrySubPut(locksKey, []byte("a"), []byte("1")); !common.IsReserved(err) {
+		t.Errorf("wanted %v when writing the system keyspace directly, got %v", common.ErrReserved, err)
+	}
+	if err := conn.TryPut(append(common.SystemKey(common.SystemLocks), 'x'), []byte("1")); !common.IsReserved(err) {
+		t.Errorf("wanted %v when writing a key with the system prefix, got %v", common.ErrReserved, err)
+	}
+	if err := conn.TryPut(common.SystemKeyFor(common.SystemLocks, []byte("x")), []byte("1")); !common.IsReserved(err) {
+		t.Errorf("wanted %v when writing a key containing the system prefix, got %v", common.ErrReserved, err)
+	}
+	conn.Put([]byte("user"), []byte("1"))
+	var lease common.Lease
+	if err := node.Lock(common.Lease{Key: []byte("a"), Duration: time.Minute}, &lease); err != nil || !lease.Acquired {
+		t.Fatalf("wanted an acquired lease, got %+v, %v", lease, err)
+	}
+	if err := node.Lock(common.Lease{Key: locksKey, Duration: time.Minute}, &lease); !common.IsReserved(err) {
+		t.Errorf("wanted %v when locking a key in the system keyspace, got %v", common.ErrReserved, err)
+	}
+	var item common.Item
+	if err := node.SubGet(common.Item{Key: locksKey, SubKey: []byte("a")}, &item); !common.IsReserved(err) {
+		t.Errorf("wanted %v when reading the system keyspace directly, got %v", common.ErrReserved, err)
+	}
+	var items []common.Item
+	if err := node.Slice(common.Range{Key: locksKey, MinInc: true, MaxInc: true}, &items); !common.IsReserved(err) || len(items) != 0 {
+		t.Errorf("wanted %v when slicing the system keyspace directly, got %v, %v", common.ErrReserved, items, err)
+	}
+	if err := node.Next(common.Item{}, &item); err != nil || string(item.Key) != "user" {
+		t.Errorf("wanted the top level tree to skip the system keyspace, got %v, %v", item, err)
+	}
+	if err := node.SystemGet(common.Item{Key: leaseKey([]byte("a")), SubKey: []byte("a")}, &item); err != nil || !item.Exists {
+		t.Errorf("wanted the lease in the locks namespace of the key, got %v, %v", item, err)
+	}
+	if err := node.Get(common.Item{Key: leaseKey([]byte("a"))}, &item); !common.IsReserved(err) {
+		t.Errorf("wanted %v when reading a namespace kept per key directly, got %v", common.ErrReserved, err)
+	}
+	if err := node.ownedSubPut(common.Item{Key: common.SystemKey(common.SystemBackups), SubKey: []byte("b"), Value: []byte("1"), Sync: true}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, existed := conn.SystemGet(common.SystemBackups, []byte("b")); !existed {
+		t.Errorf("wanted the entry in the backups namespace")
+	}
+	if entries := conn.SystemEntries(common.SystemBackups); len(entries) != 1 {
+		t.Errorf("wanted one entry, got %v", entries)
+	}
+	if namespaces, err := conn.SystemNamespaces(); err != nil || !reflect.DeepEqual(namespaces, []string{common.SystemBackups}) {
+		t.Errorf("wanted the backups namespace, got %v, %v", namespaces, err)
+	}
+	encoded, err := node.Snapshot()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	snapshot, err := radix.DecodeSnapshot(encoded)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, entry := range snapshot {
+		if common.IsSystemKey(entry.Key) {
+			t.Errorf("wanted no system keyspace entries in the snapshot, got %v", entry)
+		}
+	}
+	if encoded, err = radix.EncodeSnapshot(node.tree.SnapshotBetween(nil, nil, true, false)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := node.Restore(encoded); !common.IsReserved(err) {
+		t.Errorf("wanted %v when restoring system keyspace entries, got %v", common.ErrReserved, err)
+	}
+}
@@ -0,0 +1,46 @@
+package dhash
+
+import (
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// timeTree will run op, which returns how long it waited for the tree lock, and if timing is not nil add that wait and the rest of the time spent in op to it.
+func timeTree(timing *common.Timing, op func() (wait time.Duration)) {
+	start := time.Now()
+	wait := op()
+	if timing != nil {
+		timing.Lock += wait
+		timing.Tree += time.Now().Sub(start) - wait
+	}
+}
+
+// timeReplication will run forward, and if timing is not nil add the time spent in it to it.
+func timeReplication(timing *common.Timing, forward func()) {
+	if timing == nil {
+		forward()
+		return
+	}
+	start := time.Now()
+	forward()
+	timing.Replication += time.Now().Sub(start)
+}
+
+// scheduleTimed will admit data like schedule, and if data is flagged Debug give it a Timing with the time spent waiting to be admitted.
+// The returned function will set the total time of the Timing, and must be called when the request is done.
+func (self *Node) scheduleTimed(data *common.Item) (done func()) {
+	start := time.Now()
+	scheduled := self.schedule(data.QoS)
+	if !data.Debug {
+		return scheduled
+	}
+	timing := &common.Timing{
+		Queue: time.Now().Sub(start),
+	}
+	data.Timing = timing
+	return func() {
+		scheduled()
+		timing.Total = time.Now().Sub(start)
+	}
+}
@@ -0,0 +1,32 @@
+package dhash
+
+import (
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestTiming(t *testing.T) {
+	node := NewEmbeddedNode("timing", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "timing")
+	timing, err := conn.DebugPut([]byte("a"), []byte("1"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if timing.Tree <= 0 || timing.Total < timing.Queue+timing.Lock+timing.Tree+timing.Replication {
+		t.Errorf("wanted the total of %v to include the tree operation and the other parts", timing)
+	}
+	value, existed, timing := conn.DebugGet([]byte("a"))
+	if !existed || string(value) != "1" {
+		t.Errorf("wanted 1, got %s, %v", value, existed)
+	}
+	if timing.Tree <= 0 || timing.Total < timing.Tree || timing.Replication != 0 {
+		t.Errorf("wanted a read timing, got %v", timing)
+	}
+	if value, _ := conn.Get([]byte("a")); string(value) != "1" {
+		t.Errorf("wanted 1, got %s", value)
+	}
+}
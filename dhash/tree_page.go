@@ -0,0 +1,73 @@
+package dhash
+
+import (
+	"bytes"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+const (
+	// defaultTreePageSize is the number of entries in a TreePage when the request doesn't limit it.
+	defaultTreePageSize = 100
+	// maxTreePageSize is the maximum number of entries in a TreePage, to keep the responses small.
+	maxTreePageSize = 10000
+)
+
+// TreePage will return a page of the entries stored in this Node, as stored, filtered and limited by r.
+// Unlike DescribeTree it can be used to browse the data of large nodes, one page at a time.
+func (self *Node) TreePage(r common.TreePageRequest) (result common.TreePage) {
+	max := r.Max
+	if max < 1 {
+		max = defaultTreePageSize
+	} else if max > maxTreePageSize {
+		max = maxTreePageSize
+	}
+	tree := self.tree
+	if r.Key != nil {
+		tree = nil
+		self.tree.EachEntryBetween(r.Key, r.Key, true, true, func(key, value []byte, timestamp int64, sub *radix.Tree) bool {
+			tree = sub
+			return false
+		})
+	}
+	result.Entries, result.Next = treePage(tree, r.Prefix, r.From, r.Depth, r.Values, &max)
+	return
+}
+
+// treePage returns at most left entries, including the members of their sub trees, of tree with keys starting with prefix, starting at from,
+// and the key to continue at if there are more. It decreases left by the number of returned entries.
+func treePage(tree *radix.Tree, prefix, from []byte, depth int, values bool, left *int) (entries []common.TreeEntry, next []byte) {
+	min := prefix
+	if bytes.Compare(from, min) > 0 {
+		min = from
+	}
+	tree.EachEntryBetween(min, nil, true, true, func(key, value []byte, timestamp int64, sub *radix.Tree) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		entry := common.TreeEntry{
+			Key:       key,
+			ValueSize: len(value),
+			Timestamp: timestamp,
+			SubSize:   sub.Size(),
+		}
+		if value == nil && entry.SubSize == 0 {
+			return true
+		}
+		if *left == 0 {
+			next = key
+			return false
+		}
+		*left--
+		if values {
+			entry.Value = value
+		}
+		if depth > 0 {
+			entry.Sub, entry.SubNext = treePage(sub, nil, nil, depth-1, values, left)
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	return
+}
@@ -0,0 +1,66 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestTreePage(t *testing.T) {
+	node := NewEmbeddedNode("treePage", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "treePage")
+	for i := 0; i < 25; i++ {
+		conn.SPut([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprint("value", i)))
+	}
+	conn.SPut([]byte("other"), []byte("x"))
+	conn.SSubPut([]byte("key05"), []byte("a"), []byte("b"))
+	conn.SSubPut([]byte("key05"), []byte("c"), []byte("d"))
+	conn.SDel([]byte("key07"))
+	r := common.TreePageRequest{Prefix: []byte("key"), Max: 10}
+	var keys []string
+	pages := 0
+	for {
+		page, err := conn.TreePage(node.node.GetPosition(), r)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		pages++
+		for _, entry := range page.Entries {
+			if entry.Value != nil || entry.Sub != nil {
+				t.Errorf("wanted no values or members, got %v", entry)
+			}
+			if string(entry.Key) == "key05" && (entry.SubSize != 2 || entry.ValueSize != len("value5")) {
+				t.Errorf("wanted key05 to have 6 bytes and 2 members, got %v", entry)
+			}
+			keys = append(keys, string(entry.Key))
+		}
+		if page.Next == nil {
+			break
+		}
+		r.From = page.Next
+	}
+	if pages != 3 || len(keys) != 24 || keys[0] != "key00" || keys[7] != "key08" || keys[23] != "key24" {
+		t.Errorf("wanted the 24 remaining keys starting with key in 3 pages, got %v in %v pages", keys, pages)
+	}
+	page := node.TreePage(common.TreePageRequest{Prefix: []byte("key05"), Depth: 1, Values: true})
+	if len(page.Entries) != 1 || string(page.Entries[0].Value) != "value5" || len(page.Entries[0].Sub) != 2 {
+		t.Fatalf("wanted key05 with its value and members, got %v", page)
+	}
+	for index, member := range page.Entries[0].Sub {
+		if wanted := [][]string{{"a", "b"}, {"c", "d"}}[index]; string(member.Key) != wanted[0] || string(member.Value) != wanted[1] {
+			t.Errorf("wanted %v, got %v", wanted, member)
+		}
+	}
+	page = node.TreePage(common.TreePageRequest{Prefix: []byte("key0"), Depth: 1, Max: 7})
+	if len(page.Entries) != 6 || string(page.Next) != "key06" || len(page.Entries[5].Sub) != 1 || string(page.Entries[5].SubNext) != "c" {
+		t.Fatalf("wanted the members to count towards the max, and the member to continue the sub tree at, got %v", page)
+	}
+	page = node.TreePage(common.TreePageRequest{Key: []byte("key05"), From: page.Entries[5].SubNext, Values: true})
+	if len(page.Entries) != 1 || string(page.Entries[0].Key) != "c" || string(page.Entries[0].Value) != "d" || page.Next != nil {
+		t.Errorf("wanted the rest of the sub tree of key05, got %v", page)
+	}
+}
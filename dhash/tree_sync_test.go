@@ -0,0 +1,94 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func TestTreeSync(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:15791", "127.0.0.1:15791", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:15891", "127.0.0.1:15891", "")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:15791")
+	common.AssertWithin(t, func() (string, bool) {
+		return fmt.Sprint(node1.node.GetNodes()), len(node1.node.GetNodes()) == 2
+	}, time.Second*10)
+	conn := client.MustConn("127.0.0.1:15791")
+	conn.SPut([]byte("b"), []byte("remote b"))
+	conn.SPut([]byte("x"), []byte("remote x"))
+	conn.SPut([]byte("d"), []byte("remote d"))
+	conn.SSubPut([]byte("e"), []byte("remote"), []byte("1"))
+	tree := radix.NewTree()
+	now := time.Now().UnixNano()
+	tree.Put([]byte("c"), []byte("local c"), now)
+	tree.Put([]byte("y"), []byte("local y"), now)
+	tree.Put([]byte("d"), []byte("local d"), now)
+	tree.SubPut([]byte("e"), []byte("local"), []byte("2"), now)
+	var reports []client.TreeSyncProgress
+	progress, err := conn.TreeSync(tree).From([]byte("a")).To([]byte("m")).Resolve(func(c client.TreeConflict) (client.TreeVersion, bool) {
+		return client.TreeVersion{Value: []byte(fmt.Sprintf("%s, %s", c.Local.Value, c.Remote.Value)), Present: true}, true
+	}).Progress(func(p client.TreeSyncProgress) {
+		reports = append(reports, p)
+	}).Run()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(reports) != progress.Ranges || progress.Done != progress.Ranges || progress.Conflicts != 1 {
+		t.Errorf("wanted progress for each range and one conflict, got %+v and %+v", reports, progress)
+	}
+	for key, wanted := range map[string]string{"b": "remote b", "c": "local c", "d": "local d, remote d", "y": "local y"} {
+		if value, _, _ := tree.Get([]byte(key)); string(value) != wanted {
+			t.Errorf("wanted %v to be %#v locally, got %#v", key, wanted, string(value))
+		}
+	}
+	if _, _, existed := tree.Get([]byte("x")); existed {
+		t.Errorf("wanted x, outside the synchronized range, to not be pulled")
+	}
+	if size := tree.SubSize([]byte("e")); size != 2 {
+		t.Errorf("wanted the local sub tree to get the remote member, got %v members", size)
+	}
+	for key, wanted := range map[string]string{"b": "remote b", "c": "local c", "d": "local d, remote d", "x": "remote x"} {
+		if value, _ := conn.Get([]byte(key)); string(value) != wanted {
+			t.Errorf("wanted %v to be %#v in the cluster, got %#v", key, wanted, string(value))
+		}
+	}
+	if _, existed := conn.Get([]byte("y")); existed {
+		t.Errorf("wanted y, outside the synchronized range, to not be pushed")
+	}
+	if value, _ := conn.SubGet([]byte("e"), []byte("local")); string(value) != "2" {
+		t.Errorf("wanted the remote sub tree to get the local member, got %#v", string(value))
+	}
+	if progress, err = conn.TreeSync(tree).From([]byte("a")).To([]byte("m")).Run(); err != nil || progress.Pushed != 0 || progress.Pulled != 0 {
+		t.Errorf("wanted nothing to copy the second time, got %+v, %v", progress, err)
+	}
+	skewed := time.Now().Add(time.Hour).UnixNano()
+	tree.Put([]byte("f"), []byte("skewed f"), skewed)
+	if _, err = conn.TreeSync(tree).From([]byte("a")).To([]byte("m")).Run(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if value, timestamp, _ := tree.Get([]byte("f")); string(value) != "skewed f" || timestamp >= skewed {
+		t.Errorf("wanted f to be restamped with the time of the cluster, got %#v at %v", string(value), timestamp)
+	}
+	conn.SPut([]byte("f"), []byte("remote f"))
+	if _, err = conn.TreeSync(tree).From([]byte("a")).To([]byte("m")).Run(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if value, _, _ := tree.Get([]byte("f")); string(value) != "remote f" {
+		t.Errorf("wanted the newer remote f to win over the skewed clock, got %#v", string(value))
+	}
+	if err = conn.PutImmutable([]byte("g"), []byte("remote g")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tree.Put([]byte("g"), []byte("local g"), skewed)
+	if _, err = conn.TreeSync(tree).From([]byte("a")).To([]byte("m")).Run(); !common.IsImmutable(err) {
+		t.Errorf("wanted pushing over a write-once key to fail, got %v", err)
+	}
+}
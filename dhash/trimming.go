@@ -0,0 +1,110 @@
+package dhash
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// retentionPolicy returns the maximum number of members and maximum age of members configured in conf, and whether any of them is.
+func retentionPolicy(conf map[string]string) (maxMembers int, maxAge time.Duration, ok bool) {
+	if n, err := strconv.Atoi(conf[common.MaxMembersConf]); err == nil && n > 0 {
+		maxMembers, ok = n, true
+	}
+	if d, err := time.ParseDuration(conf[common.MaxAgeConf]); err == nil && d > 0 {
+		maxAge, ok = d, true
+	}
+	return
+}
+
+type member struct {
+	key       []byte
+	timestamp int64
+}
+
+type membersByAge []member
+
+func (self membersByAge) Len() int {
+	return len(self)
+}
+func (self membersByAge) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self membersByAge) Less(i, j int) bool {
+	return self[i].timestamp < self[j].timestamp
+}
+
+// trimSubTree will remove the members of the sub tree under key put longer than maxAge ago, and the oldest members beyond maxMembers.
+// Zero maxMembers or maxAge means no limit. The members are removed even if the sub tree is write-once, since the retention policy was configured for it.
+func (self *Node) trimSubTree(key []byte, maxMembers int, maxAge time.Duration) (removed int) {
+	var members membersByAge
+	self.tree.SubEachBetween(key, nil, nil, true, true, func(subKey, value []byte, timestamp int64) bool {
+		members = append(members, member{
+			key:       subKey,
+			timestamp: timestamp,
+		})
+		return true
+	})
+	sort.Sort(members)
+	excess := 0
+	if maxMembers > 0 && len(members) > maxMembers {
+		excess = len(members) - maxMembers
+	}
+	var deadline int64
+	if maxAge > 0 {
+		deadline = self.timer.ContinuousTime() - int64(maxAge)
+		for excess < len(members) && members[excess].timestamp < deadline {
+			excess++
+		}
+	}
+	for _, m := range members[:excess] {
+		if err := self.ownedSubDel(common.Item{
+			Key:      key,
+			SubKey:   m.key,
+			Override: true,
+			QoS:      common.Batch,
+		}); err == nil {
+			removed++
+			if m.timestamp < deadline {
+				self.reportExpired(key, m.key, m.timestamp, maxAge)
+			}
+		}
+	}
+	return
+}
+
+// trimAfterPut will trim the sub tree under key right away if it has more members than its retention policy allows.
+func (self *Node) trimAfterPut(key []byte) {
+	conf, _ := self.tree.SubConfiguration(key)
+	if maxMembers, _, ok := retentionPolicy(conf); ok && maxMembers > 0 && self.tree.SubSize(key) > maxMembers {
+		self.trimSubTree(key, maxMembers, 0)
+	}
+}
+
+// Trim will enforce the retention policies of the sub trees owned by this Node, configured using common.MaxMembersConf and common.MaxAgeConf,
+// and remove the expired leases of cluster wide locks of the keys owned by this Node. It returns the number of removed members.
+func (self *Node) Trim() (removed int) {
+	type policy struct {
+		key        []byte
+		maxMembers int
+		maxAge     time.Duration
+	}
+	var policies []policy
+	self.eachSubConfigurationBetween(self.node.GetPredecessor().Pos, self.node.GetPosition(), func(key []byte, conf map[string]string) bool {
+		if maxMembers, maxAge, ok := retentionPolicy(conf); ok {
+			policies = append(policies, policy{
+				key:        key,
+				maxMembers: maxMembers,
+				maxAge:     maxAge,
+			})
+		}
+		return true
+	})
+	for _, p := range policies {
+		removed += self.trimSubTree(p.key, p.maxMembers, p.maxAge)
+	}
+	removed += self.expireLeases()
+	return
+}
@@ -0,0 +1,48 @@
+package dhash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestTrimming(t *testing.T) {
+	node := NewEmbeddedNode("trimming", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "trimming")
+	feed := []byte("feed")
+	conn.SetRetention(feed, 3, 0)
+	for i := 0; i < 5; i++ {
+		conn.SSubPut(feed, []byte(fmt.Sprint(9-i)), []byte("x"))
+	}
+	if items := conn.Slice(feed, nil, nil, true, true); len(items) != 3 || string(items[0].Key) != "5" || string(items[2].Key) != "7" {
+		t.Errorf("wanted the 3 newest members 5, 6 and 7, got %v", items)
+	}
+	old := []byte("old")
+	conn.SSubPut(old, []byte("a"), []byte("x"))
+	time.Sleep(time.Millisecond * 50)
+	conn.SSubPut(old, []byte("b"), []byte("x"))
+	conn.SetRetention(old, 0, time.Millisecond*25)
+	if removed, err := conn.Trim(); err != nil || removed != 1 {
+		t.Errorf("wanted 1 removed member, got %v, %v", removed, err)
+	}
+	if _, existed := conn.SubGet(old, []byte("a")); existed {
+		t.Errorf("a should have been trimmed")
+	}
+	var expired []string
+	if hasEvent(node.Events(0, 0).Events, common.EventExpired, "") {
+		t.Errorf("wanted the expirations kept apart from the other events")
+	}
+	for _, event := range node.Expiries(0, 0).Events {
+		if event.Type == common.EventExpired {
+			expired = append(expired, string(event.Key)+"/"+string(event.SubKey))
+		}
+	}
+	if fmt.Sprint(expired) != "[old/a]" {
+		t.Errorf("wanted only old/a to be reported as expired, not the members trimmed for exceeding maxMembers, got %v", expired)
+	}
+}
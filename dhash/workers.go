@@ -0,0 +1,118 @@
+package dhash
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// worker runs one periodic task of a Node, recovering from panics in it, and keeps track of its runs.
+type worker struct {
+	node     *Node
+	name     string
+	interval func() time.Duration
+	// delayed workers wait for the interval before their first run.
+	delayed bool
+	task    func()
+	lock    *sync.Mutex
+	status  common.WorkerStatus
+}
+
+func constantInterval(d time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return d
+	}
+}
+
+// startWorkers will start the workers running the sync, clean, migrate, garbage collection, trimming and statistics tasks of this Node.
+func (self *Node) startWorkers() {
+	workers := []*worker{
+		self.newWorker("sync", self.SyncInterval, false, self.periodicSync),
+		self.newWorker("clean", self.CleanInterval, false, self.clean),
+		self.newWorker("migrate", self.SyncInterval, false, self.periodicMigrate),
+		self.newWorker("gc", self.GCInterval, true, func() { self.CollectGarbage() }),
+		self.newWorker("trim", self.CleanInterval, true, func() { self.Trim() }),
+		self.newWorker("stats", constantInterval(statsInterval), false, func() {
+			self.updateRequestRates()
+			self.updateLoad()
+			self.reportOverload()
+			self.checkConvergence()
+		}),
+	}
+	self.lock.Lock()
+	self.workers = workers
+	self.lock.Unlock()
+	for _, w := range workers {
+		go w.loop()
+	}
+}
+
+func (self *Node) newWorker(name string, interval func() time.Duration, delayed bool, task func()) *worker {
+	return &worker{
+		node:     self,
+		name:     name,
+		interval: interval,
+		delayed:  delayed,
+		task:     task,
+		lock:     new(sync.Mutex),
+		status: common.WorkerStatus{
+			Name:  name,
+			Since: time.Now(),
+		},
+	}
+}
+
+func (self *worker) loop() {
+	if self.delayed {
+		time.Sleep(self.interval())
+	}
+	for self.node.hasState(started) {
+		self.run()
+		time.Sleep(self.interval())
+	}
+}
+
+// run will run the task once, and record a panic in it instead of letting it crash the process.
+func (self *worker) run() {
+	start := time.Now()
+	self.lock.Lock()
+	self.status.Running, self.status.Since, self.status.LastRun = true, start, start
+	self.lock.Unlock()
+	defer func() {
+		e := recover()
+		if e != nil {
+			log.Printf("%v worker %v panicked: %v\n%s", self.node.GetBroadcastAddr(), self.name, e, debug.Stack())
+		}
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		now := time.Now()
+		self.status.Running, self.status.Since, self.status.LastDuration = false, now, now.Sub(start)
+		self.status.Runs++
+		if e != nil {
+			self.status.Panics++
+			self.status.LastPanic = fmt.Sprint(e)
+		}
+	}()
+	self.task()
+}
+
+func (self *worker) getStatus() common.WorkerStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.status
+}
+
+// Workers returns what each background worker of this Node is doing, and when it last ran.
+func (self *Node) Workers() (result []common.WorkerStatus) {
+	self.lock.RLock()
+	workers := self.workers
+	self.lock.RUnlock()
+	for _, w := range workers {
+		result = append(result, w.getStatus())
+	}
+	return
+}
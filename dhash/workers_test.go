@@ -0,0 +1,65 @@
+package dhash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+func TestWorkers(t *testing.T) {
+	node := NewEmbeddedNode("workers", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "workers")
+	common.AssertWithin(t, func() (string, bool) {
+		statuses, err := conn.Workers()
+		for _, status := range statuses[node.GetBroadcastAddr()] {
+			if status.Name == "stats" {
+				return status.String(), err == nil && status.Runs > 0 && !status.LastRun.IsZero()
+			}
+		}
+		return "", false
+	}, time.Second*5)
+	w := node.newWorker("panicky", constantInterval(time.Millisecond), false, func() {
+		panic("oops")
+	})
+	w.run()
+	w.run()
+	if status := w.getStatus(); status.Runs != 2 || status.Panics != 2 || status.LastPanic != "oops" || status.Running {
+		t.Errorf("wanted 2 recovered panics, got %v", status)
+	}
+}
+
+func TestNonPositiveIntervals(t *testing.T) {
+	node := NewEmbeddedNode("intervals", "")
+	for name, setGet := range map[string][2]interface{}{
+		"sync":  {node.SetSyncInterval, node.SyncInterval},
+		"clean": {node.SetCleanInterval, node.CleanInterval},
+		"gc":    {node.SetGCInterval, node.GCInterval},
+	} {
+		set, get := setGet[0].(func(time.Duration) *Node), setGet[1].(func() time.Duration)
+		for _, d := range []time.Duration{0, -time.Second} {
+			set(time.Hour)
+			if set(d); get() <= 0 || get() == time.Hour {
+				t.Errorf("wanted a %v interval of %v to be replaced by the default, got %v", name, d, get())
+			}
+		}
+	}
+	if h := node.SetMigrateHysteresis(0.5).MigrateHysteresis(); h != 1 {
+		t.Errorf("wanted a migrate hysteresis below 1 to be raised to 1, got %v", h)
+	}
+	if f := node.SetMigrateWaitFactor(-1).MigrateWaitFactor(); f != 0 {
+		t.Errorf("wanted a negative migrate wait factor to be raised to 0, got %v", f)
+	}
+	if d := node.SetGCGracePeriod(-time.Second).GCGracePeriod(); d != 0 {
+		t.Errorf("wanted a negative garbage collection grace period to be raised to 0, got %v", d)
+	}
+	if size := node.SetChunkSize(-1).ChunkSize(); size != 0 {
+		t.Errorf("wanted a negative chunk size to disable chunking, got %v", size)
+	}
+	if interval := node.SetConvergenceBound(2).SyncInterval(); interval != 1 {
+		t.Errorf("wanted a tiny convergence bound to give the shortest sync interval, got %v", interval)
+	}
+}
@@ -0,0 +1,84 @@
+package dhash
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+func TestWriteListener(t *testing.T) {
+	node1 := NewNodeDir("127.0.0.1:13291", "127.0.0.1:13291", "")
+	node1.MustStart()
+	defer node1.Stop()
+	node2 := NewNodeDir("127.0.0.1:13391", "127.0.0.1:13391", "")
+	node2.MustStart()
+	defer node2.Stop()
+	node2.MustJoin("127.0.0.1:13291")
+	writes := make(chan radix.Write, 16)
+	listener := func(write radix.Write) bool {
+		if bytes.Compare(write.Key, []byte("k")) == 0 {
+			writes <- write
+		}
+		return true
+	}
+	node1.AddWriteListener(listener)
+	node2.AddWriteListener(listener)
+	conn := node1.client()
+	conn.SPut([]byte("k"), []byte("v1"))
+	conn.SPut([]byte("k"), []byte("v2"))
+	// Both the owner and the replica should report both writes, the second one with the value it replaced.
+	var replaced int
+	for i := 0; i < 4; i++ {
+		select {
+		case write := <-writes:
+			if bytes.Compare(write.OldValue, []byte("v1")) == 0 && bytes.Compare(write.NewValue, []byte("v2")) == 0 {
+				replaced++
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("wanted 4 writes, got %v", i)
+		}
+	}
+	if replaced != 2 {
+		t.Errorf("wanted 2 writes replacing v1 with v2, got %v", replaced)
+	}
+}
+
+func TestMutationListenerAddedWhileTriggered(t *testing.T) {
+	node := NewEmbeddedNode("mutationListeners", "")
+	var calls []string
+	node.addMutationListener(func(operation string, data common.Item) bool {
+		calls = append(calls, "first")
+		node.addMutationListener(func(operation string, data common.Item) bool {
+			calls = append(calls, "second")
+			return true
+		})
+		return false
+	})
+	node.triggerMutationListeners("Put", common.Item{})
+	node.triggerMutationListeners("Put", common.Item{})
+	if fmt.Sprint(calls) != "[first second]" {
+		t.Errorf("wanted the listener added while the first was called to be kept, got %v", calls)
+	}
+}
+
+func TestWriteListenerAddedWhileTriggered(t *testing.T) {
+	node := NewEmbeddedNode("writeListeners", "")
+	var calls []string
+	node.AddWriteListener(func(write radix.Write) bool {
+		calls = append(calls, "first")
+		node.AddWriteListener(func(write radix.Write) bool {
+			calls = append(calls, "second")
+			return true
+		})
+		return false
+	})
+	node.triggerWriteListeners(radix.Write{})
+	node.triggerWriteListeners(radix.Write{})
+	if fmt.Sprint(calls) != "[first second]" {
+		t.Errorf("wanted the listener added while the first was called to be kept, got %v", calls)
+	}
+}
@@ -0,0 +1,32 @@
+package dhash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/god/client"
+)
+
+func TestZones(t *testing.T) {
+	dhashes := testStartup(t, 3, 13891)
+	defer stopServers(dhashes)
+	for index, d := range dhashes {
+		d.PauseSync()
+		d.SetZone(string('a' + byte(index)))
+	}
+	// Give each replica its own value, to see which one answers.
+	for index, d := range dhashes {
+		d.tree.Put([]byte("k"), []byte{byte(index)}, int64(index+1))
+	}
+	conn := client.MustConn(dhashes[0].GetBroadcastAddr())
+	conn.SetZone("b")
+	if value, existed := conn.GetStale([]byte("k")); !existed || bytes.Compare(value, []byte{1}) != 0 {
+		t.Errorf("wanted the value of the replica in zone b, got %v, %v", value, existed)
+	}
+	if value, _ := conn.Get([]byte("k")); bytes.Compare(value, []byte{2}) != 0 {
+		t.Errorf("wanted the most recent value, got %v", value)
+	}
+	if latencies := conn.ZoneLatencies(); len(latencies) != 3 {
+		t.Errorf("wanted latencies to 3 zones, got %v", latencies)
+	}
+}
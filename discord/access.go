@@ -0,0 +1,152 @@
+package discord
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+)
+
+const (
+	// keyHashSize is the number of bytes of the murmur hash of the key included in each Access.
+	keyHashSize = 4
+	// accessQueueSize is how many sampled requests a Node queues for its AccessListeners before dropping them.
+	accessQueueSize = 1024
+)
+
+// Access describes a sampled request handled by a Node.
+type Access struct {
+	// Time is when the request was received.
+	Time time.Time
+	// Operation is the service method called, like DHash.Put.
+	Operation string
+	// KeyHash is a hex encoded prefix of the murmur hash of the key of the request, to group requests by key without logging the keys themselves.
+	// It is empty for requests without keys.
+	KeyHash string
+	// Latency is the time from receiving the request to responding to it.
+	Latency time.Duration
+	// Result is "ok", or the error returned.
+	Result string
+	// Principal is the remote address of the caller.
+	Principal string
+}
+
+// AccessListener is a function listening to the sampled requests handled by a Node.
+type AccessListener func(access Access) (keep bool)
+
+type sample struct {
+	start     time.Time
+	operation string
+	key       []byte
+}
+
+func accessKey(body interface{}) []byte {
+	switch data := body.(type) {
+	case *common.Item:
+		return data.Key
+	case *common.Range:
+		return data.Key
+	case *common.ConfItem:
+		return data.TreeKey
+	}
+	return nil
+}
+
+// SetAccessSampleRate will make this Node report the given fraction, between 0 and 1, of the requests it handles to its AccessListeners.
+// Requests to the Discord service are never reported.
+func (self *Node) SetAccessSampleRate(rate float64) *Node {
+	atomic.StoreUint64(&self.accessSampleRate, math.Float64bits(rate))
+	return self
+}
+
+// AccessSampleRate returns the fraction of the requests this Node reports to its AccessListeners.
+func (self *Node) AccessSampleRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&self.accessSampleRate))
+}
+func (self *Node) sample() bool {
+	rate := self.AccessSampleRate()
+	return rate > 0 && rand.Float64() < rate
+}
+
+// AddAccessListener will make l get the sampled requests this Node handles until it returns false.
+// The listeners are called by a goroutine of their own, so slow listeners don't delay the responses, but they miss the requests sampled while
+// accessQueueSize requests are already waiting for them.
+func (self *Node) AddAccessListener(l AccessListener) {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	self.accessListeners = append(self.accessListeners, &l)
+	atomic.StoreInt32(&self.nAccessListeners, int32(len(self.accessListeners)))
+	if !self.dispatchingAccess {
+		self.dispatchingAccess = true
+		go self.dispatchAccess()
+	}
+}
+
+// dispatchAccess will call the AccessListeners with the queued requests until there are no listeners left.
+func (self *Node) dispatchAccess() {
+	for access := range self.accesses {
+		if !self.triggerAccessListeners(access) {
+			return
+		}
+	}
+}
+
+// triggerAccessListeners will call the AccessListeners with access, and return whether any of them are left.
+func (self *Node) triggerAccessListeners(access Access) bool {
+	self.metaLock.RLock()
+	listeners := self.accessListeners
+	self.metaLock.RUnlock()
+	var removed map[*AccessListener]bool
+	for _, l := range listeners {
+		if !(*l)(access) {
+			if removed == nil {
+				removed = make(map[*AccessListener]bool)
+			}
+			removed[l] = true
+		}
+	}
+	if removed == nil {
+		return true
+	}
+	// Only remove the listeners that returned false, since others may have been added while we called them.
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	newListeners := make([]*AccessListener, 0, len(self.accessListeners))
+	for _, l := range self.accessListeners {
+		if !removed[l] {
+			newListeners = append(newListeners, l)
+		}
+	}
+	self.accessListeners = newListeners
+	atomic.StoreInt32(&self.nAccessListeners, int32(len(self.accessListeners)))
+	if len(self.accessListeners) == 0 {
+		self.dispatchingAccess = false
+		return false
+	}
+	return true
+}
+func (self *Node) logAccess(s sample, principal string, err string) {
+	access := Access{
+		Time:      s.start,
+		Operation: s.operation,
+		Latency:   time.Now().Sub(s.start),
+		Result:    "ok",
+		Principal: principal,
+	}
+	if s.key != nil {
+		access.KeyHash = common.HexEncode(murmur.HashBytes(s.key)[:keyHashSize])
+	}
+	if err != "" {
+		access.Result = err
+	}
+	if atomic.LoadInt32(&self.nAccessListeners) == 0 {
+		return
+	}
+	select {
+	case self.accesses <- access:
+	default:
+	}
+}
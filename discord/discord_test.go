@@ -3,6 +3,9 @@ package discord
 import (
 	"fmt"
 	"github.com/zond/god/common"
+	"github.com/zond/god/murmur"
+	"net"
+	"net/rpc"
 	"testing"
 	"time"
 )
@@ -28,3 +31,159 @@ func TestStartup(t *testing.T) {
 		return fmt.Sprint(routes), len(routes) == 1 && nodes[0].ring.Size() > 0
 	}, time.Second*30)
 }
+
+func TestGossip(t *testing.T) {
+	firstPort := 9291
+	var nodes []*Node
+	n := 5
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, NewNode(fmt.Sprintf("%v:%v", "127.0.0.1", firstPort+i), fmt.Sprintf("%v:%v", "127.0.0.1", firstPort+i)))
+	}
+	for i := 0; i < n; i++ {
+		nodes[i].MustStart()
+	}
+	for i := 1; i < n; i++ {
+		nodes[i].MustJoin(nodes[0].GetBroadcastAddr())
+	}
+	common.AssertWithin(t, func() (string, bool) {
+		for i := 0; i < n; i++ {
+			if nodes[i].CountNodes() != n {
+				return nodes[i].Describe(), false
+			}
+		}
+		return "", true
+	}, time.Second*30)
+	dead := nodes[n-1]
+	dead.Stop()
+	nodes = nodes[:n-1]
+	common.AssertWithin(t, func() (string, bool) {
+		for _, node := range nodes {
+			if node.HasNode(dead.GetPosition()) {
+				return node.Describe(), false
+			}
+			if state, ok := node.MemberStates()[dead.GetBroadcastAddr()]; ok && state != Dead {
+				return fmt.Sprintf("%v thinks %v is %v", node, dead, state), false
+			}
+		}
+		return "", true
+	}, time.Second*30)
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
+
+type slowService struct{}
+
+func (self *slowService) Sleep(d time.Duration, x *int) error {
+	time.Sleep(d)
+	return nil
+}
+
+func (self *slowService) Fail(item common.Item, x *int) error {
+	return fmt.Errorf("failed")
+}
+
+func dialTest(t *testing.T, addr string) *rpc.Client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return rpc.NewClientWithCodec(common.NewClientCodec(conn))
+}
+
+func TestLimits(t *testing.T) {
+	node := NewNode("127.0.0.1:9391", "127.0.0.1:9391")
+	node.Export("Slow", &slowService{})
+	node.SetMaxConnections(1).SetMaxInFlight(1)
+	node.MustStart()
+	defer node.Stop()
+	first := dialTest(t, "127.0.0.1:9391")
+	defer first.Close()
+	var x int
+	if err := first.Call("Slow.Sleep", time.Duration(0), &x); err != nil {
+		t.Errorf("%v", err)
+	}
+	second := dialTest(t, "127.0.0.1:9391")
+	defer second.Close()
+	if err := second.Call("Slow.Sleep", time.Duration(0), &x); !common.IsOverloaded(err) {
+		t.Errorf("wanted %v, got %v", common.ErrOverloaded, err)
+	}
+	slow := first.Go("Slow.Sleep", time.Millisecond*200, &x, nil)
+	time.Sleep(time.Millisecond * 50)
+	if err := first.Call("Slow.Sleep", time.Duration(0), &x); !common.IsOverloaded(err) {
+		t.Errorf("wanted %v, got %v", common.ErrOverloaded, err)
+	}
+	if err := (<-slow.Done).Error; err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := first.Call("Slow.Sleep", time.Duration(0), &x); err != nil {
+		t.Errorf("%v", err)
+	}
+	if connections, requests := node.Rejected(); connections != 1 || requests != 2 {
+		t.Errorf("wanted 1 rejected connection and 2 rejected requests, got %v and %v", connections, requests)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	node := NewNode("127.0.0.1:9392", "127.0.0.1:9392")
+	node.Export("Slow", &slowService{})
+	node.SetAccessSampleRate(1)
+	accesses := make(chan Access, 10)
+	node.AddAccessListener(func(access Access) bool {
+		accesses <- access
+		return true
+	})
+	node.MustStart()
+	defer node.Stop()
+	client := dialTest(t, "127.0.0.1:9392")
+	defer client.Close()
+	var x int
+	if err := client.Call("Slow.Sleep", time.Millisecond*10, &x); err != nil {
+		t.Errorf("%v", err)
+	}
+	if access := <-accesses; access.Operation != "Slow.Sleep" || access.Result != "ok" || access.KeyHash != "" || access.Latency < time.Millisecond*10 || access.Principal == "" {
+		t.Errorf("wanted a 10ms Slow.Sleep without key, got %+v", access)
+	}
+	client.Call("Slow.Fail", common.Item{Key: []byte("a")}, &x)
+	if access := <-accesses; access.Operation != "Slow.Fail" || access.Result != "failed" || access.KeyHash != common.HexEncode(murmur.HashBytes([]byte("a"))[:keyHashSize]) {
+		t.Errorf("wanted a failed Slow.Fail with the hash of a, got %+v", access)
+	}
+	node.SetAccessSampleRate(0)
+	client.Call("Slow.Sleep", time.Duration(0), &x)
+	select {
+	case access := <-accesses:
+		t.Errorf("wanted no access with sample rate 0, got %+v", access)
+	default:
+	}
+}
+
+func TestSlowAccessListener(t *testing.T) {
+	node := NewNode("127.0.0.1:9492", "127.0.0.1:9492")
+	node.Export("Slow", &slowService{})
+	node.SetAccessSampleRate(1)
+	release := make(chan struct{})
+	defer close(release)
+	node.AddAccessListener(func(access Access) bool {
+		<-release
+		return true
+	})
+	node.MustStart()
+	defer node.Stop()
+	client := dialTest(t, "127.0.0.1:9492")
+	defer client.Close()
+	var x int
+	for i := 0; i < 3; i++ {
+		done := make(chan error)
+		go func() {
+			done <- client.Call("Slow.Sleep", time.Duration(0), &x)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wanted the reply not to wait for the blocked access listener")
+		}
+	}
+}
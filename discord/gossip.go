@@ -0,0 +1,304 @@
+package discord
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+const (
+	// gossipInterval is how often a Node probes a random other Node.
+	gossipInterval = common.PingInterval
+	// probeTimeout is how long a Node waits for the answer to a probe.
+	probeTimeout = common.PingInterval / 2
+	// suspectTimeout is how long a suspected Node has to refute the suspicion before it is confirmed dead.
+	suspectTimeout = common.PingInterval * 5
+	// indirectProbes is how many other Nodes are asked to probe a Node that didn't answer a direct probe.
+	indirectProbes = 3
+	// maxRumors is the maximum number of rumors piggybacked on each probe.
+	maxRumors = 8
+)
+
+// MemberState is the state of a Node in the gossip membership protocol.
+type MemberState int
+
+const (
+	Alive MemberState = iota
+	Suspect
+	Dead
+)
+
+func (self MemberState) String() string {
+	switch self {
+	case Alive:
+		return "Alive"
+	case Suspect:
+		return "Suspect"
+	case Dead:
+		return "Dead"
+	}
+	return fmt.Sprintf("MemberState(%d)", int(self))
+}
+
+// Rumor is a piece of membership news, disseminated by piggybacking on probes.
+// Rumors with higher incarnations override rumors with lower, and for the same incarnation Dead overrides Suspect which overrides Alive.
+type Rumor struct {
+	Remote      common.Remote
+	State       MemberState
+	Incarnation int64
+}
+
+// GossipPack is sent and returned by probes.
+type GossipPack struct {
+	Caller common.Remote
+	Rumors []Rumor
+}
+
+type member struct {
+	remote      common.Remote
+	state       MemberState
+	incarnation int64
+	suspected   time.Time
+}
+
+type rumorEntry struct {
+	rumor         Rumor
+	transmissions int
+}
+
+func (self *Node) overrides(rumor Rumor) bool {
+	known, ok := self.members[rumor.Remote.Addr]
+	if !ok {
+		return true
+	}
+	if rumor.Incarnation != known.incarnation {
+		return rumor.Incarnation > known.incarnation
+	}
+	return rumor.State > known.state
+}
+
+// spread will queue rumor for dissemination. Must be called with gossipLock held.
+func (self *Node) spread(rumor Rumor) {
+	self.rumors[rumor.Remote.Addr] = &rumorEntry{rumor: rumor}
+}
+
+// announce will spread a new incarnation of this Node, overriding any suspicions about it and telling others about its position.
+func (self *Node) announce() {
+	self.gossipLock.Lock()
+	defer self.gossipLock.Unlock()
+	self.incarnation++
+	self.spread(Rumor{
+		Remote:      self.Remote(),
+		State:       Alive,
+		Incarnation: self.incarnation,
+	})
+}
+
+// hear will apply rumor to the membership and ring of this Node, and spread it further if it was news.
+func (self *Node) hear(rumor Rumor) {
+	if rumor.Remote.Addr == self.GetBroadcastAddr() {
+		if rumor.State != Alive {
+			self.gossipLock.Lock()
+			if rumor.Incarnation > self.incarnation {
+				self.incarnation = rumor.Incarnation
+			}
+			self.gossipLock.Unlock()
+			self.announce()
+		}
+		return
+	}
+	self.gossipLock.Lock()
+	if !self.overrides(rumor) {
+		self.gossipLock.Unlock()
+		return
+	}
+	self.members[rumor.Remote.Addr] = &member{
+		remote:      rumor.Remote,
+		state:       rumor.State,
+		incarnation: rumor.Incarnation,
+		suspected:   time.Now(),
+	}
+	self.spread(rumor)
+	self.gossipLock.Unlock()
+	switch rumor.State {
+	case Alive:
+		self.routeLock.Lock()
+		self.ring.Add(rumor.Remote)
+		self.routeLock.Unlock()
+	case Dead:
+		self.RemoveNode(rumor.Remote)
+	}
+}
+
+// gossipPack will return a GossipPack with the least transmitted rumors of this Node, and forget the rumors transmitted enough times.
+func (self *Node) gossipPack() (result GossipPack) {
+	result.Caller = self.Remote()
+	limit := int(math.Ceil(3 * math.Log2(float64(self.ring.Size()+1))))
+	self.gossipLock.Lock()
+	defer self.gossipLock.Unlock()
+	entries := make([]*rumorEntry, 0, len(self.rumors))
+	for _, entry := range self.rumors {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].transmissions < entries[j].transmissions
+	})
+	for index, entry := range entries {
+		if index >= maxRumors {
+			break
+		}
+		result.Rumors = append(result.Rumors, entry.rumor)
+		if entry.transmissions++; entry.transmissions >= limit {
+			delete(self.rumors, entry.rumor.Remote.Addr)
+		}
+	}
+	return
+}
+
+// Probe will apply the rumors in pack and return the rumors of this Node.
+func (self *Node) Probe(pack GossipPack) GossipPack {
+	for _, rumor := range pack.Rumors {
+		self.hear(rumor)
+	}
+	return self.gossipPack()
+}
+
+func (self *Node) probe(target common.Remote) (ok bool) {
+	var reply GossipPack
+	op := "Discord.Probe"
+	self.triggerCommListeners(self.Remote(), target, op)
+	select {
+	case call := <-target.Go(op, self.gossipPack(), &reply).Done:
+		if call.Error != nil {
+			return false
+		}
+	case <-time.After(probeTimeout):
+		return false
+	}
+	for _, rumor := range reply.Rumors {
+		self.hear(rumor)
+	}
+	return true
+}
+
+// ProbeFor will probe target on behalf of another Node, that failed to probe it directly.
+func (self *Node) ProbeFor(target common.Remote) bool {
+	return self.probe(target)
+}
+
+func (self *Node) probeIndirectly(target common.Remote, helpers common.Remotes) bool {
+	results := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper common.Remote) {
+			var ok bool
+			select {
+			case call := <-helper.Go("Discord.ProbeFor", target, &ok).Done:
+				results <- call.Error == nil && ok
+			case <-time.After(probeTimeout * 2):
+				results <- false
+			}
+		}(helper)
+	}
+	for _ = range helpers {
+		if <-results {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Node) suspect(target common.Remote) {
+	self.gossipLock.Lock()
+	var incarnation int64
+	if known, ok := self.members[target.Addr]; ok {
+		incarnation = known.incarnation
+	}
+	self.gossipLock.Unlock()
+	self.hear(Rumor{
+		Remote:      target,
+		State:       Suspect,
+		Incarnation: incarnation,
+	})
+}
+
+// confirmSuspects will declare the Nodes suspected for longer than suspectTimeout dead.
+func (self *Node) confirmSuspects() {
+	var dead []Rumor
+	self.gossipLock.Lock()
+	for _, known := range self.members {
+		if known.state == Suspect && time.Now().Sub(known.suspected) > suspectTimeout {
+			dead = append(dead, Rumor{
+				Remote:      known.remote,
+				State:       Dead,
+				Incarnation: known.incarnation,
+			})
+		}
+	}
+	self.gossipLock.Unlock()
+	for _, rumor := range dead {
+		self.hear(rumor)
+	}
+}
+
+// others will return the Nodes in the ring, and the members not yet confirmed dead, except this one, in random order.
+// Members removed from the ring by pings or notifications are still probed, so that they eventually get confirmed dead everywhere.
+func (self *Node) others() (result common.Remotes) {
+	seen := map[string]bool{self.GetBroadcastAddr(): true}
+	for _, remote := range self.ring.Nodes() {
+		if !seen[remote.Addr] {
+			seen[remote.Addr] = true
+			result = append(result, remote)
+		}
+	}
+	self.gossipLock.Lock()
+	for addr, known := range self.members {
+		if !seen[addr] && known.state != Dead {
+			seen[addr] = true
+			result = append(result, known.remote)
+		}
+	}
+	self.gossipLock.Unlock()
+	for i := range result {
+		j := rand.Intn(i + 1)
+		result[i], result[j] = result[j], result[i]
+	}
+	return
+}
+
+func (self *Node) gossip() {
+	others := self.others()
+	if len(others) > 0 {
+		target := others[0]
+		if !self.probe(target) {
+			helpers := others[1:]
+			if len(helpers) > indirectProbes {
+				helpers = helpers[:indirectProbes]
+			}
+			if !self.probeIndirectly(target, helpers) {
+				self.suspect(target)
+			}
+		}
+	}
+	self.confirmSuspects()
+}
+func (self *Node) gossipPeriodically() {
+	for self.hasState(started) {
+		self.gossip()
+		time.Sleep(gossipInterval)
+	}
+}
+
+// MemberStates returns the gossip membership state of all Nodes this Node has heard rumors about.
+func (self *Node) MemberStates() (result map[string]MemberState) {
+	self.gossipLock.Lock()
+	defer self.gossipLock.Unlock()
+	result = make(map[string]MemberState, len(self.members))
+	for addr, known := range self.members {
+		result[addr] = known.state
+	}
+	return
+}
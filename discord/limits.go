@@ -0,0 +1,169 @@
+package discord
+
+import (
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/common"
+)
+
+// connCodec is a net/rpc.ServerCodec that samples requests for the AccessListeners of the Node, and rejects requests with common.ErrOverloaded when too many requests on the same connection are in flight.
+// Connections count against the connection limit of the Node from their first request to another service than Discord.
+// If the limit is reached by then, the connection is overloaded: all its requests are rejected, and it is closed after responding to the first one.
+// Requests to the Discord service are never rejected, since failing them would make the caller think this Node is dead.
+type connCodec struct {
+	rpc.ServerCodec
+	conn       net.Conn
+	node       *Node
+	lock       *sync.Mutex
+	seq        uint64
+	method     string
+	inFlight   int
+	counted    map[uint64]bool
+	sampled    map[uint64]sample
+	admitted   bool
+	overloaded bool
+}
+
+func (self *connCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	if err = self.ServerCodec.ReadRequestHeader(r); err == nil {
+		self.seq, self.method = r.Seq, r.ServiceMethod
+	}
+	return
+}
+func (self *connCodec) ReadRequestBody(body interface{}) (err error) {
+	if err = self.ServerCodec.ReadRequestBody(body); err != nil || strings.HasPrefix(self.method, "Discord.") {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.node.sample() {
+		self.sampled[self.seq] = sample{
+			start:     time.Now(),
+			operation: self.method,
+			key:       accessKey(body),
+		}
+	}
+	if !self.admitted && !self.overloaded {
+		if self.node.admit(self.conn) {
+			self.admitted = true
+		} else {
+			self.overloaded = true
+		}
+	}
+	if max := self.node.MaxInFlight(); self.overloaded || (max > 0 && self.inFlight >= max) {
+		atomic.AddInt64(&self.node.rejectedRequests, 1)
+		return common.ErrOverloaded
+	}
+	self.inFlight++
+	self.counted[self.seq] = true
+	return
+}
+func (self *connCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	self.lock.Lock()
+	if self.counted[r.Seq] {
+		delete(self.counted, r.Seq)
+		self.inFlight--
+	}
+	overloaded := self.overloaded
+	s, sampled := self.sampled[r.Seq]
+	delete(self.sampled, r.Seq)
+	self.lock.Unlock()
+	err = self.ServerCodec.WriteResponse(r, body)
+	self.node.countRequest(r.ServiceMethod)
+	if sampled {
+		self.node.logAccess(s, self.conn.RemoteAddr().String(), r.Error)
+	}
+	if overloaded && r.Error == common.ErrOverloaded.Error() {
+		self.conn.Close()
+	}
+	return
+}
+
+// SetMaxConnections will limit the number of connections this Node serves at the same time. Zero means unlimited.
+// Connections above the limit get their first request rejected with common.ErrOverloaded, and are then closed.
+// Remember that other Nodes keep pools of connections to this Node as well. Connections only used for the Discord service don't count against the limit.
+func (self *Node) SetMaxConnections(n int) *Node {
+	atomic.StoreInt32(&self.maxConns, int32(n))
+	return self
+}
+
+// MaxConnections returns the number of connections this Node serves at the same time.
+func (self *Node) MaxConnections() int {
+	return int(atomic.LoadInt32(&self.maxConns))
+}
+
+// SetMaxInFlight will limit the number of requests this Node handles at the same time for each connection. Zero means unlimited.
+// Requests above the limit are rejected with common.ErrOverloaded.
+func (self *Node) SetMaxInFlight(n int) *Node {
+	atomic.StoreInt32(&self.maxInFlight, int32(n))
+	return self
+}
+
+// MaxInFlight returns the number of requests this Node handles at the same time for each connection.
+func (self *Node) MaxInFlight() int {
+	return int(atomic.LoadInt32(&self.maxInFlight))
+}
+
+// Rejected returns the number of connections and requests this Node has rejected because of its limits.
+func (self *Node) Rejected() (connections, requests int64) {
+	return atomic.LoadInt64(&self.rejectedConns), atomic.LoadInt64(&self.rejectedRequests)
+}
+
+func (self *Node) countRequest(method string) {
+	self.requestsLock.Lock()
+	defer self.requestsLock.Unlock()
+	self.requests[method]++
+}
+
+// Requests returns the number of requests this Node has handled for each service method since it started.
+func (self *Node) Requests() (result map[string]int64) {
+	self.requestsLock.Lock()
+	defer self.requestsLock.Unlock()
+	result = make(map[string]int64, len(self.requests))
+	for method, count := range self.requests {
+		result[method] = count
+	}
+	return
+}
+
+// admit will count conn against the connection limit of this Node, unless the limit is already reached.
+func (self *Node) admit(conn net.Conn) bool {
+	self.metaLock.Lock()
+	defer self.metaLock.Unlock()
+	if max := self.MaxConnections(); max > 0 {
+		admitted := 0
+		for _, counted := range self.conns {
+			if counted {
+				admitted++
+			}
+		}
+		if admitted >= max {
+			atomic.AddInt64(&self.rejectedConns, 1)
+			return false
+		}
+	}
+	self.conns[conn] = true
+	return true
+}
+func (self *Node) serveConn(server *rpc.Server, conn net.Conn) {
+	codec := &connCodec{
+		ServerCodec: common.NewServerCodec(conn),
+		conn:        conn,
+		node:        self,
+		lock:        new(sync.Mutex),
+		counted:     make(map[uint64]bool),
+		sampled:     make(map[uint64]sample),
+	}
+	self.metaLock.Lock()
+	self.conns[conn] = false
+	self.metaLock.Unlock()
+	server.ServeCodec(codec)
+	self.metaLock.Lock()
+	delete(self.conns, conn)
+	self.metaLock.Unlock()
+}
@@ -17,10 +17,11 @@ import (
 // CommListener is a function listening for generic communication between two Nodes.
 type CommListener func(source, dest common.Remote, typ string) bool
 
-// PingPack contains the sender and a hash of its discord ring, to let the receiver compare to its current ring.
+// PingPack contains the sender and a hash of its discord ring, to let the receiver compare to its current ring, and the common.WireVersion of the sender.
 type PingPack struct {
 	Caller   common.Remote
 	RingHash []byte
+	Version  int
 }
 
 const (
@@ -34,16 +35,33 @@ const (
 // Like chord networks, it is a ring of nodes ordered by a position metric. Unlike chord, every node has every other node in its routing table.
 // This allows stable networks to route with a constant time complexity.
 type Node struct {
-	ring          *common.Ring
-	position      []byte
-	listenAddr    string
-	broadcastAddr string
-	listener      *net.TCPListener
-	metaLock      *sync.RWMutex
-	routeLock     *sync.Mutex
-	state         int32
-	exports       map[string]interface{}
-	commListeners []CommListener
+	ring             *common.Ring
+	position         []byte
+	listenAddr       string
+	broadcastAddr    string
+	listener         net.Listener
+	metaLock         *sync.RWMutex
+	routeLock        *sync.Mutex
+	state            int32
+	exports          map[string]interface{}
+	commListeners    []CommListener
+	conns            map[net.Conn]bool
+	gossipLock       *sync.Mutex
+	incarnation      int64
+	members          map[string]*member
+	rumors           map[string]*rumorEntry
+	maxConns         int32
+	maxInFlight      int32
+	rejectedConns    int64
+	rejectedRequests int64
+	accessSampleRate uint64
+	accessListeners  []*AccessListener
+	nAccessListeners int32
+	accesses         chan Access
+	requestsLock     *sync.Mutex
+	requests         map[string]int64
+	// dispatchingAccess tells whether a goroutine is calling the AccessListeners with the queued requests.
+	dispatchingAccess bool
 }
 
 func NewNode(listenAddr, broadcastAddr string) (result *Node) {
@@ -56,6 +74,14 @@ func NewNode(listenAddr, broadcastAddr string) (result *Node) {
 		metaLock:      new(sync.RWMutex),
 		routeLock:     new(sync.Mutex),
 		state:         created,
+		conns:         make(map[net.Conn]bool),
+		gossipLock:    new(sync.Mutex),
+		incarnation:   time.Now().UnixNano(),
+		members:       make(map[string]*member),
+		rumors:        make(map[string]*rumorEntry),
+		requestsLock:  new(sync.Mutex),
+		requests:      make(map[string]int64),
+		accesses:      make(chan Access, accessQueueSize),
 	}
 }
 
@@ -98,8 +124,9 @@ func (self *Node) SetPosition(position []byte) *Node {
 	copy(self.position, position)
 	self.metaLock.Unlock()
 	self.routeLock.Lock()
-	defer self.routeLock.Unlock()
 	self.ring.Add(self.Remote())
+	self.routeLock.Unlock()
+	self.announce()
 	return self
 }
 
@@ -113,6 +140,11 @@ func (self *Node) Redundancy() int {
 	return self.ring.Redundancy()
 }
 
+// Replicas will return the Nodes responsible for key according to the ring of this Node, starting with its successor.
+func (self *Node) Replicas(key []byte) common.Remotes {
+	return self.ring.Replicas(key, self.Redundancy())
+}
+
 // CountNodes returns the number of Nodes in the ring.
 func (self *Node) CountNodes() int {
 	return self.ring.Size()
@@ -153,12 +185,12 @@ func (self *Node) hasState(s int32) bool {
 func (self *Node) changeState(old, neu int32) bool {
 	return atomic.CompareAndSwapInt32(&self.state, old, neu)
 }
-func (self *Node) getListener() *net.TCPListener {
+func (self *Node) getListener() net.Listener {
 	self.metaLock.RLock()
 	defer self.metaLock.RUnlock()
 	return self.listener
 }
-func (self *Node) setListener(l *net.TCPListener) {
+func (self *Node) setListener(l net.Listener) {
 	self.metaLock.Lock()
 	defer self.metaLock.Unlock()
 	self.listener = l
@@ -169,10 +201,15 @@ func (self *Node) Remote() common.Remote {
 	return common.Remote{self.GetPosition(), self.GetBroadcastAddr()}
 }
 
-// Stop will shut down this Node permanently.
+// Stop will shut down this Node permanently, closing its listener and all connections to it.
 func (self *Node) Stop() {
 	if self.changeState(started, stopped) {
 		self.getListener().Close()
+		self.metaLock.Lock()
+		defer self.metaLock.Unlock()
+		for conn, _ := range self.conns {
+			conn.Close()
+		}
 	}
 }
 func (self *Node) MustStart() {
@@ -181,7 +218,7 @@ func (self *Node) MustStart() {
 	}
 }
 
-// Start will spin up this Node, export all its api interfaces and start its notify and ping jobs.
+// Start will spin up this Node, export all its api interfaces and start its notify, ping and gossip jobs.
 func (self *Node) Start() (err error) {
 	if !self.changeState(created, started) {
 		return fmt.Errorf("%v can only be started when in state 'created'", self)
@@ -189,13 +226,19 @@ func (self *Node) Start() (err error) {
 	if self.listenAddr == "" {
 		return fmt.Errorf("%v needs to have an address to listen at", self)
 	}
-	var addr *net.TCPAddr
-	if addr, err = net.ResolveTCPAddr("tcp", self.listenAddr); err != nil {
-		return
-	}
-	var listener *net.TCPListener
-	if listener, err = net.ListenTCP("tcp", addr); err != nil {
-		return
+	var listener net.Listener
+	if common.IsEmbedded(self.listenAddr) {
+		if listener, err = common.Switch.Listen(self.listenAddr); err != nil {
+			return
+		}
+	} else {
+		var addr *net.TCPAddr
+		if addr, err = net.ResolveTCPAddr("tcp", self.listenAddr); err != nil {
+			return
+		}
+		if listener, err = net.ListenTCP("tcp", addr); err != nil {
+			return
+		}
 	}
 	self.setListener(listener)
 	server := rpc.NewServer()
@@ -208,10 +251,11 @@ func (self *Node) Start() (err error) {
 		}
 	}
 	self.ring.Add(self.Remote())
+	self.announce()
 	go func() {
 		var conn net.Conn
 		for conn, err = self.getListener().Accept(); err == nil; conn, err = self.getListener().Accept() {
-			go server.ServeConn(conn)
+			go self.serveConn(server, conn)
 		}
 		if !strings.Contains(err.Error(), "use of closed network connection") {
 			panic(err)
@@ -219,6 +263,7 @@ func (self *Node) Start() (err error) {
 	}()
 	go self.notifyPeriodically()
 	go self.pingPeriodically()
+	go self.gossipPeriodically()
 	return
 }
 
@@ -264,12 +309,16 @@ func (self *Node) pingPredecessor() {
 	ping := PingPack{
 		RingHash: self.ring.Hash(),
 		Caller:   self.Remote(),
+		Version:  common.WireVersion,
 	}
 	var newPred common.Remote
 	op := "Discord.Ping"
 	self.triggerCommListeners(self.Remote(), pred, op)
 	if err := pred.Call(op, ping, &newPred); err != nil {
-		self.RemoveNode(pred)
+		// A Node alone in its ring pings itself, which fails only when it is stopping.
+		if pred.Addr != self.GetBroadcastAddr() {
+			self.RemoveNode(pred)
+		}
 	} else {
 		self.routeLock.Lock()
 		defer self.routeLock.Unlock()
@@ -296,7 +345,10 @@ func (self *Node) notifySuccessor() {
 	selfRemote := self.Remote()
 	self.triggerCommListeners(selfRemote, succ, op)
 	if err := succ.Call(op, selfRemote, &otherPred); err != nil {
-		self.RemoveNode(succ)
+		// A Node alone in its ring notifies itself, which fails only when it is stopping.
+		if succ.Addr != self.GetBroadcastAddr() {
+			self.RemoveNode(succ)
+		}
 	} else {
 		if otherPred.Addr != self.GetBroadcastAddr() {
 			self.routeLock.Lock()
@@ -393,6 +445,10 @@ func (self *Node) GetSuccessorFor(key []byte) common.Remote {
 		if !common.BetweenIE(key, predecessor.Pos, successor.Pos) {
 			// Otherwise, ask the predecessor we actually found about who is the successor of the key
 			if err := predecessor.Call("Discord.GetSuccessorFor", key, successor); err != nil {
+				if predecessor.Addr == self.GetBroadcastAddr() {
+					// We can't reach ourselves, so we have been stopped while serving this request.
+					return *successor
+				}
 				self.RemoveNode(*predecessor)
 				return self.GetSuccessorFor(key)
 			}
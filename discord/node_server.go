@@ -15,6 +15,9 @@ func (self *nodeServer) Nodes(x int, nodes *common.Remotes) error {
 	return nil
 }
 func (self *nodeServer) Ping(ping PingPack, remote *common.Remote) error {
+	if ping.Version < common.MinWireVersion {
+		return common.ErrIncompatibleVersion
+	}
 	*remote = (*Node)(self).Ping(ping)
 	return nil
 }
@@ -26,3 +29,11 @@ func (self *nodeServer) GetSuccessorFor(key []byte, successor *common.Remote) er
 	*successor = (*Node)(self).GetSuccessorFor(key)
 	return nil
 }
+func (self *nodeServer) Probe(pack GossipPack, reply *GossipPack) error {
+	*reply = (*Node)(self).Probe(pack)
+	return nil
+}
+func (self *nodeServer) ProbeFor(target common.Remote, ok *bool) error {
+	*ok = (*Node)(self).ProbeFor(target)
+	return nil
+}
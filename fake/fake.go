@@ -0,0 +1,244 @@
+// Package fake contains an in-memory implementation of the data API of god, for unit testing code using god without a running cluster.
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+	"github.com/zond/setop"
+)
+
+// Fake is an in-memory implementation of client.KV, client.SubKV, client.SetOps and client.Watcher, for unit testing code using god without a running cluster.
+// It keeps everything in one radix.Tree, and does not enforce immutability, frozen ranges or quorum, so the Try methods only fail where client.Conn would panic.
+type Fake struct {
+	lock           *sync.RWMutex
+	timestamp      int64
+	writeListeners common.Listeners[client.WriteListener]
+	tree           *radix.Tree
+}
+
+// NewFake returns an empty Fake.
+func NewFake() (result *Fake) {
+	result = &Fake{
+		lock: new(sync.RWMutex),
+		tree: radix.NewTree(),
+	}
+	result.tree.SetWriteListener(result.triggerWriteListeners)
+	return
+}
+
+// now returns a timestamp greater than all previous timestamps of this Fake, so that each write replaces the previous one.
+func (self *Fake) now() int64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if now := time.Now().UnixNano(); now > self.timestamp {
+		self.timestamp = now
+	} else {
+		self.timestamp++
+	}
+	return self.timestamp
+}
+
+// AddWriteListener will make l get notified of each write to this Fake, with the value it replaced, until it returns false.
+func (self *Fake) AddWriteListener(l client.WriteListener) {
+	self.writeListeners.Add(l)
+}
+func (self *Fake) triggerWriteListeners(write radix.Write) {
+	self.writeListeners.Trigger(func(l client.WriteListener) bool {
+		return l(write)
+	})
+}
+
+func (self *Fake) Get(key []byte) (value []byte, existed bool) {
+	value, _, existed = self.tree.Get(key)
+	return
+}
+func (self *Fake) Put(key, value []byte) {
+	self.tree.Put(key, value, self.now())
+}
+func (self *Fake) SPut(key, value []byte) {
+	self.Put(key, value)
+}
+func (self *Fake) TryPut(key, value []byte) error {
+	self.Put(key, value)
+	return nil
+}
+func (self *Fake) Del(key []byte) {
+	self.tree.FakeDel(key, self.now())
+}
+func (self *Fake) SDel(key []byte) {
+	self.Del(key)
+}
+func (self *Fake) TryDel(key []byte) error {
+	self.Del(key)
+	return nil
+}
+func (self *Fake) Next(key []byte) (nextKey, nextValue []byte, existed bool) {
+	nextKey, nextValue, _, existed = self.tree.Next(key)
+	return
+}
+func (self *Fake) Prev(key []byte) (prevKey, prevValue []byte, existed bool) {
+	prevKey, prevValue, _, existed = self.tree.Prev(key)
+	return
+}
+func (self *Fake) Size() int {
+	return self.tree.Size()
+}
+func (self *Fake) SubGet(key, subKey []byte) (value []byte, existed bool) {
+	value, _, existed = self.tree.SubGet(key, subKey)
+	return
+}
+func (self *Fake) SubPut(key, subKey, value []byte) {
+	self.tree.SubPut(key, subKey, value, self.now())
+}
+func (self *Fake) SSubPut(key, subKey, value []byte) {
+	self.SubPut(key, subKey, value)
+}
+func (self *Fake) TrySubPut(key, subKey, value []byte) error {
+	self.SubPut(key, subKey, value)
+	return nil
+}
+func (self *Fake) SubDel(key, subKey []byte) {
+	self.tree.SubFakeDel(key, subKey, self.now())
+}
+func (self *Fake) SSubDel(key, subKey []byte) {
+	self.SubDel(key, subKey)
+}
+func (self *Fake) TrySubDel(key, subKey []byte) error {
+	self.SubDel(key, subKey)
+	return nil
+}
+func (self *Fake) SubClear(key []byte) {
+	self.tree.SubClear(key, self.now())
+}
+func (self *Fake) SSubClear(key []byte) {
+	self.SubClear(key)
+}
+func (self *Fake) TrySubClear(key []byte) error {
+	self.SubClear(key)
+	return nil
+}
+func (self *Fake) SubSize(key []byte) int {
+	return self.tree.SubSize(key)
+}
+func (self *Fake) First(key []byte) (firstKey, firstValue []byte, existed bool) {
+	firstKey, firstValue, _, existed = self.tree.SubFirst(key)
+	return
+}
+func (self *Fake) Last(key []byte) (lastKey, lastValue []byte, existed bool) {
+	lastKey, lastValue, _, existed = self.tree.SubLast(key)
+	return
+}
+func (self *Fake) SubNext(key, subKey []byte) (nextKey, nextValue []byte, existed bool) {
+	nextKey, nextValue, _, existed = self.tree.SubNext(key, subKey)
+	return
+}
+func (self *Fake) SubPrev(key, subKey []byte) (prevKey, prevValue []byte, existed bool) {
+	prevKey, prevValue, _, existed = self.tree.SubPrev(key, subKey)
+	return
+}
+func (self *Fake) Count(key, min, max []byte, mininc, maxinc bool) int {
+	return self.tree.SubSizeBetween(key, min, max, mininc, maxinc)
+}
+func (self *Fake) IndexOf(key, subKey []byte) (index int, existed bool) {
+	return self.tree.SubIndexOf(key, subKey)
+}
+func (self *Fake) Slice(key, min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	self.tree.SubEachBetween(key, min, max, mininc, maxinc, func(key []byte, value []byte, version int64) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version})
+		return true
+	})
+	return
+}
+func (self *Fake) ReverseSlice(key, min, max []byte, mininc, maxinc bool) (result []common.Item) {
+	self.tree.SubReverseEachBetween(key, min, max, mininc, maxinc, func(key []byte, value []byte, version int64) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version})
+		return true
+	})
+	return
+}
+func (self *Fake) SliceLen(key, min []byte, mininc bool, maxRes int) (result []common.Item) {
+	self.tree.SubEachBetween(key, min, nil, mininc, false, func(key []byte, value []byte, version int64) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version})
+		return len(result) < maxRes
+	})
+	return
+}
+func (self *Fake) ReverseSliceLen(key, max []byte, maxinc bool, maxRes int) (result []common.Item) {
+	self.tree.SubReverseEachBetween(key, nil, max, false, maxinc, func(key []byte, value []byte, version int64) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version})
+		return len(result) < maxRes
+	})
+	return
+}
+func (self *Fake) SliceIndex(key []byte, min, max *int) (result []common.Item) {
+	self.tree.SubEachBetweenIndex(key, min, max, func(key []byte, value []byte, version int64, index int) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version, Index: index})
+		return true
+	})
+	return
+}
+func (self *Fake) ReverseSliceIndex(key []byte, min, max *int) (result []common.Item) {
+	self.tree.SubReverseEachBetweenIndex(key, min, max, func(key []byte, value []byte, version int64, index int) bool {
+		result = append(result, common.Item{Key: key, Value: value, Timestamp: version, Index: index})
+		return true
+	})
+	return
+}
+
+// SetExpression will run expr against this Fake like a Node would, and panic where client.Conn would panic because the Node returned an error.
+func (self *Fake) SetExpression(expr setop.SetExpression) (result []setop.SetOpResult) {
+	merge, err := common.ParseExpression(&expr)
+	if err != nil {
+		panic(err)
+	}
+	if expr.Dest != nil && merge == nil && expr.Op.Merge == setop.Append {
+		panic(fmt.Errorf("When storing results of Set expressions the Append merge function is not allowed"))
+	}
+	if err = expr.Each(func(b []byte) (setop.Skipper, error) {
+		return &treeSkipper{
+			tree: self.tree,
+			key:  b,
+		}, nil
+	}, func(res *setop.SetOpResult) {
+		if merge != nil {
+			res.Values = [][]byte{merge(res.Key, res.Values)}
+		}
+		if expr.Dest == nil {
+			result = append(result, *res)
+		} else {
+			self.SubPut(expr.Dest, res.Key, res.Values[0])
+		}
+	}); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// treeSkipper is a setop.Skipper over a sub tree of a radix.Tree.
+type treeSkipper struct {
+	tree *radix.Tree
+	key  []byte
+}
+
+func (self *treeSkipper) Skip(min []byte, inc bool) (result *setop.SetOpResult, err error) {
+	self.tree.SubEachBetween(self.key, min, nil, inc, false, func(key, value []byte, timestamp int64) bool {
+		result = &setop.SetOpResult{
+			Key:    key,
+			Values: [][]byte{value},
+		}
+		return false
+	})
+	return
+}
+
+var (
+	_ client.KV      = (*Fake)(nil)
+	_ client.SubKV   = (*Fake)(nil)
+	_ client.SetOps  = (*Fake)(nil)
+	_ client.Watcher = (*Fake)(nil)
+)
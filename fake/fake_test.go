@@ -0,0 +1,79 @@
+package fake
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/dhash"
+	"github.com/zond/god/radix"
+	"github.com/zond/setop"
+)
+
+type fakeable interface {
+	client.KV
+	client.SubKV
+	client.SetOps
+}
+
+func assertDataAPI(t *testing.T, name string, db fakeable) {
+	db.Put([]byte("a"), []byte("1"))
+	db.Put([]byte("b"), []byte("2"))
+	if value, existed := db.Get([]byte("a")); !existed || string(value) != "1" {
+		t.Errorf("%v: wanted 1, got %s, %v", name, value, existed)
+	}
+	if key, _, existed := db.Next([]byte("a")); !existed || string(key) != "b" {
+		t.Errorf("%v: wanted b after a, got %s, %v", name, key, existed)
+	}
+	db.Del([]byte("a"))
+	if _, existed := db.Get([]byte("a")); existed {
+		t.Errorf("%v: a should be deleted", name)
+	}
+	if size := db.Size(); size != 1 {
+		t.Errorf("%v: wanted size 1, got %v", name, size)
+	}
+	for _, k := range []string{"x", "y", "z"} {
+		db.SubPut([]byte("s1"), []byte(k), []byte(k))
+	}
+	db.SubPut([]byte("s2"), []byte("y"), []byte("y"))
+	if count := db.Count([]byte("s1"), []byte("x"), []byte("z"), true, false); count != 2 {
+		t.Errorf("%v: wanted 2 between x and z, got %v", name, count)
+	}
+	if index, existed := db.IndexOf([]byte("s1"), []byte("y")); !existed || index != 1 {
+		t.Errorf("%v: wanted y at 1, got %v, %v", name, index, existed)
+	}
+	if items := db.ReverseSliceLen([]byte("s1"), nil, false, 2); len(items) != 2 || string(items[0].Key) != "z" {
+		t.Errorf("%v: wanted z and y, got %v", name, items)
+	}
+	min := 1
+	if items := db.SliceIndex([]byte("s1"), &min, nil); len(items) != 2 || string(items[0].Key) != "y" {
+		t.Errorf("%v: wanted y and z, got %v", name, items)
+	}
+	if res := db.SetExpression(setop.SetExpression{Code: "(I s1 s2)"}); len(res) != 1 || string(res[0].Key) != "y" {
+		t.Errorf("%v: wanted the intersection y, got %v", name, res)
+	}
+	db.SubClear([]byte("s1"))
+	if size := db.SubSize([]byte("s1")); size != 0 {
+		t.Errorf("%v: wanted s1 cleared, got size %v", name, size)
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := NewFake()
+	var writes []radix.Write
+	fake.AddWriteListener(func(write radix.Write) bool {
+		writes = append(writes, write)
+		return len(writes) < 2
+	})
+	assertDataAPI(t, "fake", fake)
+	if len(writes) != 2 || !bytes.Equal(writes[1].Key, []byte("b")) {
+		t.Errorf("wanted the listener to see the first two writes, got %v", writes)
+	}
+	node := dhash.NewEmbeddedNode("fake", "")
+	node.MustStart()
+	defer node.Stop()
+	assertDataAPI(t, "conn", client.MustConn(common.EmbeddedPrefix+"fake"))
+	node.Local().SubClear([]byte("s2"))
+	assertDataAPI(t, "local", node.Local())
+}
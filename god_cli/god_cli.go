@@ -6,14 +6,19 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/big"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/query"
 	"github.com/zond/setop"
 )
 
@@ -30,6 +35,7 @@ type action func(conn *client.Conn, args []string)
 
 var ip = flag.String("ip", "127.0.0.1", "IP address to connect to")
 var port = flag.Int("port", 9191, "Port to connect to")
+var override = flag.Bool("override", false, "Whether to override immutability when writing write-once keys")
 var enc = flag.String("enc", stringFormat, fmt.Sprintf("What format to assume when encoding and decoding byte slices: %v", formats))
 
 func encode(s string) []byte {
@@ -108,8 +114,13 @@ var actions = map[*actionSpec]action{
 	newActionSpec("sliceLen \\S+ \\S+ \\d+"):                sliceLen,
 	newActionSpec("reverseSliceLen \\S+ \\S+ \\d+"):         reverseSliceLen,
 	newActionSpec("setOp .+"):                               setOp,
+	newActionSpec("query .+"):                               runQuery,
+	newActionSpec("exec .+"):                                runUpdate,
 	newActionSpec("dumpSetOp \\S+ .+"):                      dumpSetOp,
 	newActionSpec("put \\S+ \\S+"):                          put,
+	newActionSpec("putImmutable \\S+ \\S+"):                 putImmutable,
+	newActionSpec("immutablePrefix \\S+"):                   immutablePrefix,
+	newActionSpec("mutablePrefix \\S+"):                     mutablePrefix,
 	newActionSpec("clear"):                                  clear,
 	newActionSpec("dump"):                                   dump,
 	newActionSpec("subDump \\S+"):                           subDump,
@@ -118,13 +129,30 @@ var actions = map[*actionSpec]action{
 	newActionSpec("count \\S+ \\S+ \\S+"):                   count,
 	newActionSpec("mirrorCount \\S+ \\S+ \\S+"):             mirrorCount,
 	newActionSpec("get \\S+"):                               get,
+	newActionSpec("debugGet \\S+"):                          debugGet,
+	newActionSpec("getRange \\S+ \\d+ \\d+"):                getRange,
+	newActionSpec("appendValue \\S+ \\S+"):                  appendValue,
+	newActionSpec("debugPut \\S+ \\S+"):                     debugPut,
+	newActionSpec("delMulti \\S+"):                          delMulti,
 	newActionSpec("del \\S+"):                               del,
 	newActionSpec("subPut \\S+ \\S+ \\S+"):                  subPut,
 	newActionSpec("subGet \\S+ \\S+"):                       subGet,
 	newActionSpec("subDel \\S+ \\S+"):                       subDel,
 	newActionSpec("subClear \\S+"):                          subClear,
 	newActionSpec("describeAll"):                            describeAll,
+	newActionSpec("stats"):                                  stats,
+	newActionSpec("risk"):                                   risk,
+	newActionSpec("divergences"):                            divergences,
+	newActionSpec("divergences \\S+"):                       divergences,
+	newActionSpec("events"):                                 events,
+	newActionSpec("expiries"):                               expiries,
+	newActionSpec("workers"):                                workers,
+	newActionSpec("nextID"):                                 nextID,
+	newActionSpec("heatmap \\d+"):                           heatmap,
 	newActionSpec("describe \\S+"):                          describe,
+	newActionSpec("browse \\S+"):                            browse,
+	newActionSpec("browse \\S+ \\S+"):                       browse,
+	newActionSpec("browse \\S+ \\S+ \\d+"):                  browse,
 	newActionSpec("describeTree \\S+"):                      describeTree,
 	newActionSpec("describeAllTrees"):                       describeAllTrees,
 	newActionSpec("mirrorFirst \\S+"):                       mirrorFirst,
@@ -144,11 +172,33 @@ var actions = map[*actionSpec]action{
 	newActionSpec("subNext \\S+ \\S+"):                      subNext,
 	newActionSpec("subPrev \\S+ \\S+"):                      subPrev,
 	newActionSpec("indexOf \\S+ \\S+"):                      indexOf,
+	newActionSpec("rank \\S+ \\S+"):                         rank,
+	newActionSpec("topN \\S+ \\d+"):                         topN,
 	newActionSpec("reverseIndexOf \\S+ \\S+"):               reverseIndexOf,
 	newActionSpec("configuration"):                          configuration,
 	newActionSpec("subConfiguration \\S+"):                  subConfiguration,
 	newActionSpec("configure \\S+ \\S+"):                    configure,
 	newActionSpec("subConfigure \\S+ \\S+ \\S+"):            subConfigure,
+	newActionSpec("status"):                                 status,
+	newActionSpec("sync \\S+"):                              syncNode,
+	newActionSpec("decommission \\S+"):                      decommission,
+	newActionSpec("restoreReport \\S+"):                     restoreReport,
+	newActionSpec("snapshot \\S+"):                          snapshot,
+	newActionSpec("restore \\S+"):                           restore,
+	newActionSpec("backup \\S+"):                            backup,
+	newActionSpec("backups"):                                backups,
+	newActionSpec("restoreBackup \\S+"):                     restoreBackup,
+	newActionSpec("restoreBackupDir \\S+ \\S+"):             restoreBackupDir,
+	newActionSpec("pauseMigration"):                         pauseMigration,
+	newActionSpec("resumeMigration"):                        resumeMigration,
+	newActionSpec("pauseSync"):                              pauseSync,
+	newActionSpec("resumeSync"):                             resumeSync,
+	newActionSpec("redundancy \\d+"):                        redundancy,
+	newActionSpec("freeze \\S+ \\S+"):                       freeze,
+	newActionSpec("unfreeze \\S+ \\S+"):                     unfreeze,
+	newActionSpec("frozen"):                                 frozen,
+	newActionSpec("systemNamespaces"):                       systemNamespaces,
+	newActionSpec("system \\S+"):                            system,
 }
 
 func mustAtoi(s string) *int {
@@ -274,6 +324,37 @@ func setOp(conn *client.Conn, args []string) {
 	}
 }
 
+func runUpdate(conn *client.Conn, args []string) {
+	update, err := query.ParseUpdate(strings.Join(args[1:], " "))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err = update.Run(conn); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func runQuery(conn *client.Conn, args []string) {
+	stmt, err := query.Parse(strings.Join(args[1:], " "))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(strings.Join(stmt.Columns, "\t"))
+	for _, row := range stmt.Run(conn) {
+		var cols []string
+		for index, col := range row {
+			if stmt.Columns[index] == "value" {
+				cols = append(cols, decode(col))
+			} else {
+				cols = append(cols, string(col))
+			}
+		}
+		fmt.Println(strings.Join(cols, "\t"))
+	}
+}
+
 func dumpSetOp(conn *client.Conn, args []string) {
 	op, err := setop.NewSetOpParser(args[2]).Parse()
 	if err != nil {
@@ -309,6 +390,18 @@ func indexOf(conn *client.Conn, args []string) {
 	}
 }
 
+func rank(conn *client.Conn, args []string) {
+	if rank, existed := conn.Rank([]byte(args[1]), []byte(args[2])); existed {
+		fmt.Println(rank)
+	}
+}
+
+func topN(conn *client.Conn, args []string) {
+	for _, item := range conn.TopN([]byte(args[1]), *(mustAtoi(args[2]))) {
+		fmt.Printf("%v: %v => %v\n", item.Index, decode(item.Key), string(item.Value))
+	}
+}
+
 func show(conn *client.Conn) {
 	fmt.Println(conn.Describe())
 }
@@ -411,6 +504,339 @@ func describeAll(conn *client.Conn, args []string) {
 	}
 }
 
+func status(conn *client.Conn, args []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Addr\tPos\tOwned\tHeld\tLoad\tClockOffset\tLastSync\tLastMigrate\tPaused")
+	for _, description := range conn.DescribeAllNodes() {
+		var paused []string
+		if description.SyncPaused {
+			paused = append(paused, "sync")
+		}
+		if description.MigrationPaused {
+			paused = append(paused, "migration")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.2f\t%v\t%v\t%v\t%v\n",
+			description.Addr,
+			hex.EncodeToString(description.Pos),
+			description.OwnedEntries,
+			description.HeldEntries,
+			description.Load,
+			description.ClockOffset,
+			description.LastSync.Format(time.RFC3339),
+			description.LastMigrate.Format(time.RFC3339),
+			strings.Join(paused, ","))
+	}
+	w.Flush()
+}
+
+func stats(conn *client.Conn, args []string) {
+	cluster, err := conn.ClusterStats()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	requestRate := func(rates map[string]float64) (result float64) {
+		for _, rate := range rates {
+			result += rate
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Addr\tUptime\tOwned\tHeld\tLogBytes\tReq/s\tSyncs\tCleans\tMigrations\tRejected\tClockError")
+	for _, node := range cluster.Nodes {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%.1f\t%v\t%v\t%v\t%v\t%v\n",
+			node.Addr,
+			node.Uptime,
+			node.OwnedEntries,
+			node.HeldEntries,
+			node.LogBytes,
+			requestRate(node.RequestRates),
+			node.Syncs,
+			node.Cleans,
+			node.Migrations,
+			node.RejectedRequests,
+			node.ClockError)
+	}
+	fmt.Fprintf(w, "Total\t\t%v\t%v\t%v\t%.1f\t\t\t\t\t%v\n",
+		cluster.OwnedEntries,
+		cluster.HeldEntries,
+		cluster.LogBytes,
+		requestRate(cluster.RequestRates),
+		cluster.MaxClockError)
+	w.Flush()
+	for addr, err := range cluster.Failed {
+		fmt.Printf("%v failed: %v\n", addr, err)
+	}
+}
+
+func workers(conn *client.Conn, args []string) {
+	result, err := conn.Workers()
+	if err != nil {
+		fmt.Println(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Addr\tWorker\tState\tSince\tRuns\tLastRun\tLastDuration\tPanics\tLastPanic")
+	for addr, statuses := range result {
+		for _, status := range statuses {
+			state := "waiting"
+			if status.Running {
+				state = "running"
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", addr, status.Name, state, status.Since, status.Runs, status.LastRun, status.LastDuration, status.Panics, status.LastPanic)
+		}
+	}
+	w.Flush()
+}
+
+func divergences(conn *client.Conn, args []string) {
+	var key []byte
+	if len(args) > 1 {
+		key = []byte(args[1])
+	}
+	result, err := conn.Divergences(key)
+	if err != nil {
+		fmt.Println(err)
+	}
+	for addr, divergences := range result {
+		for _, divergence := range divergences {
+			fmt.Printf("%v: %v\n", addr, divergence)
+		}
+	}
+}
+
+func events(conn *client.Conn, args []string) {
+	conn.Start()
+	conn.Subscribe(func(event common.Event) bool {
+		fmt.Println(event)
+		return true
+	})
+	select {}
+}
+
+func expiries(conn *client.Conn, args []string) {
+	conn.Start()
+	conn.SubscribeExpiries(func(event common.Event) bool {
+		fmt.Println(event)
+		return true
+	})
+	select {}
+}
+
+func heatmap(conn *client.Conn, args []string) {
+	result, err := conn.Heatmap(*(mustAtoi(args[1])))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "From\tKeys\tBytes")
+	for _, bucket := range result.Buckets {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", common.HexEncode(bucket.From), bucket.Keys, bucket.Bytes)
+	}
+	w.Flush()
+	for addr, err := range result.Failed {
+		fmt.Printf("%v failed: %v\n", addr, err)
+	}
+}
+
+func risk(conn *client.Conn, args []string) {
+	report, err := conn.RiskReport()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Owner\tReplicas\tHosts\tZones")
+	for _, r := range report.Ranges {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.Owner, len(r.Replicas), r.Hosts, r.Zones)
+	}
+	w.Flush()
+	fmt.Printf("%v of %v ranges have fewer than %v replicas, %v have replicas sharing a host or zone\n", len(report.UnderReplicated), len(report.Ranges), report.Redundancy, len(report.Colocated))
+	for host, addrs := range report.SharedHosts {
+		fmt.Printf("%v runs %v\n", host, addrs)
+	}
+	fmt.Printf("Oldest unsynced write: %v, longest divergence: %v\n", report.OldestUnsynced, report.MaxDivergenceAge)
+	for _, n := range report.Nodes {
+		if !n.ConvergenceMet() {
+			fmt.Printf("%v is %v behind, missing its convergence bound of %v\n", n.Addr, n.Lag(), n.ConvergenceBound)
+		}
+	}
+	fmt.Printf("Estimated loss probability if each host fails with probability 0.01: %.6f\n", report.LossProbability(0.01))
+	for addr, err := range report.Failed {
+		fmt.Printf("%v failed: %v\n", addr, err)
+	}
+}
+
+func pauseMigration(conn *client.Conn, args []string) {
+	if err := conn.PauseMigration(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func resumeMigration(conn *client.Conn, args []string) {
+	if err := conn.ResumeMigration(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func pauseSync(conn *client.Conn, args []string) {
+	if err := conn.PauseSync(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func resumeSync(conn *client.Conn, args []string) {
+	if err := conn.ResumeSync(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func redundancy(conn *client.Conn, args []string) {
+	if err := conn.SetRedundancy(*mustAtoi(args[1])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func freeze(conn *client.Conn, args []string) {
+	if err := conn.Freeze([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func unfreeze(conn *client.Conn, args []string) {
+	if err := conn.Unfreeze([]byte(args[1]), []byte(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func frozen(conn *client.Conn, args []string) {
+	if ranges, err := conn.Frozen(); err != nil {
+		fmt.Println(err)
+	} else {
+		for _, r := range ranges {
+			fmt.Printf("%v - %v\n", string(r.Min), string(r.Max))
+		}
+	}
+}
+
+func systemNamespaces(conn *client.Conn, args []string) {
+	if namespaces, err := conn.SystemNamespaces(); err != nil {
+		fmt.Println(err)
+	} else {
+		for _, namespace := range namespaces {
+			fmt.Println(namespace)
+		}
+	}
+}
+
+func system(conn *client.Conn, args []string) {
+	for _, item := range conn.SystemEntries(args[1]) {
+		fmt.Printf("%v => %v\n", string(item.Key), string(item.Value))
+	}
+}
+
+func syncNode(conn *client.Conn, args []string) {
+	if bytes, err := hex.DecodeString(args[1]); err != nil {
+		fmt.Println(err)
+	} else if err := conn.SyncNode(bytes); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func decommission(conn *client.Conn, args []string) {
+	if bytes, err := hex.DecodeString(args[1]); err != nil {
+		fmt.Println(err)
+	} else if err := conn.DecommissionNode(bytes); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func restoreReport(conn *client.Conn, args []string) {
+	if bytes, err := hex.DecodeString(args[1]); err != nil {
+		fmt.Println(err)
+	} else {
+		if report, err := conn.RestoreReport(bytes); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(report)
+		}
+	}
+}
+
+func snapshot(conn *client.Conn, args []string) {
+	if encoded, err := conn.Snapshot(); err != nil {
+		fmt.Println(err)
+	} else if err := ioutil.WriteFile(args[1], encoded, 0644); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func restore(conn *client.Conn, args []string) {
+	if encoded, err := ioutil.ReadFile(args[1]); err != nil {
+		fmt.Println(err)
+	} else {
+		if changed, err := conn.Restore(encoded); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println(changed)
+		}
+	}
+}
+
+func printBackup(manifest common.BackupManifest) {
+	fmt.Printf("%v at %v, complete: %v, entries newer than the backup: %v\n", manifest.ID, manifest.Time(), manifest.Complete(), manifest.Newer())
+	for _, file := range manifest.Files {
+		fmt.Printf("  %v: %v (%v entries, %v newer, %v bytes)\n", file.Addr, file.Path, file.Entries, file.Newer, file.Bytes)
+	}
+	for addr, err := range manifest.Failed {
+		fmt.Printf("  %v failed: %v\n", addr, err)
+	}
+}
+
+func backup(conn *client.Conn, args []string) {
+	if manifest, err := conn.Backup(args[1]); err != nil {
+		fmt.Println(err)
+	} else {
+		printBackup(manifest)
+	}
+}
+
+func backups(conn *client.Conn, args []string) {
+	manifests, err := conn.Backups()
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, manifest := range manifests {
+		printBackup(manifest)
+	}
+}
+
+func restoreBackupWith(conn *client.Conn, manifest common.BackupManifest, read client.BackupReader) {
+	if changed, err := conn.RestoreBackupWith(manifest, read); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(changed)
+	}
+}
+
+func restoreBackup(conn *client.Conn, args []string) {
+	if manifest, existed, err := conn.GetBackup(args[1]); err != nil {
+		fmt.Println(err)
+	} else if !existed {
+		fmt.Printf("No backup %v found\n", args[1])
+	} else {
+		restoreBackupWith(conn, manifest, client.FetchBackup(args[1]))
+	}
+}
+
+func restoreBackupDir(conn *client.Conn, args []string) {
+	if manifest, err := client.ReadBackupManifest(args[2], args[1]); err != nil {
+		fmt.Println(err)
+	} else {
+		restoreBackupWith(conn, manifest, client.BackupDir(args[2]))
+	}
+}
+
 func describeAllTrees(conn *client.Conn, args []string) {
 	fmt.Print(conn.DescribeAllTrees())
 }
@@ -439,12 +865,94 @@ func describeTree(conn *client.Conn, args []string) {
 	}
 }
 
+func browse(conn *client.Conn, args []string) {
+	pos, err := hex.DecodeString(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r := common.TreePageRequest{Values: true}
+	if len(args) > 2 {
+		r.Prefix = []byte(args[2])
+	}
+	if len(args) > 3 {
+		r.Depth = *(mustAtoi(args[3]))
+	}
+	for {
+		page, err := conn.TreePage(pos, r)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printTreeEntries(conn, pos, page.Entries, r.Values, "")
+		if page.Next == nil {
+			return
+		}
+		r.From = page.Next
+	}
+}
+
+func printTreeEntries(conn *client.Conn, pos []byte, entries []common.TreeEntry, values bool, indent string) {
+	for _, entry := range entries {
+		fmt.Printf("%v%s", indent, entry.Key)
+		if entry.Value != nil {
+			fmt.Printf(" = %v", decode(entry.Value))
+		}
+		if entry.SubSize > 0 {
+			fmt.Printf(" (%v members)", entry.SubSize)
+		}
+		fmt.Println()
+		printTreeEntries(conn, pos, entry.Sub, values, indent+"  ")
+		r := common.TreePageRequest{Key: entry.Key, From: entry.SubNext, Values: values}
+		for r.From != nil {
+			page, err := conn.TreePage(pos, r)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			printTreeEntries(conn, pos, page.Entries, values, indent+"  ")
+			r.From = page.Next
+		}
+	}
+}
+
 func get(conn *client.Conn, args []string) {
 	if value, existed := conn.Get([]byte(args[1])); existed {
 		fmt.Printf("%v\n", decode(value))
 	}
 }
 
+func getRange(conn *client.Conn, args []string) {
+	value, existed, err := conn.GetRange([]byte(args[1]), *(mustAtoi(args[2])), *(mustAtoi(args[3])))
+	if err != nil {
+		fmt.Println(err)
+	} else if existed {
+		fmt.Printf("%v\n", decode(value))
+	}
+}
+
+func appendValue(conn *client.Conn, args []string) {
+	if err := conn.AppendValue([]byte(args[1]), encode(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func debugGet(conn *client.Conn, args []string) {
+	value, existed, timing := conn.DebugGet([]byte(args[1]))
+	if existed {
+		fmt.Printf("%v\n", decode(value))
+	}
+	fmt.Println(timing)
+}
+
+func debugPut(conn *client.Conn, args []string) {
+	if timing, err := conn.DebugPut([]byte(args[1]), encode(args[2])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(timing)
+	}
+}
+
 func subGet(conn *client.Conn, args []string) {
 	if value, existed := conn.SubGet([]byte(args[1]), []byte(args[2])); existed {
 		fmt.Printf("%v\n", decode(value))
@@ -488,7 +996,56 @@ func linedump(dump chan [2][]byte, wait *sync.WaitGroup) {
 }
 
 func put(conn *client.Conn, args []string) {
-	conn.Put([]byte(args[1]), encode(args[2]))
+	if err := conn.TryPut([]byte(args[1]), encode(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func putImmutable(conn *client.Conn, args []string) {
+	if err := conn.PutImmutable([]byte(args[1]), encode(args[2])); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func putContent(conn *client.Conn, args []string) {
+	if key, err := conn.PutContent(encode(args[1])); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println(hex.EncodeToString(key))
+	}
+}
+
+func delContent(conn *client.Conn, args []string) {
+	if key, err := hex.DecodeString(args[1]); err != nil {
+		fmt.Println(err)
+	} else if err := conn.DelContent(key); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func nextID(conn *client.Conn, args []string) {
+	if id, err := conn.NextID(); err != nil {
+		fmt.Println(err)
+	} else {
+		t, node, seq := common.DecodeID(id)
+		fmt.Printf("%v (time %v, node %v, sequence %v)\n", id, t, node, seq)
+	}
+}
+
+func collectGarbage(conn *client.Conn, args []string) {
+	removed, err := conn.CollectGarbage()
+	fmt.Println(removed)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func immutablePrefix(conn *client.Conn, args []string) {
+	conn.AddImmutablePrefix([]byte(args[1]))
+}
+
+func mutablePrefix(conn *client.Conn, args []string) {
+	conn.RemoveImmutablePrefix([]byte(args[1]))
 }
 
 func subPut(conn *client.Conn, args []string) {
@@ -503,13 +1060,28 @@ func subDel(conn *client.Conn, args []string) {
 	conn.SubDel([]byte(args[1]), []byte(args[2]))
 }
 
+func delMulti(conn *client.Conn, args []string) {
+	keys := make([][]byte, len(args)-1)
+	for index, arg := range args[1:] {
+		keys[index] = []byte(arg)
+	}
+	for index, err := range conn.DelMulti(keys, false) {
+		if err != nil {
+			fmt.Printf("%s: %v\n", keys[index], err)
+		}
+	}
+}
+
 func del(conn *client.Conn, args []string) {
-	conn.Del([]byte(args[1]))
+	if err := conn.TryDel([]byte(args[1])); err != nil {
+		fmt.Println(err)
+	}
 }
 
 func main() {
 	flag.Parse()
 	conn := client.MustConn(fmt.Sprintf("%v:%v", *ip, *port))
+	conn.SetOverride(*override)
 	if len(flag.Args()) == 0 {
 		show(conn)
 	} else {
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/zond/god/client"
+)
+
+var ip = flag.String("ip", "127.0.0.1", "IP address of a node in the cluster.")
+var port = flag.Int("port", 9191, "Port of a node in the cluster.")
+var respAddr = flag.String("resp", "127.0.0.1:6379", "Address to listen to for Redis protocol connections. The empty string will turn it off.")
+var memcacheAddr = flag.String("memcache", "127.0.0.1:11211", "Address to listen to for memcached protocol connections. The empty string will turn it off.")
+var httpAddr = flag.String("http", "127.0.0.1:8080", "Address to listen to for HTTP requests. The empty string will turn it off.")
+
+type command struct {
+	minArgs int
+	maxArgs int
+	run     func(conn *client.Conn, w respWriter, args [][]byte)
+}
+
+func orEmpty(value []byte, existed bool) []byte {
+	if existed && value == nil {
+		return []byte{}
+	}
+	return value
+}
+
+var commands = map[string]command{
+	"PING": {0, 1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		if len(args) == 0 {
+			w.status("PONG")
+		} else {
+			w.bulk(args[0])
+		}
+	}},
+	"ECHO": {1, 1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.bulk(args[0])
+	}},
+	"COMMAND": {0, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.array(0)
+	}},
+	"GET": {1, 1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.bulk(orEmpty(conn.Get(args[0])))
+	}},
+	"SET": {2, 2, func(conn *client.Conn, w respWriter, args [][]byte) {
+		conn.Put(args[0], args[1])
+		w.status("OK")
+	}},
+	"MGET": {1, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.array(len(args))
+		for _, key := range args {
+			w.bulk(orEmpty(conn.Get(key)))
+		}
+	}},
+	"MSET": {2, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		if len(args)%2 != 0 {
+			w.error(fmt.Errorf("wrong number of arguments for 'mset' command"))
+			return
+		}
+		for i := 0; i < len(args); i += 2 {
+			conn.Put(args[i], args[i+1])
+		}
+		w.status("OK")
+	}},
+	"DEL": {1, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		deleted := 0
+		for _, key := range args {
+			if _, existed := conn.Get(key); existed {
+				conn.Del(key)
+				deleted++
+			}
+		}
+		w.integer(deleted)
+	}},
+	"EXISTS": {1, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		found := 0
+		for _, key := range args {
+			if _, existed := conn.Get(key); existed {
+				found++
+			}
+		}
+		w.integer(found)
+	}},
+	"DBSIZE": {0, 0, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.integer(conn.Size())
+	}},
+	"HGET": {2, 2, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.bulk(orEmpty(conn.SubGet(args[0], args[1])))
+	}},
+	"HSET": {3, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		if len(args)%2 != 1 {
+			w.error(fmt.Errorf("wrong number of arguments for 'hset' command"))
+			return
+		}
+		added := 0
+		for i := 1; i < len(args); i += 2 {
+			if _, existed := conn.SubGet(args[0], args[i]); !existed {
+				added++
+			}
+			conn.SubPut(args[0], args[i], args[i+1])
+		}
+		w.integer(added)
+	}},
+	"HDEL": {2, -1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		deleted := 0
+		for _, field := range args[1:] {
+			if _, existed := conn.SubGet(args[0], field); existed {
+				conn.SubDel(args[0], field)
+				deleted++
+			}
+		}
+		w.integer(deleted)
+	}},
+	"HLEN": {1, 1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		w.integer(conn.SubSize(args[0]))
+	}},
+	"HGETALL": {1, 1, func(conn *client.Conn, w respWriter, args [][]byte) {
+		items := conn.Slice(args[0], nil, nil, true, true)
+		w.array(len(items) * 2)
+		for _, item := range items {
+			w.bulk(item.Key)
+			w.bulk(orEmpty(item.Value, true))
+		}
+	}},
+}
+
+// serveResp will execute the commands received over c until it is closed or sends QUIT.
+func serveResp(conn *client.Conn, c net.Conn) {
+	defer c.Close()
+	r := respReader{bufio.NewReader(c)}
+	w := respWriter{bufio.NewWriter(c)}
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				w.error(err)
+				w.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			w.status("OK")
+			w.writer.Flush()
+			return
+		}
+		if cmd, ok := commands[name]; !ok {
+			w.error(fmt.Errorf("unknown command '%v'", string(args[0])))
+		} else if len(args)-1 < cmd.minArgs || (cmd.maxArgs != -1 && len(args)-1 > cmd.maxArgs) {
+			w.error(fmt.Errorf("wrong number of arguments for '%v' command", strings.ToLower(name)))
+		} else {
+			cmd.run(conn, w, args[1:])
+		}
+		if r.reader.Buffered() == 0 {
+			if err = w.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func listenResp(conn *client.Conn, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error accepting Redis protocol connection: %v", err)
+			continue
+		}
+		go serveResp(conn, c)
+	}
+}
+
+// serveHttp will serve GET, PUT and DELETE of the value under the key in the request path.
+func serveHttp(conn *client.Conn, w http.ResponseWriter, r *http.Request) {
+	key := []byte(strings.TrimPrefix(r.URL.Path, "/"))
+	switch r.Method {
+	case "GET":
+		if value, existed := conn.Get(key); existed {
+			w.Write(value)
+		} else {
+			http.NotFound(w, r)
+		}
+	case "PUT", "POST":
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn.Put(key, value)
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		conn.Del(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("Method %v not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	flag.Parse()
+	conn := client.MustConn(fmt.Sprintf("%v:%v", *ip, *port))
+	conn.Start()
+	if *respAddr != "" {
+		go listenResp(conn, *respAddr)
+	}
+	if *memcacheAddr != "" {
+		go listenMemcache(conn, *memcacheAddr)
+	}
+	if *httpAddr != "" {
+		go func() {
+			panic(http.ListenAndServe(*httpAddr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				serveHttp(conn, w, r)
+			})))
+		}()
+	}
+	select {}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/murmur"
+)
+
+const (
+	// memcacheHeaderSize is the size of the flags and expiry time stored before values set using the memcached protocol.
+	memcacheHeaderSize = 12
+	// maxRelativeExptime is the largest exptime memcached treats as relative to now instead of as a unix time.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+	// memcacheLockTTL is for how long incr, decr, add, replace and cas lock the key they change.
+	memcacheLockTTL = time.Second * 10
+	// maxItemSize is the largest value a client may store using the memcached protocol, the same as the default of memcached.
+	maxItemSize     = 1 << 20
+	memcacheVersion = "god"
+)
+
+// memcacheItem is a value stored using the memcached protocol, with the flags of the client and the time it expires.
+type memcacheItem struct {
+	flags   uint32
+	expires int64
+	data    []byte
+}
+
+func (self memcacheItem) encode() (result []byte) {
+	result = make([]byte, memcacheHeaderSize+len(self.data))
+	binary.BigEndian.PutUint32(result, self.flags)
+	binary.BigEndian.PutUint64(result[4:], uint64(self.expires))
+	copy(result[memcacheHeaderSize:], self.data)
+	return
+}
+
+// unique returns the cas unique of the encoded item b, which changes whenever the item is stored again.
+func unique(b []byte) uint64 {
+	return binary.BigEndian.Uint64(murmur.HashBytes(b))
+}
+
+func (self memcacheItem) expired() bool {
+	return self.expires != 0 && self.expires <= time.Now().UnixNano()
+}
+
+func decodeMemcacheItem(b []byte) (result memcacheItem, ok bool) {
+	if len(b) < memcacheHeaderSize {
+		return
+	}
+	result.flags = binary.BigEndian.Uint32(b)
+	result.expires = int64(binary.BigEndian.Uint64(b[4:]))
+	result.data = b[memcacheHeaderSize:]
+	return result, true
+}
+
+// memcacheExpires returns when an item set with exptime expires, in unix nanoseconds, or 0 if it never expires.
+func memcacheExpires(exptime int64) int64 {
+	if exptime == 0 {
+		return 0
+	}
+	if exptime < 0 {
+		return 1
+	}
+	if exptime <= maxRelativeExptime {
+		return time.Now().Add(time.Duration(exptime) * time.Second).UnixNano()
+	}
+	return time.Unix(exptime, 0).UnixNano()
+}
+
+// getMemcacheItem returns the item under key, removing it if it has expired.
+func getMemcacheItem(conn *client.Conn, key []byte) (result memcacheItem, existed bool) {
+	result, _, existed = getMemcacheItemUnique(conn, key)
+	return
+}
+
+// getMemcacheItemUnique returns the item under key and its cas unique, removing it if it has expired.
+func getMemcacheItemUnique(conn *client.Conn, key []byte) (result memcacheItem, cas uint64, existed bool) {
+	value, existed := conn.Get(key)
+	if !existed {
+		return
+	}
+	cas = unique(value)
+	if result, existed = decodeMemcacheItem(value); existed && result.expired() {
+		conn.Del(key)
+		existed = false
+	}
+	return
+}
+
+// withLock will run f while holding the lease on key, to make read-modify-write commands atomic.
+func withLock(conn *client.Conn, key []byte, f func()) (err error) {
+	deadline := time.Now().Add(memcacheLockTTL)
+	for {
+		if token, acquired := conn.Lock(key, memcacheLockTTL); acquired {
+			defer conn.Unlock(key, token)
+			f()
+			return
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the lock on %v", string(key))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// memcacheConn reads commands and writes responses in the memcached ASCII protocol.
+type memcacheConn struct {
+	conn   *client.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func (self memcacheConn) line() (result string, err error) {
+	if result, err = self.reader.ReadString('\n'); err != nil {
+		return
+	}
+	result = strings.TrimRight(result, "\r\n")
+	return
+}
+
+func (self memcacheConn) respond(noreply bool, format string, args ...interface{}) {
+	if !noreply {
+		fmt.Fprintf(self.writer, format+"\r\n", args...)
+	}
+}
+
+// get will execute get, or gets if withUnique is set.
+func (self memcacheConn) get(keys []string, withUnique bool) {
+	for _, key := range keys {
+		if item, cas, existed := getMemcacheItemUnique(self.conn, []byte(key)); existed {
+			if withUnique {
+				fmt.Fprintf(self.writer, "VALUE %v %v %v %v\r\n", key, item.flags, len(item.data), cas)
+			} else {
+				fmt.Fprintf(self.writer, "VALUE %v %v %v\r\n", key, item.flags, len(item.data))
+			}
+			self.writer.Write(item.data)
+			self.writer.WriteString("\r\n")
+		}
+	}
+	self.writer.WriteString("END\r\n")
+}
+
+// store will execute set, add, replace or cas with the arguments args, reading the data block following the command.
+func (self memcacheConn) store(command string, args []string) (err error) {
+	fields := 4
+	if command == "cas" {
+		fields = 5
+	}
+	if len(args) < fields || len(args) > fields+1 {
+		self.respond(false, "ERROR")
+		return
+	}
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		self.respond(false, "CLIENT_ERROR bad command line format")
+		return nil
+	}
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		self.respond(false, "CLIENT_ERROR bad command line format")
+		return nil
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		// without a valid size the data block can't be skipped, so the connection is closed
+		self.respond(false, "CLIENT_ERROR bad data chunk")
+		return fmt.Errorf("bad data chunk size %#v", args[3])
+	}
+	var cas uint64
+	if command == "cas" {
+		if cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			self.respond(false, "CLIENT_ERROR bad command line format")
+			return nil
+		}
+	}
+	noreply := len(args) == fields+1 && args[fields] == "noreply"
+	if size > maxItemSize {
+		if _, err = io.CopyN(ioutil.Discard, self.reader, int64(size)+2); err != nil {
+			return
+		}
+		self.respond(false, "CLIENT_ERROR object too large for cache")
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err = io.ReadFull(self.reader, data); err != nil {
+		return
+	}
+	if string(data[size:]) != "\r\n" {
+		self.respond(false, "CLIENT_ERROR bad data chunk")
+		return nil
+	}
+	key := []byte(args[0])
+	item := memcacheItem{
+		flags:   uint32(flags),
+		expires: memcacheExpires(exptime),
+		data:    data[:size],
+	}
+	if command == "set" {
+		self.conn.Put(key, item.encode())
+		self.respond(noreply, "STORED")
+		return
+	}
+	response := "NOT_STORED"
+	if err = withLock(self.conn, key, func() {
+		_, current, existed := getMemcacheItemUnique(self.conn, key)
+		if command == "cas" {
+			if !existed {
+				response = "NOT_FOUND"
+				return
+			}
+			if current != cas {
+				response = "EXISTS"
+				return
+			}
+		} else if existed != (command == "replace") {
+			return
+		}
+		self.conn.Put(key, item.encode())
+		response = "STORED"
+	}); err != nil {
+		self.respond(noreply, "SERVER_ERROR %v", err)
+		return nil
+	}
+	self.respond(noreply, "%v", response)
+	return
+}
+
+func (self memcacheConn) delete(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		self.respond(false, "ERROR")
+		return
+	}
+	noreply := len(args) == 2 && args[1] == "noreply"
+	key := []byte(args[0])
+	if _, existed := getMemcacheItem(self.conn, key); existed {
+		self.conn.Del(key)
+		self.respond(noreply, "DELETED")
+	} else {
+		self.respond(noreply, "NOT_FOUND")
+	}
+}
+
+// incr will execute incr or decr with the arguments args. Like in memcached, incr wraps around at 64 bits and decr stops at 0.
+func (self memcacheConn) incr(command string, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		self.respond(false, "ERROR")
+		return
+	}
+	noreply := len(args) == 3 && args[2] == "noreply"
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		self.respond(false, "CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	key := []byte(args[0])
+	var response string
+	if err = withLock(self.conn, key, func() {
+		item, existed := getMemcacheItem(self.conn, key)
+		if !existed {
+			response = "NOT_FOUND"
+			return
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(item.data)), 10, 64)
+		if err != nil {
+			response = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+			return
+		}
+		if command == "incr" {
+			value += delta
+		} else if delta > value {
+			value = 0
+		} else {
+			value -= delta
+		}
+		item.data = []byte(strconv.FormatUint(value, 10))
+		self.conn.Put(key, item.encode())
+		response = string(item.data)
+	}); err != nil {
+		response = fmt.Sprintf("SERVER_ERROR %v", err)
+	}
+	self.respond(noreply, "%v", response)
+}
+
+// serveMemcache will execute the commands received over c until it is closed or sends quit.
+func serveMemcache(conn *client.Conn, c net.Conn) {
+	defer c.Close()
+	m := memcacheConn{
+		conn:   conn,
+		reader: bufio.NewReader(c),
+		writer: bufio.NewWriter(c),
+	}
+	for {
+		line, err := m.line()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			m.respond(false, "ERROR")
+		} else {
+			switch command, args := fields[0], fields[1:]; command {
+			case "get", "gets":
+				m.get(args, command == "gets")
+			case "set", "add", "replace", "cas":
+				if err = m.store(command, args); err != nil {
+					return
+				}
+			case "delete":
+				m.delete(args)
+			case "incr", "decr":
+				m.incr(command, args)
+			case "version":
+				m.respond(false, "VERSION %v", memcacheVersion)
+			case "quit":
+				m.writer.Flush()
+				return
+			default:
+				m.respond(false, "ERROR")
+			}
+		}
+		if m.reader.Buffered() == 0 {
+			if err = m.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func listenMemcache(conn *client.Conn, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error accepting memcached protocol connection: %v", err)
+			continue
+		}
+		go serveMemcache(conn, c)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStoreLimits(t *testing.T) {
+	large := fmt.Sprintf("%v\r\n", strings.Repeat("x", maxItemSize+1))
+	out := new(bytes.Buffer)
+	m := memcacheConn{
+		reader: bufio.NewReader(strings.NewReader(large + "version\r\n")),
+		writer: bufio.NewWriter(out),
+	}
+	if err := m.store("set", []string{"a", "0", "0", fmt.Sprint(maxItemSize + 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := m.line(); err != nil || line != "version" {
+		t.Errorf("wanted the data block to be skipped, got %#v, %v", line, err)
+	}
+	if err := m.store("set", []string{"a", "0", "0", "-1"}); err == nil {
+		t.Errorf("wanted a negative size to close the connection")
+	}
+	if err := m.store("set", []string{"a", "0", "0", "99999999999999999999"}); err == nil {
+		t.Errorf("wanted an invalid size to close the connection")
+	}
+	m.writer.Flush()
+	if want := "CLIENT_ERROR object too large for cache\r\nCLIENT_ERROR bad data chunk\r\nCLIENT_ERROR bad data chunk\r\n"; out.String() != want {
+		t.Errorf("wanted %q, got %q", want, out.String())
+	}
+}
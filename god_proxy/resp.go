@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBulkLen is the longest bulk string a respReader accepts, the same as the default of Redis.
+	maxBulkLen = 512 << 20
+	// maxArrayLen is the most bulk strings a respReader accepts in one command, the same as Redis.
+	maxArrayLen = 1 << 20
+)
+
+// respReader reads commands in the Redis serialization protocol, either as arrays of bulk strings or as inline commands.
+type respReader struct {
+	reader *bufio.Reader
+}
+
+func (self respReader) line() (result string, err error) {
+	if result, err = self.reader.ReadString('\n'); err != nil {
+		return
+	}
+	result = strings.TrimRight(result, "\r\n")
+	return
+}
+
+func (self respReader) readCommand() (result [][]byte, err error) {
+	var line string
+	if line, err = self.line(); err != nil {
+		return
+	}
+	if !strings.HasPrefix(line, "*") {
+		for _, word := range strings.Fields(line) {
+			result = append(result, []byte(word))
+		}
+		return
+	}
+	var n int
+	if n, err = strconv.Atoi(line[1:]); err != nil {
+		err = fmt.Errorf("Protocol error: invalid multibulk length")
+		return
+	}
+	if n < 0 || n > maxArrayLen {
+		err = fmt.Errorf("Protocol error: invalid multibulk length")
+		return
+	}
+	for i := 0; i < n; i++ {
+		if line, err = self.line(); err != nil {
+			return
+		}
+		if !strings.HasPrefix(line, "$") {
+			err = fmt.Errorf("Expected bulk string, got %#v", line)
+			return
+		}
+		var size int
+		if size, err = strconv.Atoi(line[1:]); err != nil || size < 0 || size > maxBulkLen {
+			err = fmt.Errorf("Protocol error: invalid bulk length")
+			return
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(self.reader, buf); err != nil {
+			return
+		}
+		result = append(result, buf[:size])
+	}
+	return
+}
+
+// respWriter writes replies in the Redis serialization protocol.
+type respWriter struct {
+	writer *bufio.Writer
+}
+
+func (self respWriter) status(s string) {
+	fmt.Fprintf(self.writer, "+%v\r\n", s)
+}
+
+func (self respWriter) error(err error) {
+	fmt.Fprintf(self.writer, "-ERR %v\r\n", strings.Replace(err.Error(), "\r\n", " ", -1))
+}
+
+func (self respWriter) integer(i int) {
+	fmt.Fprintf(self.writer, ":%v\r\n", i)
+}
+
+func (self respWriter) bulk(b []byte) {
+	if b == nil {
+		self.writer.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(self.writer, "$%v\r\n", len(b))
+	self.writer.Write(b)
+	self.writer.WriteString("\r\n")
+}
+
+func (self respWriter) array(n int) {
+	fmt.Fprintf(self.writer, "*%v\r\n", n)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := respReader{bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\nPING\r\n"))}
+	if args, err := r.readCommand(); err != nil || fmt.Sprintf("%s", args) != "[GET a]" {
+		t.Errorf("wanted [GET a], got %s, %v", args, err)
+	}
+	if args, err := r.readCommand(); err != nil || fmt.Sprintf("%s", args) != "[PING]" {
+		t.Errorf("wanted [PING], got %s, %v", args, err)
+	}
+	for _, bad := range []string{
+		"*1\r\n$-5\r\n",
+		"*1\r\n$x\r\n",
+		fmt.Sprintf("*1\r\n$%v\r\n", maxBulkLen+1),
+		"*-3\r\n",
+		fmt.Sprintf("*%v\r\n", maxArrayLen+1),
+	} {
+		r := respReader{bufio.NewReader(strings.NewReader(bad))}
+		if args, err := r.readCommand(); err == nil || !strings.HasPrefix(err.Error(), "Protocol error") {
+			t.Errorf("wanted a protocol error for %q, got %s, %v", bad, args, err)
+		}
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"github.com/zond/god/common"
 	"github.com/zond/god/dhash"
+	"github.com/zond/god/persistence"
+	"github.com/zond/god/timenet"
+	"os"
 	"runtime"
+	"time"
 )
 
 const (
@@ -18,15 +22,103 @@ var port = flag.Int("port", 9191, "Port to listen to for net/rpc connections. Th
 var joinIp = flag.String("joinIp", "", "IP address to join.")
 var joinPort = flag.Int("joinPort", 9191, "Port to join.")
 var verbose = flag.Bool("verbose", false, "Whether the server should be log verbosely to the console.")
+var verify = flag.Bool("verify", false, "Whether the server should verify the restored data against the hash saved when it was last stopped.")
+var syncInterval = flag.Duration("syncInterval", time.Second, "How often to synchronize with replicas and consider migrating. Zero or less means one second.")
+var syncFanout = flag.Int("syncFanout", 16, "How many prints to fetch per request when synchronizing and cleaning.")
+var incrementalSyncs = flag.Int("incrementalSyncs", 0, "How many periodic syncs between each full sync only visit the keys changed since the previous syncs. Zero makes all periodic syncs full syncs. Should be the same for all servers.")
+var cleanInterval = flag.Duration("cleanInterval", time.Second, "How often to clean out data the server is no longer responsible for. Zero or less means one second.")
+var migrateHysteresis = flag.Float64("migrateHysteresis", 1.5, "How many times more entries than its successor the server must own before migrating. Values below 1 are raised to 1.")
+var migrateWaitFactor = flag.Int("migrateWaitFactor", 2, "For how many sync intervals after the last sync, ring change or migration the server waits before migrating. Negative values are raised to 0.")
+var syncKeysPerSecond = flag.Float64("syncKeysPerSecond", 0, "How many keys per second to copy at most when synchronizing, cleaning and shipping snapshots. Zero means unlimited.")
+var syncBytesPerSecond = flag.Float64("syncBytesPerSecond", 0, "How many bytes per second to copy at most when synchronizing, cleaning and shipping snapshots. Zero means unlimited.")
+var gcInterval = flag.Duration("gcInterval", time.Minute, "How often to remove garbage chunks. Zero or less means one minute.")
+var gcGracePeriod = flag.Duration("gcGracePeriod", time.Hour, "For how long unreferenced chunks are kept before they are removed.")
+var chunkSize = flag.Int("chunkSize", 1<<22, "Split values longer than this many bytes into chunks spread around the ring. Zero turns off chunking.")
+var compressionThreshold = flag.Int("compressionThreshold", 0, "Compress values of at least this many bytes on the wire and in the logfiles. Zero turns off compression. Only turn on compression when all servers and clients in the cluster support it.")
+var maxConnections = flag.Int("maxConnections", 0, "How many client connections to serve at the same time. Zero means unlimited.")
+var maxInFlight = flag.Int("maxInFlight", 0, "How many requests to handle at the same time for each connection. Zero means unlimited.")
+var accessLog = flag.String("accessLog", "", "File to append sampled client operations to, one JSON object per line. The empty string will turn off the access log.")
+var accessSampleRate = flag.Float64("accessSampleRate", 0.01, "Fraction of the client operations to write to the access log.")
+var resolveInterval = flag.Duration("resolveInterval", time.Second*30, "How often to resolve the host names of other servers again, to reconnect when their addresses change. Zero turns off resolving.")
+var ntpServer = flag.String("ntpServer", "", "Address of an NTP server, like pool.ntp.org:123, to keep the clock of the cluster close to the real time. The empty string will only synchronize the clock with other servers.")
+var ntpWeight = flag.Float64("ntpWeight", 0.5, "How much, between 0 and 1, of the difference to the NTP server to adjust the clock each time it is queried.")
+var minNodes = flag.Int("minNodes", 0, "How many servers the cluster must have before this server accepts writes. Use when restarting a cluster, to wait for a quorum of its servers to rejoin.")
+var redundancyGracePeriod = flag.Duration("redundancyGracePeriod", time.Hour, "For how long excess replicas are kept after the redundancy of the cluster is lowered, before they are removed.")
+var zone = flag.String("zone", "", "The zone, like a datacenter, of the server. Clients in the same zone prefer it for stale reads.")
+var forensics = flag.Int("forensics", 0, "How many of the divergent values found by the syncs with the replicas to keep for debugging lost updates. Zero turns off the recording.")
+var mirror = flag.String("mirror", "", "Address of a server, for example in another datacenter, that must store every write this server accepts as owner before the write is acknowledged. The empty string turns off mirroring.")
+var subTreeCache = flag.Int("subTreeCache", 0, "How many segments, of up to 128 members each, of remote sub trees to cache for repeated set expressions. The cached segments are validated against the hashes of the sub trees on their owners. Zero turns off the cache, and negative sizes are rejected.")
+var idNode = flag.Int("idNode", -1, "The node id, between 0 and 1023, of the server in the unique IDs it generates. Each server of a cluster must have its own. Without one the server refuses to generate IDs.")
+var convergenceBound = flag.Duration("convergenceBound", 0, "How soon every write should be copied to the replicas. Overrides syncInterval with a third of the bound, lifts the sync limits while the bound is at risk, and reports events when the bound is missed and met again. Zero turns this off.")
+var maxExpensive = flag.Int("maxExpensive", 0, "How many range scans, queries, set expressions, tree pages and bulk deletes to run at the same time before queueing more of them. Zero means four per CPU.")
+var maxHeapBytes = flag.Uint64("maxHeapBytes", 0, "How many bytes the heap may use before range scans, queries, set expressions, tree pages and bulk deletes are rejected. Zero means 90% of GOMEMLIMIT, or of the physical memory when GOMEMLIMIT isn't set.")
+var maxQueueWait = flag.Duration("maxQueueWait", time.Second, "For how long to queue range scans, queries, set expressions, tree pages and bulk deletes before rejecting them.")
+var replayWindowSize = flag.Int("replayWindowSize", 1<<20, "How many idempotency tokens of applied writes to remember at most, to not apply retried writes again. The oldest tokens are forgotten first.")
+var replayWindowTTL = flag.Duration("replayWindowTTL", time.Minute*10, "For how long to remember the idempotency tokens of applied writes.")
+var upgrade = flag.Bool("upgrade", false, "Whether to upgrade the logfiles and snapshots in dir to the current format before starting, if they are outdated. The old files are restored if the upgrade fails.")
 var dir = flag.String("dir", address, "Where to store logfiles and snapshots. Defaults to a directory named after the listening ip/port. The empty string will turn off persistence.")
 
+// advise will inspect dir, and upgrade it if it is outdated and upgrade is set, or print how to upgrade it otherwise.
+func advise() {
+	if _, err := os.Stat(*dir); err != nil {
+		return
+	}
+	layout, err := persistence.Inspect(*dir)
+	if err != nil {
+		panic(err)
+	}
+	if len(layout.Backups) > 0 {
+		fmt.Printf("%v contains the old files of previous upgrades in %v, remove them once the upgraded data is verified\n", *dir, layout.Backups)
+	}
+	if !layout.Outdated() {
+		return
+	}
+	if !*upgrade {
+		fmt.Printf("%v is outdated, restart with -upgrade or run god_upgrade -dir %v to upgrade it\n", layout, *dir)
+		return
+	}
+	if layout, err = persistence.Upgrade(*dir, false, func(progress persistence.UpgradeProgress) {
+		fmt.Printf("Upgrading %v: %v/%v files, %v ops\n", *dir, progress.Done, progress.Files, progress.Played)
+	}); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Upgraded %v\n", layout)
+}
+
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Parse()
 	if *dir == address {
 		*dir = fmt.Sprintf("%v_%v", *broadcastIp, *port)
 	}
+	if *dir != "" {
+		advise()
+	}
 	s := dhash.NewNodeDir(fmt.Sprintf("%v:%v", *listenIp, *port), fmt.Sprintf("%v:%v", *broadcastIp, *port), *dir)
+	s.SetSyncInterval(*syncInterval).SetCleanInterval(*cleanInterval).SetMigrateHysteresis(*migrateHysteresis).SetMigrateWaitFactor(*migrateWaitFactor)
+	s.SetGCInterval(*gcInterval).SetGCGracePeriod(*gcGracePeriod).SetChunkSize(*chunkSize).SetMinNodes(*minNodes)
+	s.SetRedundancyGracePeriod(*redundancyGracePeriod).SetSyncFanout(*syncFanout).SetIncrementalSyncs(*incrementalSyncs)
+	s.SetZone(*zone).SetMirror(*mirror).SetForensics(*forensics).SetIDNode(*idNode).SetSubTreeCacheSize(*subTreeCache)
+	s.SetSyncLimits(*syncKeysPerSecond, *syncBytesPerSecond).SetConvergenceBound(*convergenceBound).SetReplayWindow(*replayWindowSize, *replayWindowTTL)
+	common.SetCompressionThreshold(*compressionThreshold)
+	common.Switch.SetResolveInterval(*resolveInterval)
+	if *ntpServer != "" {
+		s.SetClockSource(timenet.NTPSource{Addr: *ntpServer}, *ntpWeight)
+	}
+	s.CompressLog(*compressionThreshold)
+	s.SetMaxConnections(*maxConnections).SetMaxInFlight(*maxInFlight)
+	s.SetAdmissionController(dhash.LoadAdmission{MaxInFlight: *maxExpensive, MaxHeapBytes: *maxHeapBytes, MaxQueueWait: *maxQueueWait})
+	if *accessLog != "" {
+		file, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			panic(err)
+		}
+		s.SetAccessSampleRate(*accessSampleRate)
+		s.LogAccess(file)
+	}
+	if *verify {
+		s.Verify()
+	}
 	if *verbose {
 		s.AddChangeListener(func(ring *common.Ring) bool {
 			fmt.Println(s.Describe())
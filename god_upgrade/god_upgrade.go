@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zond/god/persistence"
+)
+
+var dir = flag.String("dir", "", "The data directory of a stopped server to inspect and upgrade.")
+var dryRun = flag.Bool("dryRun", false, "Whether to only inspect the directory, without upgrading it.")
+var keepBackup = flag.Bool("keepBackup", false, "Whether to keep the old logfiles and snapshots in a backup directory within the directory after upgrading it.")
+
+func main() {
+	flag.Parse()
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "Usage: god_upgrade -dir DIR [-dryRun] [-keepBackup]")
+		os.Exit(2)
+	}
+	layout, err := persistence.Inspect(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(layout)
+	if !layout.Outdated() {
+		fmt.Println("Up to date")
+		return
+	}
+	if *dryRun {
+		fmt.Println("Needs upgrading")
+		return
+	}
+	if layout, err = persistence.Upgrade(*dir, *keepBackup, func(progress persistence.UpgradeProgress) {
+		fmt.Printf("%v/%v files, %v ops, replayed %v\n", progress.Done, progress.Files, progress.Played, progress.File)
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Rolled back: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(layout)
+	fmt.Println("Upgraded")
+}
@@ -0,0 +1,237 @@
+// Package godsql is a database/sql driver for god, registered as "god".
+//
+// The data source name is the address of one of the nodes of the cluster:
+//
+//	db, err := sql.Open("god", "127.0.0.1:9191")
+//
+// Query runs SELECT statements of the query package, and Exec runs its INSERT and DELETE statements.
+// Arguments are bound to ? placeholders outside string literals, always as string literals, so they can never change the statement. The query package accepts
+// strings containing numbers where it expects numbers.
+//
+// Writes in god are blind, so the results of Exec don't know how many entries were affected, and RowsAffected returns an error.
+//
+// god has no transactions. A transaction buffers the statements executed in it, and Commit executes them in order, stopping at the first error,
+// so a failed Commit may have executed some of them. It then returns a *CommitError telling how many. Queries in a transaction don't see the writes buffered in it.
+package godsql
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/query"
+)
+
+func init() {
+	sql.Register("god", Driver{})
+}
+
+// Driver opens connections to god clusters.
+type Driver struct{}
+
+// Open will return a connection to the cluster containing the node at addr.
+func (self Driver) Open(addr string) (driver.Conn, error) {
+	conn, err := client.NewConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.Start()
+	return &godConn{conn: conn}, nil
+}
+
+// CommitError is returned by Commit when a statement of the transaction failed after the statements before it were executed.
+type CommitError struct {
+	// Executed is the number of statements executed before the failed one.
+	Executed int
+	// Statements is the number of statements in the transaction.
+	Statements int
+	Err        error
+}
+
+func (self *CommitError) Error() string {
+	return fmt.Sprintf("Commit executed %v of %v statements before failing: %v", self.Executed, self.Statements, self.Err)
+}
+
+// godResult is the result of Exec, which doesn't know how many entries were affected.
+type godResult struct{}
+
+func (self godResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("god has no auto generated ids")
+}
+func (self godResult) RowsAffected() (int64, error) {
+	return 0, fmt.Errorf("Writes in god are blind, so the number of affected entries is unknown")
+}
+
+// bind will replace the ? placeholders outside string literals in s with args.
+func bind(s string, args []driver.Value) (result string, err error) {
+	buf := new(bytes.Buffer)
+	quoted := false
+	used := 0
+	for _, r := range s {
+		if r == '\'' {
+			quoted = !quoted
+		}
+		if r != '?' || quoted {
+			buf.WriteRune(r)
+			continue
+		}
+		if used >= len(args) {
+			err = fmt.Errorf("%#v has more placeholders than the %v arguments", s, len(args))
+			return
+		}
+		var value string
+		switch arg := args[used].(type) {
+		case []byte:
+			value = string(arg)
+		case nil:
+			err = fmt.Errorf("NULL can not be bound in %#v", s)
+			return
+		default:
+			value = fmt.Sprint(arg)
+		}
+		buf.WriteString("'" + strings.Replace(value, "'", "''", -1) + "'")
+		used++
+	}
+	if used != len(args) {
+		err = fmt.Errorf("%#v has %v placeholders, but got %v arguments", s, used, len(args))
+		return
+	}
+	return buf.String(), nil
+}
+
+// placeholders returns the number of ? placeholders outside string literals in s.
+func placeholders(s string) (result int) {
+	quoted := false
+	for _, r := range s {
+		if r == '\'' {
+			quoted = !quoted
+		} else if r == '?' && !quoted {
+			result++
+		}
+	}
+	return
+}
+
+// recoverConn will make operations return driver.ErrBadConn instead of panicking when the client doesn't know of any live nodes, so that database/sql retries with a new connection.
+func recoverConn(err *error) {
+	if e := recover(); e != nil {
+		if _, ok := e.(error); !ok {
+			panic(e)
+		}
+		*err = driver.ErrBadConn
+	}
+}
+
+type godConn struct {
+	conn *client.Conn
+	tx   *godTx
+}
+
+func (self *godConn) Prepare(s string) (driver.Stmt, error) {
+	return &godStmt{conn: self, query: s}, nil
+}
+func (self *godConn) Close() error {
+	self.conn.Stop()
+	return nil
+}
+func (self *godConn) Begin() (driver.Tx, error) {
+	if self.tx != nil {
+		return nil, fmt.Errorf("Transactions can not be nested")
+	}
+	self.tx = &godTx{conn: self}
+	return self.tx, nil
+}
+
+type godTx struct {
+	conn    *godConn
+	updates []*query.Update
+}
+
+func (self *godTx) Commit() (err error) {
+	defer recoverConn(&err)
+	self.conn.tx = nil
+	for index, update := range self.updates {
+		if err = update.Run(self.conn.conn); err != nil {
+			if index > 0 {
+				err = &CommitError{
+					Executed:   index,
+					Statements: len(self.updates),
+					Err:        err,
+				}
+			}
+			return
+		}
+	}
+	return
+}
+func (self *godTx) Rollback() error {
+	self.conn.tx = nil
+	return nil
+}
+
+type godStmt struct {
+	conn  *godConn
+	query string
+}
+
+func (self *godStmt) Close() error {
+	return nil
+}
+func (self *godStmt) NumInput() int {
+	return placeholders(self.query)
+}
+func (self *godStmt) Exec(args []driver.Value) (result driver.Result, err error) {
+	defer recoverConn(&err)
+	var s string
+	if s, err = bind(self.query, args); err != nil {
+		return
+	}
+	var update *query.Update
+	if update, err = query.ParseUpdate(s); err != nil {
+		return
+	}
+	if tx := self.conn.tx; tx != nil {
+		tx.updates = append(tx.updates, update)
+	} else if err = update.Run(self.conn.conn); err != nil {
+		return
+	}
+	return godResult{}, nil
+}
+func (self *godStmt) Query(args []driver.Value) (result driver.Rows, err error) {
+	defer recoverConn(&err)
+	var s string
+	if s, err = bind(self.query, args); err != nil {
+		return
+	}
+	var stmt *query.Statement
+	if stmt, err = query.Parse(s); err != nil {
+		return
+	}
+	return &godRows{columns: stmt.Columns, rows: stmt.Run(self.conn.conn)}, nil
+}
+
+type godRows struct {
+	columns []string
+	rows    []query.Row
+}
+
+func (self *godRows) Columns() []string {
+	return self.columns
+}
+func (self *godRows) Close() error {
+	return nil
+}
+func (self *godRows) Next(dest []driver.Value) error {
+	if len(self.rows) == 0 {
+		return io.EOF
+	}
+	for index, column := range self.rows[0] {
+		dest[index] = column
+	}
+	self.rows = self.rows[1:]
+	return nil
+}
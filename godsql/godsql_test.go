@@ -0,0 +1,90 @@
+package godsql
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/dhash"
+)
+
+func TestDriver(t *testing.T) {
+	node := dhash.NewEmbeddedNode("godsql", "")
+	node.MustStart()
+	defer node.Stop()
+	db, err := sql.Open("god", common.EmbeddedPrefix+"godsql")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer db.Close()
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "it's 2"}, {"c", "333"}} {
+		result, err := db.Exec("INSERT INTO range VALUES (?, ?)", kv[0], kv[1])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			t.Errorf("wanted the number of affected entries to be unknown, got %v", n)
+		}
+	}
+	if _, err = db.Exec("INSERT INTO range VALUES (?, ?)", "d", "1'), ('e', '2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err = db.Exec("DELETE FROM range WHERE key = ?", 4); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var d string
+	if err = db.QueryRow("SELECT value FROM range() WHERE key = ? LIMIT ?", "d", 1).Scan(&d); err != nil || d != "1'), ('e', '2" {
+		t.Errorf("wanted the quote in the argument to stay in the value, got %q, %v", d, err)
+	}
+	if err = db.QueryRow("SELECT value FROM range() WHERE key = ?", "e").Scan(&d); err != sql.ErrNoRows {
+		t.Errorf("wanted no e, got %q, %v", d, err)
+	}
+	if _, err = db.Exec("DELETE FROM range WHERE key = ?", "d"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	rows, err := db.Query("SELECT key, value FROM range(?, '') WHERE size(value) < ?", "b", 5)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var found []string
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			t.Fatalf("%v", err)
+		}
+		found = append(found, key+"="+value)
+	}
+	if len(found) != 1 || found[0] != "c=333" {
+		t.Errorf("wanted c=333, got %v", found)
+	}
+	var size int
+	if err = db.QueryRow("SELECT size(value) FROM range() WHERE key = ?", "b").Scan(&size); err != nil || size != 6 {
+		t.Errorf("wanted size 6 of b, got %v, %v", size, err)
+	}
+	conn := client.MustConn(common.EmbeddedPrefix + "godsql")
+	for _, commit := range []bool{false, true} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		tx.Exec("INSERT INTO sub('tree') VALUES ('x', ?)", "y")
+		tx.Exec("DELETE FROM range WHERE key = 'a'")
+		if _, existed := conn.Get([]byte("a")); !existed {
+			t.Errorf("wanted the delete to wait for the transaction")
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		_, existed := conn.Get([]byte("a"))
+		value, _ := conn.SubGet([]byte("tree"), []byte("x"))
+		if existed == commit || (string(value) == "y") != commit {
+			t.Errorf("wanted a to exist and tree/x to be missing only after rolling back, got %v and %q", existed, value)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// maxDecompressedSize is the largest size compressed values may decompress to, so that corrupt or malicious values can't exhaust the memory of the process.
+var maxDecompressedSize int64 = 1 << 30
+
+// Compress will return value compressed, and true, if threshold is positive, value is at least threshold bytes long and compressing it actually makes it smaller.
+// Otherwise it will return value and false.
+func Compress(value []byte, threshold int) ([]byte, bool) {
+	if threshold < 1 || len(value) < threshold {
+		return value, false
+	}
+	buf := new(bytes.Buffer)
+	writer, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		panic(err)
+	}
+	if _, err = writer.Write(value); err != nil {
+		panic(err)
+	}
+	if err = writer.Close(); err != nil {
+		panic(err)
+	}
+	if buf.Len() >= len(value) {
+		return value, false
+	}
+	return buf.Bytes(), true
+}
+
+// Decompress will return value decompressed if it is compressed, and value otherwise.
+// It returns an error if value decompresses to more than 1GB.
+func Decompress(value []byte, compressed bool) (result []byte, err error) {
+	if !compressed {
+		return value, nil
+	}
+	reader := flate.NewReader(bytes.NewReader(value))
+	defer reader.Close()
+	if result, err = ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize+1)); err != nil {
+		return
+	}
+	if int64(len(result)) > maxDecompressedSize {
+		return nil, fmt.Errorf("Compressed value decompresses to more than %v bytes", maxDecompressedSize)
+	}
+	return
+}
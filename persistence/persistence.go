@@ -1,9 +1,13 @@
 package persistence
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -23,13 +27,32 @@ const (
 	playing
 )
 
+// FormatVersion is the version of the format of the Ops this package logs.
+// Ops of older versions are upgraded when replayed, and rewritten in this version when merged into snapshots.
+const FormatVersion = 1
+
+// upgrades contains, for each version before FormatVersion, the function that upgrades an Op of that version to the next one.
+var upgrades = []func(Op) Op{
+	// Ops logged before versions were introduced have the same meaning in version 1.
+	func(op Op) Op {
+		return op
+	},
+}
+
 const (
 	snapSuffix       = "snap"
 	logSuffix        = "log"
 	unfinishedSuffix = "unfinished"
+	hashFile         = "hash"
 )
 
 // Op is a simple get/put/clear or configuration operation to log or replay.
+//
+// Checksum is set when the Op is logged and verified and cleared when it is replayed. Ops logged before checksums were introduced have a zero Checksum and are not verified.
+//
+// Compressed is set when the Value is compressed in the logfile. It is always cleared before the Op is replayed.
+//
+// Version is the FormatVersion of the Logger that logged the Op, or zero for Ops logged before versions were introduced. Ops are upgraded to FormatVersion and Version is cleared before they are replayed.
 type Op struct {
 	Key           []byte
 	SubKey        []byte
@@ -38,6 +61,72 @@ type Op struct {
 	Put           bool
 	Clear         bool
 	Configuration map[string]string
+	Checksum      uint32
+	Compressed    bool
+	Version       int
+}
+
+func (self Op) checksum() (result uint32) {
+	hash := crc32.NewIEEE()
+	for _, b := range [][]byte{self.Key, self.SubKey, self.Value} {
+		binary.Write(hash, binary.BigEndian, int64(len(b)))
+		hash.Write(b)
+	}
+	binary.Write(hash, binary.BigEndian, self.Timestamp)
+	binary.Write(hash, binary.BigEndian, self.Put)
+	binary.Write(hash, binary.BigEndian, self.Clear)
+	// Only hash the compression flag when set, so that Ops logged before compression was introduced still verify.
+	if self.Compressed {
+		binary.Write(hash, binary.BigEndian, self.Compressed)
+	}
+	if self.Version != 0 {
+		binary.Write(hash, binary.BigEndian, int64(self.Version))
+	}
+	keys := make([]string, 0, len(self.Configuration))
+	for key, _ := range self.Configuration {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%q=%q,", key, self.Configuration[key])
+	}
+	if result = hash.Sum32(); result == 0 {
+		result = 1
+	}
+	return
+}
+
+// Report describes the outcome of replaying logfiles.
+type Report struct {
+	// Played is the number of Ops replayed.
+	Played int
+	// Dropped is the number of Ops not replayed because their checksums didn't match.
+	Dropped int
+	// Truncated contains the logfiles that ended with data that couldn't be decoded.
+	Truncated []string
+	// Unsupported is the number of Ops not replayed because they were logged by a newer version than FormatVersion.
+	Unsupported int
+	// Hash is the hash of the restored data, and StoredHash the hash saved when the data was last sealed.
+	// StoredHash is nil if the data was not sealed, for example because the process crashed.
+	Hash       []byte
+	StoredHash []byte
+}
+
+// Clean returns true if the replay found no problems.
+func (self Report) Clean() bool {
+	return self.Dropped == 0 && len(self.Truncated) == 0 && self.Unsupported == 0 && (self.StoredHash == nil || bytes.Compare(self.Hash, self.StoredHash) == 0)
+}
+
+func (self Report) String() string {
+	hash := "no stored hash"
+	if self.StoredHash != nil {
+		if bytes.Compare(self.Hash, self.StoredHash) == 0 {
+			hash = "hash matches"
+		} else {
+			hash = fmt.Sprintf("hash %x does not match stored hash %x", self.Hash, self.StoredHash)
+		}
+	}
+	return fmt.Sprintf("played %v ops, dropped %v ops with bad checksums, skipped %v ops of unsupported versions, truncated logfiles %v, %v", self.Played, self.Dropped, self.Unsupported, self.Truncated, hash)
 }
 
 type logfile struct {
@@ -74,7 +163,18 @@ func parseLogfile(file string) (rval *logfile, err error) {
 	return
 }
 
-func (self *logfile) play(operate Operate) {
+// play will replay the Ops in this logfile using operate, upgraded to FormatVersion. Ops with bad checksums or newer versions will be dropped, and data that can't be decoded
+// (typically a tail truncated by a crash) will end the replay of this logfile.
+func (self *logfile) play(operate Operate, report *Report) {
+	self.replay(nil, operate, report)
+}
+
+// inspect will replay this logfile like play, but call inspect with each Op with a valid checksum as it was logged, before it is upgraded.
+func (self *logfile) inspect(inspect Operate, report *Report) {
+	self.replay(inspect, func(Op) {}, report)
+}
+
+func (self *logfile) replay(inspect, operate Operate, report *Report) {
 	if self == nil {
 		return
 	}
@@ -87,10 +187,40 @@ func (self *logfile) play(operate Operate) {
 		if err != nil {
 			break
 		}
+		sum := op.Checksum
+		if sum != 0 {
+			op.Checksum = 0
+			if op.checksum() != sum {
+				report.Dropped++
+				continue
+			}
+		}
+		if inspect != nil {
+			logged := op
+			logged.Checksum = sum
+			inspect(logged)
+		}
+		if op.Version > FormatVersion {
+			report.Unsupported++
+			continue
+		}
+		for ; op.Version < FormatVersion; op.Version++ {
+			op = upgrades[op.Version](op)
+		}
+		op.Version = 0
+		if op.Compressed {
+			if op.Value, err = Decompress(op.Value, true); err != nil {
+				report.Dropped++
+				continue
+			}
+			op.Compressed = false
+		}
+		report.Played++
 		operate(op)
 	}
 	if err != io.EOF {
-		panic(err)
+		log.Printf("%v is truncated: %v", self.filename, err)
+		report.Truncated = append(report.Truncated, self.filename)
 	}
 }
 
@@ -136,15 +266,16 @@ type Operate func(o Op)
 
 // Logger is something that can log or replay Ops.
 type Logger struct {
-	ops      chan Op
-	stops    chan chan bool
-	dir      string
-	state    int32
-	snapping int32
-	maxSize  int64
-	suffix   string
-	cond     *sync.Cond
-	lock     *sync.Mutex
+	ops                  chan Op
+	stops                chan chan bool
+	dir                  string
+	state                int32
+	snapping             int32
+	maxSize              int64
+	compressionThreshold int64
+	suffix               string
+	cond                 *sync.Cond
+	lock                 *sync.Mutex
 }
 
 // NewLogger will return a Logger that will dump data into dir, or replay data from dir.
@@ -176,13 +307,20 @@ func (self *Logger) setSuffix(s string) *Logger {
 }
 
 // Limit will limit the size of the last logfile to maxSize bytes.
-// When the last logfile is bigger than maxSize, it will merge the last snapshot and any logfile created after it into a new snapshot, 
+// When the last logfile is bigger than maxSize, it will merge the last snapshot and any logfile created after it into a new snapshot,
 // and start a new logfile to continue. All this will happen transparently in a separate goroutine.
 func (self *Logger) Limit(maxSize int64) *Logger {
 	self.maxSize = maxSize
 	return self
 }
 
+// Compress will make this Logger compress the values of Ops that are at least threshold bytes long before dumping them. Zero disables compression.
+// Logfiles with compressed values can only be replayed by Loggers that support compression, but Loggers that support compression can replay any logfile.
+func (self *Logger) Compress(threshold int) *Logger {
+	atomic.StoreInt64(&self.compressionThreshold, int64(threshold))
+	return self
+}
+
 func (self *Logger) logfiles() (result logfiles) {
 	dir, err := os.Open(self.dir)
 	if err != nil {
@@ -229,14 +367,40 @@ func (self *Logger) Recording() bool {
 
 // Play will replay the latest snapshot and all logfiles created after it using the provided operate.
 func (self *Logger) Play(operate Operate) {
+	self.PlayReport(operate)
+}
+
+// PlayReport will replay like Play, and return a Report of any problems found.
+func (self *Logger) PlayReport(operate Operate) (report Report) {
 	if self.changeState(stopped, playing) {
 		defer self.changeState(playing, stopped)
 		snapshot, logs := self.latest()
-		snapshot.play(operate)
+		snapshot.play(operate, &report)
 		for _, logf := range logs {
-			logf.play(operate)
+			logf.play(operate, &report)
 		}
 	}
+	return
+}
+
+// SaveHash will store hash in the directory of this Logger, to be verified after the next replay.
+func (self *Logger) SaveHash(hash []byte) {
+	if err := ioutil.WriteFile(filepath.Join(self.dir, hashFile), hash, 0666); err != nil {
+		log.Printf("failed saving hash: %v", err)
+	}
+}
+
+// TakeHash will return and remove the hash stored by SaveHash, or nil if there is none.
+func (self *Logger) TakeHash() (result []byte) {
+	filename := filepath.Join(self.dir, hashFile)
+	var err error
+	if result, err = ioutil.ReadFile(filename); err != nil {
+		return nil
+	}
+	if err = os.Remove(filename); err != nil {
+		log.Printf("failed removing %v: %v", filename, err)
+	}
+	return
 }
 
 // Stop will stop this Logger. It will not return until all running recordings or snaphots are finished.
@@ -273,7 +437,13 @@ func (self *Logger) Clear() {
 	<-self.Record()
 }
 
-func (self *Logger) snapshot(snap *logfile, files logfiles) {
+// snapshot will dump the merged Ops of snap and files, and return the Report of replaying them.
+func (self *Logger) snapshot(snap *logfile, files logfiles) (report Report) {
+	return self.snapshotProgress(snap, files, nil)
+}
+
+// snapshotProgress will snapshot like snapshot, and call progress with each replayed file and the Report so far.
+func (self *Logger) snapshotProgress(snap *logfile, files logfiles, progress func(*logfile, Report)) (report Report) {
 	byteCompressor := make(map[string]Op)
 	treeCompressor := make(map[string]map[string]Op)
 	var latestConf *Op
@@ -320,9 +490,16 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 			}
 		}
 	}
-	snap.play(operate)
-	for _, logf := range files {
-		logf.play(operate)
+	for _, logf := range append(logfiles{snap}, files...) {
+		if logf != nil {
+			logf.play(operate, &report)
+			if progress != nil {
+				progress(logf, report)
+			}
+		}
+	}
+	if report.Unsupported > 0 {
+		return
 	}
 	if latestConf != nil {
 		self.Dump(*latestConf)
@@ -338,17 +515,26 @@ func (self *Logger) snapshot(snap *logfile, files logfiles) {
 			self.Dump(op)
 		}
 	}
+	return
 }
 
 func (self *Logger) snapshotAndDelete(oldrec *logfile, p chan *logfile, snapping *int32) {
 	defer atomic.StoreInt32(snapping, 0)
 	defer self.cond.Broadcast()
 	latestSnapshot, logfiles := self.latest()
-	snapshotter := NewLogger(self.dir).setSuffix(unfinishedSuffix)
+	snapshotter := NewLogger(self.dir).setSuffix(unfinishedSuffix).Compress(int(atomic.LoadInt64(&self.compressionThreshold)))
 	snapshotfile := <-snapshotter.Record()
 	p <- snapshotfile
-	snapshotter.snapshot(latestSnapshot, logfiles)
+	report := snapshotter.snapshot(latestSnapshot, logfiles)
 	snapshotter.Stop()
+	if report.Unsupported > 0 {
+		// Merging would lose the Ops this version can't read, so keep the old files until a newer version merges them.
+		log.Printf("not merging logfiles in %v: %v", self.dir, report)
+		if err := os.Remove(snapshotfile.filename); err != nil {
+			log.Printf("failed removing %v: %v", snapshotfile.filename, err)
+		}
+		return
+	}
 	if err := os.Rename(snapshotfile.filename, filepath.Join(self.dir, fmt.Sprintf("%v.%v", snapshotfile.timestamp.UnixNano(), snapSuffix))); err != nil {
 		panic(err)
 	}
@@ -401,6 +587,12 @@ func (self *Logger) record(p chan *logfile) {
 
 		select {
 		case op = <-self.ops:
+			if !op.Compressed {
+				op.Value, op.Compressed = Compress(op.Value, int(atomic.LoadInt64(&self.compressionThreshold)))
+			}
+			op.Version = FormatVersion
+			op.Checksum = 0
+			op.Checksum = op.checksum()
 			if err = rec.encoder.Encode(op); err != nil {
 				panic(err)
 			}
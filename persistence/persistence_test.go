@@ -1,6 +1,7 @@
 package persistence
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"reflect"
@@ -129,3 +130,207 @@ func BenchmarkRecord(b *testing.B) {
 		p.Dump(op)
 	}
 }
+
+func TestPlayReport(t *testing.T) {
+	os.RemoveAll("test4")
+	p := NewLogger("test4")
+	good := Op{
+		Key:       []byte("a"),
+		Value:     []byte("1"),
+		Timestamp: 1,
+	}
+	signed := good
+	signed.Checksum = good.checksum()
+	bad := good
+	bad.Checksum = signed.Checksum + 1
+	rec := createLogfile("test4", logSuffix).write()
+	if err := rec.encoder.Encode(signed); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.encoder.Encode(bad); err != nil {
+		t.Fatal(err)
+	}
+	rec.file.Write([]byte("truncated"))
+	rec.close()
+	var ary []Op
+	report := p.PlayReport(operator(&ary))
+	if !reflect.DeepEqual(ary, []Op{good}) {
+		t.Errorf("%+v should be %+v", ary, []Op{good})
+	}
+	if report.Played != 1 || report.Dropped != 1 || len(report.Truncated) != 1 || report.Clean() {
+		t.Errorf("%v should have played 1, dropped 1 and truncated 1", report)
+	}
+	p.SaveHash([]byte("hash"))
+	if hash := p.TakeHash(); string(hash) != "hash" {
+		t.Errorf("wanted hash, got %s", hash)
+	}
+	if hash := p.TakeHash(); hash != nil {
+		t.Errorf("wanted no hash, got %s", hash)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	os.RemoveAll("test5")
+	p := NewLogger("test5").Compress(16)
+	p.Record()
+	large := Op{
+		Key:       []byte("a"),
+		Value:     bytes.Repeat([]byte("large value "), 100),
+		Timestamp: 1,
+		Put:       true,
+	}
+	small := Op{
+		Key:       []byte("b"),
+		Value:     []byte("small"),
+		Timestamp: 2,
+		Put:       true,
+	}
+	p.Dump(large)
+	p.Dump(small)
+	p.Stop()
+	_, logs := p.latest()
+	logs[0].read()
+	var stored Op
+	if err := logs[0].decoder.Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	logs[0].close()
+	if !stored.Compressed || len(stored.Value) >= len(large.Value) {
+		t.Errorf("%v bytes should have been compressed, got %v bytes", len(large.Value), len(stored.Value))
+	}
+	var ary []Op
+	report := p.PlayReport(operator(&ary))
+	if !reflect.DeepEqual(ary, []Op{large, small}) {
+		t.Errorf("%+v should be %+v", ary, []Op{large, small})
+	}
+	if !report.Clean() {
+		t.Errorf("%v should be clean", report)
+	}
+	defer func(old int64) {
+		maxDecompressedSize = old
+	}(maxDecompressedSize)
+	maxDecompressedSize = int64(len(large.Value)) - 1
+	compressed, _ := Compress(large.Value, 16)
+	if _, err := Decompress(compressed, true); err == nil {
+		t.Errorf("wanted an error decompressing more than %v bytes", maxDecompressedSize)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	os.RemoveAll("test6")
+	p := NewLogger("test6")
+	legacy := Op{
+		Key:       []byte("a"),
+		Value:     []byte("1"),
+		Timestamp: 1,
+		Put:       true,
+	}
+	legacy.Checksum = legacy.checksum()
+	future := Op{
+		Key:       []byte("b"),
+		Value:     []byte("2"),
+		Timestamp: 2,
+		Put:       true,
+		Version:   FormatVersion + 1,
+	}
+	rec := createLogfile("test6", logSuffix).write()
+	for _, op := range []Op{legacy, future} {
+		if err := rec.encoder.Encode(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec.close()
+	var ary []Op
+	report := p.PlayReport(operator(&ary))
+	legacy.Checksum = 0
+	if !reflect.DeepEqual(ary, []Op{legacy}) {
+		t.Errorf("%+v should be %+v", ary, []Op{legacy})
+	}
+	if report.Played != 1 || report.Unsupported != 1 || report.Clean() {
+		t.Errorf("%v should have played 1 and skipped 1", report)
+	}
+	// p isn't recording, so snapshot would panic if it tried to merge the Ops.
+	if report = p.snapshot(nil, logfiles{rec}); report.Unsupported != 1 {
+		t.Errorf("%v should have skipped 1", report)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	os.RemoveAll("test7")
+	p := NewLogger("test7")
+	var want []Op
+	for index, name := range []string{"a", "b"} {
+		rec := createLogfile("test7", logSuffix).write()
+		op := Op{
+			Key:       []byte(name),
+			Value:     []byte(fmt.Sprint(index)),
+			Timestamp: int64(index),
+			Put:       true,
+		}
+		if err := rec.encoder.Encode(op); err != nil {
+			t.Fatal(err)
+		}
+		rec.close()
+		want = append(want, op)
+	}
+	p.SaveHash([]byte("short"))
+	layout, err := Inspect("test7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !layout.Outdated() || layout.Logs != 2 || layout.Unchecksummed != 2 || layout.Versions[0] != 2 || layout.HashBytes != 5 {
+		t.Errorf("%v should be outdated with 2 legacy logfiles and a 5 byte hash", layout)
+	}
+	var progress []UpgradeProgress
+	if layout, err = Upgrade("test7", false, func(p UpgradeProgress) {
+		progress = append(progress, p)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if layout.Outdated() || layout.Snapshots != 1 || layout.Logs != 0 || layout.HashBytes != 0 || layout.Versions[FormatVersion] != 2 {
+		t.Errorf("%v should have one current snapshot and no hash", layout)
+	}
+	if len(progress) != 2 || progress[1].Done != 2 || progress[1].Files != 2 || progress[1].Played != 2 {
+		t.Errorf("%+v should have reported 2 of 2 files", progress)
+	}
+	var ary []Op
+	report := p.PlayReport(operator(&ary))
+	if len(ary) == 2 && string(ary[0].Key) == "b" {
+		// the snapshot doesn't keep the order of the ops
+		ary[0], ary[1] = ary[1], ary[0]
+	}
+	if !report.Clean() || !reflect.DeepEqual(ary, want) {
+		t.Errorf("%v should have cleanly played %+v, got %+v", report, want, ary)
+	}
+	if layout, err = Upgrade("test7", false, nil); err != nil || layout.Snapshots != 1 || len(layout.Backups) != 0 {
+		t.Errorf("%v should not need upgrading, got %v", layout, err)
+	}
+	p.Record()
+	p.Dump(Op{Key: []byte("c"), Value: []byte("2"), Put: true})
+	p.Stop()
+	if layout, err = Inspect("test7"); err != nil || layout.Outdated() || layout.Logs != 1 {
+		t.Errorf("%v should be up to date with one new logfile, got %v", layout, err)
+	}
+	legacy := createLogfile("test7", logSuffix).write()
+	if err := legacy.encoder.Encode(Op{Key: []byte("d"), Value: []byte("3"), Put: true}); err != nil {
+		t.Fatal(err)
+	}
+	legacy.close()
+	if layout, err = Upgrade("test7", true, nil); err != nil || layout.Outdated() || layout.Snapshots != 1 || layout.Logs != 0 || layout.Versions[FormatVersion] != 4 || len(layout.Backups) != 1 {
+		t.Errorf("%v should have been upgraded into one snapshot with a kept backup, got %v", layout, err)
+	}
+	for _, backup := range layout.Backups {
+		os.RemoveAll(backup)
+	}
+	rec := createLogfile("test7", logSuffix).write()
+	if err := rec.encoder.Encode(Op{Key: []byte("c"), Put: true, Version: FormatVersion + 1}); err != nil {
+		t.Fatal(err)
+	}
+	rec.close()
+	if _, err = Upgrade("test7", false, nil); err == nil {
+		t.Errorf("should not upgrade ops of newer formats")
+	}
+	if layout, err = Inspect("test7"); err != nil || layout.Snapshots != 1 || layout.Logs != 1 {
+		t.Errorf("%v should have been left alone, got %v", layout, err)
+	}
+}
@@ -0,0 +1,201 @@
+package persistence
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zond/god/murmur"
+)
+
+// backupPrefix is the prefix of the directories Upgrade moves the old logfiles of a directory into while upgrading it.
+const backupPrefix = "upgrade-"
+
+// Layout describes the logfiles and snapshots in a directory, to tell whether they are in the current format.
+type Layout struct {
+	Dir       string
+	Snapshots int
+	Logs      int
+	// Versions is the number of Ops of each format version, and Unchecksummed the number of Ops logged before checksums were introduced.
+	Versions      map[int]int
+	Unchecksummed int
+	// HashBytes is the length of the hash saved when the data was last sealed, or zero if there is none.
+	HashBytes int
+	// Backups contains the backup directories left by upgrades that were interrupted or asked to keep them.
+	Backups []string
+	// Report is the Report of replaying all the logfiles and snapshots.
+	Report Report
+}
+
+// Outdated returns whether the directory contains Ops of older formats, Ops without checksums, or a saved hash of another width than murmur.Size.
+func (self Layout) Outdated() bool {
+	for version, count := range self.Versions {
+		if version < FormatVersion && count > 0 {
+			return true
+		}
+	}
+	return self.Unchecksummed > 0 || (self.HashBytes != 0 && self.HashBytes != murmur.Size)
+}
+
+func (self Layout) String() string {
+	return fmt.Sprintf("%v: %v snapshots, %v logfiles, ops by version %v, %v ops without checksums, %v byte hash, upgrade backups %v, %v", self.Dir, self.Snapshots, self.Logs, self.Versions, self.Unchecksummed, self.HashBytes, self.Backups, self.Report)
+}
+
+// inspect will add the Ops of logf to this Layout.
+func (self *Layout) inspect(logf *logfile) {
+	logf.inspect(func(op Op) {
+		self.Versions[op.Version]++
+		if op.Checksum == 0 {
+			self.Unchecksummed++
+		}
+	}, &self.Report)
+}
+
+// Inspect returns the Layout of dir, without changing it.
+func Inspect(dir string) (result Layout, err error) {
+	if _, err = os.Stat(dir); err != nil {
+		return
+	}
+	logger := &Logger{dir: dir}
+	result = Layout{
+		Dir:      dir,
+		Versions: make(map[int]int),
+	}
+	snapshot, logs := logger.latest()
+	if snapshot != nil {
+		result.Snapshots = 1
+	}
+	result.Logs = len(logs)
+	if hash, err := ioutil.ReadFile(filepath.Join(dir, hashFile)); err == nil {
+		result.HashBytes = len(hash)
+	}
+	if result.Backups, err = filepath.Glob(filepath.Join(dir, backupPrefix+"*")); err != nil {
+		return
+	}
+	if snapshot != nil {
+		result.inspect(snapshot)
+	}
+	for _, logf := range logs {
+		result.inspect(logf)
+	}
+	return
+}
+
+// UpgradeProgress describes how far Upgrade has come.
+type UpgradeProgress struct {
+	// Files is the number of logfiles and snapshots to upgrade, and Done the number of them replayed into the new snapshot.
+	Files int
+	Done  int
+	// File is the last file replayed.
+	File string
+	// Played is the number of Ops replayed so far.
+	Played int
+}
+
+// Upgrade will rewrite the logfiles and snapshots of dir, which no Logger may be recording to, into one snapshot in the current format, calling progress after each replayed file.
+//
+// The new snapshot is written and verified before any old file is touched. The old files are then moved into a backup directory within dir, snapshots first,
+// and since a Logger replays the oldest snapshot and the logfiles after it, dir replays either the old or the new data if the process dies at any point.
+// If anything fails, the old files are moved back. A saved hash of the old width is dropped, since it can't be verified.
+// If keepBackup is false, the backup directory is removed after a successful upgrade. Directories with Ops of newer formats than FormatVersion are not upgraded.
+func Upgrade(dir string, keepBackup bool, progress func(UpgradeProgress)) (result Layout, err error) {
+	before, err := Inspect(dir)
+	if err != nil {
+		return
+	}
+	if before.Report.Unsupported > 0 {
+		err = fmt.Errorf("%v contains %v ops of newer formats than %v", dir, before.Report.Unsupported, FormatVersion)
+		return
+	}
+	if !before.Outdated() {
+		return before, nil
+	}
+	old := &Logger{dir: dir}
+	files := old.logfiles()
+	unfinished, err := upgradeInto(old, progress)
+	if unfinished != nil && err == nil {
+		verified := Layout{Versions: make(map[int]int)}
+		verified.inspect(unfinished)
+		if !verified.Report.Clean() || verified.Outdated() {
+			err = fmt.Errorf("upgraded %v doesn't replay cleanly: %v", dir, verified)
+		}
+	}
+	if err != nil {
+		if unfinished != nil {
+			os.Remove(unfinished.filename)
+		}
+		return
+	}
+	backup := filepath.Join(dir, fmt.Sprintf("%v%v", backupPrefix, time.Now().UnixNano()))
+	if err = os.Mkdir(backup, 0777); err != nil {
+		os.Remove(unfinished.filename)
+		return
+	}
+	snapshot := filepath.Join(dir, fmt.Sprintf("%v.%v", unfinished.timestamp.UnixNano(), snapSuffix))
+	if err = os.Rename(unfinished.filename, snapshot); err != nil {
+		os.Remove(unfinished.filename)
+		os.RemoveAll(backup)
+		return
+	}
+	var names []string
+	if before.HashBytes != 0 && before.HashBytes != murmur.Size {
+		names = append(names, hashFile)
+	}
+	// Move the snapshots first, oldest first, so that dir always has a snapshot followed by the logfiles it needs.
+	sort.Sort(files)
+	for _, suffix := range []string{snapSuffix, logSuffix} {
+		for _, logf := range files {
+			if logf.suffix == suffix {
+				names = append(names, filepath.Base(logf.filename))
+			}
+		}
+	}
+	for index, name := range names {
+		if err = os.Rename(filepath.Join(dir, name), filepath.Join(backup, name)); err != nil {
+			for moved := index - 1; moved >= 0; moved-- {
+				if e := os.Rename(filepath.Join(backup, names[moved]), filepath.Join(dir, names[moved])); e != nil {
+					err = fmt.Errorf("%v, and failed restoring %v from %v: %v", err, names[moved], backup, e)
+					return
+				}
+			}
+			os.Remove(snapshot)
+			os.RemoveAll(backup)
+			return
+		}
+	}
+	if !keepBackup {
+		os.RemoveAll(backup)
+	}
+	return Inspect(dir)
+}
+
+// upgradeInto will merge the latest snapshot and logfiles of old into a new unfinished snapshot in the same directory, and return any panic as an error.
+func upgradeInto(old *Logger, progress func(UpgradeProgress)) (result *logfile, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	snapshot, logs := old.latest()
+	snapshotter := NewLogger(old.dir).setSuffix(unfinishedSuffix)
+	result = <-snapshotter.Record()
+	state := UpgradeProgress{Files: len(logs)}
+	if snapshot != nil {
+		state.Files++
+	}
+	report := snapshotter.snapshotProgress(snapshot, logs, func(logf *logfile, report Report) {
+		state.Done++
+		state.File, state.Played = logf.filename, report.Played
+		if progress != nil {
+			progress(state)
+		}
+	})
+	snapshotter.Stop()
+	if report.Unsupported > 0 {
+		err = fmt.Errorf("%v contains ops of newer formats than %v", old.dir, FormatVersion)
+	}
+	return
+}
@@ -0,0 +1,394 @@
+// Package query implements a minimal SQL-like language to read key ranges and sub trees of a god database.
+//
+// Statements look like
+//
+//	SELECT key, value FROM range('a', 'b') WHERE size(value) > 100 LIMIT 10
+//
+// where the source is either range(min, max) in the top level tree, or sub(key) or sub(key, min, max) in the sub tree defined by key.
+// Ranges include min and exclude max, and an empty string means no bound.
+//
+// The selected columns can be *, key, value and size(value). Conditions compare a column with a string, or size(value) with a number,
+// using one of = != < <= > >=, and are joined with AND. Numbers may also be written as strings, like '100'.
+//
+// Conditions on key narrow the range before the statement is sent to the nodes, and all conditions are evaluated by the nodes owning the items.
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+// Statement is a parsed and planned query.
+type Statement struct {
+	Columns []string
+	Query   common.Query
+}
+
+// Row contains the selected columns of one item.
+type Row [][]byte
+
+type token struct {
+	typ   int
+	value string
+}
+
+const (
+	wordToken = iota
+	stringToken
+	numberToken
+	symbolToken
+)
+
+func (self token) String() string {
+	if self.typ == stringToken {
+		return fmt.Sprintf("'%v'", self.value)
+	}
+	return self.value
+}
+
+func tokenize(s string) (result []token, err error) {
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'':
+			buf := new(bytes.Buffer)
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("Unterminated string in %#v", s)
+				}
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						buf.WriteRune('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				buf.WriteRune(runes[i])
+				i++
+			}
+			result = append(result, token{stringToken, buf.String()})
+		case unicode.IsDigit(r) || r == '-':
+			start := i
+			for i++; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+			}
+			result = append(result, token{numberToken, string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_'); i++ {
+			}
+			result = append(result, token{wordToken, strings.ToLower(string(runes[start:i]))})
+		case strings.ContainsRune("<>!", r) && i+1 < len(runes) && runes[i+1] == '=':
+			result = append(result, token{symbolToken, string(runes[i : i+2])})
+			i += 2
+		case strings.ContainsRune("(),*=<>", r):
+			result = append(result, token{symbolToken, string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected %q at %v in %#v", r, i, s)
+		}
+	}
+	return
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (self *parser) peek() (result token, ok bool) {
+	if self.pos < len(self.tokens) {
+		return self.tokens[self.pos], true
+	}
+	return
+}
+
+func (self *parser) next() (result token, err error) {
+	var ok bool
+	if result, ok = self.peek(); !ok {
+		err = fmt.Errorf("Unexpected end of statement")
+		return
+	}
+	self.pos++
+	return
+}
+
+func (self *parser) accept(typ int, value string) bool {
+	if t, ok := self.peek(); ok && t.typ == typ && t.value == value {
+		self.pos++
+		return true
+	}
+	return false
+}
+
+func (self *parser) expect(typ int, value string) error {
+	if !self.accept(typ, value) {
+		if t, ok := self.peek(); ok {
+			return fmt.Errorf("Expected %v but got %v", value, t)
+		}
+		return fmt.Errorf("Expected %v but got end of statement", value)
+	}
+	return nil
+}
+
+func (self *parser) expectType(typ int, name string) (result token, err error) {
+	if result, err = self.next(); err != nil {
+		return
+	}
+	if result.typ != typ {
+		err = fmt.Errorf("Expected %v but got %v", name, result)
+	}
+	return
+}
+
+// number will parse a number, or a string containing one, like the arguments bound by godsql.
+func (self *parser) number() (result int, err error) {
+	var t token
+	if t, err = self.next(); err != nil {
+		return
+	}
+	if t.typ != numberToken && t.typ != stringToken {
+		err = fmt.Errorf("Expected number but got %v", t)
+		return
+	}
+	if result, err = strconv.Atoi(t.value); err != nil {
+		err = fmt.Errorf("Expected number but got %v", t)
+	}
+	return
+}
+
+func (self *parser) column() (result string, err error) {
+	if self.accept(wordToken, "size") {
+		if err = self.expect(symbolToken, "("); err != nil {
+			return
+		}
+		if err = self.expect(wordToken, common.ValueField); err != nil {
+			return
+		}
+		if err = self.expect(symbolToken, ")"); err != nil {
+			return
+		}
+		return common.ValueSizeField, nil
+	}
+	var t token
+	if t, err = self.expectType(wordToken, "column"); err != nil {
+		return
+	}
+	if t.value != common.KeyField && t.value != common.ValueField {
+		err = fmt.Errorf("Unknown column %v", t)
+		return
+	}
+	return t.value, nil
+}
+
+func (self *parser) columns() (result []string, err error) {
+	if self.accept(symbolToken, "*") {
+		return []string{common.KeyField, common.ValueField}, nil
+	}
+	var column string
+	for {
+		if column, err = self.column(); err != nil {
+			return
+		}
+		result = append(result, column)
+		if !self.accept(symbolToken, ",") {
+			return
+		}
+	}
+}
+
+func (self *parser) strings() (result [][]byte, err error) {
+	if err = self.expect(symbolToken, "("); err != nil {
+		return
+	}
+	if self.accept(symbolToken, ")") {
+		return
+	}
+	var t token
+	for {
+		if t, err = self.expectType(stringToken, "string"); err != nil {
+			return
+		}
+		result = append(result, []byte(t.value))
+		if self.accept(symbolToken, ")") {
+			return
+		}
+		if err = self.expect(symbolToken, ","); err != nil {
+			return
+		}
+	}
+}
+
+func bound(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func (self *parser) source(r *common.Range) (err error) {
+	var t token
+	if t, err = self.expectType(wordToken, "range or sub"); err != nil {
+		return
+	}
+	var args [][]byte
+	if args, err = self.strings(); err != nil {
+		return
+	}
+	r.MinInc = true
+	switch t.value {
+	case "range":
+		switch len(args) {
+		case 0:
+		case 2:
+			r.Min, r.Max = bound(args[0]), bound(args[1])
+		default:
+			return fmt.Errorf("range takes zero or two arguments, got %v", len(args))
+		}
+	case "sub":
+		switch len(args) {
+		case 1:
+			r.Key = args[0]
+		case 3:
+			r.Key, r.Min, r.Max = args[0], bound(args[1]), bound(args[2])
+		default:
+			return fmt.Errorf("sub takes one or three arguments, got %v", len(args))
+		}
+	default:
+		return fmt.Errorf("Unknown source %v", t)
+	}
+	return
+}
+
+func (self *parser) condition() (result common.Condition, err error) {
+	if result.Field, err = self.column(); err != nil {
+		return
+	}
+	var t token
+	if t, err = self.expectType(symbolToken, "comparison"); err != nil {
+		return
+	}
+	switch t.value {
+	case "=", "!=", "<", "<=", ">", ">=":
+		result.Op = t.value
+	default:
+		err = fmt.Errorf("Unknown comparison %v", t)
+		return
+	}
+	if result.Field == common.ValueSizeField {
+		result.Size, err = self.number()
+		return
+	}
+	if t, err = self.expectType(stringToken, "string"); err != nil {
+		return
+	}
+	result.Value = []byte(t.value)
+	return
+}
+
+// narrow will tighten r using the conditions on key.
+func narrow(r *common.Range, conditions []common.Condition) {
+	for _, condition := range conditions {
+		if condition.Field != common.KeyField {
+			continue
+		}
+		raiseMin := func(inc bool) {
+			if cmp := bytes.Compare(condition.Value, r.Min); r.Min == nil || cmp > 0 || (cmp == 0 && !inc) {
+				r.Min, r.MinInc = condition.Value, inc
+			}
+		}
+		lowerMax := func(inc bool) {
+			if cmp := bytes.Compare(condition.Value, r.Max); r.Max == nil || cmp < 0 || (cmp == 0 && !inc) {
+				r.Max, r.MaxInc = condition.Value, inc
+			}
+		}
+		switch condition.Op {
+		case "=":
+			raiseMin(true)
+			lowerMax(true)
+		case ">":
+			raiseMin(false)
+		case ">=":
+			raiseMin(true)
+		case "<":
+			lowerMax(false)
+		case "<=":
+			lowerMax(true)
+		}
+	}
+}
+
+// Parse will parse and plan s.
+func Parse(s string) (result *Statement, err error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return
+	}
+	p := &parser{tokens: tokens}
+	result = &Statement{}
+	if err = p.expect(wordToken, "select"); err != nil {
+		return
+	}
+	if result.Columns, err = p.columns(); err != nil {
+		return
+	}
+	if err = p.expect(wordToken, "from"); err != nil {
+		return
+	}
+	if err = p.source(&result.Query.Range); err != nil {
+		return
+	}
+	if p.accept(wordToken, "where") {
+		var condition common.Condition
+		for {
+			if condition, err = p.condition(); err != nil {
+				return
+			}
+			result.Query.Conditions = append(result.Query.Conditions, condition)
+			if !p.accept(wordToken, "and") {
+				break
+			}
+		}
+	}
+	if p.accept(wordToken, "limit") {
+		if result.Query.Range.Len, err = p.number(); err != nil {
+			return
+		}
+	}
+	if t, ok := p.peek(); ok {
+		err = fmt.Errorf("Unexpected %v", t)
+		return
+	}
+	narrow(&result.Query.Range, result.Query.Conditions)
+	return
+}
+
+// Run will execute this Statement using conn, and return the selected columns of the matching items.
+func (self *Statement) Run(conn *client.Conn) (result []Row) {
+	for _, item := range conn.Query(self.Query) {
+		row := make(Row, len(self.Columns))
+		for index, column := range self.Columns {
+			switch column {
+			case common.KeyField:
+				row[index] = item.Key
+			case common.ValueField:
+				row[index] = item.Value
+			case common.ValueSizeField:
+				row[index] = []byte(strconv.Itoa(len(item.Value)))
+			}
+		}
+		result = append(result, row)
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zond/god/common"
+)
+
+func assertParse(t *testing.T, s string, wanted *Statement) {
+	found, err := Parse(s)
+	if err != nil {
+		t.Errorf("%#v: %v", s, err)
+	} else if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("%#v: wanted %+v but got %+v", s, wanted, found)
+	}
+}
+
+func TestParse(t *testing.T) {
+	assertParse(t, "SELECT key, value FROM range('a', 'b') WHERE size(value) > 100 LIMIT 10", &Statement{
+		Columns: []string{common.KeyField, common.ValueField},
+		Query: common.Query{
+			Range: common.Range{
+				Min:    []byte("a"),
+				Max:    []byte("b"),
+				MinInc: true,
+				Len:    10,
+			},
+			Conditions: []common.Condition{
+				{Field: common.ValueSizeField, Op: ">", Size: 100},
+			},
+		},
+	})
+	assertParse(t, "select * from sub('tree') where key >= 'c' and key < 'f' and value != 'it''s'", &Statement{
+		Columns: []string{common.KeyField, common.ValueField},
+		Query: common.Query{
+			Range: common.Range{
+				Key:    []byte("tree"),
+				Min:    []byte("c"),
+				Max:    []byte("f"),
+				MinInc: true,
+			},
+			Conditions: []common.Condition{
+				{Field: common.KeyField, Op: ">=", Value: []byte("c")},
+				{Field: common.KeyField, Op: "<", Value: []byte("f")},
+				{Field: common.ValueField, Op: "!=", Value: []byte("it's")},
+			},
+		},
+	})
+	assertParse(t, "SELECT size(value) FROM range('', 'x') WHERE key = 'k'", &Statement{
+		Columns: []string{common.ValueSizeField},
+		Query: common.Query{
+			Range: common.Range{
+				Min:    []byte("k"),
+				Max:    []byte("k"),
+				MinInc: true,
+				MaxInc: true,
+			},
+			Conditions: []common.Condition{
+				{Field: common.KeyField, Op: "=", Value: []byte("k")},
+			},
+		},
+	})
+	for _, bad := range []string{
+		"SELECT FROM range()",
+		"SELECT key FROM range('a')",
+		"SELECT key FROM nowhere()",
+		"SELECT key FROM range() WHERE size(value) > 'a'",
+		"SELECT key FROM range() LIMIT 10 garbage",
+		"SELECT key FROM range('a",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%#v should not parse", bad)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	q := common.Query{
+		Conditions: []common.Condition{
+			{Field: common.KeyField, Op: ">", Value: []byte("b")},
+			{Field: common.ValueSizeField, Op: "<=", Size: 3},
+		},
+	}
+	if !q.Match(common.Item{Key: []byte("c"), Value: []byte("abc")}) {
+		t.Errorf("should match")
+	}
+	if q.Match(common.Item{Key: []byte("b"), Value: []byte("abc")}) {
+		t.Errorf("should not match on key")
+	}
+	if q.Match(common.Item{Key: []byte("c"), Value: []byte("abcd")}) {
+		t.Errorf("should not match on size")
+	}
+}
+
+func TestParseUpdate(t *testing.T) {
+	for s, wanted := range map[string]*Update{
+		"INSERT INTO range VALUES ('k', 'v')":           {Op: PutOp, Key: []byte("k"), Value: []byte("v")},
+		"insert into sub('tree') values ('k', 'it''s')": {Tree: []byte("tree"), Op: PutOp, Key: []byte("k"), Value: []byte("it's")},
+		"DELETE FROM range() WHERE key = 'k'":           {Op: DelOp, Key: []byte("k")},
+		"DELETE FROM sub('tree') WHERE key = 'k'":       {Tree: []byte("tree"), Op: DelOp, Key: []byte("k")},
+		"DELETE FROM sub('tree')":                       {Tree: []byte("tree"), Op: ClearOp},
+	} {
+		if found, err := ParseUpdate(s); err != nil {
+			t.Errorf("%#v: %v", s, err)
+		} else if !reflect.DeepEqual(found, wanted) {
+			t.Errorf("%#v: wanted %+v but got %+v", s, wanted, found)
+		}
+	}
+	for _, bad := range []string{
+		"INSERT INTO range VALUES ('k')",
+		"INSERT INTO sub('a', 'b', 'c') VALUES ('k', 'v')",
+		"DELETE FROM range",
+		"DELETE FROM range WHERE value = 'v'",
+		"SELECT * FROM range()",
+	} {
+		if _, err := ParseUpdate(bad); err == nil {
+			t.Errorf("%#v should not parse", bad)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+)
+
+// The operations of an Update.
+const (
+	PutOp   = "put"
+	DelOp   = "del"
+	ClearOp = "clear"
+)
+
+// Update is a parsed INSERT or DELETE statement.
+//
+// Statements look like
+//
+//	INSERT INTO range VALUES ('key', 'value')
+//	INSERT INTO sub('tree') VALUES ('key', 'value')
+//	DELETE FROM range WHERE key = 'key'
+//	DELETE FROM sub('tree') WHERE key = 'key'
+//	DELETE FROM sub('tree')
+//
+// where the last one removes the entire sub tree. INSERT replaces any existing value.
+type Update struct {
+	// Tree is the key of the sub tree to write, or nil for the top level tree.
+	Tree  []byte
+	Op    string
+	Key   []byte
+	Value []byte
+}
+
+// target will parse range or sub(key), and return the key of the sub tree, or nil for range.
+func (self *parser) target() (result []byte, err error) {
+	if self.accept(wordToken, "range") {
+		if self.accept(symbolToken, "(") {
+			err = self.expect(symbolToken, ")")
+		}
+		return
+	}
+	if err = self.expect(wordToken, "sub"); err != nil {
+		return
+	}
+	var args [][]byte
+	if args, err = self.strings(); err != nil {
+		return
+	}
+	if len(args) != 1 {
+		err = fmt.Errorf("sub takes one argument when writing, got %v", len(args))
+		return
+	}
+	return args[0], nil
+}
+
+// ParseUpdate will parse s.
+func ParseUpdate(s string) (result *Update, err error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return
+	}
+	p := &parser{tokens: tokens}
+	result = &Update{}
+	switch {
+	case p.accept(wordToken, "insert"):
+		if err = p.expect(wordToken, "into"); err != nil {
+			return
+		}
+		if result.Tree, err = p.target(); err != nil {
+			return
+		}
+		if err = p.expect(wordToken, "values"); err != nil {
+			return
+		}
+		var args [][]byte
+		if args, err = p.strings(); err != nil {
+			return
+		}
+		if len(args) != 2 {
+			err = fmt.Errorf("INSERT takes a key and a value, got %v values", len(args))
+			return
+		}
+		result.Op, result.Key, result.Value = PutOp, args[0], args[1]
+	case p.accept(wordToken, "delete"):
+		if err = p.expect(wordToken, "from"); err != nil {
+			return
+		}
+		if result.Tree, err = p.target(); err != nil {
+			return
+		}
+		if !p.accept(wordToken, "where") {
+			if result.Tree == nil {
+				err = fmt.Errorf("Deleting the entire range is not supported")
+				return
+			}
+			result.Op = ClearOp
+			break
+		}
+		if err = p.expect(wordToken, common.KeyField); err != nil {
+			return
+		}
+		if err = p.expect(symbolToken, "="); err != nil {
+			return
+		}
+		var t token
+		if t, err = p.expectType(stringToken, "string"); err != nil {
+			return
+		}
+		result.Op, result.Key = DelOp, []byte(t.value)
+	default:
+		err = fmt.Errorf("Expected INSERT or DELETE")
+		return
+	}
+	if t, ok := p.peek(); ok {
+		err = fmt.Errorf("Unexpected %v", t)
+	}
+	return
+}
+
+// Run will execute this Update using conn.
+func (self *Update) Run(conn *client.Conn) error {
+	switch self.Op {
+	case PutOp:
+		if self.Tree == nil {
+			return conn.TryPut(self.Key, self.Value)
+		}
+		return conn.TrySubPut(self.Tree, self.Key, self.Value)
+	case DelOp:
+		if self.Tree == nil {
+			return conn.TryDel(self.Key)
+		}
+		return conn.TrySubDel(self.Tree, self.Key)
+	case ClearOp:
+		return conn.TrySubClear(self.Tree)
+	}
+	return fmt.Errorf("Unknown operation %v", self.Op)
+}
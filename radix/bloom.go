@@ -0,0 +1,67 @@
+package radix
+
+import (
+	"encoding/binary"
+
+	"github.com/zond/god/murmur"
+)
+
+// Bloom is a bloom filter of key prefixes, used to make a Sync only visit the branches of a Tree leading to keys that may have changed.
+type Bloom struct {
+	Bits   []uint64
+	Hashes int
+}
+
+// NewBloom returns an empty Bloom of at least bits bits, using hashes hash functions per prefix.
+func NewBloom(bits, hashes int) *Bloom {
+	return &Bloom{
+		Bits:   make([]uint64, (bits+63)/64),
+		Hashes: hashes,
+	}
+}
+
+// indices will call f with the bit indices of prefix in this Bloom, using double hashing of a murmur hash of prefix.
+func (self *Bloom) indices(prefix []Nibble, f func(index uint64) bool) bool {
+	h := murmur.HashBytes(toBytes(prefix))
+	h1, h2 := binary.BigEndian.Uint64(h), binary.BigEndian.Uint64(h[8:])
+	size := uint64(len(self.Bits)) * 64
+	for i := 0; i < self.Hashes; i++ {
+		if !f((h1 + uint64(i)*h2) % size) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddKey will add key, and all its prefixes, to this Bloom.
+func (self *Bloom) AddKey(key []byte) {
+	ripped := Rip(key)
+	for i := 0; i <= len(ripped); i++ {
+		self.indices(ripped[:i], func(index uint64) bool {
+			self.Bits[index/64] |= 1 << (index % 64)
+			return true
+		})
+	}
+}
+
+// MayContain returns whether prefix may be the prefix of a key added to this Bloom.
+func (self *Bloom) MayContain(prefix []Nibble) bool {
+	if len(self.Bits) == 0 {
+		return true
+	}
+	return self.indices(prefix, func(index uint64) bool {
+		return self.Bits[index/64]&(1<<(index%64)) != 0
+	})
+}
+
+// Union returns a new Bloom containing the prefixes of both this Bloom and other, which must have the same size and number of hashes.
+func (self *Bloom) Union(other *Bloom) (result *Bloom) {
+	result = &Bloom{
+		Bits:   make([]uint64, len(self.Bits)),
+		Hashes: self.Hashes,
+	}
+	for index, bits := range self.Bits {
+		result.Bits[index] = bits | other.Bits[index]
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package radix
+
+// SubConfigurationIterator is called with the key and configuration of sub trees.
+type SubConfigurationIterator func(key []byte, conf map[string]string) (cont bool)
+
+// EachSubConfigurationBetween will call f with the key and configuration of every configured sub tree between min and max in this Tree.
+// f must not modify this Tree.
+func (self *Tree) EachSubConfigurationBetween(min, max []byte, mininc, maxinc bool, f SubConfigurationIterator) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, 0, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		if use&treeValue != 0 && tValue != nil {
+			if conf, _ := tValue.Configuration(); len(conf) > 0 {
+				return f(key, conf)
+			}
+		}
+		return true
+	})
+}
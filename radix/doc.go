@@ -0,0 +1,13 @@
+// Package radix implements the index of god as a standalone, embeddable data structure: a merkle tree inside a radix tree, where each key can also hold a sub tree.
+//
+// The package only depends on the standard library and the murmur and persistence packages of god, so it can be embedded in applications that never talk to a cluster:
+//
+//	tree := radix.NewTree().Log("/var/lib/app/index").Restore()
+//	tree.Put([]byte("key"), []byte("value"), time.Now().UnixNano())
+//
+// Every Tree keeps the sizes of its branches, to look up keys by index, and the hashes of its branches, so that two HashTrees can be synchronized by Sync
+// by only copying the branches that differ. Writes carry timestamps, and Sync only copies entries newer than the ones they would replace.
+// A Tree is a HashTree, and so is a node of a god cluster over RPC, so a local Tree can be synchronized with a cluster using the same Sync.
+//
+// The exported API of Tree, Sync, HashTree, Print, Bloom and Limiter is kept backwards compatible. The on disk format is the one of the persistence package.
+package radix
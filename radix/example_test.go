@@ -0,0 +1,30 @@
+package radix
+
+import (
+	"bytes"
+	"fmt"
+)
+
+func ExampleTree() {
+	tree := NewTree()
+	tree.Put([]byte("apple"), []byte("red"), 1)
+	tree.Put([]byte("banana"), []byte("yellow"), 1)
+	tree.SubPut([]byte("basket"), []byte("apple"), []byte("2"), 1)
+	value, timestamp, existed := tree.Get([]byte("apple"))
+	fmt.Println(string(value), timestamp, existed, tree.Size(), tree.SubSize([]byte("basket")))
+	// Output: red 1 true 3 1
+}
+
+func ExampleSync() {
+	local := NewTree()
+	local.Put([]byte("apple"), []byte("red"), 1)
+	local.Put([]byte("banana"), []byte("green"), 1)
+	remote := NewTree()
+	remote.Put([]byte("banana"), []byte("yellow"), 2)
+	remote.Put([]byte("cherry"), []byte("red"), 2)
+	NewSync(local, remote).Run()
+	NewSync(remote, local).Run()
+	value, _, _ := local.Get([]byte("banana"))
+	fmt.Println(local.Size(), string(value), bytes.Equal(local.Hash(), remote.Hash()))
+	// Output: 3 yellow true
+}
@@ -0,0 +1,126 @@
+package radix
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyThreshold is how many times slower than the baseline the recent operations of a Limiter must be before it slows down.
+	latencyThreshold = 2
+	// maxSlowdown is the maximum factor a Limiter will slow down with.
+	maxSlowdown = 64
+	// baselineAlpha is the weight of each new latency in the baseline latency of a Limiter.
+	baselineAlpha = 0.01
+	// recentAlpha is the weight of each new latency in the recent latency of a Limiter.
+	recentAlpha = 0.3
+)
+
+// Limiter limits the number of keys and bytes per second a Sync copies, and slows it down further when the latency of the operations against the peer rises.
+// A nil Limiter doesn't limit anything. Limiters are safe to share between concurrent Syncs.
+type Limiter struct {
+	lock           *sync.Mutex
+	keysPerSecond  float64
+	bytesPerSecond float64
+	next           time.Time
+	baseline       float64
+	recent         float64
+	slowdown       float64
+	lifted         bool
+}
+
+// NewLimiter returns a Limiter allowing keysPerSecond keys and bytesPerSecond bytes per second. Zero means unlimited.
+func NewLimiter(keysPerSecond, bytesPerSecond float64) *Limiter {
+	return &Limiter{
+		lock:           new(sync.Mutex),
+		keysPerSecond:  keysPerSecond,
+		bytesPerSecond: bytesPerSecond,
+		slowdown:       1,
+	}
+}
+
+// SetRates will change the number of keys and bytes per second this Limiter allows. Zero means unlimited.
+func (self *Limiter) SetRates(keysPerSecond, bytesPerSecond float64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.keysPerSecond, self.bytesPerSecond = keysPerSecond, bytesPerSecond
+}
+
+// Rates returns the number of keys and bytes per second this Limiter allows.
+func (self *Limiter) Rates() (keysPerSecond, bytesPerSecond float64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.keysPerSecond, self.bytesPerSecond
+}
+
+// SetLifted will make this Limiter stop enforcing its rates while lifted is true. It still slows down when the latency of the operations rises.
+func (self *Limiter) SetLifted(lifted bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.lifted = lifted
+}
+
+// Lifted returns whether this Limiter has stopped enforcing its rates.
+func (self *Limiter) Lifted() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.lifted
+}
+
+// Slowdown returns the factor this Limiter currently slows down with due to increased latency.
+func (self *Limiter) Slowdown() float64 {
+	if self == nil {
+		return 1
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.slowdown
+}
+
+// Observe will record the latency of an operation against the peer, and slow down if recent operations are much slower than usual.
+func (self *Limiter) Observe(latency time.Duration) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	l := float64(latency)
+	if self.baseline == 0 {
+		self.baseline, self.recent = l, l
+	} else {
+		self.baseline += baselineAlpha * (l - self.baseline)
+		self.recent += recentAlpha * (l - self.recent)
+	}
+	if self.baseline > 0 && self.recent > self.baseline*latencyThreshold {
+		self.slowdown = math.Min(maxSlowdown, self.recent/self.baseline)
+	} else {
+		self.slowdown = 1
+	}
+}
+
+// Wait will block until keys keys of bytes bytes may be copied.
+func (self *Limiter) Wait(keys, bytes int) {
+	if self == nil {
+		return
+	}
+	self.lock.Lock()
+	var cost float64
+	if self.keysPerSecond > 0 && !self.lifted {
+		cost = float64(keys) / self.keysPerSecond
+	}
+	if self.bytesPerSecond > 0 && !self.lifted {
+		cost = math.Max(cost, float64(bytes)/self.bytesPerSecond)
+	}
+	delay := time.Duration(cost*float64(time.Second)*self.slowdown + (self.slowdown-1)*self.recent)
+	now := time.Now()
+	if self.next.Before(now) {
+		self.next = now
+	}
+	wait := self.next.Sub(now)
+	self.next = self.next.Add(delay)
+	self.lock.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
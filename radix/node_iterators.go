@@ -42,10 +42,17 @@ func (self *node) reverseEach(prefix []Nibble, use int, f nodeIterator) (cont bo
 
 // eachBetween will iterate between min and max, including each depending on mincmp and maxcmp, in order
 func (self *node) eachBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int, f nodeIterator) (cont bool) {
+	return self.eachNodeBetween(prefix, min, max, mincmp, maxcmp, use, func(key []Nibble, n *node) bool {
+		return f(Stitch(key), n.byteValue, n.treeValue, n.use, n.timestamp)
+	})
+}
+
+// eachNodeBetween is like eachBetween, but calls f with the key and the node itself, to let f use the cached hashes and sizes of the node.
+func (self *node) eachNodeBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int, f func(key []Nibble, n *node) (cont bool)) (cont bool) {
 	cont = true
 	prefix = append(prefix, self.segment...)
 	if !self.empty && (use == 0 || self.use&use != 0) && (min == nil || nComp(prefix, min) > mincmp) && (max == nil || nComp(prefix, max) < maxcmp) {
-		cont = f(Stitch(prefix), self.byteValue, self.treeValue, self.use, self.timestamp)
+		cont = f(prefix, self)
 	}
 	if cont {
 		for _, child := range self.children {
@@ -62,7 +69,7 @@ func (self *node) eachBetween(prefix, min, max []Nibble, mincmp, maxcmp, use int
 					mma = len(max)
 				}
 				if (min == nil || nComp(childKey[:mmi], min[:mmi]) > -1) && (max == nil || nComp(childKey[:mma], max[:mma]) < 1) {
-					cont = child.eachBetween(prefix, min, max, mincmp, maxcmp, use, f)
+					cont = child.eachNodeBetween(prefix, min, max, mincmp, maxcmp, use, f)
 				}
 				if !cont {
 					break
@@ -123,6 +123,18 @@ func (self *Print) set(n *node) {
 		}
 	}
 }
+func (self *Print) subPrint(index int) SubPrint {
+	if self == nil || index >= len(self.SubPrints) {
+		return SubPrint{}
+	}
+	return self.SubPrints[index]
+}
+func (self *Print) byteHash() []byte {
+	if self == nil || self.Timestamp == 0 {
+		return nil
+	}
+	return self.ByteHash
+}
 func (self *Print) timestamp() int64 {
 	if self == nil {
 		return 0
@@ -2,6 +2,8 @@ package radix
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"github.com/zond/god/common"
@@ -102,6 +104,118 @@ func TestSyncLimits(t *testing.T) {
 	}
 }
 
+func TestSyncFanout(t *testing.T) {
+	tree1 := NewTree()
+	for i := 0; i < 100; i++ {
+		tree1.Put([]byte(murmur.HashString(fmt.Sprint(i))), []byte(fmt.Sprint(i)), 1)
+	}
+	tree2 := NewTree()
+	tree2.Put([]byte(murmur.HashString(fmt.Sprint(7))), []byte("newer"), 2)
+	tree3 := NewTree()
+	tree3.Put([]byte(murmur.HashString(fmt.Sprint(7))), []byte("newer"), 2)
+	NewSync(tree1, tree2).Fanout(5).Run()
+	NewSync(tree1, tree3).Run()
+	if !tree3.deepEqual(tree2) {
+		t.Errorf("%v and %v are unequal", tree3.Describe(), tree2.Describe())
+	}
+}
+
+func TestSyncDivergence(t *testing.T) {
+	tree1 := NewTree()
+	tree2 := NewTree()
+	for i := 0; i < 1000; i++ {
+		k := murmur.HashString(fmt.Sprint(i))
+		tree1.Put(k, []byte("v"), 1)
+		tree2.Put(k, []byte("v"), 1)
+	}
+	if d := NewSync(tree1, tree2).Divergence(2); d != 0 {
+		t.Errorf("wanted equal trees not to differ, got %v", d)
+	}
+	tree2.Put(murmur.HashString("0"), []byte("changed"), 2)
+	if d := NewSync(tree1, tree2).Divergence(2); d <= 0 || d > 0.01 {
+		t.Errorf("wanted one changed key to make the trees differ a little, got %v", d)
+	}
+	if d := NewSync(tree1, NewTree()).Divergence(2); d != 1 {
+		t.Errorf("wanted an empty tree to differ completely, got %v", d)
+	}
+}
+
+func TestEachSnapshotHashBetween(t *testing.T) {
+	tree1 := NewTree()
+	tree1.Put([]byte("a"), []byte("1"), 1)
+	tree1.SubPut([]byte("b"), []byte("x"), []byte("2"), 1)
+	tree1.SubPut([]byte("b"), []byte("y"), []byte("3"), 1)
+	tree1.Put([]byte("c"), []byte("4"), 1)
+	tree1.Del([]byte("c"))
+	tree1.FakeDel([]byte("d"), 2)
+	hashes := func(tree *Tree) (result map[string]string) {
+		result = make(map[string]string)
+		entries := 0
+		tree.EachSnapshotHashBetween(nil, nil, true, true, func(key []byte, n int, hash []byte) bool {
+			entries += n
+			result[string(key)] = fmt.Sprint(hash)
+			return true
+		})
+		if snapshot := tree.SnapshotBetween(nil, nil, true, true); entries != len(snapshot) {
+			t.Errorf("wanted %v entries like the snapshot, got %v", len(snapshot), entries)
+		}
+		return
+	}
+	tree2 := NewTree()
+	tree2.ApplySnapshot(tree1.SnapshotBetween(nil, nil, true, true))
+	if h1, h2 := hashes(tree1), hashes(tree2); !reflect.DeepEqual(h1, h2) {
+		t.Errorf("wanted equal hashes for equal trees, got %v and %v", h1, h2)
+	}
+	tree2.SubPut([]byte("b"), []byte("y"), []byte("changed"), 2)
+	if h1, h2 := hashes(tree1), hashes(tree2); h1["b"] == h2["b"] || h1["a"] != h2["a"] {
+		t.Errorf("wanted only the hash of b to change, got %v and %v", h1, h2)
+	}
+}
+
+func TestSyncFilter(t *testing.T) {
+	tree1 := NewTree()
+	tree1.Put([]byte("a"), []byte("1"), 1)
+	tree1.Put([]byte("ab"), []byte("2"), 1)
+	tree1.Put([]byte("z"), []byte("3"), 1)
+	tree2 := NewTree()
+	filter := NewBloom(1<<10, 3)
+	filter.AddKey([]byte("ab"))
+	if !filter.MayContain(Rip([]byte("a"))) || !filter.MayContain(Rip([]byte("ab"))) {
+		t.Errorf("%v should contain a and ab", filter)
+	}
+	s := NewSync(tree1, tree2).Filter(filter).Run()
+	if s.PutCount() != 2 {
+		t.Errorf("wanted 2 puts, got %v", s.PutCount())
+	}
+	if _, _, existed := tree2.Get([]byte("ab")); !existed {
+		t.Errorf("ab should have been synchronized")
+	}
+	if _, _, existed := tree2.Get([]byte("z")); existed {
+		t.Errorf("z should have been filtered out")
+	}
+}
+
+func TestSyncDiverged(t *testing.T) {
+	tree1 := NewTree()
+	tree1.Put([]byte("a"), []byte("new"), 2)
+	tree1.Put([]byte("b"), []byte("same"), 1)
+	tree1.SubPut([]byte("s"), []byte("x"), []byte("sub"), 3)
+	tree2 := NewTree()
+	tree2.Put([]byte("a"), []byte("old"), 1)
+	tree2.Put([]byte("b"), []byte("same"), 1)
+	var found []Divergence
+	NewSync(tree1, tree2).Diverged(func(d Divergence) {
+		found = append(found, d)
+	}).Run()
+	wanted := []Divergence{
+		{Key: []byte("a"), SourceTimestamp: 2, SourceHash: murmur.HashBytes([]byte("new")), DestinationTimestamp: 1, DestinationHash: murmur.HashBytes([]byte("old"))},
+		{Key: []byte("s"), SubKey: []byte("x"), SourceTimestamp: 3, SourceHash: murmur.HashBytes([]byte("sub"))},
+	}
+	if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("wanted %+v, got %+v", wanted, found)
+	}
+}
+
 func TestripStitch(t *testing.T) {
 	var b []byte
 	for i := 0; i < 1000; i++ {
@@ -316,6 +430,48 @@ func TestSyncDestructive(t *testing.T) {
 	}
 }
 
+// failingTree is a Tree that fails, returning made up prints and dropping puts, after a number of puts.
+type failingTree struct {
+	*Tree
+	puts int
+	err  error
+}
+
+func (self *failingTree) Err() error {
+	return self.err
+}
+func (self *failingTree) Finger(key []Nibble) *Print {
+	if self.err != nil {
+		return &Print{}
+	}
+	return self.Tree.Finger(key)
+}
+func (self *failingTree) PutTimestamp(key []Nibble, bValue []byte, present bool, expected, timestamp int64) bool {
+	if self.puts == 0 {
+		self.err = fmt.Errorf("unreachable")
+	}
+	if self.err != nil {
+		return false
+	}
+	self.puts--
+	return self.Tree.PutTimestamp(key, bValue, present, expected, timestamp)
+}
+
+func TestSyncDestructiveFailing(t *testing.T) {
+	source := NewTree()
+	for i := 0; i < 100; i++ {
+		source.Put([]byte(fmt.Sprint(i)), []byte(fmt.Sprint(i)), 1)
+	}
+	destination := &failingTree{Tree: NewTree(), puts: 10}
+	s := NewSync(source, destination).Destroy().Run()
+	if s.Err() == nil {
+		t.Errorf("%v should have failed", s)
+	}
+	if source.Size()+destination.Size() != 100 {
+		t.Errorf("%v and %v should together contain all 100 entries", source.Describe(), destination.Describe())
+	}
+}
+
 func TestSyncDestructiveMatching(t *testing.T) {
 	tree1 := NewTree()
 	tree2 := NewTree()
@@ -447,6 +603,74 @@ func TestSyncSubConf(t *testing.T) {
 	}
 }
 
+func TestEachSubConfigurationBetween(t *testing.T) {
+	tree := NewTree()
+	tree.SubAddConfiguration([]byte("a"), 1, "blapp", "blepp")
+	tree.SubPut([]byte("b"), []byte("x"), []byte("y"), 1)
+	tree.SubAddConfiguration([]byte("c"), 1, "blupp", "blopp")
+	tree.Put([]byte("d"), []byte("e"), 1)
+	found := make(map[string]map[string]string)
+	tree.EachSubConfigurationBetween(nil, nil, true, true, func(key []byte, conf map[string]string) bool {
+		found[string(key)] = conf
+		return true
+	})
+	wanted := map[string]map[string]string{
+		"a": map[string]string{"blapp": "blepp"},
+		"c": map[string]string{"blupp": "blopp"},
+	}
+	if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("wanted %v but got %v", wanted, found)
+	}
+	found = make(map[string]map[string]string)
+	tree.EachSubConfigurationBetween([]byte("b"), nil, true, true, func(key []byte, conf map[string]string) bool {
+		found[string(key)] = conf
+		return true
+	})
+	if len(found) != 1 || found["c"] == nil {
+		t.Errorf("wanted only c but got %v", found)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(100, 0)
+	start := time.Now()
+	for i := 0; i < 21; i++ {
+		limiter.Wait(1, 0)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < time.Millisecond*190 {
+		t.Errorf("21 keys at 100 keys per second should take at least 200ms, took %v", elapsed)
+	}
+	limiter = NewLimiter(0, 1000)
+	start = time.Now()
+	limiter.Wait(1, 100)
+	limiter.Wait(1, 0)
+	if elapsed := time.Now().Sub(start); elapsed < time.Millisecond*90 {
+		t.Errorf("100 bytes at 1000 bytes per second should take at least 100ms, took %v", elapsed)
+	}
+	limiter = NewLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		limiter.Observe(time.Millisecond)
+	}
+	if slowdown := limiter.Slowdown(); slowdown != 1 {
+		t.Errorf("stable latency should not slow down, got %v", slowdown)
+	}
+	for i := 0; i < 10; i++ {
+		limiter.Observe(time.Millisecond * 10)
+	}
+	if slowdown := limiter.Slowdown(); slowdown <= 1 {
+		t.Errorf("rising latency should slow down, got %v", slowdown)
+	}
+	for i := 0; i < 1000; i++ {
+		limiter.Observe(time.Millisecond)
+	}
+	if slowdown := limiter.Slowdown(); slowdown != 1 {
+		t.Errorf("falling latency should stop slowing down, got %v", slowdown)
+	}
+	var nilLimiter *Limiter
+	nilLimiter.Wait(1, 1)
+	nilLimiter.Observe(time.Second)
+}
+
 func TestSyncConf(t *testing.T) {
 	tree1 := NewTree()
 	tree2 := NewTree()
@@ -1183,3 +1407,134 @@ func BenchmarkTreeMirrorPut100000(b *testing.B) {
 func BenchmarkTreeMirrorPut1000000(b *testing.B) {
 	benchTree(b, 1000000, true, false)
 }
+
+func TestSnapshot(t *testing.T) {
+	tree1 := NewTree()
+	tree2 := NewTree()
+	for i := 0; i < 10; i++ {
+		tree1.Put([]byte{byte(i)}, []byte(fmt.Sprint(i)), 2)
+		tree1.SubPut([]byte{byte(i + 100)}, []byte{byte(i)}, []byte(fmt.Sprint(i)), 2)
+	}
+	tree1.FakeDel([]byte{3}, 3)
+	tree2.Put([]byte{4}, []byte("newer"), 3)
+	tree2.Put([]byte{5}, []byte("older"), 1)
+	if changed := tree2.ApplySnapshot(tree1.SnapshotBetween(nil, nil, true, true)); changed != 19 {
+		t.Errorf("wanted 19 changes, got %v", changed)
+	}
+	if value, _, _ := tree2.Get([]byte{4}); string(value) != "newer" {
+		t.Errorf("wanted newer, got %s", value)
+	}
+	if value, _, _ := tree2.Get([]byte{5}); string(value) != "5" {
+		t.Errorf("wanted 5, got %s", value)
+	}
+	if _, _, existed := tree2.Get([]byte{3}); existed {
+		t.Errorf("wanted 3 to be deleted")
+	}
+	if value, _, _ := tree2.SubGet([]byte{107}, []byte{7}); string(value) != "7" {
+		t.Errorf("wanted 7, got %s", value)
+	}
+	if size := len(tree1.SnapshotBetween([]byte{2}, []byte{5}, true, false)); size != 3 {
+		t.Errorf("wanted 3 entries, got %v", size)
+	}
+}
+
+func TestRank(t *testing.T) {
+	tree := NewTree()
+	board := []byte("board")
+	tree.SubAddConfiguration(board, 1, "mirrored", "yes")
+	for member, score := range map[string]string{"a": "3", "b": "1", "c": "3", "d": "2"} {
+		tree.SubPut(board, []byte(member), []byte(score), 1)
+	}
+	for member, wanted := range map[string]int{"c": 0, "a": 1, "d": 2, "b": 3} {
+		if rank, existed := tree.SubRank(board, []byte(member)); !existed || rank != wanted {
+			t.Errorf("wanted %v at rank %v, got %v, %v", member, wanted, rank, existed)
+		}
+	}
+	if _, existed := tree.SubRank(board, []byte("e")); existed {
+		t.Errorf("e should not have a rank")
+	}
+	tree.SubPut(board, []byte("b"), []byte("4"), 2)
+	if rank, _ := tree.SubRank(board, []byte("b")); rank != 0 {
+		t.Errorf("wanted b at rank 0 after raising its score, got %v", rank)
+	}
+}
+
+func encodeTestSnapshot(t *testing.T, snapshot interface{}) []byte {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if err := gob.NewEncoder(writer).Encode(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestSnapshotVersions(t *testing.T) {
+	entries := []SnapshotEntry{{Key: []byte("a"), Value: []byte("1"), Timestamp: 1, Present: true}}
+	encoded, err := EncodeSnapshot(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := DecodeSnapshot(encoded); err != nil || !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("wanted %v, got %v, %v", entries, decoded, err)
+	}
+	if decoded, err := DecodeSnapshot(encodeTestSnapshot(t, entries)); err != nil || !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("wanted unversioned snapshot %v, got %v, %v", entries, decoded, err)
+	}
+	if decoded, err := DecodeSnapshot(encodeTestSnapshot(t, versionedSnapshot{Version: SnapshotVersion + 1, Entries: entries})); err == nil {
+		t.Errorf("wanted an error decoding a newer snapshot, got %v", decoded)
+	}
+}
+
+func TestWriteListener(t *testing.T) {
+	var writes []Write
+	tree1 := NewTree()
+	tree1.SetWriteListener(func(write Write) {
+		writes = append(writes, write)
+		// The listener is called after the tree is unlocked.
+		tree1.Size()
+	})
+	tree1.Put([]byte("a"), []byte("1"), 1)
+	tree1.Put([]byte("a"), []byte("2"), 2)
+	tree1.FakeDel([]byte("a"), 3)
+	tree1.SubPut([]byte("b"), []byte("c"), []byte("3"), 4)
+	tree1.SubFakeDel([]byte("b"), []byte("c"), 5)
+	tree1.Del([]byte("b"))
+	expected := []Write{
+		{Operation: "Put", Key: []byte("a"), NewValue: []byte("1"), Timestamp: 1},
+		{Operation: "Put", Key: []byte("a"), OldValue: []byte("1"), NewValue: []byte("2"), Timestamp: 2},
+		{Operation: "Del", Key: []byte("a"), OldValue: []byte("2"), Timestamp: 3},
+		{Operation: "SubPut", Key: []byte("b"), SubKey: []byte("c"), NewValue: []byte("3"), Timestamp: 4},
+		{Operation: "SubDel", Key: []byte("b"), SubKey: []byte("c"), OldValue: []byte("3"), Timestamp: 5},
+	}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Errorf("wanted %+v, got %+v", expected, writes)
+	}
+	writes = nil
+	tree2 := NewTree()
+	tree2.Put([]byte("d"), []byte("4"), 6)
+	tree2.SubPut([]byte("e"), []byte("f"), []byte("5"), 7)
+	NewSync(tree2, tree1).Run()
+	found := map[string]bool{}
+	for _, write := range writes {
+		found[fmt.Sprintf("%v %s %s %s", write.Operation, write.Key, write.SubKey, write.NewValue)] = true
+	}
+	if !found["Put d  4"] || !found["SubPut e f 5"] {
+		t.Errorf("wanted the synchronized writes to be reported, got %+v", writes)
+	}
+}
+
+func TestTimedLock(t *testing.T) {
+	tree := NewTree()
+	tree.lock.Lock()
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		tree.lock.Unlock()
+	}()
+	if _, _, wait := tree.TimedPut([]byte("a"), []byte("1"), 1); wait < time.Millisecond*50 {
+		t.Errorf("wanted the put to wait for the held lock, got %v", wait)
+	}
+	if value, _, existed, wait := tree.TimedGet([]byte("a")); !existed || string(value) != "1" || wait >= time.Millisecond*50 {
+		t.Errorf("wanted 1 without waiting for the free lock, got %s, %v and %v", value, existed, wait)
+	}
+}
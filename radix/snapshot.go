@@ -0,0 +1,163 @@
+package radix
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/zond/god/murmur"
+)
+
+// SnapshotVersion is the version of the snapshot format EncodeSnapshot produces.
+// DecodeSnapshot reads this and all previous versions, including the unversioned snapshots made before versions were introduced.
+const SnapshotVersion = 1
+
+// versionedSnapshot is the envelope stamping encoded snapshots with their version.
+type versionedSnapshot struct {
+	Version int
+	Entries []SnapshotEntry
+}
+
+// SnapshotEntry is a byte value, tombstone or sub tree value in a snapshot of a Tree.
+type SnapshotEntry struct {
+	Key       []byte
+	SubKey    []byte
+	Value     []byte
+	Timestamp int64
+	Present   bool
+	Sub       bool
+}
+
+// EncodeSnapshot will return snapshot as gzipped gob, stamped with SnapshotVersion.
+func EncodeSnapshot(snapshot []SnapshotEntry) (result []byte, err error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if err = gob.NewEncoder(writer).Encode(versionedSnapshot{
+		Version: SnapshotVersion,
+		Entries: snapshot,
+	}); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	result = buf.Bytes()
+	return
+}
+
+// DecodeSnapshot will return the snapshot encoded in b by EncodeSnapshot, of this or any previous version.
+func DecodeSnapshot(b []byte) (result []SnapshotEntry, err error) {
+	reader, err := gzip.NewReader(bytes.NewBuffer(b))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	var versioned versionedSnapshot
+	if err = gob.NewDecoder(reader).Decode(&versioned); err != nil {
+		return decodeUnversionedSnapshot(b)
+	}
+	if versioned.Version > SnapshotVersion {
+		err = fmt.Errorf("Snapshot version %v is newer than the supported version %v", versioned.Version, SnapshotVersion)
+		return
+	}
+	result = versioned.Entries
+	return
+}
+
+// decodeUnversionedSnapshot will return the snapshot encoded in b by an EncodeSnapshot from before snapshots were versioned.
+func decodeUnversionedSnapshot(b []byte) (result []SnapshotEntry, err error) {
+	reader, err := gzip.NewReader(bytes.NewBuffer(b))
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	err = gob.NewDecoder(reader).Decode(&result)
+	return
+}
+
+// SnapshotBetween returns all byte values, tombstones and sub tree values between min and max in this Tree.
+func (self *Tree) SnapshotBetween(min, max []byte, mininc, maxinc bool) (result []SnapshotEntry) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, 0, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		if use&treeValue == 0 || use&byteValue != 0 {
+			result = append(result, SnapshotEntry{
+				Key:       key,
+				Value:     bValue,
+				Timestamp: timestamp,
+				Present:   use&byteValue != 0,
+			})
+		}
+		if use&treeValue != 0 && tValue != nil {
+			tValue.lock.RLock()
+			defer tValue.lock.RUnlock()
+			tValue.root.each(nil, 0, func(subKey, subValue []byte, subTree *Tree, subUse int, subTimestamp int64) bool {
+				result = append(result, SnapshotEntry{
+					Key:       key,
+					SubKey:    subKey,
+					Value:     subValue,
+					Timestamp: subTimestamp,
+					Present:   subUse&byteValue != 0,
+					Sub:       true,
+				})
+				return true
+			})
+		}
+		return true
+	})
+	return
+}
+
+// EachSnapshotHashBetween will call f with each key between min and max having a byte value, tombstone or sub tree, the number of entries SnapshotBetween
+// would return for it, and a hash of those entries. The hash is made from the cached hashes of the value and the sub tree, so no values are copied or hashed.
+func (self *Tree) EachSnapshotHashBetween(min, max []byte, mininc, maxinc bool, f func(key []byte, entries int, hash []byte) (cont bool)) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachNodeBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, 0, func(key []Nibble, n *node) bool {
+		entries := 0
+		hash := murmur.NewBytes(toBytes(key))
+		if n.use&treeValue == 0 || n.use&byteValue != 0 {
+			entries++
+			hash.MustWrite(n.byteHash)
+			hash.MustWrite(murmur.HashInt64(n.timestamp))
+			hash.MustWrite([]byte{byte(n.use & byteValue)})
+		}
+		if n.use&treeValue != 0 && n.treeValue != nil {
+			entries += n.treeValue.RealSize()
+			hash.MustWrite(n.treeValue.Hash())
+		}
+		return f(Stitch(key), entries, hash.Get())
+	})
+}
+
+// ApplySnapshot will insert all entries of snapshot that are newer than what this Tree contains, and return the number of entries inserted.
+func (self *Tree) ApplySnapshot(snapshot []SnapshotEntry) (changed int) {
+	for _, entry := range snapshot {
+		key := Rip(entry.Key)
+		if entry.Sub {
+			subKey := Rip(entry.SubKey)
+			_, current, present := self.SubGetTimestamp(key, subKey)
+			if entry.Timestamp > current && (entry.Present || present) {
+				if self.SubPutTimestamp(key, subKey, entry.Value, entry.Present, current, entry.Timestamp) {
+					changed++
+				}
+			}
+		} else {
+			if _, current, _ := self.GetTimestamp(key); entry.Timestamp > current {
+				if self.PutTimestamp(key, entry.Value, entry.Present, current, entry.Timestamp) {
+					changed++
+				}
+			}
+		}
+	}
+	return
+}
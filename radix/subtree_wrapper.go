@@ -17,6 +17,12 @@ func (self *subTreeWrapper) Hash() (hash []byte) {
 	}
 	return
 }
+func (self *subTreeWrapper) Err() (err error) {
+	if fallible, ok := self.parentTree.(FallibleHashTree); ok {
+		err = fallible.Err()
+	}
+	return
+}
 func (self *subTreeWrapper) Finger(subKey []Nibble) *Print {
 	return self.parentTree.SubFinger(self.key, subKey)
 }
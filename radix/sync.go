@@ -2,7 +2,7 @@ package radix
 
 import (
 	"bytes"
-	"github.com/zond/god/common"
+	"time"
 )
 
 const (
@@ -32,6 +32,33 @@ type HashTree interface {
 	SubKillTimestamp(key []Nibble, expected int64) (deleted int)
 }
 
+// BatchHashTree is a HashTree that can return the prints of many keys at once, to let a Sync fetch them with fewer round trips.
+type BatchHashTree interface {
+	HashTree
+	Fingers(keys [][]Nibble) []*Print
+}
+
+// FallibleHashTree is a HashTree whose methods can fail, for example because it calls a remote node, and that remembers its first error since the HashTree methods can't return them.
+// A Sync stops at the first error of its source or destination, and never deletes anything from the source after it, since the prints and results it got may be made up.
+type FallibleHashTree interface {
+	HashTree
+	Err() error
+}
+
+// Divergence describes a byte value that differed between the source and destination of a Sync, and was copied to the destination.
+type Divergence struct {
+	Key []byte
+	// SubKey is the key of the value within the sub tree of Key, or nil if the value is the byte value of Key.
+	SubKey []byte
+	// SourceHash and DestinationHash are the murmur hashes of the values, and DestinationHash is nil if the destination had no value.
+	SourceTimestamp      int64
+	SourceHash           []byte
+	DestinationTimestamp int64
+	DestinationHash      []byte
+	// Deleted is whether the copied value was a tombstone.
+	Deleted bool
+}
+
 // Sync synchronizes HashTrees using their fingerprints and mutators.
 type Sync struct {
 	source      HashTree
@@ -39,6 +66,10 @@ type Sync struct {
 	from        []Nibble
 	to          []Nibble
 	destructive bool
+	limiter     *Limiter
+	fanout      int
+	filter      *Bloom
+	diverged    func(Divergence)
 	putCount    int
 	delCount    int
 }
@@ -68,6 +99,31 @@ func (self *Sync) Destroy() *Sync {
 	return self
 }
 
+// Limit defines that this Sync will copy entries no faster than limiter allows.
+func (self *Sync) Limit(limiter *Limiter) *Sync {
+	self.limiter = limiter
+	return self
+}
+
+// Fanout defines that this Sync will fetch the prints of up to n keys at a time from HashTrees that are BatchHashTrees.
+func (self *Sync) Fanout(n int) *Sync {
+	self.fanout = n
+	return self
+}
+
+// Filter defines that this Sync will only visit the keys whose prefixes filter may contain, for example the keys changed since the last Sync.
+// Keys not in filter are left as they are, even if they differ between the trees.
+func (self *Sync) Filter(filter *Bloom) *Sync {
+	self.filter = filter
+	return self
+}
+
+// Diverged defines that this Sync will call f with each byte value it copies to the destination Tree.
+func (self *Sync) Diverged(f func(Divergence)) *Sync {
+	self.diverged = f
+	return self
+}
+
 // PutCount returns the number of entries this Sync has inserted into the destination Tree.
 func (self *Sync) PutCount() int {
 	return self.putCount
@@ -78,6 +134,19 @@ func (self *Sync) DelCount() int {
 	return self.delCount
 }
 
+// Err returns the first error of the source or destination of this Sync, if they are FallibleHashTrees.
+// A Sync with an error stopped early, and may have left the trees unsynchronized.
+func (self *Sync) Err() (err error) {
+	for _, tree := range []HashTree{self.source, self.destination} {
+		if fallible, ok := tree.(FallibleHashTree); ok {
+			if err = fallible.Err(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 // Run will start this Sync and return when it is finished.
 func (self *Sync) Run() *Sync {
 	// If we have from and to, and they are equal, that means this sync is over an empty set... just ignore it
@@ -90,11 +159,98 @@ func (self *Sync) Run() *Sync {
 		if sourceTs > destTs {
 			self.destination.Configure(sourceConf, sourceTs)
 		}
-		self.synchronize(self.source.Finger(nil), self.destination.Finger(nil))
+		if self.Err() == nil {
+			self.walk()
+		}
 	}
 	return self
 }
 
+// Divergence will walk the top depth levels of the trees within the limits of this Sync, without copying anything, and return an estimate of the fraction of their entries that differ.
+// Only the children of differing nodes are visited, and the estimate is the product of the fractions of the visited children that differ on each level.
+// Trees with equal hashes don't differ at all.
+func (self *Sync) Divergence(depth int) (result float64) {
+	if self.from != nil && self.to != nil && nComp(self.from, self.to) == 0 {
+		return 0
+	}
+	if bytes.Compare(self.source.Hash(), self.destination.Hash()) == 0 {
+		return 0
+	}
+	result = 1
+	keys := [][]Nibble{nil}
+	for level := 0; level < depth && len(keys) > 0; level++ {
+		sourcePrints, destinationPrints := fingersOf(self.source, keys), fingersOf(self.destination, keys)
+		if self.Err() != nil {
+			return 0
+		}
+		var differing [][]Nibble
+		visited := 0
+		for index := range keys {
+			for child := 0; child < 1<<(8/parts); child++ {
+				sourceSub, destinationSub := sourcePrints[index].subPrint(child), destinationPrints[index].subPrint(child)
+				key := sourceSub.Key
+				if !sourceSub.Exists {
+					key = destinationSub.Key
+				}
+				if (sourceSub.Exists || destinationSub.Exists) && self.potentiallyWithinLimits(key) {
+					visited++
+					if !sourceSub.Exists || !destinationSub.Exists || !sourceSub.equals(destinationSub) {
+						differing = append(differing, key)
+					}
+				}
+			}
+		}
+		if visited == 0 {
+			break
+		}
+		result *= float64(len(differing)) / float64(visited)
+		keys = differing
+	}
+	return
+}
+
+// walk will synchronize the trees depth first, fetching the prints of up to fanout pending keys at a time.
+func (self *Sync) walk() {
+	batchSize := self.fanout
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	pending := [][]Nibble{nil}
+	for len(pending) > 0 {
+		n := batchSize
+		if n > len(pending) {
+			n = len(pending)
+		}
+		keys := append([][]Nibble{}, pending[len(pending)-n:]...)
+		pending = pending[:len(pending)-n]
+		sourcePrints, destinationPrints := self.fingers(keys)
+		if self.Err() != nil {
+			return
+		}
+		for index := range keys {
+			pending = append(pending, self.synchronize(sourcePrints[index], destinationPrints[index])...)
+		}
+	}
+}
+
+// fingers will return the prints for keys in the source and destination, and let the Limiter observe how long it took per key.
+func (self *Sync) fingers(keys [][]Nibble) (sourcePrints, destinationPrints []*Print) {
+	start := time.Now()
+	sourcePrints, destinationPrints = fingersOf(self.source, keys), fingersOf(self.destination, keys)
+	self.limiter.Observe(time.Now().Sub(start) / time.Duration(len(keys)))
+	return
+}
+func fingersOf(tree HashTree, keys [][]Nibble) (result []*Print) {
+	if batch, ok := tree.(BatchHashTree); ok && len(keys) > 1 {
+		return batch.Fingers(keys)
+	}
+	result = make([]*Print, len(keys))
+	for index, key := range keys {
+		result[index] = tree.Finger(key)
+	}
+	return
+}
+
 // potentiallyWithinLimits will check if the given key can contain children between the from and to Nibbles
 // for this Sync when considering the namespace circular.
 func (self *Sync) potentiallyWithinLimits(key []Nibble) bool {
@@ -111,7 +267,30 @@ func (self *Sync) potentiallyWithinLimits(key []Nibble) bool {
 	if m > len(cmpTo) {
 		m = len(cmpTo)
 	}
-	return common.BetweenII(cmpKey[:m], cmpFrom[:m], cmpTo[:m])
+	return betweenII(cmpKey[:m], cmpFrom[:m], cmpTo[:m])
+}
+
+// betweenII returns whether needle is between fromInc and toInc, both inclusive, wrapping around like common.BetweenII.
+// It is repeated here to keep the radix package free of the networking code in the common package.
+func betweenII(needle, fromInc, toInc []byte) bool {
+	switch bytes.Compare(fromInc, toInc) {
+	case -1:
+		return bytes.Compare(fromInc, needle) < 1 && bytes.Compare(needle, toInc) < 1
+	case 1:
+		return bytes.Compare(fromInc, needle) < 1 || bytes.Compare(needle, toInc) < 1
+	}
+	return true
+}
+
+// betweenIE returns whether needle is between fromInc, inclusive, and toExc, exclusive, wrapping around like common.BetweenIE.
+func betweenIE(needle, fromInc, toExc []byte) bool {
+	switch bytes.Compare(fromInc, toExc) {
+	case -1:
+		return bytes.Compare(fromInc, needle) < 1 && bytes.Compare(needle, toExc) < 0
+	case 1:
+		return bytes.Compare(fromInc, needle) < 1 || bytes.Compare(needle, toExc) < 0
+	}
+	return true
 }
 
 // withinLimits will check i the given key is actually between the from and to Nibbles for this Sync.
@@ -119,11 +298,11 @@ func (self *Sync) withinLimits(key []Nibble) bool {
 	if self.from == nil || self.to == nil {
 		return true
 	}
-	return common.BetweenIE(toBytes(key), toBytes(self.from), toBytes(self.to))
+	return betweenIE(toBytes(key), toBytes(self.from), toBytes(self.to))
 }
 
-// synchronize will recursively run the actual synchronization.
-func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
+// synchronize will synchronize the node of sourcePrint, and return the keys of the children that need to be synchronized.
+func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) (children [][]Nibble) {
 	// If there is a source key
 	if sourcePrint.Exists {
 		// If it represents a node containing synchronizable data, and it is within our limits
@@ -135,6 +314,7 @@ func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 					// If the source is empty, but not the destination, and the source is newer than the destination
 					if sourcePrint.TreeSize == 0 && destinationPrint.TreeSize > 0 && sourcePrint.TreeDataTimestamp > destinationPrint.TreeDataTimestamp {
 						// Clear the destination and count the number of removed keys.
+						self.limiter.Wait(1, 0)
 						self.putCount += self.destination.SubClearTimestamp(sourcePrint.Key, destinationPrint.TreeDataTimestamp, sourcePrint.TreeDataTimestamp)
 					}
 					// Synchronize the sub trees. If the destination is previously cleared, this will copy the configuration.
@@ -148,10 +328,17 @@ func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 					if self.destructive {
 						subSync.Destroy()
 					}
-					subSync.Run()
+					if self.diverged != nil {
+						key := Stitch(sourcePrint.Key)
+						subSync.Diverged(func(d Divergence) {
+							d.Key, d.SubKey = key, d.Key
+							self.diverged(d)
+						})
+					}
+					subSync.Limit(self.limiter).Run()
 					self.putCount += subSync.PutCount()
 					self.delCount += subSync.DelCount()
-				} else if self.destructive {
+				} else if self.destructive && self.Err() == nil {
 					// If the trees are equal, but this Sync is destructive, just remove the source sub tree.
 					self.delCount += self.source.SubKillTimestamp(sourcePrint.Key, sourcePrint.TreeDataTimestamp)
 				}
@@ -163,13 +350,24 @@ func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 					// If the source still contains the same timestamp
 					if value, timestamp, present := self.source.GetTimestamp(sourcePrint.Key); timestamp == sourcePrint.timestamp() {
 						// Put the found data in the destination
+						self.limiter.Wait(1, len(value))
 						if self.destination.PutTimestamp(sourcePrint.Key, value, present, destinationPrint.timestamp(), sourcePrint.timestamp()) {
 							self.putCount++
+							if self.diverged != nil {
+								self.diverged(Divergence{
+									Key:                  Stitch(sourcePrint.Key),
+									SourceTimestamp:      sourcePrint.timestamp(),
+									SourceHash:           sourcePrint.ByteHash,
+									DestinationTimestamp: destinationPrint.timestamp(),
+									DestinationHash:      destinationPrint.byteHash(),
+									Deleted:              !present,
+								})
+							}
 						}
 					}
 				}
 				// If we are destructive and contain something
-				if self.destructive && !sourcePrint.Empty {
+				if self.destructive && !sourcePrint.Empty && self.Err() == nil {
 					// Remove the byte value in the source.
 					if self.source.DelTimestamp(sourcePrint.Key, sourcePrint.timestamp()) {
 						self.delCount++
@@ -177,19 +375,18 @@ func (self *Sync) synchronize(sourcePrint, destinationPrint *Print) {
 				}
 			}
 		}
-		// For each child of the source print
-		for index, subPrint := range sourcePrint.SubPrints {
+		// For each child of the source print, last first to make the walk visit them in order
+		for index := len(sourcePrint.SubPrints) - 1; index >= 0; index-- {
+			subPrint := sourcePrint.SubPrints[index]
 			// If there is a child node there, and it might contain matching keys
-			if subPrint.Exists && self.potentiallyWithinLimits(subPrint.Key) {
+			if subPrint.Exists && self.potentiallyWithinLimits(subPrint.Key) && (self.filter == nil || self.filter.MayContain(subPrint.Key)) {
 				// If we are destructive, or if the prints are dissimilar
 				if self.destructive || (!destinationPrint.Exists || !subPrint.equals(destinationPrint.SubPrints[index])) {
 					// Synchronize the children
-					self.synchronize(
-						self.source.Finger(subPrint.Key),
-						self.destination.Finger(subPrint.Key),
-					)
+					children = append(children, subPrint.Key)
 				}
 			}
 		}
 	}
+	return
 }
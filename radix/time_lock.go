@@ -1,4 +1,4 @@
-package common
+package radix
 
 import (
 	"sync"
@@ -10,40 +10,42 @@ const (
 	logsize = 16
 )
 
-type TimeLock struct {
+// timeLock is a sync.RWMutex that remembers for how long it was write locked the last logsize times, to tell how busy its Tree is.
+type timeLock struct {
 	lockdurations [logsize]int64
 	locktimes     [logsize]int64
 	lock          *sync.RWMutex
 	index         int
 }
 
-func NewTimeLock() *TimeLock {
-	return &TimeLock{
+func newTimeLock() *timeLock {
+	return &timeLock{
 		lock: new(sync.RWMutex),
 	}
 }
 
-func (self *TimeLock) Lock() {
+func (self *timeLock) Lock() {
 	self.lock.Lock()
 	atomic.StoreInt64(&self.locktimes[self.index], time.Now().UnixNano())
 	atomic.StoreInt64(&self.lockdurations[self.index], -self.locktimes[self.index])
 }
 
-func (self *TimeLock) Unlock() {
+func (self *timeLock) Unlock() {
 	atomic.AddInt64(&self.lockdurations[self.index], time.Now().UnixNano())
 	self.index = (self.index + 1) % logsize
 	self.lock.Unlock()
 }
 
-func (self *TimeLock) RLock() {
+func (self *timeLock) RLock() {
 	self.lock.RLock()
 }
 
-func (self *TimeLock) RUnlock() {
+func (self *timeLock) RUnlock() {
 	self.lock.RUnlock()
 }
 
-func (self *TimeLock) Load() float64 {
+// Load returns the fraction of the time since the oldest of the remembered write locks that this timeLock was write locked.
+func (self *timeLock) Load() float64 {
 	var sum int64
 	var first int64
 	var tmp int64
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
-	"github.com/zond/god/common"
 	"github.com/zond/god/murmur"
 	"github.com/zond/god/persistence"
 	"math/big"
 	"sync/atomic"
+	"time"
 )
 
 // NaiveTimer is a Timer that just provides the current system time.
@@ -24,6 +24,10 @@ func (self NaiveTimer) ContinuousTime() int64 {
 // If they return false, the iteration will end.
 type TreeIterator func(key, value []byte, timestamp int64) (cont bool)
 
+// EntryIterators iterate over trees, and see the keys having byte values, sub trees or both, with the byte value or nil, its timestamp, and the sub tree or nil.
+// If they return false, the iteration will end.
+type EntryIterator func(key, value []byte, timestamp int64, sub *Tree) (cont bool)
+
 // TreeIndexIterators iterate over trees, and see the key, value, timestamp and index of what they iterate over.
 // If they return false, the iteration will end.
 type TreeIndexIterator func(key, value []byte, timestamp int64, index int) (cont bool)
@@ -98,7 +102,7 @@ func newNodeIndexIterator(f TreeIndexIterator) nodeIndexIterator {
 //
 // A Tree is configured to be mirrored or not by using AddConfiguration or SubAddConfiguration (for a sub tree) setting 'mirrored' to 'yes'.
 type Tree struct {
-	lock                   *common.TimeLock
+	lock                   *timeLock
 	timer                  Timer
 	logger                 *persistence.Logger
 	root                   *node
@@ -106,6 +110,9 @@ type Tree struct {
 	configuration          map[string]string
 	configurationTimestamp int64
 	dataTimestamp          int64
+	verify                 bool
+	report                 persistence.Report
+	writeListener          WriteListener
 }
 
 func NewTree() *Tree {
@@ -113,7 +120,7 @@ func NewTree() *Tree {
 }
 func NewTreeTimer(timer Timer) (result *Tree) {
 	result = &Tree{
-		lock:          common.NewTimeLock(),
+		lock:          newTimeLock(),
 		timer:         timer,
 		configuration: make(map[string]string),
 	}
@@ -121,9 +128,11 @@ func NewTreeTimer(timer Timer) (result *Tree) {
 	result.dataTimestamp = timer.ContinuousTime()
 	return
 }
+// Load returns the fraction of the recent time this Tree was locked for writing.
 func (self *Tree) Load() float64 {
 	return self.lock.Load()
 }
+
 func (self *Tree) deepEqual(o *Tree) bool {
 	return self.Describe() == o.Describe()
 }
@@ -147,31 +156,28 @@ func (self *Tree) mirrorClear(timestamp int64) {
 		self.mirror.Clear(timestamp)
 	}
 }
+
+// mirrorKey returns the key of the entry for key and value in the mirror Tree.
+func mirrorKey(key, value []byte) (result []byte) {
+	escapedKey := escapeBytes(key)
+	result = make([]byte, len(escapedKey)+len(value)+1)
+	copy(result, value)
+	copy(result[len(value)+1:], escapedKey)
+	return
+}
 func (self *Tree) mirrorPut(key, value []byte, timestamp int64) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.Put(newKey, key, timestamp)
+		self.mirror.Put(mirrorKey(key, value), key, timestamp)
 	}
 }
 func (self *Tree) mirrorFakeDel(key, value []byte, timestamp int64) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.FakeDel(newKey, timestamp)
+		self.mirror.FakeDel(mirrorKey(key, value), timestamp)
 	}
 }
 func (self *Tree) mirrorDel(key, value []byte) {
 	if self.mirror != nil {
-		escapedKey := escapeBytes(key)
-		newKey := make([]byte, len(escapedKey)+len(value)+1)
-		copy(newKey, value)
-		copy(newKey[len(value)+1:], escapedKey)
-		self.mirror.Del(newKey)
+		self.mirror.Del(mirrorKey(key, value))
 	}
 }
 func (self *Tree) startMirroring() {
@@ -224,11 +230,19 @@ func (self *Tree) Log(dir string) *Tree {
 	return self
 }
 
+// CompressLog will make the Logger of this Tree compress values of at least threshold bytes. Zero disables compression.
+func (self *Tree) CompressLog(threshold int) *Tree {
+	if self.logger != nil {
+		self.logger.Compress(threshold)
+	}
+	return self
+}
+
 // Restore will temporarily stop the Logger of this Tree, make it replay all operations
 // to allow us to restore the state logged in that directory, and then start recording again.
 func (self *Tree) Restore() *Tree {
 	self.logger.Stop()
-	self.logger.Play(func(op persistence.Op) {
+	self.report = self.logger.PlayReport(func(op persistence.Op) {
 		if op.Configuration != nil {
 			if op.Key == nil {
 				self.Configure(op.Configuration, op.Timestamp)
@@ -257,9 +271,55 @@ func (self *Tree) Restore() *Tree {
 			}
 		}
 	})
+	if stored := self.logger.TakeHash(); stored != nil && self.verify {
+		self.report.StoredHash = stored
+		self.report.Hash = self.ContentHash()
+	}
 	<-self.logger.Record()
 	return self
 }
+
+// Verify will make the next Restore compare the hash of the restored data with the hash saved by the last Seal.
+func (self *Tree) Verify() *Tree {
+	self.verify = true
+	return self
+}
+
+// RestoreReport returns the report from the last Restore of this Tree.
+func (self *Tree) RestoreReport() persistence.Report {
+	return self.report
+}
+
+// Seal will save the hash of the current data in the Logger of this Tree, to be verified by the next Restore.
+func (self *Tree) Seal() {
+	if self.logger != nil {
+		self.logger.SaveHash(self.ContentHash())
+	}
+}
+
+// ContentHash returns a hash of the byte values and sub tree values in this Tree, ignoring tombstones.
+func (self *Tree) ContentHash() []byte {
+	if self == nil {
+		return nil
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	hash := murmur.New()
+	self.root.each(nil, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		hash.MustWrite(key)
+		if use&byteValue != 0 {
+			hash.MustWrite(bValue)
+			if use&treeValue == 0 {
+				hash.MustWrite(murmur.HashInt64(timestamp))
+			}
+		}
+		if use&treeValue != 0 {
+			hash.MustWrite(tValue.ContentHash())
+		}
+		return true
+	})
+	return hash.Get()
+}
 func (self *Tree) log(op persistence.Op) {
 	if self.logger != nil && self.logger.Recording() {
 		self.logger.Dump(op)
@@ -314,6 +374,50 @@ func (self *Tree) EachBetween(min, max []byte, mininc, maxinc bool, f TreeIterat
 	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue, newNodeIterator(f))
 }
 
+// EachSizeBetween will call f with each key between min and max having a byte value or a sub tree, and the number of bytes of the key and value, or of the keys and values in the sub tree.
+func (self *Tree) EachSizeBetween(min, max []byte, mininc, maxinc bool, f func(key []byte, bytes int) (cont bool)) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		bytes := 0
+		if use&byteValue != 0 {
+			bytes += len(key) + len(bValue)
+		}
+		if use&treeValue != 0 && tValue != nil {
+			tValue.lock.RLock()
+			tValue.root.each(nil, byteValue, func(subKey, subValue []byte, subTree *Tree, subUse int, subTimestamp int64) bool {
+				bytes += len(subKey) + len(subValue)
+				return true
+			})
+			tValue.lock.RUnlock()
+		}
+		return f(key, bytes)
+	})
+}
+
+// EachEntryBetween will iterate between min and max over the keys having byte values, sub trees or both, using f.
+func (self *Tree) EachEntryBetween(min, max []byte, mininc, maxinc bool, f EntryIterator) {
+	if self == nil {
+		return
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	mincmp, maxcmp := cmps(mininc, maxinc)
+	self.root.eachBetween(nil, Rip(min), Rip(max), mincmp, maxcmp, byteValue|treeValue, func(key, bValue []byte, tValue *Tree, use int, timestamp int64) bool {
+		if use&byteValue == 0 {
+			bValue = nil
+		}
+		if use&treeValue == 0 {
+			tValue = nil
+		}
+		return f(key, bValue, timestamp, tValue)
+	})
+}
+
 // MirrorReverseEachBetween will iterate between min and max in the mirror Tree, in reverse order, using f.
 func (self *Tree) MirrorReverseEachBetween(min, max []byte, mininc, maxinc bool, f TreeIterator) {
 	if self == nil || self.mirror == nil {
@@ -370,6 +474,18 @@ func (self *Tree) IndexOf(key []byte) (index int, existed bool) {
 	return
 }
 
+// Rank will return the number of entries in the mirror Tree after the one for key, that is the number of keys with greater values, or equal values and greater keys.
+func (self *Tree) Rank(key []byte) (rank int, existed bool) {
+	if self == nil || self.mirror == nil {
+		return
+	}
+	var value []byte
+	if value, _, existed = self.Get(key); existed {
+		rank, _ = self.mirror.ReverseIndexOf(mirrorKey(key, value))
+	}
+	return
+}
+
 // MirrorReverseIndexOf will return the index from the end (or the index it would have if it existed) key in the mirror Tree.
 func (self *Tree) MirrorReverseIndexOf(key []byte) (index int, existed bool) {
 	if self == nil || self.mirror == nil {
@@ -567,6 +683,8 @@ func (self *Tree) Describe() string {
 
 // FakeDel will insert a tombstone at key with timestamp in this Tree.
 func (self *Tree) FakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree *Tree, existed bool) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	var ex int
@@ -577,6 +695,7 @@ func (self *Tree) FakeDel(key []byte, timestamp int64) (oldBytes []byte, oldTree
 		self.log(persistence.Op{
 			Key: key,
 		})
+		write = Write{Operation: "Del", Key: key, OldValue: oldBytes, Timestamp: timestamp}
 	}
 	return
 }
@@ -588,7 +707,17 @@ func (self *Tree) put(key []Nibble, byteValue []byte, treeValue *Tree, use int,
 
 // Put will put key and value with timestamp in this Tree.
 func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	oldBytes, existed, _ = self.TimedPut(key, bValue, timestamp)
+	return
+}
+
+// TimedPut will put like Put, and also return how long it waited for the lock of this Tree.
+func (self *Tree) TimedPut(key []byte, bValue []byte, timestamp int64) (oldBytes []byte, existed bool, wait time.Duration) {
+	var write Write
+	defer self.notify(&write)
+	start := time.Now()
 	self.lock.Lock()
+	wait = time.Now().Sub(start)
 	defer self.lock.Unlock()
 	oldBytes, _, ex := self.put(Rip(key), bValue, nil, byteValue, timestamp)
 	existed = ex*byteValue != 0
@@ -602,12 +731,21 @@ func (self *Tree) Put(key []byte, bValue []byte, timestamp int64) (oldBytes []by
 		Timestamp: timestamp,
 		Put:       true,
 	})
+	write = Write{Operation: "Put", Key: key, OldValue: oldBytes, NewValue: bValue, Timestamp: timestamp}
 	return
 }
 
 // Get will return the value and timestamp at key.
 func (self *Tree) Get(key []byte) (bValue []byte, timestamp int64, existed bool) {
+	bValue, timestamp, existed, _ = self.TimedGet(key)
+	return
+}
+
+// TimedGet will get like Get, and also return how long it waited for the lock of this Tree.
+func (self *Tree) TimedGet(key []byte) (bValue []byte, timestamp int64, existed bool, wait time.Duration) {
+	start := time.Now()
 	self.lock.RLock()
+	wait = time.Now().Sub(start)
 	defer self.lock.RUnlock()
 	bValue, _, timestamp, ex := self.root.get(Rip(key))
 	existed = ex&byteValue != 0
@@ -869,6 +1007,14 @@ func (self *Tree) SubMirrorReverseIndexOf(key, subKey []byte) (index int, existe
 	}
 	return
 }
+func (self *Tree) SubRank(key, subKey []byte) (rank int, existed bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if _, subTree, _, ex := self.root.get(Rip(key)); ex&treeValue != 0 && subTree != nil {
+		rank, existed = subTree.Rank(subKey)
+	}
+	return
+}
 func (self *Tree) SubMirrorIndexOf(key, subKey []byte) (index int, existed bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
@@ -1078,6 +1224,8 @@ func (self *Tree) SubEachBetweenIndex(key []byte, min, max *int, f TreeIndexIter
 	}
 }
 func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	ripped := Rip(key)
@@ -1095,6 +1243,7 @@ func (self *Tree) SubPut(key, subKey []byte, byteValue []byte, timestamp int64)
 		Timestamp: timestamp,
 		Put:       true,
 	})
+	write = Write{Operation: "SubPut", Key: key, SubKey: subKey, OldValue: oldBytes, NewValue: byteValue, Timestamp: timestamp}
 	return
 }
 func (self *Tree) SubDel(key, subKey []byte) (oldBytes []byte, existed bool) {
@@ -1118,6 +1267,8 @@ func (self *Tree) SubDel(key, subKey []byte) (oldBytes []byte, existed bool) {
 	return
 }
 func (self *Tree) SubFakeDel(key, subKey []byte, timestamp int64) (oldBytes []byte, existed bool) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	ripped := Rip(key)
@@ -1130,12 +1281,15 @@ func (self *Tree) SubFakeDel(key, subKey []byte, timestamp int64) (oldBytes []by
 			Key:    key,
 			SubKey: subKey,
 		})
+		write = Write{Operation: "SubDel", Key: key, SubKey: subKey, OldValue: oldBytes, Timestamp: timestamp}
 	}
 	return
 }
 
 // SubClear does Clear on the sub tree.
 func (self *Tree) SubClear(key []byte, timestamp int64) (deleted int) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	ripped := Rip(key)
@@ -1150,6 +1304,7 @@ func (self *Tree) SubClear(key []byte, timestamp int64) (deleted int) {
 			Clear:     true,
 			Timestamp: timestamp,
 		})
+		write = Write{Operation: "SubClear", Key: key, Timestamp: timestamp}
 	}
 	return
 }
@@ -1176,6 +1331,17 @@ func (self *Tree) Finger(key []Nibble) *Print {
 	defer self.lock.RUnlock()
 	return self.root.finger(&Print{}, key)
 }
+
+// Fingers returns the prints of all keys, like Finger.
+func (self *Tree) Fingers(keys [][]Nibble) (result []*Print) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	result = make([]*Print, len(keys))
+	for index, key := range keys {
+		result[index] = self.root.finger(&Print{}, key)
+	}
+	return
+}
 func (self *Tree) GetTimestamp(key []Nibble) (bValue []byte, timestamp int64, present bool) {
 	self.lock.RLock()
 	defer self.lock.RUnlock()
@@ -1191,6 +1357,8 @@ func (self *Tree) putTimestamp(key []Nibble, bValue []byte, treeValue *Tree, nod
 	return
 }
 func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expected, timestamp int64) (result bool) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	nodeUse := 0
@@ -1209,6 +1377,10 @@ func (self *Tree) PutTimestamp(key []Nibble, bValue []byte, present bool, expect
 			Timestamp: timestamp,
 			Put:       true,
 		})
+		write = Write{Operation: "Put", Key: stitched, OldValue: oldBytes, NewValue: bValue, Timestamp: timestamp}
+		if !present {
+			write.Operation, write.NewValue = "Del", nil
+		}
 	}
 	return
 }
@@ -1295,13 +1467,19 @@ func (self *Tree) SubGetTimestamp(key, subKey []Nibble) (byteValue []byte, times
 	return
 }
 func (self *Tree) SubPutTimestamp(key, subKey []Nibble, bValue []byte, present bool, subExpected, subTimestamp int64) (result bool) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	_, subTree, subTreeTimestamp, _ := self.root.get(key)
+	var oldBytes []byte
 	if subTree == nil {
 		result = true
 		subTree = self.newTreeWith(subKey, bValue, subTimestamp)
 	} else {
+		if self.writeListener != nil {
+			oldBytes, _, _ = subTree.GetTimestamp(subKey)
+		}
 		result = subTree.PutTimestamp(subKey, bValue, present, subExpected, subTimestamp)
 	}
 	self.putTimestamp(key, nil, subTree, treeValue, treeValue, subTreeTimestamp, subTreeTimestamp)
@@ -1313,6 +1491,10 @@ func (self *Tree) SubPutTimestamp(key, subKey []Nibble, bValue []byte, present b
 			Timestamp: subTimestamp,
 			Put:       true,
 		})
+		write = Write{Operation: "SubPut", Key: Stitch(key), SubKey: Stitch(subKey), OldValue: oldBytes, NewValue: bValue, Timestamp: subTimestamp}
+		if !present {
+			write.Operation, write.NewValue = "SubDel", nil
+		}
 	}
 	return
 }
@@ -1336,6 +1518,8 @@ func (self *Tree) SubDelTimestamp(key, subKey []Nibble, subExpected int64) (resu
 	return
 }
 func (self *Tree) SubClearTimestamp(key []Nibble, expected, timestamp int64) (deleted int) {
+	var write Write
+	defer self.notify(&write)
 	self.lock.Lock()
 	defer self.lock.Unlock()
 	if _, subTree, subTreeTimestamp, ex := self.root.get(key); ex&treeValue != 0 && subTree != nil && subTree.DataTimestamp() == expected {
@@ -1349,6 +1533,7 @@ func (self *Tree) SubClearTimestamp(key []Nibble, expected, timestamp int64) (de
 			Clear:     true,
 			Timestamp: timestamp,
 		})
+		write = Write{Operation: "SubClear", Key: Stitch(key), Timestamp: timestamp}
 	}
 	return
 }
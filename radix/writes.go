@@ -0,0 +1,32 @@
+package radix
+
+// Write describes a value or tombstone put in a Tree, or a sub tree of it.
+type Write struct {
+	// Operation is one of Put, Del, SubPut, SubDel and SubClear.
+	Operation string
+	Key       []byte
+	SubKey    []byte
+	// OldValue is the value replaced by the write, if any.
+	OldValue []byte
+	// NewValue is the value put by the write, or nil for deletes.
+	NewValue  []byte
+	Timestamp int64
+}
+
+// WriteListener is a function listening to the writes to a Tree.
+type WriteListener func(write Write)
+
+// SetWriteListener will make this Tree call l with each value or tombstone put in it, including the ones put when synchronizing with other trees.
+// Removing data without leaving a tombstone, like when cleaning out data that has been moved elsewhere, is not reported.
+// l is called after the Tree is unlocked, so it may use the Tree. Must be called before the Tree is used concurrently.
+func (self *Tree) SetWriteListener(l WriteListener) *Tree {
+	self.writeListener = l
+	return self
+}
+
+// notify will call the WriteListener of this Tree with write, unless write is empty.
+func (self *Tree) notify(write *Write) {
+	if write.Operation != "" && self.writeListener != nil {
+		self.writeListener(*write)
+	}
+}
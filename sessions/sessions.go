@@ -0,0 +1,414 @@
+// Package sessions implements an expiring session store on top of a god database.
+//
+// Each Store keeps its sessions as members of one sub tree, defined by its namespace, so stores with different namespaces never see each others sessions.
+// The sub tree is given a retention policy with the time to live of the Store, making the owner of the sub tree remove sessions that haven't been refreshed within it.
+// Since the owner only removes them every clean interval, the expiry time is also stored with each session and checked on every lookup.
+// Expiry times are in the time of the cluster, so clients with skewed clocks agree on when sessions expire.
+//
+// Refresh, Update and Destroy hold a cluster wide lock on the session while they change it, so a session destroyed by one client is never brought back by
+// a concurrent refresh from another.
+package sessions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/radix"
+)
+
+// Prefix is prepended to the namespace of a Store to get the key of the sub tree containing its sessions.
+const Prefix = "sessions/"
+
+const (
+	// Created is the type of the Event generated when a session is created.
+	Created = "Created"
+	// Refreshed is the type of the Event generated when a session is refreshed or updated.
+	Refreshed = "Refreshed"
+	// Destroyed is the type of the Event generated when a session is destroyed before it expired.
+	Destroyed = "Destroyed"
+	// Expired is the type of the Event generated when an expired session is removed.
+	Expired = "Expired"
+)
+
+const (
+	// lockTTL is for how long the lock on a session is held at most, if the client holding it dies before releasing it.
+	lockTTL = time.Second * 10
+	// lockTimeout is for how long Refresh, Update and Destroy wait for the lock on a session before giving up.
+	lockTimeout          = time.Second * 10
+	lockBackoff          = time.Millisecond * 10
+	defaultWatchInterval = time.Second
+	// reportedMemory is for how long a watch remembers that it reported the expiry of a session, to not report it again when found another way.
+	reportedMemory = time.Minute
+)
+
+// Event describes a change to a session.
+type Event struct {
+	Type string
+	ID   string
+	Data []byte
+}
+
+// Listener is a function listening to the changes to the sessions of a Store.
+type Listener func(event Event) (keep bool)
+
+// Store creates, refreshes, looks up and destroys the sessions of one namespace.
+type Store struct {
+	conn          *client.Conn
+	key           []byte
+	ttl           time.Duration
+	watchInterval int64
+	lock          *sync.Mutex
+	watcher       client.Watcher
+	watch         *watch
+}
+
+// NewStore returns a Store keeping sessions in namespace using conn, where sessions expire when not refreshed within ttl.
+// It will configure the retention policy of the sub tree for namespace, so all stores for the same namespace should use the same ttl.
+func NewStore(conn *client.Conn, namespace string, ttl time.Duration) (result *Store) {
+	result = &Store{
+		conn:          conn,
+		key:           []byte(Prefix + namespace),
+		ttl:           ttl,
+		watchInterval: int64(defaultWatchInterval),
+		lock:          new(sync.Mutex),
+	}
+	conn.SetRetention(result.key, 0, ttl)
+	return
+}
+
+// SetWatcher will make the watches of this Store get the changes to its sessions from the writes reported by w, instead of from the owner of the sessions.
+// w must keep the sessions, like the dhash.Local or fake.Fake conn talks to, or the dhash.Node owning them in a single node cluster.
+// It only affects watches started after it is set.
+func (self *Store) SetWatcher(w client.Watcher) *Store {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.watcher = w
+	return self
+}
+
+// SetWatchInterval will set how often the watches of this Store without a Watcher look for created, refreshed and destroyed sessions.
+func (self *Store) SetWatchInterval(d time.Duration) *Store {
+	atomic.StoreInt64(&self.watchInterval, int64(d))
+	return self
+}
+
+// WatchInterval returns how often the watches of this Store without a Watcher look for created, refreshed and destroyed sessions.
+func (self *Store) WatchInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&self.watchInterval))
+}
+
+func encode(expires time.Time, data []byte) (result []byte) {
+	result = make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(result, uint64(expires.UnixNano()))
+	copy(result[8:], data)
+	return
+}
+
+func decode(value []byte) (expires time.Time, data []byte, ok bool) {
+	if len(value) < 8 {
+		return
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), value[8:], true
+}
+
+// Create will store data in a new session, and return the random id of the session.
+func (self *Store) Create(data []byte) (id string, err error) {
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	id = hex.EncodeToString(buf)
+	if err = self.conn.TrySSubPut(self.key, []byte(id), encode(self.conn.Time().Add(self.ttl), data)); err != nil {
+		id = ""
+	}
+	return
+}
+
+// Lookup will return the data of the session with id, unless it doesn't exist or has expired.
+func (self *Store) Lookup(id string) (data []byte, existed bool) {
+	value, existed := self.conn.SubGet(self.key, []byte(id))
+	if !existed {
+		return
+	}
+	expires, data, existed := decode(value)
+	if existed && self.conn.Time().After(expires) {
+		data, existed = nil, false
+	}
+	return
+}
+
+// withLock will run f while holding the cluster wide lock on the session with id.
+func (self *Store) withLock(id string, f func() error) (err error) {
+	lockKey := []byte(string(self.key) + "/" + id)
+	deadline := time.Now().Add(lockTimeout)
+	token, acquired := self.conn.Lock(lockKey, lockTTL)
+	for !acquired {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Unable to lock session %v within %v", id, lockTimeout)
+		}
+		time.Sleep(lockBackoff)
+		token, acquired = self.conn.Lock(lockKey, lockTTL)
+	}
+	defer self.conn.Unlock(lockKey, token)
+	return f()
+}
+
+// Refresh will make the session with id expire ttl from now, and return whether it existed and hadn't expired.
+func (self *Store) Refresh(id string) (existed bool, err error) {
+	err = self.withLock(id, func() (err error) {
+		var data []byte
+		if data, existed = self.Lookup(id); existed {
+			err = self.conn.TrySSubPut(self.key, []byte(id), encode(self.conn.Time().Add(self.ttl), data))
+		}
+		return
+	})
+	return
+}
+
+// Update will replace the data of the session with id and make it expire ttl from now, and return whether it existed and hadn't expired.
+func (self *Store) Update(id string, data []byte) (existed bool, err error) {
+	err = self.withLock(id, func() (err error) {
+		if _, existed = self.Lookup(id); existed {
+			err = self.conn.TrySSubPut(self.key, []byte(id), encode(self.conn.Time().Add(self.ttl), data))
+		}
+		return
+	})
+	return
+}
+
+// Destroy will remove the session with id.
+func (self *Store) Destroy(id string) error {
+	return self.withLock(id, func() error {
+		return self.conn.TrySSubDel(self.key, []byte(id))
+	})
+}
+
+// Watch will make l get notified of the changes to the sessions of this Store, one at a time, until l returns false.
+//
+// With a Watcher, every write to the sessions is reported as it happens.
+// Otherwise the expiry of sessions is reported as the owner of the sessions removes them, using client.Conn.SubscribeExpiries, and the
+// other changes are found by comparing the sessions with the ones seen last every watch interval, so several changes to the same session within
+// one interval are reported as one Event, and a session created and destroyed within one interval is not reported.
+//
+// All watches of a Store share one Watcher listener, expiry subscription or comparison, whichever is used.
+func (self *Store) Watch(l Listener) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.watch != nil && self.watch.add(l) {
+		return
+	}
+	self.watch = newWatch(self, l)
+	if self.watcher != nil {
+		self.watcher.AddWriteListener(self.watch.write)
+	} else {
+		self.watch.seen = self.sessions()
+		self.conn.SubscribeExpiries(self.watch.expired)
+		go self.watch.compare()
+	}
+	go self.watch.deliver()
+}
+
+// sessions returns the values of all sessions of this Store, by id.
+func (self *Store) sessions() (result map[string]common.Item) {
+	result = make(map[string]common.Item)
+	for _, item := range self.conn.Slice(self.key, nil, nil, true, true) {
+		result[string(item.Key)] = item
+	}
+	return
+}
+
+// watch delivers the changes to the sessions of a Store, found by one Watcher listener or by one expiry subscription and comparison, to its listeners.
+type watch struct {
+	store     *Store
+	lock      *sync.Mutex
+	listeners []*Listener
+	queue     []Event
+	queued    chan struct{}
+	stopped   bool
+	// seen contains the sessions found by the last comparison.
+	seen map[string]common.Item
+	// reported contains when the expiry of each session was reported by one of the expiry subscription and the comparison, so that the other doesn't report it again.
+	reported map[string]time.Time
+}
+
+func newWatch(store *Store, l Listener) *watch {
+	return &watch{
+		store:     store,
+		lock:      new(sync.Mutex),
+		listeners: []*Listener{&l},
+		queued:    make(chan struct{}, 1),
+		reported:  make(map[string]time.Time),
+	}
+}
+
+// add will add l to the listeners of this watch, unless it has stopped.
+func (self *watch) add(l Listener) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		return false
+	}
+	self.listeners = append(self.listeners, &l)
+	return true
+}
+func (self *watch) isStopped() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stopped
+}
+
+// push will queue events for delivery, and return whether this watch is still running.
+func (self *watch) push(events ...Event) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		return false
+	}
+	if len(events) > 0 {
+		self.queue = append(self.queue, events...)
+		select {
+		case self.queued <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// deliver will deliver the queued events to the listeners, outside the writes and subscriptions that found them, until no listener is left.
+func (self *watch) deliver() {
+	for _ = range self.queued {
+		self.lock.Lock()
+		queue, listeners := self.queue, self.listeners
+		self.queue = nil
+		self.lock.Unlock()
+		removed := make(map[*Listener]bool)
+		for _, event := range queue {
+			for _, l := range listeners {
+				if !removed[l] && !(*l)(event) {
+					removed[l] = true
+				}
+			}
+		}
+		// Only remove the listeners that returned false, since others may have been added while we called them.
+		self.lock.Lock()
+		newListeners := make([]*Listener, 0, len(self.listeners))
+		for _, l := range self.listeners {
+			if !removed[l] {
+				newListeners = append(newListeners, l)
+			}
+		}
+		self.listeners = newListeners
+		self.stopped = len(self.listeners) == 0
+		stopped := self.stopped
+		self.lock.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+// write will find the change to a session made by write, if any.
+func (self *watch) write(write radix.Write) bool {
+	if bytes.Compare(write.Key, self.store.key) != 0 || write.SubKey == nil {
+		return self.push()
+	}
+	event := Event{ID: string(write.SubKey)}
+	if write.NewValue != nil {
+		if _, event.Data, _ = decode(write.NewValue); write.OldValue == nil {
+			event.Type = Created
+		} else {
+			event.Type = Refreshed
+		}
+	} else if expires, data, ok := decode(write.OldValue); ok {
+		event.Type, event.Data = Destroyed, data
+		if self.store.conn.Time().After(expires) {
+			event.Type = Expired
+		}
+	} else {
+		return self.push()
+	}
+	return self.push(event)
+}
+
+// expired will report the expiry of a session removed by the owner of the sessions, unless the comparison already has.
+func (self *watch) expired(event common.Event) bool {
+	if event.Type != common.EventExpired || bytes.Compare(event.Key, self.store.key) != 0 {
+		return !self.isStopped()
+	}
+	id := string(event.SubKey)
+	self.lock.Lock()
+	_, found := self.reported[id]
+	if found {
+		delete(self.reported, id)
+	} else {
+		self.reported[id] = time.Now()
+	}
+	_, data, _ := decode(self.seen[id].Value)
+	self.lock.Unlock()
+	if found {
+		return self.push()
+	}
+	return self.push(Event{Type: Expired, ID: id, Data: data})
+}
+
+// compare will find the created, refreshed and destroyed sessions every watch interval, and the expired sessions the expiry subscription hasn't reported.
+func (self *watch) compare() {
+	for {
+		time.Sleep(self.store.WatchInterval())
+		if self.isStopped() {
+			return
+		}
+		current := self.store.sessions()
+		now := self.store.conn.Time()
+		var events []Event
+		self.lock.Lock()
+		for id, item := range current {
+			event := Event{ID: id}
+			if _, event.Data, _ = decode(item.Value); self.seen[id].Key == nil {
+				event.Type = Created
+			} else if self.seen[id].Timestamp != item.Timestamp || bytes.Compare(self.seen[id].Value, item.Value) != 0 {
+				event.Type = Refreshed
+			} else {
+				continue
+			}
+			events = append(events, event)
+		}
+		for id, item := range self.seen {
+			if _, found := current[id]; found {
+				continue
+			}
+			expires, data, ok := decode(item.Value)
+			if !ok {
+				continue
+			}
+			event := Event{ID: id, Data: data, Type: Destroyed}
+			if now.After(expires) {
+				if _, found := self.reported[id]; found {
+					delete(self.reported, id)
+					continue
+				}
+				self.reported[id] = time.Now()
+				event.Type = Expired
+			}
+			events = append(events, event)
+		}
+		for id, at := range self.reported {
+			if time.Now().Sub(at) > reportedMemory {
+				delete(self.reported, id)
+			}
+		}
+		self.seen = current
+		self.lock.Unlock()
+		if !self.push(events...) {
+			return
+		}
+	}
+}
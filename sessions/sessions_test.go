@@ -0,0 +1,162 @@
+package sessions
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zond/god/client"
+	"github.com/zond/god/common"
+	"github.com/zond/god/dhash"
+)
+
+func TestSessions(t *testing.T) {
+	node := dhash.NewEmbeddedNode("sessions", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "sessions")
+	store := NewStore(conn, "web", time.Second).SetWatchInterval(time.Millisecond * 5)
+	other := NewStore(conn, "api", time.Hour)
+	lock := new(sync.Mutex)
+	var events []Event
+	stopped := false
+	store.Watch(func(event Event) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+		return !stopped
+	})
+	awaitEvents := func(n int) {
+		common.AssertWithin(t, func() (string, bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			return fmt.Sprint(events), len(events) == n
+		}, time.Second)
+	}
+	id, err := store.Create([]byte("alice"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	awaitEvents(1)
+	if data, existed := store.Lookup(id); !existed || string(data) != "alice" {
+		t.Errorf("wanted alice, got %s, %v", data, existed)
+	}
+	if _, existed := other.Lookup(id); existed {
+		t.Errorf("sessions should not be visible in other namespaces")
+	}
+	if existed, err := store.Update(id, []byte("bob")); err != nil || !existed {
+		t.Errorf("wanted %v to be updated, got %v, %v", id, existed, err)
+	}
+	awaitEvents(2)
+	time.Sleep(time.Millisecond * 600)
+	if existed, err := store.Refresh(id); err != nil || !existed {
+		t.Errorf("wanted %v to be refreshed, got %v, %v", id, existed, err)
+	}
+	awaitEvents(3)
+	time.Sleep(time.Millisecond * 600)
+	if data, existed := store.Lookup(id); !existed || string(data) != "bob" {
+		t.Errorf("wanted the refreshed session to contain bob, got %s, %v", data, existed)
+	}
+	time.Sleep(time.Millisecond * 1200)
+	if _, existed := store.Lookup(id); existed {
+		t.Errorf("wanted %v to have expired", id)
+	}
+	if existed, err := store.Refresh(id); err != nil || existed {
+		t.Errorf("expired sessions should not be refreshed, got %v, %v", existed, err)
+	}
+	if _, err := conn.Trim(); err != nil {
+		t.Errorf("%v", err)
+	}
+	awaitEvents(4)
+	id2, _ := store.Create([]byte("carol"))
+	awaitEvents(5)
+	if err := store.Destroy(id2); err != nil {
+		t.Errorf("%v", err)
+	}
+	if _, existed := store.Lookup(id2); existed {
+		t.Errorf("wanted %v to be destroyed", id2)
+	}
+	awaitEvents(6)
+	if existed, err := store.Update(id2, []byte("dave")); err != nil || existed {
+		t.Errorf("destroyed sessions should not be updated, got %v, %v", existed, err)
+	}
+	wanted := []string{Created, Refreshed, Refreshed, Expired, Created, Destroyed}
+	lock.Lock()
+	stopped = true
+	lock.Unlock()
+	// stop the watch before the node, by giving it one more event to deliver.
+	store.Create([]byte("erin"))
+	common.AssertWithin(t, func() (string, bool) {
+		return "", store.watch.isStopped()
+	}, time.Second)
+	time.Sleep(store.WatchInterval() * 2)
+	lock.Lock()
+	defer lock.Unlock()
+	events = events[:len(wanted)]
+	if len(events) != len(wanted) {
+		t.Fatalf("wanted %v, got %+v", wanted, events)
+	}
+	for index, event := range events {
+		if event.Type != wanted[index] {
+			t.Errorf("wanted %v, got %+v", wanted, events)
+		}
+	}
+}
+
+func TestSessionsWatcher(t *testing.T) {
+	node := dhash.NewEmbeddedNode("sessions_watcher", "")
+	node.MustStart()
+	defer node.Stop()
+	conn := client.MustConn(common.EmbeddedPrefix + "sessions_watcher")
+	store := NewStore(conn, "web", time.Millisecond*500).SetWatcher(node).SetWatchInterval(time.Hour)
+	lock := new(sync.Mutex)
+	var events []Event
+	stopped := false
+	store.Watch(func(event Event) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+		return !stopped
+	})
+	awaitEvents := func(n int) {
+		common.AssertWithin(t, func() (string, bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			return fmt.Sprint(events), len(events) == n
+		}, time.Second)
+	}
+	// a session created and destroyed at once is still reported, since the watch doesn't compare the sessions every watch interval.
+	id, err := store.Create([]byte("alice"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := store.Destroy(id); err != nil {
+		t.Errorf("%v", err)
+	}
+	awaitEvents(2)
+	id, _ = store.Create([]byte("bob"))
+	if existed, err := store.Update(id, []byte("carol")); err != nil || !existed {
+		t.Errorf("wanted %v to be updated, got %v, %v", id, existed, err)
+	}
+	awaitEvents(4)
+	time.Sleep(time.Millisecond * 600)
+	if _, err := conn.Trim(); err != nil {
+		t.Errorf("%v", err)
+	}
+	awaitEvents(5)
+	wanted := []Event{
+		{Type: Created, Data: []byte("alice")},
+		{Type: Destroyed, Data: []byte("alice")},
+		{Type: Created, Data: []byte("bob")},
+		{Type: Refreshed, Data: []byte("carol")},
+		{Type: Expired, Data: []byte("carol")},
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for index, event := range events {
+		if event.Type != wanted[index].Type || string(event.Data) != string(wanted[index].Data) {
+			t.Errorf("wanted %+v, got %+v", wanted, events)
+		}
+	}
+}
@@ -52,6 +52,19 @@ God = function() {
 		dash.graphics.beginStroke(createjs.Graphics.getRGB(0,0,0)).moveTo(that.cx, that.cy - that.r - 30).lineTo(that.cx, that.cy - that.r + 30);
 		stage.addChild(dash);
 
+		if (that.heatmap != null && that.heatmap.Buckets.length > 0) {
+			var buckets = that.heatmap.Buckets;
+			var maxBytes = _.max(_.map(buckets, function(b) { return b.Bytes; }));
+			_.each(buckets, function(bucket, i) {
+				var heat = maxBytes > 0 ? bucket.Bytes / maxBytes : 0;
+				var from = 3 * Math.PI / 2 + (i / buckets.length) * Math.PI * 2;
+				var to = 3 * Math.PI / 2 + ((i + 1) / buckets.length) * Math.PI * 2;
+				var arc = new createjs.Shape();
+				arc.graphics.setStrokeStyle(40).beginStroke(createjs.Graphics.getRGB(Math.round(255 * heat), 0, Math.round(255 * (1 - heat)), 0.2 + 0.6 * heat)).arc(that.cx, that.cy, that.r + 60, from, to);
+				stage.addChild(arc);
+			});
+		}
+
 		if (that.last_route_update > that.last_route_redraw) {
 			$("#nodes .node").remove();
 		}
@@ -125,10 +138,50 @@ God = function() {
 				$("#node_owned_keys").text(that.node.data.OwnedEntries);
 				$("#node_held_keys").text(that.node.data.HeldEntries);
 				$("#node_load").text(that.node.data.Load);
+				$("#node_clock_offset").text(that.formatDuration(that.node.data.ClockOffset));
+				$("#node_clock_error").text(that.formatDuration(that.node.data.ClockError));
+				$("#node_last_sync").text(that.node.data.LastSync);
+				$("#node_last_migrate").text(that.node.data.LastMigrate);
 			}
 			that.last_meta_redraw = new Date().getTime();
 		}
 	};
+	that.formatDuration = function(nanos) {
+	  if (nanos < 0) {
+		  return "unknown";
+		}
+	  return (nanos / 1000000).toFixed(3) + " ms";
+	};
+	that.heatmap = null;
+	that.heatmapBuckets = 64;
+	that.updateHeatmap = function() {
+		$.ajax('/rpc/DHash.Heatmap', {
+			type: 'POST',
+			contentType: 'application/json; charset=UTF-8',
+			data: JSON.stringify({ Buckets: that.heatmapBuckets }),
+			success: function(data) {
+				that.heatmap = data;
+			},
+			dataType: 'json',
+		});
+	};
+	that.adminAction = function(method) {
+	  if (that.node == null) {
+		  return;
+		}
+		$.ajax('/rpc/DHash.' + method, {
+			type: 'POST',
+			contentType: 'application/json; charset=UTF-8',
+			data: JSON.stringify({ Addr: that.node.gob_addr }),
+			success: function(data) {
+				displayResult(method + " of " + that.node.gob_addr + " requested");
+			},
+			error: function(xhr) {
+				displayResult(xhr.responseText);
+			},
+			dataType: 'json',
+		});
+	};
 	that.selectNodeWithAddr = function(addr) {
 	  for (var a in that.node_by_addr) {
 		  if (a == addr) {
@@ -219,6 +272,18 @@ God = function() {
 						};
 						that.animate(item);
 					},
+					Migrate: function(e) {
+						var item = {
+							source: that.getPos(e.data.source),
+							destination: that.getPos(e.data.destination),
+							ttl: new Date().getTime() + 1000,
+							created: new Date().getTime(),
+							color: [0,150,150],
+							strokeWidth: 6,
+							caps: 1,
+						};
+						that.animate(item);
+					},
 					Clean: function(e) {
 						var item = {
 							source: that.getPos(e.data.source.Pos),
@@ -264,6 +329,8 @@ God = function() {
 	};
 	that.start = function() {
 		window.setInterval(that.drawChord, 40);
+		that.updateHeatmap();
+		window.setInterval(that.updateHeatmap, 5000);
 		that.open_socket(document.location.hostname + ":" + document.location.port);
 		_.each(that.api_endpoints, function(api_endpoint) {
 		  $("#endpoints").append(that.api_endpoint_item_templ({ api_endpoint: api_endpoint }));
@@ -271,6 +338,14 @@ God = function() {
 		$("#endpoints li").click(function(ev) {
 		  that.display_endpoint_form(that.api_endpoints[$(ev.target).attr("data-endpoint-name")]);
 		});
+		$("#node_sync").click(function(ev) {
+		  that.adminAction("Sync");
+		});
+		$("#node_decommission").click(function(ev) {
+		  if (that.node != null && confirm("Decommission " + that.node.gob_addr + "?")) {
+			  that.adminAction("Decommission");
+			}
+		});
 	};
 	return that;
 };
@@ -1,4 +1,4 @@
 package templates
 const (
-  Timestamp = 1371395444937947947
+  Timestamp = 1792061827236257181
 )
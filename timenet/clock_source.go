@@ -0,0 +1,124 @@
+package timenet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// clockSourceId is the id the ClockSource of a Timer has among its peers when calculating the error of the Timer.
+	clockSourceId = "clock source"
+	// ntpEpochOffset is the number of seconds between the NTP epoch, 1900, and the Unix epoch, 1970.
+	ntpEpochOffset = 2208988800
+	ntpTimeout     = time.Second * 5
+	// clockSourceInterval is how often a running Timer samples its ClockSource, to avoid being rate limited by public NTP servers.
+	clockSourceInterval = time.Minute
+)
+
+// ClockSource is an external time reference, like an NTP server, that a Timer can weight into its time to keep a whole cluster from drifting together.
+type ClockSource interface {
+	// Now returns the time according to the source, and how uncertain that time is.
+	Now() (now time.Time, uncertainty time.Duration, err error)
+}
+
+// NTPSource is a ClockSource querying an NTP server using SNTP.
+type NTPSource struct {
+	// Addr is the address of the NTP server, like pool.ntp.org:123.
+	Addr string
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, (fraction*int64(time.Second))>>32)
+}
+func ntpDuration(b []byte) time.Duration {
+	return time.Duration((int64(binary.BigEndian.Uint32(b)) * int64(time.Second)) >> 16)
+}
+
+// Now will query the NTP server, and return its time adjusted for the round trip, and the uncertainty of it.
+func (self NTPSource) Now() (now time.Time, uncertainty time.Duration, err error) {
+	conn, err := net.DialTimeout("udp", self.Addr, ntpTimeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+	request := make([]byte, 48)
+	// Leap indicator 0, version 4, mode 3 (client).
+	request[0] = 0x23
+	sent := time.Now()
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+	response := make([]byte, 48)
+	var n int
+	if n, err = conn.Read(response); err != nil {
+		return
+	}
+	received := time.Now()
+	if n < 48 || response[0]&0x7 != 4 {
+		err = fmt.Errorf("%v sent an invalid NTP response", self.Addr)
+		return
+	}
+	if response[1] == 0 {
+		err = fmt.Errorf("%v is not synchronized", self.Addr)
+		return
+	}
+	serverReceived, serverSent := ntpTime(response[32:40]), ntpTime(response[40:48])
+	roundTrip := received.Sub(sent) - serverSent.Sub(serverReceived)
+	now = serverSent.Add(roundTrip / 2).Add(time.Now().Sub(received))
+	uncertainty = roundTrip/2 + ntpDuration(response[4:8])/2 + ntpDuration(response[8:12])
+	return
+}
+
+// SetClockSource will make this Timer regularly compare itself to source, and adjust weight, between 0 and 1, of the difference.
+// A nil source removes the clock source.
+func (self *Timer) SetClockSource(source ClockSource, weight float64) *Timer {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.clockSource, self.clockSourceWeight = source, weight
+	self.sourceError = -1
+	delete(self.peerErrors, clockSourceId)
+	return self
+}
+
+// SampleClockSource will make this Timer compare itself to its ClockSource, if any, and Skew weighted according to the difference.
+func (self *Timer) SampleClockSource() (err error) {
+	self.lock.Lock()
+	source, weight := self.clockSource, self.clockSourceWeight
+	self.lastSourceSample = time.Now()
+	self.lock.Unlock()
+	if source == nil {
+		return
+	}
+	sourceTime, uncertainty, err := source.Now()
+	if err != nil {
+		return
+	}
+	myTime := self.ActualTime()
+	delta := sourceTime.Sub(myTime)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if delta < 0 {
+		self.sourceError = uncertainty - delta
+	} else {
+		self.sourceError = uncertainty + delta
+	}
+	self.adjust(clockSourceId, int64(float64(delta)*weight))
+	return
+}
+
+// EstimatedError returns an estimate of how far from the correct time this Timer is, or -1 if it can't be estimated.
+// It is the larger of the deviation of the error of this Timer compared to its peers, and, with a ClockSource, the difference to the source when last sampled plus the uncertainty of the source.
+func (self *Timer) EstimatedError() time.Duration {
+	self.lock.RLock()
+	sourceError := self.sourceError
+	self.lock.RUnlock()
+	if peerError := self.Error(); peerError > sourceError {
+		return peerError
+	}
+	return sourceError
+}
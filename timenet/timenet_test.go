@@ -81,3 +81,39 @@ func TestSample(t *testing.T) {
 		return fmt.Sprint(d), d > 0 && d < 1000000
 	}, time.Second*20)
 }
+
+type testClockSource time.Duration
+
+func (self testClockSource) Now() (time.Time, time.Duration, error) {
+	return time.Now().Add(time.Duration(self)), time.Millisecond * 10, nil
+}
+
+func TestClockSource(t *testing.T) {
+	timer := NewTimer(newTestPeerProducer())
+	if err := timer.EstimatedError(); err != -1 {
+		t.Errorf("%v should have an unknown error, but has %v", timer, err)
+	}
+	timer.SetClockSource(testClockSource(time.Second), 0.5)
+	if err := timer.SampleClockSource(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if offset := timer.Offset(); offset < time.Millisecond*490 || offset > time.Millisecond*510 {
+		t.Errorf("%v should have moved half way to its clock source, but has offset %v", timer, offset)
+	}
+	if err := timer.EstimatedError(); err < time.Millisecond*1000 || err > time.Millisecond*1020 {
+		t.Errorf("%v should have an error of the distance to and uncertainty of its clock source, but has %v", timer, err)
+	}
+	if err := timer.SampleClockSource(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if offset := timer.Offset(); offset < time.Millisecond*740 || offset > time.Millisecond*760 {
+		t.Errorf("%v should have moved closer to its clock source, but has offset %v", timer, offset)
+	}
+	if err := timer.EstimatedError(); err < time.Millisecond*500 || err > time.Millisecond*520 {
+		t.Errorf("%v should have a smaller error after moving closer to its clock source, but has %v", timer, err)
+	}
+	timer.SetClockSource(nil, 0)
+	if err := timer.EstimatedError(); err != -1 {
+		t.Errorf("%v should have an unknown error without a clock source, but has %v", timer, err)
+	}
+}
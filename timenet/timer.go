@@ -35,13 +35,17 @@ type PeerProducer interface {
 // that respond within standard deviation from the normal response times when adjusting
 // its timer.
 type Timer struct {
-	lock          *sync.RWMutex
-	state         int32
-	offset        int64
-	dilations     *dilations
-	peerProducer  PeerProducer
-	peerErrors    map[string]int64
-	peerLatencies map[string]times
+	lock              *sync.RWMutex
+	state             int32
+	offset            int64
+	dilations         *dilations
+	peerProducer      PeerProducer
+	peerErrors        map[string]int64
+	peerLatencies     map[string]times
+	clockSource       ClockSource
+	clockSourceWeight float64
+	sourceError       time.Duration
+	lastSourceSample  time.Time
 }
 
 func NewTimer(producer PeerProducer) *Timer {
@@ -52,6 +56,7 @@ func NewTimer(producer PeerProducer) *Timer {
 		dilations:     &dilations{},
 		peerErrors:    make(map[string]int64),
 		peerLatencies: make(map[string]times),
+		sourceError:   -1,
 	}
 }
 func (self *Timer) adjustments() int64 {
@@ -66,6 +71,13 @@ func (self *Timer) ActualTime() time.Time {
 	return time.Unix(0, time.Now().UnixNano()+self.adjustments())
 }
 
+// Offset returns the difference between what time this Timer thinks it is and the local clock.
+func (self *Timer) Offset() time.Duration {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return time.Duration(self.adjustments())
+}
+
 // ContinuousTime will return a continous nice version of the time this Timer thinks it is. It us guaranteed
 // to never move backwards, and to only move forwards in a smooth fashion.
 func (self *Timer) ContinuousTime() (result int64) {
@@ -114,6 +126,19 @@ func (self *Timer) Stability() (result time.Duration) {
 	}
 	return
 }
+
+// Latencies returns the mean latency between this Timer and each of its peers with enough measurements.
+func (self *Timer) Latencies() (result map[string]time.Duration) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	result = make(map[string]time.Duration)
+	for id, latencies := range self.peerLatencies {
+		if mean, _ := latencies.stats(); mean >= 0 {
+			result[id] = time.Duration(mean)
+		}
+	}
+	return
+}
 func (self *Timer) adjust(id string, adjustment int64) {
 	self.peerErrors[id] = adjustment
 	self.dilations.add(adjustment)
@@ -215,10 +240,16 @@ func (self *Timer) sleep() {
 	}
 }
 
-// Run will make this Timer regularly Sample. 
+// Run will make this Timer regularly Sample.
 func (self *Timer) Run() {
 	for self.hasState(started) {
 		self.Sample()
+		self.lock.RLock()
+		sampleSource := time.Now().Sub(self.lastSourceSample) > clockSourceInterval
+		self.lock.RUnlock()
+		if sampleSource {
+			self.SampleClockSource()
+		}
 		self.sleep()
 	}
 }